@@ -0,0 +1,137 @@
+// This file contains the commands for importing and exporting
+// the materials, the products and the categories in bulk.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/spf13/cobra"
+)
+
+// CatalogProcess represents the process of importing or exporting the catalog.
+type CatalogProcess struct {
+	Config   config.Config
+	Logger   logger.ILogger
+	Format   string
+	IsDryRun bool
+}
+
+// format returns the format set by the flag, or the one of the file extension, json is the default.
+func (cp *CatalogProcess) format(file_name string) string {
+	if cp.Format != "" {
+		return strings.ToLower(cp.Format)
+	}
+
+	if extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(file_name)), "."); extension != "" {
+		return extension
+	}
+
+	return services.CatalogFormatJSON
+}
+
+// GetImportCmd returns the cobra command for importing the catalog from a file.
+func (cp *CatalogProcess) GetImportCmd() (*cobra.Command, error) {
+
+	cmd := &cobra.Command{
+		Use:   "import [materials|products|categories] [file]",
+		Short: "Import materials, products or categories from a csv or json file.",
+		Long: `Import materials, products or categories from a csv or json file.
+		The rows are matched to the existing records by id then by name, the matched records are updated and the others are created.
+		The materials and the sub products of the products are referenced by id, SKU or name.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cp.Import(args[0], args[1], cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().BoolVar(&cp.IsDryRun, "dry-run", false, "validate the file and report what would be imported without saving anything")
+	cmd.Flags().StringVar(&cp.Format, "format", "", "csv or json, taken from the file extension when it's not set")
+
+	return cmd, nil
+}
+
+// GetExportCmd returns the cobra command for exporting the catalog to a file.
+func (cp *CatalogProcess) GetExportCmd() (*cobra.Command, error) {
+
+	cmd := &cobra.Command{
+		Use:   "export [materials|products|categories] [file]",
+		Short: "Export materials, products or categories to a csv or json file that can be imported back.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file_name := ""
+			if len(args) > 1 {
+				file_name = args[1]
+			}
+
+			return cp.Export(args[0], file_name, cmd.OutOrStdout())
+		},
+	}
+
+	cmd.Flags().StringVar(&cp.Format, "format", "", "csv or json, taken from the file extension when it's not set")
+
+	return cmd, nil
+}
+
+// Import imports the catalog file and prints the report.
+func (cp *CatalogProcess) Import(kind string, file_name string, out io.Writer) error {
+
+	file, err := os.Open(file_name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	catalog_svc := services.CatalogService{
+		Logger: cp.Logger,
+		Config: cp.Config,
+	}
+
+	report, err := catalog_svc.Import(kind, cp.format(file_name), file, cp.IsDryRun, "")
+	if err != nil {
+		return err
+	}
+
+	for _, row := range report.Rows {
+		if row.Action == services.ImportActionError {
+			fmt.Fprintf(out, "row %d %s: %s\n", row.Row, row.Name, row.Error)
+		} else {
+			fmt.Fprintf(out, "row %d %s: %s %s\n", row.Row, row.Name, row.Action, row.Id)
+		}
+	}
+
+	if report.IsDryRun {
+		fmt.Fprint(out, "dry run, nothing was saved\n")
+	}
+
+	fmt.Fprintf(out, "%d %s: %d created, %d updated, %d failed\n", report.Total, report.Kind, report.Created, report.Updated, report.Failed)
+
+	return nil
+}
+
+// Export writes the catalog to the file, or to the output when there is no file.
+func (cp *CatalogProcess) Export(kind string, file_name string, out io.Writer) error {
+
+	catalog_svc := services.CatalogService{
+		Logger: cp.Logger,
+		Config: cp.Config,
+	}
+
+	if file_name == "" {
+		return catalog_svc.Export(kind, cp.format(file_name), out)
+	}
+
+	file, err := os.Create(file_name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return catalog_svc.Export(kind, cp.format(file_name), file)
+}
@@ -0,0 +1,43 @@
+// This file contains the command for migrating the documents
+// saved by the older versions to the current models.
+package cmd
+
+import (
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/spf13/cobra"
+)
+
+// MigrateProcess represents the process of migrating the db.
+type MigrateProcess struct {
+	Config config.Config
+	Logger logger.ILogger
+}
+
+// GetCmd returns the cobra command for migrating the db.
+func (mp *MigrateProcess) GetCmd() (*cobra.Command, error) {
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the documents saved by older versions to the current models.",
+		Long: `Migrate the documents saved by older versions to the current models.
+		The migrations also run when the server starts, and the migrated documents are skipped so it's safe to run it more than once.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return mp.Migrate()
+		},
+	}
+
+	return cmd, nil
+}
+
+// Migrate runs the migrations of the core module.
+func (mp *MigrateProcess) Migrate() error {
+
+	migration_svc := services.MigrationService{
+		Config: mp.Config,
+		Logger: mp.Logger,
+	}
+
+	return migration_svc.Migrate()
+}
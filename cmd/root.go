@@ -37,6 +37,15 @@ func (root *RootProcess) Execute() error {
 		Free forever and distributed under the MIT license. https://github.com/elmawardy/nutrix`,
 
 		Run: func(cmd *cobra.Command, args []string) {
+			migrateProcess := MigrateProcess{
+				Config: root.Config,
+				Logger: root.Logger,
+			}
+
+			if err := migrateProcess.Migrate(); err != nil {
+				root.Logger.Error(err.Error())
+			}
+
 			srv := &http.Server{
 				Handler: root.Router,
 				Addr:    "127.0.0.1:8000",
@@ -64,6 +73,42 @@ func (root *RootProcess) Execute() error {
 
 	root.cmd.AddCommand(seedCmd)
 
+	migrateProcess := MigrateProcess{
+		Config: root.Config,
+		Logger: root.Logger,
+	}
+
+	migrateCmd, err := migrateProcess.GetCmd()
+	if err != nil {
+		return err
+	}
+
+	root.cmd.AddCommand(migrateCmd)
+
+	importProcess := CatalogProcess{
+		Config: root.Config,
+		Logger: root.Logger,
+	}
+
+	importCmd, err := importProcess.GetImportCmd()
+	if err != nil {
+		return err
+	}
+
+	root.cmd.AddCommand(importCmd)
+
+	exportProcess := CatalogProcess{
+		Config: root.Config,
+		Logger: root.Logger,
+	}
+
+	exportCmd, err := exportProcess.GetExportCmd()
+	if err != nil {
+		return err
+	}
+
+	root.cmd.AddCommand(exportCmd)
+
 	if err := root.cmd.Execute(); err != nil {
 		return err
 	}
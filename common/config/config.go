@@ -41,6 +41,32 @@ type Config struct {
 	JwtSecretKey string        `mapstructure:"jwt_secret_key"`
 	TimeZone     string        `mapstructure:"timezone"`
 	UploadsPath  string        `mapstructure:"uploads_path"`
+	Storage      StorageConfig `mapstructure:"storage"`
+}
+
+// StorageConfig holds the configuration of the storage of the uploaded files
+type StorageConfig struct {
+	// Type is local or s3, local stores the files in UploadsPath and is used when it's not set.
+	Type string `mapstructure:"type"`
+	// PublicURL is prepended to the keys of the stored files to build their urls.
+	PublicURL string   `mapstructure:"public_url"`
+	S3        S3Config `mapstructure:"s3"`
+	// MaxImageBytes is the maximum size of an uploaded image, 5MB is used when it's not set.
+	MaxImageBytes int64 `mapstructure:"max_image_bytes"`
+	// ThumbnailWidth is the width of the generated thumbnails in pixels, 320 is used when it's not set.
+	ThumbnailWidth int `mapstructure:"thumbnail_width"`
+}
+
+// S3Config holds the configuration of an S3 compatible storage such as AWS S3 or MinIO
+type S3Config struct {
+	// Endpoint is the base url of the storage, for example https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	// UsePathStyle addresses the bucket in the path instead of the host name, it's required by MinIO.
+	UsePathStyle bool `mapstructure:"use_path_style"`
 }
 
 // Database holds the configuration for database connections
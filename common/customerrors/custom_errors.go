@@ -28,3 +28,89 @@ var ErrInvalidObjectId = errors.New("invalid object id")
 
 // ErrInsufficientReady is an error returned when there is not enough ready.
 var ErrInsufficientReady = errors.New("insufficient ready")
+
+// ErrEntryNotUsable is an error returned when a material entry is quarantined or expired.
+var ErrEntryNotUsable = errors.New("material entry is quarantined or expired")
+
+// ErrInvalidMovement is an error returned when an inventory movement has an unknown type or a quantity with the wrong sign.
+var ErrInvalidMovement = errors.New("invalid inventory movement")
+
+// ErrEntryNotFound is an error returned when a material entry doesn't exist.
+var ErrEntryNotFound = errors.New("material entry not found")
+
+// ErrEntryNotQuarantined is an error returned when a material entry is wasted without being quarantined.
+var ErrEntryNotQuarantined = errors.New("material entry isn't quarantined")
+
+// ErrInsufficientStock is an error returned when the usable material entries can't cover a requested quantity.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ErrInvalidYield is an error returned when a recipe has a yield percentage out of the 0 to 100 range.
+var ErrInvalidYield = errors.New("invalid yield percentage")
+
+// ErrInvalidRecipe is an error returned when a recipe has a cycle, a reference to a missing material or product,
+// or is nested deeper than allowed.
+var ErrInvalidRecipe = errors.New("invalid recipe")
+
+// ErrInvalidPrice is an error returned when a product price is negative.
+var ErrInvalidPrice = errors.New("invalid price")
+
+// ErrPriceInEffect is an error returned when changing a scheduled price that already took effect.
+var ErrPriceInEffect = errors.New("price already in effect")
+
+// ErrInvalidPriceList is an error returned when a price list is missing, has an unknown service style,
+// a service style already used by another list, or a price for a missing product.
+var ErrInvalidPriceList = errors.New("invalid price list")
+
+// ErrInvalidSchedule is an error returned when an availability window has an invalid weekday or time.
+var ErrInvalidSchedule = errors.New("invalid availability schedule")
+
+// ErrNotOnMenu is an error returned when an ordered product is 86'd or out of its schedule.
+var ErrNotOnMenu = errors.New("product is not on the menu")
+
+// ErrInvalidNutrition is an error returned when a material has negative nutrition facts, an unknown allergen or diet.
+var ErrInvalidNutrition = errors.New("invalid nutrition")
+
+// ErrInvalidImage is an error returned when an uploaded image isn't a jpeg, png or gif image, or can't be decoded.
+var ErrInvalidImage = errors.New("invalid image")
+
+// ErrImageTooLarge is an error returned when an uploaded image is bigger than the configured maximum size or dimensions.
+var ErrImageTooLarge = errors.New("image too large")
+
+// ErrInvalidImport is an error returned when an import file is malformed, or its catalog or format is unknown.
+var ErrInvalidImport = errors.New("invalid import file")
+
+// ErrInvalidScenario is an error returned when a costing what-if scenario references a missing material or product,
+// or ends up with a negative cost, quantity or price.
+var ErrInvalidScenario = errors.New("invalid costing scenario")
+
+// ErrInvalidCategory is an error returned when a category references a missing product or parent, is nested in itself
+// or in one of its own subcategories, or is shown on an unknown channel.
+var ErrInvalidCategory = errors.New("invalid category")
+
+// ErrCustomerNotFound is an error returned when an order is attached to a customer that doesn't exist.
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// ErrCreditLimitExceeded is an error returned when a pay-later order would take the balance of the customer over their credit limit.
+var ErrCreditLimitExceeded = errors.New("credit limit exceeded")
+
+// ErrInvalidPayment is an error returned when a payment has a non positive amount.
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// ErrInvalidRedemption is an error returned when loyalty points are redeemed without a customer, while the program is
+// disabled, for a product that isn't a reward, below the minimum points, or for more than the order total,
+// and when zero points are adjusted.
+var ErrInvalidRedemption = errors.New("invalid loyalty redemption")
+
+// ErrInsufficientPoints is an error returned when a customer redeems more loyalty points than they have.
+var ErrInsufficientPoints = errors.New("insufficient loyalty points")
+
+// ErrInvalidGiftCard is an error returned when a gift card is issued with a taken code or a non positive balance,
+// or when the gift cards of an order pay a non positive amount or more than the order total.
+var ErrInvalidGiftCard = errors.New("invalid gift card")
+
+// ErrGiftCardNotFound is an error returned when there is no gift card with a code.
+var ErrGiftCardNotFound = errors.New("gift card not found")
+
+// ErrGiftCardUnavailable is an error returned when a gift card is redeemed while it's deactivated or expired,
+// or for more than its balance.
+var ErrGiftCardUnavailable = errors.New("gift card unavailable")
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage stores the files in a directory of the local filesystem.
+type LocalStorage struct {
+	root      string
+	publicURL string
+}
+
+// NewLocalStorage creates a storage in the root directory, the files are served under the public url.
+func NewLocalStorage(root string, public_url string) *LocalStorage {
+	if root == "" {
+		root = "./public"
+	}
+
+	return &LocalStorage{root: root, publicURL: public_url}
+}
+
+// path returns the path of the key in the root directory, the keys escaping the root are rejected.
+func (ls *LocalStorage) path(key string) (string, error) {
+	path := filepath.Join(ls.root, filepath.FromSlash(key))
+
+	relative, err := filepath.Rel(ls.root, path)
+	if err != nil || relative == "." || strings.HasPrefix(relative, "..") {
+		return "", fmt.Errorf("invalid storage key %s", key)
+	}
+
+	return path, nil
+}
+
+// Put writes the data to the file of the key, creating its directories.
+func (ls *LocalStorage) Put(ctx context.Context, key string, content_type string, data []byte) error {
+
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Delete removes the file of the key.
+func (ls *LocalStorage) Delete(ctx context.Context, key string) error {
+
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// URL returns the key under the public url.
+func (ls *LocalStorage) URL(key string) string {
+	return joinURL(ls.publicURL, key)
+}
+
+// Root returns the directory the files are stored in.
+func (ls *LocalStorage) Root() string {
+	return ls.root
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+)
+
+// S3Storage stores the files in a bucket of an S3 compatible storage such as AWS S3 or MinIO,
+// the requests are signed with AWS signature version 4.
+type S3Storage struct {
+	conf      config.S3Config
+	publicURL string
+	client    *http.Client
+}
+
+// NewS3Storage creates a storage in the bucket of the config, the files are served under the public url,
+// or from the bucket url when it's not set.
+func NewS3Storage(conf config.S3Config, public_url string) *S3Storage {
+	if conf.Region == "" {
+		conf.Region = "us-east-1"
+	}
+
+	return &S3Storage{
+		conf:      conf,
+		publicURL: public_url,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// objectURL returns the url of the object of the key in the bucket.
+func (s3 *S3Storage) objectURL(key string) (*url.URL, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(s3.conf.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", s3.conf.Endpoint)
+	}
+
+	object_path := "/" + strings.TrimPrefix(key, "/")
+	if s3.conf.UsePathStyle {
+		object_path = "/" + s3.conf.Bucket + object_path
+	} else {
+		endpoint.Host = s3.conf.Bucket + "." + endpoint.Host
+	}
+
+	endpoint.Path = endpoint.Path + object_path
+	endpoint.RawPath = escapePath(endpoint.Path)
+
+	return endpoint, nil
+}
+
+// do signs and sends a request to the object of the key, any status other than 2xx is returned as an error.
+func (s3 *S3Storage) do(ctx context.Context, method string, key string, content_type string, data []byte) error {
+
+	object_url, err := s3.objectURL(key)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, object_url.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if content_type != "" {
+		request.Header.Set("Content-Type", content_type)
+	}
+
+	s3.sign(request, data, time.Now().UTC())
+
+	response, err := s3.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("s3 %s %s failed with status %d: %s", method, key, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// sign adds the AWS signature version 4 authorization of the request.
+func (s3 *S3Storage) sign(request *http.Request, payload []byte, at time.Time) {
+
+	amz_date := at.Format("20060102T150405Z")
+	date := at.Format("20060102")
+
+	payload_hash := sha256Hex(payload)
+
+	request.Header.Set("Host", request.URL.Host)
+	request.Header.Set("X-Amz-Date", amz_date)
+	request.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	header_names := make([]string, 0, len(request.Header))
+	for name := range request.Header {
+		header_names = append(header_names, strings.ToLower(name))
+	}
+	sort.Strings(header_names)
+
+	canonical_headers := ""
+	for _, name := range header_names {
+		canonical_headers += name + ":" + strings.TrimSpace(request.Header.Get(name)) + "\n"
+	}
+	signed_headers := strings.Join(header_names, ";")
+
+	canonical_request := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonical_headers,
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	scope := date + "/" + s3.conf.Region + "/s3/aws4_request"
+
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amz_date,
+		scope,
+		sha256Hex([]byte(canonical_request)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s3.conf.SecretKey), date)
+	key = hmacSHA256(key, s3.conf.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, string_to_sign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.conf.AccessKey, scope, signed_headers, signature,
+	))
+
+	// the host is sent from the url, it's set above to be signed only
+	request.Header.Del("Host")
+}
+
+// Put uploads the data to the object of the key.
+func (s3 *S3Storage) Put(ctx context.Context, key string, content_type string, data []byte) error {
+	return s3.do(ctx, http.MethodPut, key, content_type, data)
+}
+
+// Delete removes the object of the key, S3 doesn't fail when the object doesn't exist.
+func (s3 *S3Storage) Delete(ctx context.Context, key string) error {
+	return s3.do(ctx, http.MethodDelete, key, "", nil)
+}
+
+// URL returns the key under the public url, or the url of the object in the bucket when there is no public url.
+func (s3 *S3Storage) URL(key string) string {
+	if s3.publicURL != "" {
+		return joinURL(s3.publicURL, key)
+	}
+
+	object_url, err := s3.objectURL(key)
+	if err != nil {
+		return key
+	}
+
+	return object_url.String()
+}
+
+// escapePath escapes the path as S3 expects it, every byte except the unreserved characters and the slashes is escaped.
+func escapePath(path string) string {
+	var escaped strings.Builder
+
+	for _, b := range []byte(path) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,41 @@
+// Package storage implements the storage of the uploaded files.
+//
+// The files are stored by key, a slash separated path such as products/<id>/<name>.jpg,
+// in the local filesystem or in an S3 compatible storage.
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/elmawardy/nutrix/common/config"
+)
+
+// IStorage interface defines methods to store and remove files by key
+type IStorage interface {
+	// Put stores the data under the key, replacing the file stored under it if any.
+	Put(ctx context.Context, key string, content_type string, data []byte) error
+	// Delete removes the file stored under the key, removing a missing file isn't an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the url the file stored under the key is served at.
+	URL(key string) string
+}
+
+// StorageFactory creates the storage set in the config, the local storage is used when no type is set.
+func StorageFactory(conf config.Config) IStorage {
+	switch conf.Storage.Type {
+	case "s3":
+		return NewS3Storage(conf.Storage.S3, conf.Storage.PublicURL)
+	}
+
+	return NewLocalStorage(conf.UploadsPath, conf.Storage.PublicURL)
+}
+
+// joinURL joins the public url and the key, the key is returned as is when there is no public url.
+func joinURL(public_url string, key string) string {
+	if public_url == "" {
+		return key
+	}
+
+	return strings.TrimSuffix(public_url, "/") + "/" + strings.TrimPrefix(key, "/")
+}
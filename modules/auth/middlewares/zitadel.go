@@ -75,11 +75,11 @@ func (za *ZitadelAuth) AllowAnyOfRoles(next http.Handler, roles ...string) http.
 				return
 			}
 
-			_, err := za.AuthZ.CheckAuthorization(r.Context(), reqToken, authorization.WithRole(role))
+			authCtx, err := za.AuthZ.CheckAuthorization(r.Context(), reqToken, authorization.WithRole(role))
 
 			if err == nil {
 				authorized = true
-				next.ServeHTTP(w, r)
+				next.ServeHTTP(w, r.WithContext(authorization.WithAuthContext(r.Context(), authCtx)))
 				break
 			}
 		}
@@ -100,3 +100,9 @@ func (za *ZitadelAuth) AllowAnyOfRoles(next http.Handler, roles ...string) http.
 
 	// return handler(next)
 }
+
+// UserId returns the id of the user authorized by the middlewares,
+// it returns an empty string if the request wasn't authorized.
+func UserId(ctx context.Context) string {
+	return authorization.Context[*oauth.IntrospectionContext](ctx).UserID()
+}
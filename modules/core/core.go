@@ -11,6 +11,7 @@ import (
 
 	"github.com/elmawardy/nutrix/common/config"
 	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/common/storage"
 	"github.com/elmawardy/nutrix/common/userio"
 	"github.com/elmawardy/nutrix/modules"
 	auth_mw "github.com/elmawardy/nutrix/modules/auth/middlewares"
@@ -131,7 +132,49 @@ func (c *Core) RegisterBackgroundWorkers() []modules.Worker {
 		{
 			Interval: 1 * time.Hour,
 			Task: func() {
-				services.CheckExpirationDates(c.Logger, c.Config, c.NotificationSvc)
+				services.CheckExpirationDates(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 1 * time.Hour,
+			Task: func() {
+				services.CheckBatchesExpiry(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 1 * time.Minute,
+			Task: func() {
+				services.ApplyScheduledPrices(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 1 * time.Minute,
+			Task: func() {
+				services.ResetEightySixedProducts(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 24 * time.Hour,
+			Task: func() {
+				services.NotifyReorderSuggestions(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 24 * time.Hour,
+			Task: func() {
+				services.CheckLedgerConsistency(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 1 * time.Hour,
+			Task: func() {
+				services.ExpireLoyaltyPoints(c.Logger, c.Config, c.Settings, c.NotificationSvc)
+			},
+		},
+		{
+			Interval: 1 * time.Hour,
+			Task: func() {
+				services.ExpireGiftCards(c.Logger, c.Config, c.Settings, c.NotificationSvc)
 			},
 		},
 	}
@@ -146,45 +189,94 @@ func (c *Core) RegisterHttpHandlers(router *mux.Router, prefix string) {
 
 	c.Logger.Info("Successfully conntected to Zitadel")
 
+	router.Handle(prefix+"/api/giftcards", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetGiftCards(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/giftcards", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.IssueGiftCard(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/giftcards/{code}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetGiftCard(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/giftcards/{code}/transactions", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetGiftCardTransactions(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/giftcards/{code}/deactivate", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeactivateGiftCard(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/loyalty", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomerLoyalty(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/loyalty", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.AdjustCustomerLoyalty(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/payments", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.PostCustomerPayment(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/receivables", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomerReceivables(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/statement", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomerStatement(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/customers/{id}/orders", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomerOrders(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/customers/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateCustomer(c.Config, c.Logger), "admin", "cashier"))).Methods("PATCH", "OPTIONS")
 	router.Handle(prefix+"/api/customers/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteCustomer(c.Config, c.Logger, c.Settings), "admin"))).Methods("DELETE", "OPTIONS")
 	router.Handle(prefix+"/api/customers/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomer(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/customers", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCustomers(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/customers", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.AddCustomer(c.Config, c.Logger), "admin", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/salesperday", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetSalesPerDay(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/materials", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetMaterials(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/materials", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.AddMaterial(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
-	router.Handle(prefix+"/api/materials/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.EditMaterial(c.Config, c.Logger), "admin"))).Methods("PATCH", "OPTIONS")
+	router.Handle(prefix+"/api/reports/inventoryvaluation", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetInventoryValuation(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/reports/menuengineering", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetMenuEngineering(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/reports/giftcardliability", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetGiftCardLiability(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/reports/receivablesaging", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetReceivablesAging(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/reports/whatif", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.SimulateCosting(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/traceability/lots", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.TraceLot(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/traceability/orders/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.TraceOrder(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/inventory/movements", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetInventoryMovements(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/inventory/movements", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.RecordInventoryMovement(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/inventory/stock", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetStockAsOf(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/inventory/consistency", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetLedgerConsistency(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/inventory/reconcile", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.ReconcileLedger(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/materials", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetMaterials(c.Config, c.Logger, c.Settings), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/materials", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.AddMaterial(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/materials/reordersuggestions", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetReorderSuggestions(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/materials/quarantine", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetQuarantinedEntries(c.Config, c.Logger), "admin", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/materials/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.EditMaterial(c.Config, c.Logger, c.Settings), "admin"))).Methods("PATCH", "OPTIONS")
 	router.Handle(prefix+"/api/materials/{id}/logs", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetMaterialLogs(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/materials/{id}/entries", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.PushMaterialEntry(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/materials/{material_id}/entries/{entry_id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteEntry(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/materials/{material_id}/entries/{entry_id}/cost", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.CalculateMaterialCost(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/categories", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCategories(c.Config, c.Logger), "admin", "cashier"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/categories", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.InsertCategory(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/materials/{material_id}/entries/{entry_id}/waste", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.WasteEntry(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/materials/{material_id}/entries/{entry_id}/extend", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.ExtendEntryExpiry(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/materials/{material_id}/entries/{entry_id}/cost", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.CalculateMaterialCost(c.Config, c.Logger, c.Settings), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/categories", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetCategories(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/categories", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.InsertCategory(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/categories/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteCategory(c.Config, c.Logger), "admin"))).Methods("DELETE", "OPTIONS")
-	router.Handle(prefix+"/api/categories/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateCategory(c.Config, c.Logger), "admin"))).Methods("PATCH", "OPTIONS")
+	router.Handle(prefix+"/api/categories/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateCategory(c.Config, c.Logger, c.Settings), "admin"))).Methods("PATCH", "OPTIONS")
 	router.Handle(prefix+"/api/orders", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetOrders(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/orders", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.SubmitOrder(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetOrder(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteOrder(c.Config, c.Logger), "admin", "cashier"))).Methods("DELETE", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}/start", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.StartOrder(c.Config, c.Logger, c.Settings), "admin", "chef"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}/cancel", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.CancelOrder(c.Config, c.Logger), "admin", "cashier"))).Methods("POST", "OPTIONS")
-	router.Handle(prefix+"/api/orders/{id}/finish", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.FinishOrder(c.Config, c.Logger), "admin", "chef"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/orders/{id}/finish", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.FinishOrder(c.Config, c.Logger, c.Settings), "admin", "chef"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}/pay", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.Payorder(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}/printkitchenreceipt", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.PrintKitchenReceipt(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/orders/{id}/printclientreceipt", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.PrintClientReceipt(c.Config, c.Logger, c.Settings), "admin", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/products/availability", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetRecipeAvailability(c.Config, c.Logger), "admin", "chef", "cashier"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/products/{id}/recipetree", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetRecipeTree(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/integrity", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.CheckRecipesIntegrity(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/recipetree", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetRecipeTree(c.Config, c.Logger, c.Settings), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/production", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.ProduceBatch(c.Config, c.Logger, c.Settings), "admin", "chef"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/production", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetProductionBatches(c.Config, c.Logger, c.Settings), "admin", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/versions", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetRecipeVersions(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/versions/diff", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DiffRecipeVersions(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/versions/{version:[0-9]+}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetRecipeVersion(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/versions/{version:[0-9]+}/rollback", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.RollbackRecipe(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/prices", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetPriceHistory(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/prices", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.SchedulePrice(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/prices/{price_id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.CancelScheduledPrice(c.Config, c.Logger), "admin"))).Methods("DELETE", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/eightysix", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.SetEightySixed(c.Config, c.Logger), "admin", "chef", "cashier"))).Methods("POST", "OPTIONS")
 	router.Handle(prefix+"/api/products/{id}/image", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateProductImage(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
-	router.Handle(prefix+"/api/products/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetProduct(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/images", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.AddProductImage(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/images/{image_id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteProductImage(c.Config, c.Logger), "admin"))).Methods("DELETE", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}/images/{image_id}/primary", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.SetPrimaryProductImage(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetProduct(c.Config, c.Logger, c.Settings), "admin"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/products/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeleteProduct(c.Config, c.Logger), "admin"))).Methods("DELETE", "OPTIONS")
-	router.Handle(prefix+"/api/products/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateProduct(c.Config, c.Logger), "admin"))).Methods("PATCH", "OPTIONS")
-	router.Handle(prefix+"/api/products", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetProducts(c.Config, c.Logger), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
-	router.Handle(prefix+"/api/products", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.InesrtNewProduct(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/products/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateProduct(c.Config, c.Logger, c.Settings), "admin"))).Methods("PATCH", "OPTIONS")
+	router.Handle(prefix+"/api/products", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetProducts(c.Config, c.Logger, c.Settings), "admin", "cashier", "chef"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/products", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.InesrtNewProduct(c.Config, c.Logger, c.Settings), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/pricelists", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetPriceLists(c.Config, c.Logger), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/pricelists", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.InsertPriceList(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/pricelists/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetPriceList(c.Config, c.Logger), "admin", "cashier"))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/pricelists/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdatePriceList(c.Config, c.Logger), "admin"))).Methods("PATCH", "OPTIONS")
+	router.Handle(prefix+"/api/pricelists/{id}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.DeletePriceList(c.Config, c.Logger), "admin"))).Methods("DELETE", "OPTIONS")
+	router.Handle(prefix+"/api/import/{kind}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.ImportCatalog(c.Config, c.Logger), "admin"))).Methods("POST", "OPTIONS")
+	router.Handle(prefix+"/api/export/{kind}", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.ExportCatalog(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/settings", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.GetSettings(c.Config, c.Logger), "admin"))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/settings", middlewares.AllowCors(auth_svc.AllowAnyOfRoles(handlers.UpdateSettings(c.Config, c.Logger), "admin"))).Methods("PATCH", "OPTIONS")
 	router.Handle(prefix+"/api/languages", middlewares.AllowCors(handlers.GetAvailableLanguages(c.Config, c.Logger))).Methods("GET", "OPTIONS")
 	router.Handle(prefix+"/api/languages/{code}", middlewares.AllowCors(handlers.GetLanguage(c.Config, c.Logger))).Methods("GET", "OPTIONS")
+	router.Handle(prefix+"/api/menu", middlewares.AllowCors(handlers.GetPublicMenu(c.Config, c.Logger, c.Settings))).Methods("GET", "OPTIONS")
 
 	if c.NotificationSvc == nil {
 		notification_service, err := services.SpawnNotificationSingletonSvc("melody", c.Logger, c.Config)
@@ -195,8 +287,10 @@ func (c *Core) RegisterHttpHandlers(router *mux.Router, prefix string) {
 		c.NotificationSvc = notification_service
 	}
 
-	// Serve static files from the "static" directory
-	router.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir("./public"))))
+	// Serve the uploaded files from the uploads directory when they're stored locally
+	if local_storage, ok := storage.StorageFactory(c.Config).(*storage.LocalStorage); ok {
+		router.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir(local_storage.Root()))))
+	}
 
 	router.Handle(prefix+"/ws", handlers.HandleNotificationsWsRequest(c.Config, c.Logger, c.NotificationSvc))
 }
@@ -0,0 +1,99 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// CatalogReference references a material or a product of the catalog by id, by the SKU of one of its entries,
+// or by name. They're tried in that order so a file exported from another branch resolves by SKU or name.
+type CatalogReference struct {
+	Id   string `json:"id,omitempty"`
+	SKU  string `json:"sku,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// CatalogMaterialEntry is an inventory entry of an imported or exported material.
+type CatalogMaterialEntry struct {
+	Id               string    `json:"id,omitempty"`
+	SKU              string    `json:"sku"`
+	Company          string    `json:"company"`
+	Quantity         float64   `json:"quantity"`
+	PurchaseQuantity float64   `json:"purchase_quantity"`
+	PurchasePrice    float64   `json:"purchase_price"`
+	ExpirationDate   time.Time `json:"expiration_date"`
+}
+
+// CatalogMaterial is a material of a catalog import or export, it's matched to the existing materials by id then by name.
+type CatalogMaterial struct {
+	// Row is the position of the material in the imported file, the line of its first row for a csv file.
+	Row          int                     `json:"-"`
+	Id           string                  `json:"id,omitempty"`
+	Name         string                  `json:"name"`
+	Description  string                  `json:"description,omitempty"`
+	Translations models.Translations     `json:"translations,omitempty"`
+	Category     string                  `json:"category"`
+	Unit         string                  `json:"unit"`
+	YieldPercent float64                 `json:"yield_percent"`
+	Settings     models.MaterialSettings `json:"settings"`
+	Nutrition    *models.NutritionFacts  `json:"nutrition,omitempty"`
+	Allergens    []string                `json:"allergens,omitempty"`
+	Diet         string                  `json:"diet,omitempty"`
+	Entries      []CatalogMaterialEntry  `json:"entries"`
+}
+
+// CatalogComponent is a material or a sub product of an imported or exported recipe with its net quantity.
+type CatalogComponent struct {
+	CatalogReference
+	Quantity     float64 `json:"quantity"`
+	YieldPercent float64 `json:"yield_percent,omitempty"`
+}
+
+// CatalogProduct is a product of a catalog import or export, it's matched to the existing products by id then by name.
+type CatalogProduct struct {
+	// Row is the position of the product in the imported file, the line of its first row for a csv file.
+	Row            int                 `json:"-"`
+	Id             string              `json:"id,omitempty"`
+	Name           string              `json:"name"`
+	Description    string              `json:"description,omitempty"`
+	Translations   models.Translations `json:"translations,omitempty"`
+	Price          float64             `json:"price"`
+	Unit           string              `json:"unit"`
+	ShelfLifeHours float64             `json:"shelf_life_hours"`
+	Materials      []CatalogComponent  `json:"materials"`
+	SubProducts    []CatalogComponent  `json:"sub_products"`
+}
+
+// CatalogCategory is a category of a catalog import or export, it's matched to the existing categories by id then by name.
+type CatalogCategory struct {
+	// Row is the position of the category in the imported file, the line of its first row for a csv file.
+	Row          int                 `json:"-"`
+	Id           string              `json:"id,omitempty"`
+	Name         string              `json:"name"`
+	Description  string              `json:"description,omitempty"`
+	Translations models.Translations `json:"translations,omitempty"`
+	Products     []CatalogReference  `json:"products"`
+}
+
+// ImportRowResult is the outcome of a single imported material, product or category.
+type ImportRowResult struct {
+	Row  int    `json:"row"`
+	Name string `json:"name"`
+	// Id is the id of the matched or the created record, it's empty when the row failed.
+	Id string `json:"id,omitempty"`
+	// Action is create, update or error.
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes a catalog import, a dry run reports what would be done without saving anything.
+type ImportReport struct {
+	Kind     string            `json:"kind"`
+	IsDryRun bool              `json:"is_dry_run"`
+	Total    int               `json:"total"`
+	Created  int               `json:"created"`
+	Updated  int               `json:"updated"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
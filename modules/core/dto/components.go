@@ -2,9 +2,20 @@
 // in the core module of nutrix.
 package dto
 
+import "github.com/elmawardy/nutrix/modules/core/models"
+
 // ComponentQuantity is a DTO containing the ID of a component and the
 // quantity of this component.
 type ComponentQuantity struct {
 	ComponentId string  `json:"component_id"` // The ID of the component.
 	Quantity    float64 `json:"quantity"`     // The quantity of the component.
 }
+
+// QuarantinedEntry is a DTO containing a quarantined material entry
+// with the information of the material it belongs to.
+type QuarantinedEntry struct {
+	MaterialId   string               `json:"material_id"`
+	MaterialName string               `json:"material_name"`
+	Unit         string               `json:"unit"`
+	Entry        models.MaterialEntry `json:"entry"`
+}
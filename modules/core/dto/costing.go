@@ -0,0 +1,78 @@
+package dto
+
+import "time"
+
+// MaterialPriceChange is a hypothetical change of the unit cost of a material, either by a percentage
+// like 20 for a 20% increase, or to a new unit cost.
+type MaterialPriceChange struct {
+	MaterialId    string   `json:"material_id"`
+	ChangePercent float64  `json:"change_percent"`
+	UnitCost      *float64 `json:"unit_cost,omitempty"`
+}
+
+// ScenarioComponentChange sets the net quantity of a material or a sub product in a recipe,
+// the component is added when the recipe doesn't have it and removed when the quantity is zero.
+type ScenarioComponentChange struct {
+	Id       string  `json:"id"`
+	Quantity float64 `json:"quantity"`
+}
+
+// RecipeChange is a hypothetical tweak of the components of a recipe.
+type RecipeChange struct {
+	ProductId   string                    `json:"product_id"`
+	Materials   []ScenarioComponentChange `json:"materials"`
+	SubProducts []ScenarioComponentChange `json:"sub_products"`
+}
+
+// SalePriceChange is a hypothetical change of the sale price of a product, either by a percentage or to a new price.
+type SalePriceChange struct {
+	ProductId     string   `json:"product_id"`
+	ChangePercent float64  `json:"change_percent"`
+	Price         *float64 `json:"price,omitempty"`
+}
+
+// CostingScenario is a DTO containing the hypothetical changes of a what-if costing simulation.
+type CostingScenario struct {
+	Materials []MaterialPriceChange `json:"materials"`
+	Recipes   []RecipeChange        `json:"recipes"`
+	Prices    []SalePriceChange     `json:"prices"`
+}
+
+// SimulatedProduct is a DTO containing the current and the simulated cost and margin of a single unit of a product,
+// and the projected impact of the scenario on its sales in the period.
+type SimulatedProduct struct {
+	ProductId   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Cost        float64 `json:"cost"`
+	NewCost     float64 `json:"new_cost"`
+	CostDelta   float64 `json:"cost_delta"`
+	Price       float64 `json:"price"`
+	NewPrice    float64 `json:"new_price"`
+	PriceDelta  float64 `json:"price_delta"`
+	// Margin is the contribution margin (sale price - cost) of a unit, MarginPercent is its percentage of the sale price.
+	Margin           float64 `json:"margin"`
+	NewMargin        float64 `json:"new_margin"`
+	MarginDelta      float64 `json:"margin_delta"`
+	MarginPercent    float64 `json:"margin_percent"`
+	NewMarginPercent float64 `json:"new_margin_percent"`
+	// UnitsSold are the units sold in the period, ProfitImpact is the change of their total margin had the
+	// scenario been in effect, with the same sales mix.
+	UnitsSold    float64 `json:"units_sold"`
+	ProfitImpact float64 `json:"profit_impact"`
+}
+
+// CostingSimulation is a DTO containing the products affected by a what-if scenario,
+// and its projected impact on the sales of the period.
+type CostingSimulation struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Revenue, Cost and Profit are the actual totals of the period, the New ones are projected with the scenario.
+	Revenue     float64            `json:"revenue"`
+	NewRevenue  float64            `json:"new_revenue"`
+	Cost        float64            `json:"cost"`
+	NewCost     float64            `json:"new_cost"`
+	Profit      float64            `json:"profit"`
+	NewProfit   float64            `json:"new_profit"`
+	ProfitDelta float64            `json:"profit_delta"`
+	Products    []SimulatedProduct `json:"products"`
+}
@@ -0,0 +1,40 @@
+package dto
+
+import "time"
+
+// GiftCardIssue is a DTO containing a gift card to sell, the code is generated when it's not set.
+type GiftCardIssue struct {
+	Code           string     `json:"code,omitempty"`
+	InitialBalance float64    `json:"initial_balance"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	CustomerId     string     `json:"customer_id,omitempty"`
+	Comment        string     `json:"comment,omitempty"`
+}
+
+// GiftCardDeactivation is a DTO containing the reason a gift card is deactivated.
+type GiftCardDeactivation struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// GiftCardBalance is the balance left on a gift card at the date of a liability report.
+type GiftCardBalance struct {
+	Id        string     `json:"id"`
+	Code      string     `json:"code"`
+	Balance   float64    `json:"balance"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// GiftCardLiability is a report of what's owed on the balances of the gift cards not redeemed by a date,
+// with the totals of the transactions up to it. The outstanding balance is what's issued and refunded
+// less what's redeemed, expired and deactivated.
+type GiftCardLiability struct {
+	AsOf        time.Time         `json:"as_of"`
+	Issued      float64           `json:"issued"`
+	Redeemed    float64           `json:"redeemed"`
+	Refunded    float64           `json:"refunded"`
+	Expired     float64           `json:"expired"`
+	Deactivated float64           `json:"deactivated"`
+	Outstanding float64           `json:"outstanding"`
+	Cards       []GiftCardBalance `json:"cards"`
+}
@@ -0,0 +1,63 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// EntryStockLevel is a DTO containing the quantity of a material entry derived from the ledger.
+type EntryStockLevel struct {
+	EntryId  string  `json:"entry_id"`
+	SKU      string  `json:"sku"`
+	Company  string  `json:"company"`
+	Quantity float64 `json:"quantity"`
+}
+
+// MaterialStockLevel is a DTO containing the quantity of a material, and of each of its entries, derived from the ledger.
+type MaterialStockLevel struct {
+	MaterialId   string            `json:"material_id"`
+	MaterialName string            `json:"material_name"`
+	Unit         string            `json:"unit"`
+	Quantity     float64           `json:"quantity"`
+	Entries      []EntryStockLevel `json:"entries"`
+}
+
+// StockAsOf is a DTO containing the stock of the inventory as of a time.
+type StockAsOf struct {
+	AsOf      time.Time            `json:"as_of"`
+	Materials []MaterialStockLevel `json:"materials"`
+}
+
+// LedgerDrift is a DTO describing an entry whose stored quantity doesn't match the sum of its ledger movements.
+type LedgerDrift struct {
+	MaterialId     string  `json:"material_id"`
+	MaterialName   string  `json:"material_name"`
+	EntryId        string  `json:"entry_id"`
+	StoredQuantity float64 `json:"stored_quantity"`
+	LedgerQuantity float64 `json:"ledger_quantity"`
+	// Drift is the stored quantity minus the ledger quantity.
+	Drift float64 `json:"drift"`
+	// HasMovements is false for the entries created before the ledger, they are backfilled on reconciliation.
+	HasMovements bool `json:"has_movements"`
+	// IsEntryMissing is set when the ledger has a balance for an entry that was removed from its material.
+	IsEntryMissing bool `json:"is_entry_missing"`
+}
+
+// InventoryMovementRequest is a DTO used in the request body of the POST /api/inventory/movements endpoint.
+// The destination material and entry are only used by transfers.
+type InventoryMovementRequest struct {
+	Type         string  `json:"type"`
+	MaterialId   string  `json:"material_id"`
+	EntryId      string  `json:"entry_id"`
+	Quantity     float64 `json:"quantity"`
+	Reason       string  `json:"reason"`
+	ToMaterialId string  `json:"to_material_id"`
+	ToEntryId    string  `json:"to_entry_id"`
+}
+
+// LedgerDriftServerMessage is the message sent by the server on the inventory_drift topic.
+type LedgerDriftServerMessage struct {
+	models.WebsocketTopicServerMessage `json:",inline"`
+	Drifts                             []LedgerDrift `json:"drifts"`
+}
@@ -0,0 +1,8 @@
+package dto
+
+// LoyaltyAdjustment is a DTO containing a manual correction of the loyalty points of a customer,
+// the points are positive to add points and negative to take them.
+type LoyaltyAdjustment struct {
+	Points  float64 `json:"points"`
+	Comment string  `json:"comment,omitempty"`
+}
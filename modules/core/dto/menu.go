@@ -0,0 +1,48 @@
+package dto
+
+// PublicMenuImage is a DTO containing the absolute urls of an image of a product on the public menu.
+type PublicMenuImage struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+// PublicMenuProduct is a DTO containing the fields of a product that are safe to show to the guests,
+// its materials and costs are never exposed.
+type PublicMenuProduct struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	Price        float64           `json:"price"`
+	ImageURL     string            `json:"image_url,omitempty"`
+	ThumbnailURL string            `json:"thumbnail_url,omitempty"`
+	Images       []PublicMenuImage `json:"images"`
+	// IsOnMenu is set when the product is served at the moment, IsAvailable when it can also be made from the stock.
+	IsOnMenu    bool     `json:"is_on_menu"`
+	IsAvailable bool     `json:"is_available"`
+	Reason      string   `json:"reason,omitempty"`
+	Allergens   []string `json:"allergens"`
+	DietaryTags []string `json:"dietary_tags"`
+	// EnergyKcal is only set when all the materials of the recipe tree have their nutrition facts.
+	EnergyKcal *float64 `json:"energy_kcal,omitempty"`
+}
+
+// PublicMenuCategory is a DTO containing a category of the public menu and its products.
+type PublicMenuCategory struct {
+	Id string `json:"id"`
+	// ParentId is the category this one is nested in, the subcategories follow their parent on the menu.
+	ParentId    string              `json:"parent_id,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Products    []PublicMenuProduct `json:"products"`
+}
+
+// PublicMenu is a DTO containing the public menu in a language, priced for a sales channel.
+type PublicMenu struct {
+	Language  string `json:"language"`
+	Direction string `json:"direction"`
+	// Labels are the translated labels of the menu from the language pack.
+	Labels       map[string]string    `json:"labels"`
+	PriceListId  string               `json:"price_list_id,omitempty"`
+	ServiceStyle string               `json:"service_style,omitempty"`
+	Categories   []PublicMenuCategory `json:"categories"`
+}
@@ -0,0 +1,66 @@
+package dto
+
+import "time"
+
+// Menu engineering classes, a product is popular when its share of the units sold reaches 70% of an even share,
+// and profitable when its contribution margin per unit reaches the average of its category.
+const (
+	MenuClassStar      = "star"
+	MenuClassPlowhorse = "plowhorse"
+	MenuClassPuzzle    = "puzzle"
+	MenuClassDog       = "dog"
+)
+
+// MenuPeriodStats is a DTO containing the sales of a product over a period and its menu engineering class.
+type MenuPeriodStats struct {
+	UnitsSold float64 `json:"units_sold"`
+	Revenue   float64 `json:"revenue"`
+	Cost      float64 `json:"cost"`
+	// UnitMargin is the average contribution margin (sale price - cost) of a single unit.
+	UnitMargin  float64 `json:"unit_margin"`
+	TotalMargin float64 `json:"total_margin"`
+	// MenuMix is the percentage of the units sold in the category that were of this product.
+	MenuMix float64 `json:"menu_mix"`
+	Class   string  `json:"class"`
+}
+
+// MenuEngineeringItem is a DTO containing the menu engineering analysis of a product within a category,
+// with its stats in the previous period of the same length to show the trend.
+type MenuEngineeringItem struct {
+	ProductId   string          `json:"product_id"`
+	ProductName string          `json:"product_name"`
+	Current     MenuPeriodStats `json:"current"`
+	Previous    MenuPeriodStats `json:"previous"`
+	// UnitsSoldChange and UnitMarginChange are the differences from the previous period.
+	UnitsSoldChange  float64 `json:"units_sold_change"`
+	UnitMarginChange float64 `json:"unit_margin_change"`
+	// IsClassChanged is set when the product moved to another quadrant since the previous period.
+	IsClassChanged bool `json:"is_class_changed"`
+}
+
+// MenuEngineeringCategory is a DTO containing the menu engineering analysis of the products of a category,
+// the products are classified against the thresholds of their category.
+type MenuEngineeringCategory struct {
+	CategoryId   string  `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	UnitsSold    float64 `json:"units_sold"`
+	TotalMargin  float64 `json:"total_margin"`
+	// PopularityThreshold is the menu mix percentage a product needs to be popular.
+	PopularityThreshold float64 `json:"popularity_threshold"`
+	// MarginThreshold is the average contribution margin per unit of the category.
+	MarginThreshold float64               `json:"margin_threshold"`
+	Stars           int                   `json:"stars"`
+	Plowhorses      int                   `json:"plowhorses"`
+	Puzzles         int                   `json:"puzzles"`
+	Dogs            int                   `json:"dogs"`
+	Items           []MenuEngineeringItem `json:"items"`
+}
+
+// MenuEngineeringReport is a DTO containing the menu engineering analysis of a period, by category.
+type MenuEngineeringReport struct {
+	From         time.Time                 `json:"from"`
+	To           time.Time                 `json:"to"`
+	PreviousFrom time.Time                 `json:"previous_from"`
+	PreviousTo   time.Time                 `json:"previous_to"`
+	Categories   []MenuEngineeringCategory `json:"categories"`
+}
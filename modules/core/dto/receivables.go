@@ -0,0 +1,56 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// CustomerPayment is a DTO containing a payment posted against the balance of a customer account.
+type CustomerPayment struct {
+	Amount float64 `json:"amount"`
+	Method string  `json:"method"`
+	// OrderId is the order the payment settles first, the rest of the payment settles the oldest charges.
+	OrderId string `json:"order_id,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// AgingBuckets are the amounts still owed of the charges of an account by their age in days.
+type AgingBuckets struct {
+	Current    float64 `json:"current"`
+	Days31To60 float64 `json:"days_31_60"`
+	Over60     float64 `json:"over_60"`
+	Total      float64 `json:"total"`
+}
+
+// CustomerAging is a DTO containing the aged balance of a customer account.
+type CustomerAging struct {
+	CustomerId   string   `json:"customer_id"`
+	CustomerName string   `json:"customer_name"`
+	Phone        string   `json:"phone"`
+	Balance      float64  `json:"balance"`
+	CreditLimit  *float64 `json:"credit_limit,omitempty"`
+	AgingBuckets
+	// OldestCharge is the date of the oldest charge that's still owed.
+	OldestCharge *time.Time `json:"oldest_charge,omitempty"`
+}
+
+// AgingReport is a DTO containing the aged balances of the customer accounts as of a date, the biggest debts first.
+type AgingReport struct {
+	AsOf      time.Time       `json:"as_of"`
+	Customers []CustomerAging `json:"customers"`
+	Totals    AgingBuckets    `json:"totals"`
+}
+
+// CustomerStatement is a DTO containing the entries of a customer account in a period,
+// between its opening and closing balances.
+type CustomerStatement struct {
+	Customer       models.Customer          `json:"customer"`
+	From           time.Time                `json:"from"`
+	To             time.Time                `json:"to"`
+	OpeningBalance float64                  `json:"opening_balance"`
+	ClosingBalance float64                  `json:"closing_balance"`
+	Entries        []models.ReceivableEntry `json:"entries"`
+	// Aging is the closing balance by the age of the charges at the end of the period.
+	Aging AgingBuckets `json:"aging"`
+}
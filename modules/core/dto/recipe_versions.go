@@ -0,0 +1,30 @@
+package dto
+
+// RecipeFieldChange is a DTO containing a field of a recipe that changed between two versions.
+type RecipeFieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RecipeComponentChange is a DTO containing a material or a sub product that was added to,
+// removed from or changed in a recipe between two versions.
+type RecipeComponentChange struct {
+	Id string `json:"id"`
+	// Change is one of added, removed or changed.
+	Change           string  `json:"change"`
+	FromQuantity     float64 `json:"from_quantity"`
+	ToQuantity       float64 `json:"to_quantity"`
+	FromYieldPercent float64 `json:"from_yield_percent"`
+	ToYieldPercent   float64 `json:"to_yield_percent"`
+}
+
+// RecipeVersionDiff is a DTO containing the changes of a recipe between two of its versions.
+type RecipeVersionDiff struct {
+	RecipeId    string                  `json:"recipe_id"`
+	From        int                     `json:"from"`
+	To          int                     `json:"to"`
+	Fields      []RecipeFieldChange     `json:"fields"`
+	Materials   []RecipeComponentChange `json:"materials"`
+	SubProducts []RecipeComponentChange `json:"sub_products"`
+}
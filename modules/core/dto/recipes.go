@@ -3,6 +3,8 @@
 // The component requirements are also included in this DTO.
 package dto
 
+import "time"
+
 // RecipeAvailability is a DTO containing the id of a recipe and the availability
 // of that recipe. The availability is a sum of the available and ready quantity.
 // The component requirements are also included in this DTO.
@@ -13,4 +15,46 @@ type RecipeAvailability struct {
 	Available             float64            `json:"available"`
 	Ready                 float64            `json:"ready"`
 	ComponentRequirements map[string]float64 `json:"component_requirements"`
+	// Bottleneck is the component limiting the number of units that can be made,
+	// it's nil when the recipe has no components.
+	Bottleneck *AvailabilityBottleneck `json:"bottleneck,omitempty"`
+	// ComputedAt is the time the availability was computed, it's older than the request when it's served from the cache.
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// Availability bottleneck types.
+const (
+	BottleneckTypeMaterial = "material"
+	BottleneckTypeProduct  = "product"
+)
+
+// AvailabilityBottleneck is a DTO describing the component that limits the availability of a recipe.
+type AvailabilityBottleneck struct {
+	ComponentId   string `json:"component_id"`
+	ComponentName string `json:"component_name"`
+	// Type is material, or product when the limit is the ready stock of a sub product without components to make more.
+	Type string `json:"type"`
+	// Available is the usable quantity of the material, or the ready stock of the product.
+	Available float64 `json:"available"`
+	// RequiredPerUnit is the quantity of the component needed to make a single unit of the recipe.
+	RequiredPerUnit float64 `json:"required_per_unit"`
+}
+
+// Recipe integrity problem types.
+const (
+	RecipeProblemCycle           = "cycle"
+	RecipeProblemMissingMaterial = "missing_material"
+	RecipeProblemMissingProduct  = "missing_product"
+	RecipeProblemTooDeep         = "too_deep"
+	RecipeProblemInvalidYield    = "invalid_yield"
+)
+
+// RecipeIntegrityProblem is a DTO describing a problem found in a recipe by the integrity check.
+type RecipeIntegrityProblem struct {
+	RecipeId   string `json:"recipe_id"`
+	RecipeName string `json:"recipe_name"`
+	Problem    string `json:"problem"`
+	Details    string `json:"details"`
+	// Path is the chain of recipe ids forming the cycle, it's only set for the cycle problems.
+	Path []string `json:"path,omitempty"`
 }
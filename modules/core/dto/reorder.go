@@ -0,0 +1,34 @@
+package dto
+
+import "github.com/elmawardy/nutrix/modules/core/models"
+
+// ReorderSuggestion is a DTO describing the consumption velocity of a material
+// and whether it should be reordered, and how much of it.
+type ReorderSuggestion struct {
+	MaterialId   string `json:"material_id"`
+	MaterialName string `json:"material_name"`
+	Unit         string `json:"unit"`
+	// Available is the current quantity of the material in the inventory.
+	Available float64 `json:"available"`
+	// AverageDailyUsage is the average consumed quantity per day over the lookback window.
+	AverageDailyUsage float64 `json:"average_daily_usage"`
+	// WeekdayAverageUsage is the average consumed quantity per weekday (Monday, Tuesday, ...),
+	// it's only filled when the suggestions are requested by weekday.
+	WeekdayAverageUsage map[string]float64 `json:"weekday_average_usage,omitempty"`
+	LeadTimeDays        float64            `json:"lead_time_days"`
+	SafetyStock         float64            `json:"safety_stock"`
+	// ReorderPoint is the quantity at which a new purchase should be placed.
+	ReorderPoint float64 `json:"reorder_point"`
+	// DaysOfStockLeft is the number of days the available quantity will last at the current velocity,
+	// it's -1 when the material has no recorded usage.
+	DaysOfStockLeft   float64 `json:"days_of_stock_left"`
+	SuggestedQuantity float64 `json:"suggested_quantity"`
+	IsReorderNeeded   bool    `json:"is_reorder_needed"`
+}
+
+// ReorderSuggestionsServerMessage is the message sent by the server on the
+// reorder_suggestions topic.
+type ReorderSuggestionsServerMessage struct {
+	models.WebsocketTopicServerMessage `json:",inline"`
+	Suggestions                        []ReorderSuggestion `json:"suggestions"`
+}
@@ -0,0 +1,98 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// TracedLot is a DTO describing a material entry (lot) reached by a traceability query.
+type TracedLot struct {
+	MaterialId     string    `json:"material_id"`
+	MaterialName   string    `json:"material_name"`
+	Unit           string    `json:"unit"`
+	EntryId        string    `json:"entry_id"`
+	SKU            string    `json:"sku"`
+	Company        string    `json:"company"`
+	ReceivedAt     time.Time `json:"received_at"`
+	ExpirationDate time.Time `json:"expiration_date"`
+}
+
+// TracedRecipe is a DTO containing the quantity of the traced lots consumed by a recipe.
+type TracedRecipe struct {
+	RecipeId   string  `json:"recipe_id"`
+	RecipeName string  `json:"recipe_name"`
+	Quantity   float64 `json:"quantity"`
+	// IsStockedReady is set when the recipe has ready stock not backed by a production batch consumed after
+	// the lots were used in it, the orders served from that ready stock are reported as possibly affected.
+	IsStockedReady bool `json:"is_stocked_ready"`
+}
+
+// TracedBatch is a DTO describing a production batch that consumed the traced lots.
+type TracedBatch struct {
+	BatchId        string    `json:"batch_id"`
+	ProductId      string    `json:"product_id"`
+	ProductName    string    `json:"product_name"`
+	Quantity       float64   `json:"quantity"`
+	ProducedAt     time.Time `json:"produced_at"`
+	ExpirationDate time.Time `json:"expiration_date"`
+	IsExpired      bool      `json:"is_expired"`
+}
+
+// TracedOrder is a DTO describing an order that received the traced lots.
+type TracedOrder struct {
+	OrderId     string          `json:"order_id"`
+	DisplayId   string          `json:"display_id"`
+	SubmittedAt time.Time       `json:"submitted_at"`
+	State       string          `json:"state"`
+	Customer    models.Customer `json:"customer"`
+	RecipeIds   []string        `json:"recipe_ids"`
+	// Quantity is the quantity of the traced lots consumed directly by the order.
+	Quantity float64 `json:"quantity"`
+	// ViaBatchIds are the production batches served to the order that contain the traced lots.
+	ViaBatchIds []string `json:"via_batch_ids,omitempty"`
+	// ViaReadyProductIds are the ready products not backed by a batch served to the order that may contain the traced lots.
+	ViaReadyProductIds []string `json:"via_ready_product_ids,omitempty"`
+}
+
+// LotTrace is a DTO containing the forward trace of lots, to the recipes, orders and customers that received them.
+type LotTrace struct {
+	Lots      []TracedLot       `json:"lots"`
+	Recipes   []TracedRecipe    `json:"recipes"`
+	Batches   []TracedBatch     `json:"batches"`
+	Orders    []TracedOrder     `json:"orders"`
+	Customers []models.Customer `json:"customers"`
+	// TotalConsumed is the total quantity of the traced lots consumed by orders.
+	TotalConsumed float64 `json:"total_consumed"`
+}
+
+// TracedConsumption is a DTO describing the quantity of a lot consumed by an order item.
+type TracedConsumption struct {
+	Lot        TracedLot `json:"lot"`
+	RecipeId   string    `json:"recipe_id"`
+	RecipeName string    `json:"recipe_name"`
+	Quantity   float64   `json:"quantity"`
+	Date       time.Time `json:"date"`
+	// ViaBatchId is the production batch served to the order that consumed the lot.
+	ViaBatchId string `json:"via_batch_id,omitempty"`
+}
+
+// TracedReadyConsumption is a DTO describing a quantity of a product served from its ready stock.
+type TracedReadyConsumption struct {
+	ProductId string `json:"product_id"`
+	// BatchId is empty for the ready stock not backed by a production batch.
+	BatchId     string    `json:"batch_id,omitempty"`
+	ProductName string    `json:"product_name"`
+	Quantity    float64   `json:"quantity"`
+	Date        time.Time `json:"date"`
+}
+
+// OrderLotTrace is a DTO containing the backward trace of an order, to the lots it consumed.
+type OrderLotTrace struct {
+	OrderId      string                   `json:"order_id"`
+	DisplayId    string                   `json:"display_id"`
+	SubmittedAt  time.Time                `json:"submitted_at"`
+	Customer     models.Customer          `json:"customer"`
+	Consumptions []TracedConsumption      `json:"consumptions"`
+	Ready        []TracedReadyConsumption `json:"ready"`
+}
@@ -0,0 +1,44 @@
+package dto
+
+import "time"
+
+// MaterialValuation is a DTO containing the stocked quantity of a material and its value.
+type MaterialValuation struct {
+	MaterialId   string  `json:"material_id"`
+	MaterialName string  `json:"material_name"`
+	Category     string  `json:"category"`
+	Unit         string  `json:"unit"`
+	Quantity     float64 `json:"quantity"`
+	// UnitCost is the average value of a single unit of the stocked quantity.
+	UnitCost float64 `json:"unit_cost"`
+	Value    float64 `json:"value"`
+}
+
+// CategoryValuation is a DTO containing the total stock value of the materials in a category.
+type CategoryValuation struct {
+	Category       string  `json:"category"`
+	MaterialsCount int     `json:"materials_count"`
+	Value          float64 `json:"value"`
+}
+
+// ProductValuation is a DTO containing the ready stock of a product held in production batches and its value.
+type ProductValuation struct {
+	ProductId   string  `json:"product_id"`
+	ProductName string  `json:"product_name"`
+	Quantity    float64 `json:"quantity"`
+	UnitCost    float64 `json:"unit_cost"`
+	Value       float64 `json:"value"`
+}
+
+// InventoryValuation is a DTO containing the total value of the inventory as of a date,
+// broken down by material and by category.
+type InventoryValuation struct {
+	AsOf          time.Time           `json:"as_of"`
+	CostingMethod string              `json:"costing_method"`
+	TotalValue    float64             `json:"total_value"`
+	Materials     []MaterialValuation `json:"materials"`
+	Categories    []CategoryValuation `json:"categories"`
+	// ReadyValue is the value of the ready stock held in production batches, it's part of the total value.
+	ReadyValue float64            `json:"ready_value"`
+	Products   []ProductValuation `json:"products"`
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	auth_mw "github.com/elmawardy/nutrix/modules/auth/middlewares"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+)
+
+// maxCatalogFileBytes is the maximum size of an imported catalog file.
+const maxCatalogFileBytes = 32 << 20
+
+// catalogFormat returns the format of a catalog file from its name or its content type, json is the default.
+func catalogFormat(file_name string, content_type string) string {
+
+	if extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(file_name)), "."); extension != "" {
+		return extension
+	}
+
+	media_type, _, _ := mime.ParseMediaType(content_type)
+	if strings.HasSuffix(media_type, "csv") {
+		return services.CatalogFormatCSV
+	}
+
+	return services.CatalogFormatJSON
+}
+
+// ImportCatalog returns a HTTP handler function to import the materials, the products or the categories
+// from a csv or json file. The file is either the request body or the file field of a multipart form,
+// its format is taken from the format query parameter, the file name or the content type.
+func ImportCatalog(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		kind := mux.Vars(r)["kind"]
+
+		dry_run := false
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			var err error
+			dry_run, err = strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid dry_run", http.StatusBadRequest)
+				return
+			}
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxCatalogFileBytes)
+
+		var file io.Reader = r.Body
+		format := catalogFormat("", r.Header.Get("Content-Type"))
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			form_file, file_header, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer form_file.Close()
+
+			file = form_file
+			format = catalogFormat(file_header.Filename, file_header.Header.Get("Content-Type"))
+		}
+
+		if value := r.URL.Query().Get("format"); value != "" {
+			format = strings.ToLower(value)
+		}
+
+		catalog_svc := services.CatalogService{
+			Logger: logger,
+			Config: config,
+		}
+
+		report, err := catalog_svc.Import(kind, format, file, dry_run, auth_mw.UserId(r.Context()))
+
+		var max_bytes_error *http.MaxBytesError
+		if errors.As(err, &max_bytes_error) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: report,
+			Meta: JSONAPIMeta{
+				TotalRecords: report.Total,
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, "Failed to marshal import report response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// ExportCatalog returns a HTTP handler function to download the materials, the products or the categories
+// as a csv or json file that can be imported back, the format query parameter is json by default.
+func ExportCatalog(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		kind := mux.Vars(r)["kind"]
+
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		if format == "" {
+			format = services.CatalogFormatJSON
+		}
+
+		catalog_svc := services.CatalogService{
+			Logger: logger,
+			Config: config,
+		}
+
+		var file bytes.Buffer
+
+		err := catalog_svc.Export(kind, format, &file)
+		if errors.Is(err, customerrors.ErrInvalidImport) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		content_type := "application/json"
+		if format == services.CatalogFormatCSV {
+			content_type = "text/csv"
+		}
+
+		w.Header().Set("Content-Type", content_type)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", kind+"."+format))
+		w.Write(file.Bytes())
+	}
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
@@ -25,7 +28,7 @@ type JSONApiOkResponse struct {
 }
 
 // InsertCategory returns a HTTP handler function to insert a Category into the database.
-func InsertCategory(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func InsertCategory(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		request := struct {
@@ -38,12 +41,18 @@ func InsertCategory(config config.Config, logger logger.ILogger) http.HandlerFun
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).NormalizeCategory(&request.Data)
+
 		categoryService := services.CategoryService{
 			Logger: logger,
 			Config: config,
 		}
 
 		err = categoryService.InsertCategory(request.Data)
+		if errors.Is(err, customerrors.ErrInvalidSchedule) || errors.Is(err, customerrors.ErrInvalidCategory) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -76,7 +85,7 @@ func DeleteCategory(config config.Config, logger logger.ILogger) http.HandlerFun
 }
 
 // UpdateCategory returns a HTTP handler function to update a Category in the database.
-func UpdateCategory(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func UpdateCategory(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		body := struct {
@@ -89,17 +98,26 @@ func UpdateCategory(config config.Config, logger logger.ILogger) http.HandlerFun
 			return
 		}
 
+		localizer := catalogLocalizer(r, config, logger, settings)
+		localizer.NormalizeCategory(&body.Data)
+
 		categoryService := services.CategoryService{
 			Logger: logger,
 			Config: config,
 		}
 
 		category, err := categoryService.UpdateCategory(body.Data)
+		if errors.Is(err, customerrors.ErrInvalidSchedule) || errors.Is(err, customerrors.ErrInvalidCategory) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		localizer.LocalizeCategory(&category)
+
 		w.WriteHeader(http.StatusCreated)
 		jsonResponse, err := json.Marshal(JSONApiOkResponse{
 			Data: category,
@@ -120,7 +138,10 @@ func UpdateCategory(config config.Config, logger logger.ILogger) http.HandlerFun
 }
 
 // GetCategories returns a HTTP handler function to retrieve a list of Categories from the database.
-func GetCategories(config config.Config, logger logger.ILogger) http.HandlerFunc {
+//
+// The categories are returned as a flat page, or nested in their parents when the tree query param is true.
+// The channel query param leaves out the categories hidden on a service style.
+func GetCategories(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -134,32 +155,29 @@ func GetCategories(config config.Config, logger logger.ILogger) http.HandlerFunc
 			page_size = 50
 		}
 
+		channel := r.URL.Query().Get("channel")
+		if channel != "" && !services.IsCategoryChannel(channel) {
+			http.Error(w, fmt.Sprintf("%s: unknown channel %s", customerrors.ErrInvalidCategory, channel), http.StatusBadRequest)
+			return
+		}
+
 		categoryService := services.CategoryService{
 			Logger: logger,
 			Config: config,
 		}
 
-		categories, err := categoryService.GetCategories(page_number, page_size)
+		var categories []models.Category
+		if r.URL.Query().Get("tree") == "true" {
+			categories, err = categoryService.GetCategoryTree(channel)
+		} else {
+			categories, err = categoryService.GetCategories(page_number, page_size, channel)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		product_svc := services.RecipeService{
-			Logger: logger,
-			Config: config,
-		}
-
-		for i, category := range categories {
-			for j, product := range category.Products {
-				product, err := product_svc.GetProduct(product.Id)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				categories[i].Products[j] = product
-			}
-		}
+		catalogLocalizer(r, config, logger, settings).LocalizeCategories(categories)
 
 		response := JSONApiOkResponse{
 			Data: categories,
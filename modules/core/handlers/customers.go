@@ -10,6 +10,7 @@ import (
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func DeleteCustomer(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
@@ -53,6 +54,9 @@ func GetCustomers(config config.Config, logger logger.ILogger, settings models.S
 			params.PageSize = page_size
 		}
 
+		params.Search = r.URL.Query().Get("filter[search]")
+		params.Phone = r.URL.Query().Get("filter[phone]")
+
 		customers_svc := services.CustomersService{
 			Logger:   logger,
 			Config:   config,
@@ -191,3 +195,54 @@ func GetCustomer(config config.Config, logger logger.ILogger) http.HandlerFunc {
 		w.Write(jsonResponse)
 	}
 }
+
+// GetCustomerOrders returns a HTTP handler function to retrieve the order history of a customer, the newest first.
+func GetCustomerOrders(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		id_param := params["id"]
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		customers_svc := services.CustomersService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		orders, total_records, err := customers_svc.GetCustomerOrders(id_param, page_number, page_size)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "customer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: orders,
+			Meta: JSONAPIMeta{
+				TotalRecords: total_records,
+				PageNumber:   page_number,
+				PageSize:     page_size,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+)
+
+// IssueGiftCard returns a HTTP handler function to sell a gift card.
+func IssueGiftCard(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		request := struct {
+			Data dto.GiftCardIssue `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		card, err := gift_card_svc.IssueGiftCard(request.Data)
+		if errors.Is(err, customerrors.ErrInvalidGiftCard) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: card,
+			Meta: JSONAPIMeta{
+				TotalRecords: 1,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// GetGiftCards returns a HTTP handler function to retrieve a page of the gift cards, the newest first.
+// It accepts optional filter[search] (the beginning of the code) and filter[state] (active or closed) query strings.
+func GetGiftCards(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		state := r.URL.Query().Get("filter[state]")
+		if state != "" && state != "active" && state != "closed" {
+			http.Error(w, "filter[state] must be active or closed", http.StatusBadRequest)
+			return
+		}
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		cards, total_records, err := gift_card_svc.GetGiftCards(services.GetGiftCardsParams{
+			Search:     r.URL.Query().Get("filter[search]"),
+			State:      state,
+			PageNumber: page_number,
+			PageSize:   page_size,
+		})
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: cards,
+			Meta: JSONAPIMeta{
+				TotalRecords: total_records,
+				PageNumber:   page_number,
+				PageSize:     page_size,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetGiftCard returns a HTTP handler function to look up the balance of a gift card by its code.
+func GetGiftCard(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		card, err := gift_card_svc.GetGiftCard(params["code"])
+		if errors.Is(err, customerrors.ErrGiftCardNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: card,
+			Meta: JSONAPIMeta{
+				TotalRecords: 1,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetGiftCardTransactions returns a HTTP handler function to retrieve the transactions of a gift card, the newest first.
+func GetGiftCardTransactions(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		transactions, total_records, err := gift_card_svc.GetTransactions(params["code"], page_number, page_size)
+		if errors.Is(err, customerrors.ErrGiftCardNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: transactions,
+			Meta: JSONAPIMeta{
+				TotalRecords: total_records,
+				PageNumber:   page_number,
+				PageSize:     page_size,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// DeactivateGiftCard returns a HTTP handler function to deactivate a gift card, the balance left on it is taken.
+// The request body with the reason of the deactivation is optional.
+func DeactivateGiftCard(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		request := struct {
+			Data dto.GiftCardDeactivation `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		card, err := gift_card_svc.DeactivateGiftCard(params["code"], request.Data)
+		if errors.Is(err, customerrors.ErrGiftCardNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrGiftCardUnavailable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: card,
+			Meta: JSONAPIMeta{
+				TotalRecords: 1,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetGiftCardLiability returns a HTTP handler function to retrieve the liability of the gift card balances.
+// It accepts an optional as_of query string (RFC3339 or 2006-01-02), the current time is used when it's not set.
+func GetGiftCardLiability(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		as_of := time.Now()
+
+		if as_of_param := r.URL.Query().Get("as_of"); as_of_param != "" {
+			t, err := parseDateParam(as_of_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid as_of date", http.StatusBadRequest)
+				return
+			}
+			as_of = t
+		}
+
+		gift_card_svc := services.GiftCardService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		report, err := gift_card_svc.GetLiabilityReport(as_of)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: report,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(report.Cards),
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// readImageUpload reads the image field of the multipart form, the body is limited
+// to the configured maximum image size and some room for the other fields.
+func readImageUpload(w http.ResponseWriter, r *http.Request, config config.Config) ([]byte, error) {
+
+	max_bytes := services.MaxImageBytes(config)
+	r.Body = http.MaxBytesReader(w, r.Body, max_bytes+(1<<20))
+
+	err := r.ParseMultipartForm(max_bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// one more byte is read so an image over the limit is rejected instead of cut
+	return io.ReadAll(io.LimitReader(file, max_bytes+1))
+}
+
+// writeImageError writes the status of an image upload or image management error.
+func writeImageError(w http.ResponseWriter, logger logger.ILogger, err error) {
+
+	var max_bytes_error *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &max_bytes_error) || errors.Is(err, customerrors.ErrImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, customerrors.ErrInvalidImage) || errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == mongo.ErrNoDocuments:
+		http.Error(w, "product or image not found", http.StatusNotFound)
+	default:
+		logger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// AddProductImage returns a HTTP handler function to upload an additional image of a product,
+// the image is made the primary one when the is_primary form field is true.
+func AddProductImage(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		id_param := params["id"]
+
+		data, err := readImageUpload(w, r, config)
+		if err != nil {
+			writeImageError(w, logger, err)
+			return
+		}
+
+		is_primary := false
+		if value := r.FormValue("is_primary"); value != "" {
+			is_primary, err = strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid is_primary", http.StatusBadRequest)
+				return
+			}
+		}
+
+		recipe_svc := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		product, err := recipe_svc.AddProductImage(id_param, data, is_primary)
+		if err != nil {
+			writeImageError(w, logger, err)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: product,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, "Failed to marshal product response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// DeleteProductImage returns a HTTP handler function to delete an image of a product and its files.
+func DeleteProductImage(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		recipe_svc := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		_, err := recipe_svc.DeleteProductImage(params["id"], params["image_id"])
+		if err != nil {
+			writeImageError(w, logger, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetPrimaryProductImage returns a HTTP handler function to make an image the primary image of a product.
+func SetPrimaryProductImage(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		recipe_svc := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		product, err := recipe_svc.SetPrimaryProductImage(params["id"], params["image_id"])
+		if err != nil {
+			writeImageError(w, logger, err)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: product,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, "Failed to marshal product response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
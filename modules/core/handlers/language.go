@@ -5,13 +5,57 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/elmawardy/nutrix/common/config"
 	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
 	"github.com/gorilla/mux"
 )
 
+// requestLanguage returns the language the response is requested in, from the lang query param, the Accept-Language header
+// or else the default language of the settings. Only the codes that have a language pack are picked.
+func requestLanguage(r *http.Request, lang_svc services.LanguageService, settings models.Settings) string {
+
+	codes := []string{r.URL.Query().Get("lang")}
+
+	for _, lang := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		code := strings.TrimSpace(strings.Split(lang, ";")[0])
+		code = strings.ToLower(strings.Split(code, "-")[0])
+		codes = append(codes, code)
+	}
+
+	for _, code := range codes {
+		if code == "" || code == "*" {
+			continue
+		}
+
+		lang, err := lang_svc.GetLanguage(code)
+		if err == nil && lang.Code != "" {
+			return lang.Code
+		}
+	}
+
+	if settings.Language.Code != "" {
+		return settings.Language.Code
+	}
+
+	return "en"
+}
+
+// catalogLocalizer returns a localizer to the language the catalog is requested in.
+func catalogLocalizer(r *http.Request, config config.Config, logger logger.ILogger, settings models.Settings) services.CatalogLocalizer {
+
+	lang_svc := services.LanguageService{
+		Config:   config,
+		Logger:   logger,
+		Settings: settings,
+	}
+
+	return services.NewCatalogLocalizer(requestLanguage(r, lang_svc, settings), settings)
+}
+
 // GetLanguage  is an http handler that receives a lang code like "en" or "ar" and reads the
 // related language pack json file and return it as response.
 func GetLanguage(config config.Config, logger logger.ILogger) http.HandlerFunc {
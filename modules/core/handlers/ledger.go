@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+)
+
+// ledgerErrorStatus returns the HTTP status code of a ledger error.
+func ledgerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, customerrors.ErrInvalidMovement):
+		return http.StatusBadRequest
+	case errors.Is(err, customerrors.ErrEntryNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetInventoryMovements returns a HTTP handler function to retrieve the inventory ledger movements, the newest first.
+// It accepts the following optional query string parameters:
+// page[number], page[size]: the pagination of the movements
+// filter[material_id], filter[entry_id], filter[type]: filter the movements
+// filter[from], filter[to]: limit the movements date (RFC3339 or 2006-01-02)
+func GetInventoryMovements(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		params := services.GetMovementsParams{
+			MaterialId: r.URL.Query().Get("filter[material_id]"),
+			EntryId:    r.URL.Query().Get("filter[entry_id]"),
+			Type:       r.URL.Query().Get("filter[type]"),
+			PageNumber: page_number,
+			PageSize:   page_size,
+		}
+
+		if from := r.URL.Query().Get("filter[from]"); from != "" {
+			params.From, err = parseDateParam(from, config, false)
+			if err != nil {
+				http.Error(w, "invalid filter[from] date", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if to := r.URL.Query().Get("filter[to]"); to != "" {
+			params.To, err = parseDateParam(to, config, true)
+			if err != nil {
+				http.Error(w, "invalid filter[to] date", http.StatusBadRequest)
+				return
+			}
+		}
+
+		ledgerService := services.LedgerService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		movements, total_records, err := ledgerService.GetMovements(params)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: movements,
+			Meta: JSONAPIMeta{
+				TotalRecords: int(total_records),
+				PageNumber:   page_number,
+				PageSize:     page_size,
+				PageCount:    int(math.Ceil(float64(total_records) / float64(page_size))),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// RecordInventoryMovement returns a HTTP handler function to record a manual movement in the inventory ledger.
+// Only return, waste, adjustment and transfer movements can be recorded manually, receipts are recorded when
+// entries are added to a material, and consumptions when orders are started.
+func RecordInventoryMovement(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		request := struct {
+			Data dto.InventoryMovementRequest `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ledgerService := services.LedgerService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		movements := make([]models.InventoryMovement, 0)
+
+		switch request.Data.Type {
+		case models.MovementTypeTransfer:
+			movements, err = ledgerService.RecordTransfer(
+				request.Data.MaterialId,
+				request.Data.EntryId,
+				request.Data.ToMaterialId,
+				request.Data.ToEntryId,
+				request.Data.Quantity,
+				request.Data.Reason,
+			)
+
+		case models.MovementTypeReturn, models.MovementTypeWaste, models.MovementTypeAdjustment:
+			var movement models.InventoryMovement
+			movement, err = ledgerService.RecordMovement(models.InventoryMovement{
+				Type:       request.Data.Type,
+				MaterialId: request.Data.MaterialId,
+				EntryId:    request.Data.EntryId,
+				Quantity:   request.Data.Quantity,
+				Reason:     request.Data.Reason,
+			})
+			if err == nil {
+				movements = append(movements, movement)
+			}
+
+		default:
+			http.Error(w, "type must be one of return, waste, adjustment or transfer", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), ledgerErrorStatus(err))
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: movements,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// GetStockAsOf returns a HTTP handler function to retrieve the stock of the inventory derived from the ledger.
+// It accepts an optional as_of query string (RFC3339 or 2006-01-02), the current time is used when it's not set.
+func GetStockAsOf(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		as_of := time.Now()
+
+		if as_of_param := r.URL.Query().Get("as_of"); as_of_param != "" {
+			t, err := parseDateParam(as_of_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid as_of date", http.StatusBadRequest)
+				return
+			}
+			as_of = t
+		}
+
+		ledgerService := services.LedgerService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		stock, err := ledgerService.GetStockAsOf(as_of)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: stock,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetLedgerConsistency returns a HTTP handler function to retrieve the entries whose stored quantity
+// drifted from their ledger quantity.
+func GetLedgerConsistency(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		ledgerService := services.LedgerService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		drifts, err := ledgerService.CheckConsistency()
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: drifts,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(drifts),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// ReconcileLedger returns a HTTP handler function to fix the drifts between the stored quantities and the ledger.
+// It requires a source query string: ledger (overwrite the stored quantities) or stored (record adjustment movements).
+func ReconcileLedger(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		source := r.URL.Query().Get("source")
+		if source != services.ReconcileFromLedger && source != services.ReconcileFromStored {
+			http.Error(w, "source must be ledger or stored", http.StatusBadRequest)
+			return
+		}
+
+		ledgerService := services.LedgerService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		drifts, err := ledgerService.Reconcile(source)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: drifts,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(drifts),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
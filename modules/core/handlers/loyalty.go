@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetCustomerLoyalty returns a HTTP handler function to retrieve the loyalty points ledger of a customer, the newest first.
+func GetCustomerLoyalty(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		loyalty_svc := services.LoyaltyService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		entries, total_records, err := loyalty_svc.GetEntries(services.GetLoyaltyEntriesParams{
+			CustomerId: params["id"],
+			PageNumber: page_number,
+			PageSize:   page_size,
+		})
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "customer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: entries,
+			Meta: JSONAPIMeta{
+				TotalRecords: total_records,
+				PageNumber:   page_number,
+				PageSize:     page_size,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// AdjustCustomerLoyalty returns a HTTP handler function to add or take loyalty points of a customer by hand.
+func AdjustCustomerLoyalty(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		request := struct {
+			Data dto.LoyaltyAdjustment `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		loyalty_svc := services.LoyaltyService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		entry, err := loyalty_svc.AdjustPoints(params["id"], request.Data)
+		if errors.Is(err, customerrors.ErrInvalidRedemption) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, customerrors.ErrCustomerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInsufficientPoints) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: entry,
+			Meta: JSONAPIMeta{
+				TotalRecords: 1,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
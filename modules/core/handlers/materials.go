@@ -11,11 +11,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
@@ -26,7 +29,7 @@ import (
 //
 // This handler retrieves the entry ID, material ID, and quantity from the query string,
 // and uses the MaterialService to calculate and return the cost.
-func CalculateMaterialCost(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func CalculateMaterialCost(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		params := mux.Vars(r)
@@ -48,8 +51,9 @@ func CalculateMaterialCost(config config.Config, logger logger.ILogger) http.Han
 		}
 
 		materialService := services.MaterialService{
-			Logger: logger,
-			Config: config,
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
 		}
 
 		cost, err := materialService.CalculateMaterialCost(entry_id_param, material_id_param, quantity)
@@ -75,7 +79,7 @@ func CalculateMaterialCost(config config.Config, logger logger.ILogger) http.Han
 }
 
 // GetMaterials returns a HTTP handler function to retrieve a list of materials from the database.
-func GetMaterials(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func GetMaterials(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -100,6 +104,8 @@ func GetMaterials(config config.Config, logger logger.ILogger) http.HandlerFunc
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).LocalizeMaterials(materials)
+
 		response := JSONApiOkResponse{
 			Data: materials,
 			Meta: JSONAPIMeta{
@@ -123,7 +129,7 @@ func GetMaterials(config config.Config, logger logger.ILogger) http.HandlerFunc
 }
 
 // AddMaterial returns a HTTP handler function to add a new material to the database.
-func AddMaterial(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func AddMaterial(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Parse the request body into a DBComponent struct
@@ -142,11 +148,17 @@ func AddMaterial(config config.Config, logger logger.ILogger) http.HandlerFunc {
 			request.Data.Entries[index].PurchaseQuantity = entry.Quantity
 		}
 
+		catalogLocalizer(r, config, logger, settings).NormalizeMaterial(&request.Data)
+
 		materialService := services.MaterialService{
 			Logger: logger,
 			Config: config,
 		}
 		err = materialService.AddComponent(request.Data)
+		if errors.Is(err, customerrors.ErrInvalidNutrition) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -185,7 +197,7 @@ func DeleteEntry(config config.Config, logger logger.ILogger) http.HandlerFunc {
 }
 
 // EditMaterial returns a HTTP handler function to edit an existing material in the database.
-func EditMaterial(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func EditMaterial(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		params := mux.Vars(r)
@@ -201,6 +213,8 @@ func EditMaterial(config config.Config, logger logger.ILogger) http.HandlerFunc
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).NormalizeMaterial(&request.Data)
+
 		materialService := services.MaterialService{
 			Logger: logger,
 			Config: config,
@@ -294,3 +308,116 @@ func GetMaterialLogs(config config.Config, logger logger.ILogger) http.HandlerFu
 		w.Write(jsonLogs)
 	}
 }
+
+// GetQuarantinedEntries returns a HTTP handler function to retrieve the quarantined material entries awaiting review.
+func GetQuarantinedEntries(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		materialService := services.MaterialService{
+			Logger: logger,
+			Config: config,
+		}
+
+		entries, err := materialService.GetQuarantinedEntries()
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: entries,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(entries),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// WasteEntry returns a HTTP handler function to write off the remaining quantity of a quarantined entry.
+func WasteEntry(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		material_id_param := params["material_id"]
+		entry_id_param := params["entry_id"]
+
+		request := struct {
+			Data struct {
+				Reason string `json:"reason"`
+			} `json:"data"`
+		}{}
+
+		if r.ContentLength > 0 {
+			err := json.NewDecoder(r.Body).Decode(&request)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		materialService := services.MaterialService{
+			Logger: logger,
+			Config: config,
+		}
+
+		err := materialService.WasteEntry(material_id_param, entry_id_param, request.Data.Reason)
+		if errors.Is(err, customerrors.ErrEntryNotQuarantined) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ExtendEntryExpiry returns a HTTP handler function to extend the expiration date of an entry
+// and release it from the quarantine.
+func ExtendEntryExpiry(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		material_id_param := params["material_id"]
+		entry_id_param := params["entry_id"]
+
+		request := struct {
+			Data struct {
+				ExpirationDate time.Time `json:"expiration_date"`
+			} `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		materialService := services.MaterialService{
+			Logger: logger,
+			Config: config,
+		}
+
+		err = materialService.ExtendEntryExpiry(material_id_param, entry_id_param, request.Data.ExpirationDate)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+)
+
+// GetPublicMenu returns a HTTP handler function to retrieve the public menu for the QR menus and the menu boards.
+// It doesn't need authentication and only exposes the fields safe to show to the guests.
+// The menu is returned as json, or rendered as html or pdf with the format query param,
+// and it's cacheable with its ETag.
+func GetPublicMenu(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = services.MenuFormatJSON
+		}
+
+		if format != services.MenuFormatJSON && format != services.MenuFormatHTML && format != services.MenuFormatPDF {
+			http.Error(w, "format must be json, html or pdf", http.StatusBadRequest)
+			return
+		}
+
+		lang_svc := services.LanguageService{
+			Config:   config,
+			Logger:   logger,
+			Settings: settings,
+		}
+
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+
+		menuService := services.MenuService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		menu, err := menuService.GetPublicMenu(services.PublicMenuParams{
+			PriceListId:  r.URL.Query().Get("price_list_id"),
+			ServiceStyle: r.URL.Query().Get("service_style"),
+			LangCode:     requestLanguage(r, lang_svc, settings),
+			AssetBaseURL: fmt.Sprintf("%s://%s", scheme, r.Host),
+		})
+		if errors.Is(err, customerrors.ErrInvalidPriceList) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		etag, err := services.MenuETag(menu, format)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			for _, tag := range strings.Split(match, ",") {
+				tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+				if tag == etag || tag == "*" {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+		}
+
+		if format != services.MenuFormatJSON {
+
+			rendered, err := menuService.RenderMenu(menu, format, etag)
+			if err != nil {
+				logger.Error(err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if format == services.MenuFormatPDF {
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", "inline; filename=\"menu.pdf\"")
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			}
+
+			w.Write(rendered)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: menu,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -12,6 +12,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"math"
 	"net/http"
@@ -20,6 +21,7 @@ import (
 	"strings"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
@@ -257,15 +259,16 @@ func CancelOrder(config config.Config, logger logger.ILogger) http.HandlerFunc {
 }
 
 // FinishOrder returns a HTTP handler function to finish an order.
-func FinishOrder(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func FinishOrder(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		params := mux.Vars(r)
 		id_param := params["id"]
 
 		orderService := services.OrderService{
-			Logger: logger,
-			Config: config,
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
 		}
 
 		err := orderService.FinishOrder(id_param)
@@ -334,11 +337,22 @@ func SubmitOrder(config config.Config, logger logger.ILogger, settings models.Se
 		}
 
 		orderService := services.OrderService{
-			Logger: logger,
-			Config: config,
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
 		}
 
 		order, err = orderService.SubmitOrder(request.Data)
+		if errors.Is(err, customerrors.ErrInvalidPriceList) || errors.Is(err, customerrors.ErrCustomerNotFound) || errors.Is(err, customerrors.ErrInvalidRedemption) ||
+			errors.Is(err, customerrors.ErrInvalidGiftCard) || errors.Is(err, customerrors.ErrGiftCardNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, customerrors.ErrNotOnMenu) || errors.Is(err, customerrors.ErrCreditLimitExceeded) || errors.Is(err, customerrors.ErrInsufficientPoints) ||
+			errors.Is(err, customerrors.ErrGiftCardUnavailable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.Error(err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetPriceLists returns a HTTP handler function to retrieve all the price lists.
+func GetPriceLists(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		priceListService := services.PriceListService{
+			Logger: logger,
+			Config: config,
+		}
+
+		price_lists, err := priceListService.GetPriceLists()
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: price_lists,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(price_lists),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetPriceList returns a HTTP handler function to retrieve a single price list.
+func GetPriceList(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		price_list_id := params["id"]
+
+		priceListService := services.PriceListService{
+			Logger: logger,
+			Config: config,
+		}
+
+		price_list, err := priceListService.GetPriceList(price_list_id)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "price list not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: price_list,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// InsertPriceList returns a HTTP handler function to insert a price list.
+func InsertPriceList(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		request := struct {
+			Data models.PriceList `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		priceListService := services.PriceListService{
+			Logger: logger,
+			Config: config,
+		}
+
+		price_list, err := priceListService.InsertPriceList(request.Data)
+		if errors.Is(err, customerrors.ErrInvalidPriceList) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: price_list,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// UpdatePriceList returns a HTTP handler function to update a price list.
+func UpdatePriceList(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		price_list_id := params["id"]
+
+		request := struct {
+			Data models.PriceList `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		priceListService := services.PriceListService{
+			Logger: logger,
+			Config: config,
+		}
+
+		price_list, err := priceListService.UpdatePriceList(price_list_id, request.Data)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "price list not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInvalidPriceList) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: price_list,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// DeletePriceList returns a HTTP handler function to delete a price list.
+func DeletePriceList(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		price_list_id := params["id"]
+
+		priceListService := services.PriceListService{
+			Logger: logger,
+			Config: config,
+		}
+
+		err := priceListService.DeletePriceList(price_list_id)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "price list not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
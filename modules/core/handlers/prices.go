@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	auth_mw "github.com/elmawardy/nutrix/modules/auth/middlewares"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SchedulePrice returns a HTTP handler function to set the price of a product effective from a time,
+// the price takes effect right away when effective_from isn't set or isn't in the future.
+func SchedulePrice(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		request := struct {
+			Data struct {
+				Price         float64   `json:"price"`
+				EffectiveFrom time.Time `json:"effective_from"`
+			} `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		price, err := recipeService.SchedulePrice(product_id, request.Data.Price, request.Data.EffectiveFrom, auth_mw.UserId(r.Context()))
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInvalidPrice) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: price,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetPriceHistory returns a HTTP handler function to retrieve the dated prices of a product,
+// including the scheduled ones, the latest effective first.
+func GetPriceHistory(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		prices, err := recipeService.GetPriceHistory(product_id)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: prices,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(prices),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// CancelScheduledPrice returns a HTTP handler function to delete a scheduled price of a product before it takes effect.
+func CancelScheduledPrice(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+		price_id := params["price_id"]
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		err := recipeService.CancelScheduledPrice(product_id, price_id)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "price not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrPriceInEffect) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
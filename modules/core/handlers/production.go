@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ProduceBatch returns a HTTP handler function to record a production batch of a product.
+// The request body contains the produced quantity, and an optional expiration date,
+// the product shelf life is used when it's not set.
+func ProduceBatch(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		request := struct {
+			Data struct {
+				Quantity       float64   `json:"quantity"`
+				ExpirationDate time.Time `json:"expiration_date"`
+			} `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.Data.Quantity <= 0 {
+			http.Error(w, "quantity must be positive", http.StatusBadRequest)
+			return
+		}
+
+		productionService := services.ProductionService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		batch, err := productionService.ProduceBatch(product_id, request.Data.Quantity, request.Data.ExpirationDate)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInsufficientStock) || errors.Is(err, customerrors.ErrInsufficientReady) || errors.Is(err, customerrors.ErrEntryNotUsable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: batch,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// GetProductionBatches returns a HTTP handler function to retrieve the production batches of a product.
+// The consumed and expired batches are only returned when the include_closed query string is true.
+func GetProductionBatches(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		include_closed, _ := strconv.ParseBool(r.URL.Query().Get("include_closed"))
+
+		productionService := services.ProductionService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		batches, err := productionService.GetBatches(product_id, include_closed)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: batches,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(batches),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
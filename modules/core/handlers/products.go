@@ -11,108 +11,67 @@ package handlers
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
-	"github.com/elmawardy/nutrix/common/helpers"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
+	auth_mw "github.com/elmawardy/nutrix/modules/auth/middlewares"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"github.com/elmawardy/nutrix/modules/core/services"
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// UpdateProductImage returns a HTTP handler function to replace the primary image of a product,
+// the previous primary image is deleted from the storage.
 func UpdateProductImage(config config.Config, logger logger.ILogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parse the multipart form data
-		err := r.ParseMultipartForm(32 << 20) // Max file size: 32MB
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
 
 		params := mux.Vars(r)
 		id_param := params["id"]
 
-		product_svc := services.RecipeService{
-			Logger: logger,
-			Config: config,
-		}
-
-		product, err := product_svc.GetProduct(id_param)
+		data, err := readImageUpload(w, r, config)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeImageError(w, logger, err)
 			return
 		}
 
-		// Get the uploaded file
-		file, fileHeader, err := r.FormFile("image")
-		if err != nil {
-			logger.Error("Error uploading file %s", err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-
-		names := strings.Split(fileHeader.Filename, ".")
-		file_extension := ""
-
-		if len(names) > 0 {
-			file_extension = "." + names[len(names)-1]
-		}
-
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		product_svc := services.RecipeService{
+			Logger: logger,
+			Config: config,
 		}
-		defer file.Close()
-
-		newpath := filepath.Join(".", "public")
-		err = os.MkdirAll(newpath, os.ModePerm)
 
+		product, err := product_svc.ReplaceProductImage(id_param, data)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeImageError(w, logger, err)
 			return
 		}
 
-		random_string := helpers.RandStringBytesMaskImprSrc(20)
-
-		// Create a new file on the server
-		dst, err := os.Create(config.UploadsPath + "/" + random_string + file_extension)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		response := JSONApiOkResponse{
+			Data: product,
 		}
-		defer dst.Close()
 
-		// Copy the uploaded file to the server file
-		_, err = io.Copy(dst, file)
+		jsonResponse, err := json.Marshal(response)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logger.Error(err.Error())
+			http.Error(w, "Failed to marshal product response", http.StatusInternalServerError)
 			return
 		}
 
-		// Delete the old image file from the public directory
-		oldImagePath := config.UploadsPath + "/" + product.ImageURL
-		err = os.Remove(oldImagePath)
-		if err != nil {
-			logger.Error("Error deleting old image file %s", err.Error())
-			// Optionally handle the error, but don't return it to the client
-		}
-
-		product.ImageURL = random_string + file_extension
-
-		product_svc.UpdateProduct(id_param, product)
-
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
 	}
 }
 
 // UpdateProduct returns a HTTP handler function to update a product in the database.
-func UpdateProduct(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func UpdateProduct(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		params := mux.Vars(r)
@@ -128,12 +87,23 @@ func UpdateProduct(config config.Config, logger logger.ILogger) http.HandlerFunc
 			return
 		}
 
+		localizer := catalogLocalizer(r, config, logger, settings)
+		localizer.NormalizeProduct(&request.Data)
+
 		recipeService := services.RecipeService{
 			Logger: logger,
 			Config: config,
 		}
 
-		err = recipeService.UpdateProduct(id_param, request.Data)
+		err = recipeService.UpdateProduct(id_param, request.Data, auth_mw.UserId(r.Context()))
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInvalidYield) || errors.Is(err, customerrors.ErrInvalidRecipe) || errors.Is(err, customerrors.ErrInvalidSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -146,6 +116,8 @@ func UpdateProduct(config config.Config, logger logger.ILogger) http.HandlerFunc
 			return
 		}
 
+		localizer.LocalizeProduct(&updated_product)
+
 		response := JSONApiOkResponse{
 			Data: updated_product,
 		}
@@ -175,22 +147,11 @@ func DeleteProduct(config config.Config, logger logger.ILogger) http.HandlerFunc
 			Config: config,
 		}
 
-		product, err := recipeService.GetProduct(id_param)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		err := recipeService.DeleteProduct(id_param)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
 			return
 		}
-
-		if product.ImageURL != "" {
-			err = os.Remove(filepath.Join(".", "public", product.ImageURL))
-			if err != nil {
-				logger.Error(err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-
-		err = recipeService.DeleteProduct(id_param)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -201,7 +162,7 @@ func DeleteProduct(config config.Config, logger logger.ILogger) http.HandlerFunc
 }
 
 // InesrtNewProduct returns a HTTP handler function to insert a new product in the database.
-func InesrtNewProduct(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func InesrtNewProduct(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		request := struct {
@@ -214,17 +175,26 @@ func InesrtNewProduct(config config.Config, logger logger.ILogger) http.HandlerF
 			return
 		}
 
+		localizer := catalogLocalizer(r, config, logger, settings)
+		localizer.NormalizeProduct(&request.Data)
+
 		recipeService := services.RecipeService{
 			Logger: logger,
 			Config: config,
 		}
 
-		new_product, err := recipeService.InsertNew(request.Data)
+		new_product, err := recipeService.InsertNew(request.Data, auth_mw.UserId(r.Context()))
+		if errors.Is(err, customerrors.ErrInvalidYield) || errors.Is(err, customerrors.ErrInvalidRecipe) || errors.Is(err, customerrors.ErrInvalidSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		localizer.LocalizeProduct(&new_product)
+
 		response := JSONApiOkResponse{
 			Data: new_product,
 		}
@@ -240,7 +210,7 @@ func InesrtNewProduct(config config.Config, logger logger.ILogger) http.HandlerF
 }
 
 // GetProduct gets a single product from the db
-func GetProduct(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func GetProduct(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		params := mux.Vars(r)
@@ -257,6 +227,8 @@ func GetProduct(config config.Config, logger logger.ILogger) http.HandlerFunc {
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).LocalizeProduct(&product)
+
 		response := JSONApiOkResponse{
 			Data: product,
 		}
@@ -274,7 +246,7 @@ func GetProduct(config config.Config, logger logger.ILogger) http.HandlerFunc {
 // It requires two query string parameters:
 // first_index: the index of the first product to be retrieved
 // rows: the number of products to be retrieved
-func GetProducts(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func GetProducts(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -307,6 +279,8 @@ func GetProducts(config config.Config, logger logger.ILogger) http.HandlerFunc {
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).LocalizeProducts(products)
+
 		response := JSONApiOkResponse{
 			Data: products,
 			Meta: JSONAPIMeta{
@@ -324,7 +298,7 @@ func GetProducts(config config.Config, logger logger.ILogger) http.HandlerFunc {
 
 // GetRecipeTree returns a HTTP handler function to retrieve a recipe tree.
 // The recipe ID is required as query string.
-func GetRecipeTree(config config.Config, logger logger.ILogger) http.HandlerFunc {
+func GetRecipeTree(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -342,6 +316,8 @@ func GetRecipeTree(config config.Config, logger logger.ILogger) http.HandlerFunc
 			return
 		}
 
+		catalogLocalizer(r, config, logger, settings).LocalizeProduct(&tree)
+
 		response := JSONApiOkResponse{
 			Data: tree,
 		}
@@ -356,25 +332,26 @@ func GetRecipeTree(config config.Config, logger logger.ILogger) http.HandlerFunc
 }
 
 // GetRecipeAvailability returns a HTTP handler function to check the availability of multiple recipes.
-// The recipe IDs are required as query string, comma separated.
+// The recipe IDs are set as query string, comma separated, the availability of the whole menu is returned without them.
 func GetRecipeAvailability(config config.Config, logger logger.ILogger) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		id := r.URL.Query().Get("ids")
-		if id == "" {
-			http.Error(w, "recipe ids comma separated is required as query string", http.StatusBadRequest)
-			return
+		ids := []string{}
+		if id := r.URL.Query().Get("ids"); id != "" {
+			ids = strings.Split(id, `,`)
 		}
 
-		ids := strings.Split(id, `,`)
-
 		recipeService := services.RecipeService{
 			Logger: logger,
 			Config: config,
 		}
 
 		availabilities, err := recipeService.CheckRecipesAvailability(ids)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -395,3 +372,131 @@ func GetRecipeAvailability(config config.Config, logger logger.ILogger) http.Han
 	}
 
 }
+
+// CheckRecipesIntegrity returns a HTTP handler function to scan all the recipes and report their integrity problems,
+// the cycles, the references to missing materials or products, the excessive depth and the invalid yields.
+func CheckRecipesIntegrity(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		problems, err := recipeService.CheckRecipesIntegrity()
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: problems,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(problems),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// SetEightySixed returns a HTTP handler function to 86 a product or put it back on the menu,
+// the change is broadcast on the product_86 topic. The product is put back on the menu automatically at until when it's set.
+func SetEightySixed(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		request := struct {
+			Data struct {
+				IsEightySixed bool      `json:"is_eighty_sixed"`
+				Until         time.Time `json:"until"`
+			} `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.Data.IsEightySixed && !request.Data.Until.IsZero() && !request.Data.Until.After(time.Now()) {
+			http.Error(w, "until must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		product, err := recipeService.SetEightySixed(product_id, request.Data.IsEightySixed, request.Data.Until)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		message := fmt.Sprintf("%s is back on the menu", product.Name)
+		if product.IsEightySixed {
+			message = fmt.Sprintf("%s was 86'd", product.Name)
+		}
+
+		msg := models.WebsocketEightySixServerMessage{
+			ProductId:        product.Id,
+			ProductName:      product.Name,
+			IsEightySixed:    product.IsEightySixed,
+			EightySixedUntil: product.EightySixedUntil,
+			WebsocketTopicServerMessage: models.WebsocketTopicServerMessage{
+				Type:      "topic_message",
+				TopicName: "product_86",
+				Message:   message,
+				Severity:  "info",
+				Date:      time.Now(),
+				Key:       fmt.Sprintf("product_86@%s@%d", product.Id, time.Now().UnixNano()),
+			},
+		}
+
+		msgJson, err := json.Marshal(msg)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		notifications_svc, err := services.SpawnNotificationSingletonSvc("melody", logger, config)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		notifications_svc.SendToTopic("product_86", string(msgJson))
+
+		response := JSONApiOkResponse{
+			Data: product,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PostCustomerPayment returns a HTTP handler function to post a payment against the balance of a customer.
+func PostCustomerPayment(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		id_param := params["id"]
+
+		request := struct {
+			Data dto.CustomerPayment `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		receivables_svc := services.ReceivablesService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		entry, err := receivables_svc.PostPayment(id_param, request.Data)
+		if errors.Is(err, customerrors.ErrInvalidPayment) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, customerrors.ErrCustomerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: entry,
+			Meta: JSONAPIMeta{
+				TotalRecords: 1,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(jsonResponse)
+	}
+}
+
+// GetCustomerReceivables returns a HTTP handler function to retrieve the entries of the account of a customer, the newest first.
+func GetCustomerReceivables(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		page_number, err := strconv.Atoi(r.URL.Query().Get("page[number]"))
+		if err != nil {
+			page_number = 1
+		}
+
+		page_size, err := strconv.Atoi(r.URL.Query().Get("page[size]"))
+		if err != nil {
+			page_size = 50
+		}
+
+		receivables_svc := services.ReceivablesService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		entries, total_records, err := receivables_svc.GetReceivables(services.GetReceivablesParams{
+			CustomerId: params["id"],
+			PageNumber: page_number,
+			PageSize:   page_size,
+		})
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "customer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: entries,
+			Meta: JSONAPIMeta{
+				TotalRecords: total_records,
+				PageNumber:   page_number,
+				PageSize:     page_size,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetCustomerStatement returns a HTTP handler function to retrieve the statement of the account of a customer.
+// It accepts optional from and to query strings (RFC3339 or 2006-01-02), the last 30 days are used by default.
+// The statement is returned as json, or rendered as html or pdf with the format query param.
+func GetCustomerStatement(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = services.StatementFormatJSON
+		}
+
+		if format != services.StatementFormatJSON && format != services.StatementFormatHTML && format != services.StatementFormatPDF {
+			http.Error(w, "format must be json, html or pdf", http.StatusBadRequest)
+			return
+		}
+
+		to := time.Now()
+		if to_param := r.URL.Query().Get("to"); to_param != "" {
+			t, err := parseDateParam(to_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid to date", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+
+		from := to.AddDate(0, 0, -30)
+		if from_param := r.URL.Query().Get("from"); from_param != "" {
+			t, err := parseDateParam(from_param, config, false)
+			if err != nil {
+				http.Error(w, "invalid from date", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		receivables_svc := services.ReceivablesService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		statement, err := receivables_svc.GetStatement(params["id"], from, to)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "customer not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if format != services.StatementFormatJSON {
+
+			lang_svc := services.LanguageService{
+				Config:   config,
+				Logger:   logger,
+				Settings: settings,
+			}
+
+			rendered, err := receivables_svc.RenderStatement(statement, format, requestLanguage(r, lang_svc, settings))
+			if err != nil {
+				logger.Error(err.Error())
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if format == services.StatementFormatPDF {
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"statement-%s.pdf\"", statement.Customer.Id))
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			}
+
+			w.Write(rendered)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: statement,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetReceivablesAging returns a HTTP handler function to retrieve the aging report of the customer accounts.
+// It accepts an optional as_of query string (RFC3339 or 2006-01-02), the current time is used when it's not set.
+func GetReceivablesAging(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		as_of := time.Now()
+
+		if as_of_param := r.URL.Query().Get("as_of"); as_of_param != "" {
+			t, err := parseDateParam(as_of_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid as_of date", http.StatusBadRequest)
+				return
+			}
+			as_of = t
+		}
+
+		receivables_svc := services.ReceivablesService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		report, err := receivables_svc.GetAgingReport(as_of)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsonResponse, err := json.Marshal(JSONApiOkResponse{
+			Data: report,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(report.Customers),
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	auth_mw "github.com/elmawardy/nutrix/modules/auth/middlewares"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetRecipeVersions returns a HTTP handler function to retrieve the versions of a product recipe, the newest first.
+func GetRecipeVersions(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		versions, err := recipeService.GetRecipeVersions(product_id)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: versions,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(versions),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetRecipeVersion returns a HTTP handler function to retrieve a single version of a product recipe.
+func GetRecipeVersion(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		version_number, err := strconv.Atoi(params["version"])
+		if err != nil {
+			http.Error(w, "version must be a number", http.StatusBadRequest)
+			return
+		}
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		version, err := recipeService.GetRecipeVersion(product_id, version_number)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "recipe version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: version,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// DiffRecipeVersions returns a HTTP handler function to compare two versions of a product recipe,
+// the versions are set by the from and to query strings.
+func DiffRecipeVersions(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		from, err := strconv.Atoi(r.URL.Query().Get("from"))
+		if err != nil {
+			http.Error(w, "from must be a version number", http.StatusBadRequest)
+			return
+		}
+
+		to, err := strconv.Atoi(r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, "to must be a version number", http.StatusBadRequest)
+			return
+		}
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		diff, err := recipeService.DiffRecipeVersions(product_id, from, to)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "recipe version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: diff,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// RollbackRecipe returns a HTTP handler function to restore an earlier version of a product recipe,
+// the restored recipe is saved as a new version.
+func RollbackRecipe(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		product_id := params["id"]
+
+		version_number, err := strconv.Atoi(params["version"])
+		if err != nil {
+			http.Error(w, "version must be a number", http.StatusBadRequest)
+			return
+		}
+
+		recipeService := services.RecipeService{
+			Logger: logger,
+			Config: config,
+		}
+
+		product, err := recipeService.RollbackRecipe(product_id, version_number, auth_mw.UserId(r.Context()))
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "recipe version not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, customerrors.ErrInvalidYield) || errors.Is(err, customerrors.ErrInvalidRecipe) || errors.Is(err, customerrors.ErrInvalidSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: product,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
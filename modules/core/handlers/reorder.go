@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+)
+
+// GetReorderSuggestions returns a HTTP handler function to retrieve the reorder suggestions of the materials.
+// It accepts the following optional query string parameters:
+// lookback_days: the number of past days of consumption to compute the usage from
+// by_weekday: forecast the lead time demand using the average usage per weekday
+// filter[needs_reorder]: return only the materials that need to be reordered
+func GetReorderSuggestions(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := services.GetReorderSuggestionsParams{}
+
+		lookback_days, err := strconv.Atoi(r.URL.Query().Get("lookback_days"))
+		if err == nil {
+			params.LookbackDays = lookback_days
+		}
+
+		by_weekday, err := strconv.ParseBool(r.URL.Query().Get("by_weekday"))
+		if err == nil {
+			params.ByWeekday = by_weekday
+		}
+
+		only_needed, err := strconv.ParseBool(r.URL.Query().Get("filter[needs_reorder]"))
+		if err == nil {
+			params.OnlyNeeded = only_needed
+		}
+
+		reorder_svc := services.ReorderService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		suggestions, err := reorder_svc.GetReorderSuggestions(params)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: suggestions,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(suggestions),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+)
+
+// parseDateParam parses a query string date in RFC3339 or 2006-01-02 format,
+// a date without time is interpreted in the configured time zone, at the end of the day if end_of_day is set.
+func parseDateParam(value string, config config.Config, end_of_day bool) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	loc, err := time.LoadLocation(config.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return t, err
+	}
+
+	if end_of_day {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
+
+// GetInventoryValuation returns a HTTP handler function to retrieve the inventory valuation report.
+// It accepts an optional as_of query string (RFC3339 or 2006-01-02), the current time is used when it's not set.
+func GetInventoryValuation(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		as_of := time.Now()
+
+		if as_of_param := r.URL.Query().Get("as_of"); as_of_param != "" {
+			t, err := parseDateParam(as_of_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid as_of date", http.StatusBadRequest)
+				return
+			}
+			as_of = t
+		}
+
+		materialService := services.MaterialService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		valuation, err := materialService.GetInventoryValuation(as_of)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: valuation,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// GetMenuEngineering returns a HTTP handler function to retrieve the menu engineering report.
+// It accepts optional from and to query strings (RFC3339 or 2006-01-02), the last 30 days are used when they're not set.
+func GetMenuEngineering(config config.Config, logger logger.ILogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		to := time.Now()
+		if to_param := r.URL.Query().Get("to"); to_param != "" {
+			t, err := parseDateParam(to_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid to date", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+
+		from := to.AddDate(0, 0, -30)
+		if from_param := r.URL.Query().Get("from"); from_param != "" {
+			t, err := parseDateParam(from_param, config, false)
+			if err != nil {
+				http.Error(w, "invalid from date", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		salesService := services.SalesService{
+			Logger: logger,
+			Config: config,
+		}
+
+		report, err := salesService.GetMenuEngineering(from, to)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: report,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// SimulateCosting returns a HTTP handler function to run a what-if costing scenario of hypothetical material prices,
+// recipe tweaks and sale prices. It accepts optional from and to query strings (RFC3339 or 2006-01-02) for the sales
+// the impact is projected on, the last 30 days are used when they're not set. Nothing is saved.
+func SimulateCosting(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		request := struct {
+			Data dto.CostingScenario `json:"data"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		to := time.Now()
+		if to_param := r.URL.Query().Get("to"); to_param != "" {
+			t, err := parseDateParam(to_param, config, true)
+			if err != nil {
+				http.Error(w, "invalid to date", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+
+		from := to.AddDate(0, 0, -30)
+		if from_param := r.URL.Query().Get("from"); from_param != "" {
+			t, err := parseDateParam(from_param, config, false)
+			if err != nil {
+				http.Error(w, "invalid from date", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		materialService := services.MaterialService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		simulation, err := materialService.SimulateCosting(request.Data, from, to)
+		if errors.Is(err, customerrors.ErrInvalidScenario) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: simulation,
+			Meta: JSONAPIMeta{
+				TotalRecords: len(simulation.Products),
+			},
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
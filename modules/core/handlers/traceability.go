@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"github.com/elmawardy/nutrix/modules/core/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// formatCSVTime formats a time for the CSV exports, a zero time is exported as an empty value.
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// writeCSV writes the rows as a CSV attachment with the given file name.
+func writeCSV(w http.ResponseWriter, filename string, rows [][]string) {
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	writer := csv.NewWriter(w)
+	writer.WriteAll(rows)
+}
+
+// TraceLot returns a HTTP handler function to trace a lot forward to the orders and customers that received it.
+// It accepts the following query string parameters (at least one of entry_id and sku is required):
+// entry_id: the id of the material entry
+// sku: the sku of the lot, all the entries with this sku are traced
+// format: json (default) or csv, the csv export contains a row per affected order
+func TraceLot(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		entry_id := r.URL.Query().Get("entry_id")
+		sku := r.URL.Query().Get("sku")
+
+		if entry_id == "" && sku == "" {
+			http.Error(w, "entry_id or sku is required", http.StatusBadRequest)
+			return
+		}
+
+		traceabilityService := services.TraceabilityService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		trace, err := traceabilityService.TraceLotForward(entry_id, sku)
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			rows := [][]string{{"order_id", "display_id", "submitted_at", "state", "customer_name", "customer_phone", "customer_address", "recipe_ids", "quantity", "via_batch_ids", "via_ready_product_ids"}}
+			for _, order := range trace.Orders {
+				rows = append(rows, []string{
+					order.OrderId,
+					order.DisplayId,
+					formatCSVTime(order.SubmittedAt),
+					order.State,
+					order.Customer.Name,
+					order.Customer.Phone,
+					order.Customer.Address,
+					strings.Join(order.RecipeIds, ";"),
+					strconv.FormatFloat(order.Quantity, 'f', -1, 64),
+					strings.Join(order.ViaBatchIds, ";"),
+					strings.Join(order.ViaReadyProductIds, ";"),
+				})
+			}
+
+			name := entry_id
+			if name == "" {
+				name = sku
+			}
+
+			writeCSV(w, fmt.Sprintf("lot_trace_%s.csv", name), rows)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: trace,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
+
+// TraceOrder returns a HTTP handler function to trace an order backward to the lots it consumed.
+// It accepts an optional format query string parameter: json (default) or csv,
+// the csv export contains a row per consumed lot.
+func TraceOrder(config config.Config, logger logger.ILogger, settings models.Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := mux.Vars(r)
+		order_id := params["id"]
+
+		traceabilityService := services.TraceabilityService{
+			Logger:   logger,
+			Config:   config,
+			Settings: settings,
+		}
+
+		trace, err := traceabilityService.TraceOrderBackward(order_id)
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error(err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			rows := [][]string{{"order_id", "display_id", "material_id", "material_name", "entry_id", "sku", "company", "received_at", "expiration_date", "recipe_id", "recipe_name", "quantity", "unit", "date", "via_batch_id"}}
+			for _, consumption := range trace.Consumptions {
+				rows = append(rows, []string{
+					trace.OrderId,
+					trace.DisplayId,
+					consumption.Lot.MaterialId,
+					consumption.Lot.MaterialName,
+					consumption.Lot.EntryId,
+					consumption.Lot.SKU,
+					consumption.Lot.Company,
+					formatCSVTime(consumption.Lot.ReceivedAt),
+					formatCSVTime(consumption.Lot.ExpirationDate),
+					consumption.RecipeId,
+					consumption.RecipeName,
+					strconv.FormatFloat(consumption.Quantity, 'f', -1, 64),
+					consumption.Lot.Unit,
+					formatCSVTime(consumption.Date),
+					consumption.ViaBatchId,
+				})
+			}
+
+			writeCSV(w, fmt.Sprintf("order_trace_%s.csv", order_id), rows)
+			return
+		}
+
+		response := JSONApiOkResponse{
+			Data: trace,
+		}
+
+		jsonResponse, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonResponse)
+	}
+}
@@ -1,8 +1,42 @@
 package models
 
+import "time"
+
 type Customer struct {
 	Id      string `json:"id" bson:"id"`
 	Name    string `json:"name" bson:"name"`
 	Phone   string `json:"phone" bson:"phone"`
 	Address string `json:"address" bson:"address"`
+	// Stats are computed from the finished orders of the customer, they're kept on the customer and left out
+	// of the copies embedded in the orders.
+	Stats *CustomerStats `json:"stats,omitempty" bson:"stats,omitempty"`
+	// Balance is what the customer owes on their account, it's only changed by the receivable entries.
+	Balance float64 `json:"balance" bson:"balance"`
+	// CreditLimit is the highest balance the pay-later orders can charge the account up to, it isn't limited when it's nil.
+	CreditLimit *float64 `json:"credit_limit,omitempty" bson:"credit_limit,omitempty"`
+	// Points are the loyalty points of the customer, they're only changed by the loyalty entries. LifetimePoints are
+	// all the points they earned, the tier is the highest one they reach.
+	Points         float64 `json:"points" bson:"points"`
+	LifetimePoints float64 `json:"lifetime_points" bson:"lifetime_points"`
+	Tier           string  `json:"tier,omitempty" bson:"tier,omitempty"`
+}
+
+// CustomerStats are the lifetime statistics of a customer, they're refreshed whenever an order of the customer finishes.
+type CustomerStats struct {
+	VisitCount    int       `json:"visit_count" bson:"visit_count"`
+	TotalSpend    float64   `json:"total_spend" bson:"total_spend"`
+	AverageTicket float64   `json:"average_ticket" bson:"average_ticket"`
+	FirstVisit    time.Time `json:"first_visit" bson:"first_visit"`
+	LastVisit     time.Time `json:"last_visit" bson:"last_visit"`
+	// FavouriteProducts are the products the customer ordered the most, by quantity.
+	FavouriteProducts []FavouriteProduct `json:"favourite_products" bson:"favourite_products"`
+	UpdatedAt         time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// FavouriteProduct is a product a customer ordered, with the quantity and the number of orders it was in.
+type FavouriteProduct struct {
+	ProductId   string  `json:"product_id" bson:"product_id"`
+	ProductName string  `json:"product_name" bson:"product_name"`
+	Quantity    float64 `json:"quantity" bson:"quantity"`
+	OrderCount  int     `json:"order_count" bson:"order_count"`
 }
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// Gift card transaction types, every change of the balance of a gift card is recorded as one of them.
+const (
+	// GiftCardTypeIssue loads the initial balance of a sold gift card.
+	GiftCardTypeIssue = "issue"
+	// GiftCardTypeRedeem takes what's paid with the gift card on an order.
+	GiftCardTypeRedeem = "redeem"
+	// GiftCardTypeRefund gives back what was paid with the gift card on a cancelled order.
+	GiftCardTypeRefund = "refund"
+	// GiftCardTypeExpire takes the balance left when the gift card expires.
+	GiftCardTypeExpire = "expire"
+	// GiftCardTypeDeactivate takes the balance left when the gift card is deactivated.
+	GiftCardTypeDeactivate = "deactivate"
+)
+
+// GiftCard is a stored-value card sold to the customers, it's redeemed by its code as a payment on orders.
+type GiftCard struct {
+	Id string `json:"id" bson:"id"`
+	// Code is unique, it's what the card is looked up and redeemed by.
+	Code           string  `json:"code" bson:"code"`
+	InitialBalance float64 `json:"initial_balance" bson:"initial_balance"`
+	// Balance is what's left on the card, it's only changed by the transactions of the card.
+	Balance  float64   `json:"balance" bson:"balance"`
+	IssuedAt time.Time `json:"issued_at" bson:"issued_at"`
+	// ExpiresAt is when the balance left on the card expires, the card never expires when it's not set.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	IsActive  bool       `json:"is_active" bson:"is_active"`
+	// ClosedAt is when the card expired or was deactivated.
+	ClosedAt   *time.Time `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	CustomerId string     `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Comment    string     `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// GiftCardTransaction is an immutable record of a change of the balance of a gift card,
+// the balance of a card is the sum of its transactions.
+type GiftCardTransaction struct {
+	Id     string    `json:"id" bson:"id"`
+	CardId string    `json:"card_id" bson:"card_id"`
+	Code   string    `json:"code" bson:"code"`
+	Type   string    `json:"type" bson:"type"`
+	Date   time.Time `json:"date" bson:"date"`
+	// Amount is signed, it's positive for the issues and the refunds and negative for the rest.
+	Amount float64 `json:"amount" bson:"amount"`
+	// Balance is the balance of the card after the transaction.
+	Balance        float64 `json:"balance" bson:"balance"`
+	OrderId        string  `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	OrderDisplayId string  `json:"order_display_id,omitempty" bson:"order_display_id,omitempty"`
+	Comment        string  `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// OrderGiftCard is a payment of an order with a gift card.
+type OrderGiftCard struct {
+	Code   string  `json:"code" bson:"code"`
+	Amount float64 `json:"amount" bson:"amount"`
+}
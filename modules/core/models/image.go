@@ -0,0 +1,19 @@
+package models
+
+// ProductImage is an uploaded image of a product with its generated thumbnail,
+// the keys locate the files in the configured storage and the urls are where they're served.
+type ProductImage struct {
+	Id           string `json:"id" bson:"id"`
+	Key          string `json:"key" bson:"key"`
+	URL          string `json:"url" bson:"url"`
+	ThumbnailKey string `json:"thumbnail_key" bson:"thumbnail_key"`
+	ThumbnailURL string `json:"thumbnail_url" bson:"thumbnail_url"`
+	// ContentType is sniffed from the uploaded content, the file extension isn't trusted.
+	ContentType string `json:"content_type" bson:"content_type"`
+	Width       int    `json:"width" bson:"width"`
+	Height      int    `json:"height" bson:"height"`
+	// Size is the size of the uploaded image in bytes.
+	Size int64 `json:"size" bson:"size"`
+	// IsPrimary is set on the image shown for the product, its url is the image url of the product.
+	IsPrimary bool `json:"is_primary" bson:"is_primary"`
+}
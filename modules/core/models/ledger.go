@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Inventory movement types, every change of a material entry quantity is recorded as one of them.
+const (
+	MovementTypeReceipt     = "receipt"
+	MovementTypeConsumption = "consumption"
+	MovementTypeReturn      = "return"
+	MovementTypeWaste       = "waste"
+	MovementTypeAdjustment  = "adjustment"
+	MovementTypeTransfer    = "transfer"
+)
+
+// InventoryMovement is an immutable record of a quantity change of a material entry,
+// the quantity of an entry is the sum of its movements.
+type InventoryMovement struct {
+	Id         string    `json:"id" bson:"id"`
+	Type       string    `json:"type" bson:"type"`
+	Date       time.Time `json:"date" bson:"date"`
+	MaterialId string    `json:"material_id" bson:"material_id"`
+	EntryId    string    `json:"entry_id" bson:"entry_id"`
+	// Quantity is signed, it's positive for the movements adding to the entry and negative for the ones taking from it.
+	Quantity float64 `json:"quantity" bson:"quantity"`
+	UnitCost float64 `json:"unit_cost" bson:"unit_cost"`
+	Reason   string  `json:"reason,omitempty" bson:"reason,omitempty"`
+	OrderId  string  `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	RecipeId string  `json:"recipe_id,omitempty" bson:"recipe_id,omitempty"`
+	// ProductionBatchId is the batch produced from the consumed quantity.
+	ProductionBatchId string `json:"production_batch_id,omitempty" bson:"production_batch_id,omitempty"`
+	// TransferId links the two movements of a transfer between entries.
+	TransferId string `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// Loyalty entry types, every change of the points of a customer is recorded as one of them.
+const (
+	// LoyaltyTypeEarn adds the points earned on a finished order.
+	LoyaltyTypeEarn = "earn"
+	// LoyaltyTypeRedeem takes the points redeemed on an order for a discount or free products.
+	LoyaltyTypeRedeem = "redeem"
+	// LoyaltyTypeRefund gives back the points redeemed on a cancelled order.
+	LoyaltyTypeRefund = "refund"
+	// LoyaltyTypeReversal takes back the points earned on a cancelled order.
+	LoyaltyTypeReversal = "reversal"
+	// LoyaltyTypeExpire takes the points that weren't redeemed before they expired.
+	LoyaltyTypeExpire = "expire"
+	// LoyaltyTypeAdjustment is a manual correction of the points.
+	LoyaltyTypeAdjustment = "adjustment"
+)
+
+// LoyaltyEntry is an immutable record of a change of the points of a customer,
+// the points of a customer are the sum of their entries.
+type LoyaltyEntry struct {
+	Id         string    `json:"id" bson:"id"`
+	CustomerId string    `json:"customer_id" bson:"customer_id"`
+	Type       string    `json:"type" bson:"type"`
+	Date       time.Time `json:"date" bson:"date"`
+	// Points are signed, they're positive for the entries adding points and negative for the ones taking them.
+	Points float64 `json:"points" bson:"points"`
+	// Balance is the points of the customer after the entry.
+	Balance        float64 `json:"balance" bson:"balance"`
+	OrderId        string  `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	OrderDisplayId string  `json:"order_display_id,omitempty" bson:"order_display_id,omitempty"`
+	// ExpiresAt is when the added points expire, the redeemed points are taken from the ones expiring first.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// LotId is the entry the expired points were added by.
+	LotId   string `json:"lot_id,omitempty" bson:"lot_id,omitempty"`
+	Comment string `json:"comment,omitempty" bson:"comment,omitempty"`
+}
+
+// LoyaltyTier is a level of the loyalty program the customers reach by the points they earned in their lifetime.
+type LoyaltyTier struct {
+	Name      string  `json:"name" bson:"name"`
+	MinPoints float64 `json:"min_points" bson:"min_points"`
+	// EarnMultiplier multiplies the points the customers of the tier earn, it's 1 when it's zero.
+	EarnMultiplier float64 `json:"earn_multiplier" bson:"earn_multiplier"`
+	// DiscountPercent is discounted from every order of the customers of the tier.
+	DiscountPercent float64 `json:"discount_percent" bson:"discount_percent"`
+}
+
+// LoyaltyReward is a product the customers can get for free for its points.
+type LoyaltyReward struct {
+	ProductId string  `json:"product_id" bson:"product_id"`
+	Points    float64 `json:"points" bson:"points"`
+}
+
+// LoyaltySettings configures how the customers earn and redeem their points.
+type LoyaltySettings struct {
+	IsEnabled bool `json:"is_enabled" bson:"is_enabled"`
+	// PointsPerUnit are the points earned per currency unit paid on a finished order, they're rounded down.
+	PointsPerUnit float64 `json:"points_per_unit" bson:"points_per_unit"`
+	// CategoryMultipliers multiply the points earned on the products of the categories by category id,
+	// the highest multiplier of the categories of a product is used.
+	CategoryMultipliers map[string]float64 `json:"category_multipliers" bson:"category_multipliers"`
+	// PointValue is the discount in currency units a point is redeemed for.
+	PointValue float64 `json:"point_value" bson:"point_value"`
+	// MinRedeemPoints are the fewest points that can be redeemed for a discount on an order.
+	MinRedeemPoints float64 `json:"min_redeem_points" bson:"min_redeem_points"`
+	// ExpiryDays is the number of days the earned points are valid for, they never expire when it's zero.
+	ExpiryDays int             `json:"expiry_days" bson:"expiry_days"`
+	Tiers      []LoyaltyTier   `json:"tiers" bson:"tiers"`
+	Rewards    []LoyaltyReward `json:"rewards" bson:"rewards"`
+}
+
+// OrderLoyalty holds the points redeemed on an order and the loyalty discounts applied to it,
+// the discounts are included in the discount of the order.
+type OrderLoyalty struct {
+	// RedeemPoints are the points the customer redeems for a discount.
+	RedeemPoints float64 `json:"redeem_points" bson:"redeem_points"`
+	// RedeemedPoints are all the points taken from the customer, for the discount and for the reward items.
+	RedeemedPoints float64 `json:"redeemed_points" bson:"redeemed_points"`
+	PointsDiscount float64 `json:"points_discount" bson:"points_discount"`
+	Tier           string  `json:"tier,omitempty" bson:"tier,omitempty"`
+	TierDiscount   float64 `json:"tier_discount" bson:"tier_discount"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Reasons a product can't be ordered at the moment.
+const (
+	MenuUnavailableEightySixed   = "eighty_sixed"
+	MenuUnavailableOutOfSchedule = "out_of_schedule"
+	// MenuUnavailableSoldOut is set on the public menu when the stock can't make a single unit of the product.
+	MenuUnavailableSoldOut = "sold_out"
+)
+
+// AvailabilityWindow is a weekly time window a product or a category is served in, in the configured time zone.
+type AvailabilityWindow struct {
+	// Weekdays are the days the window opens on, 0 is Sunday. The window opens every day when it's empty.
+	Weekdays []time.Weekday `json:"weekdays" bson:"weekdays"`
+	// From and To are 15:04 times, a window that ends before it starts closes on the next day.
+	From string `json:"from" bson:"from"`
+	To   string `json:"to" bson:"to"`
+}
+
+// MenuAvailability tells whether a product can be ordered at the moment and why not.
+type MenuAvailability struct {
+	IsOnMenu bool   `json:"is_on_menu"`
+	Reason   string `json:"reason,omitempty"`
+}
@@ -22,6 +22,8 @@ import (
 	"encoding/json"
 	"math"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // JSONFloat is a float64 that is marshaled to JSON as a string
@@ -69,11 +71,34 @@ type ComponentConsumeLogs struct {
 	OrderId        string    `json:"order_id" bson:"order_id"`
 }
 
+// CategoryProduct is a reference to a product of a category, the product is resolved when the category is read.
+type CategoryProduct struct {
+	ProductId string `json:"product_id" bson:"product_id"`
+	// SortOrder orders the product within the category, ties keep the order they're saved in.
+	SortOrder int `json:"sort_order" bson:"sort_order"`
+}
+
 // Category represents the category of products.
 type Category struct {
-	Id       string    `json:"id" bson:"id"`
-	Name     string    `json:"name"`
-	Products []Product `json:"products"` // product ids
+	Id   string `json:"id" bson:"id"`
+	Name string `json:"name"`
+	// Products are resolved from the ProductRefs when the category is read, in their sort order. They're accepted as
+	// the references of the category when it's saved without ProductRefs.
+	Products []Product `json:"products" bson:"-"`
+	// Description and Name are in the default language, Translations has them in the other languages.
+	Description  string       `json:"description,omitempty" bson:"description,omitempty"`
+	Translations Translations `json:"translations,omitempty" bson:"translations,omitempty"`
+	// Schedule is the weekly windows the products of the category are served in, it's served all the time when it's empty.
+	Schedule []AvailabilityWindow `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	// ParentId is the category this one is nested in, it's a top level category when it's empty.
+	ParentId string `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	// SortOrder orders the category among its siblings, ties are ordered by name.
+	SortOrder   int               `json:"sort_order" bson:"sort_order"`
+	ProductRefs []CategoryProduct `json:"product_refs" bson:"product_refs"`
+	// Channels are the service styles the category is shown on, it's shown on all of them when it's empty.
+	Channels []string `json:"channels,omitempty" bson:"channels,omitempty"`
+	// Children are the subcategories, they're only filled when the categories are read as a tree.
+	Children []Category `json:"children,omitempty" bson:"-"`
 }
 
 // ItemCost represents the cost of an item, including the recipe cost, sale price, quantity,
@@ -113,6 +138,12 @@ type OrderItem struct {
 	Comment            string              `json:"comment" bson:"comment"`
 	SalePrice          float64             `json:"sale_price" bson:"sale_price"`
 	Cost               float64             `json:"cost" bson:"cost"`
+	// RecipeVersion is the version of the product recipe the item was prepared with.
+	RecipeVersion int `json:"recipe_version" bson:"recipe_version"`
+	// IsReward is set when the item is a free product the customer gets for its loyalty points.
+	IsReward bool `json:"is_reward" bson:"is_reward"`
+	// ReadyCost is the unit cost of the ready stock the item is served from, it's set when the item is consumed.
+	ReadyCost float64 `json:"ready_cost" bson:"ready_cost"`
 }
 
 type SubmitOrderMeta struct {
@@ -142,6 +173,12 @@ type Order struct {
 	IsTakeAway bool              `json:"is_take_away" bson:"is_take_away"`
 	IsDineIn   bool              `json:"is_dine_in" bson:"is_dine_in"`
 	CustomData map[string]string `json:"custom_data" bson:"custom_data"`
+	// PriceListId is the price list the items are priced with, it's chosen from the service style when it's not set.
+	PriceListId string `json:"price_list_id" bson:"price_list_id"`
+	// Loyalty holds the points the customer redeems on the order and the loyalty discounts of the order.
+	Loyalty *OrderLoyalty `json:"loyalty,omitempty" bson:"loyalty,omitempty"`
+	// GiftCards are the gift cards paying for the order, what they don't cover is paid or charged as usual.
+	GiftCards []OrderGiftCard `json:"gift_cards,omitempty" bson:"gift_cards,omitempty"`
 }
 
 // MaterialEntry represents an entry of material, detailing purchase and quantity information.
@@ -153,21 +190,95 @@ type MaterialEntry struct {
 	Company          string    `json:"company"`
 	SKU              string    `json:"sku"`
 	ExpirationDate   time.Time `json:"expiration_date" bson:"expiration_date"`
+	// ReceivedAt is the time the entry was received in the inventory, it defines the FIFO order of the entries.
+	ReceivedAt time.Time `json:"received_at" bson:"received_at"`
+	// IsQuarantined is set when the entry expires, quarantined entries are excluded from availability and consumption
+	// until they are reviewed (wasted or their expiry is extended).
+	IsQuarantined bool      `json:"is_quarantined" bson:"is_quarantined"`
+	QuarantinedAt time.Time `json:"quarantined_at" bson:"quarantined_at"`
+	// ExpiryAlerts holds the expiry thresholds (expire_soon, expired) that were already notified for this entry.
+	ExpiryAlerts []string `json:"expiry_alerts" bson:"expiry_alerts"`
+}
+
+// UnitCost returns the purchase price of a single unit of the entry,
+// it returns zero if the purchase quantity is not set.
+func (me MaterialEntry) UnitCost() float64 {
+	if me.PurchaseQuantity <= 0 {
+		return 0
+	}
+
+	return me.PurchasePrice / float64(me.PurchaseQuantity)
+}
+
+// ReceivedTime returns the time the entry was received, entries created before the received_at
+// field existed fall back to the creation time of their object id.
+func (me MaterialEntry) ReceivedTime() time.Time {
+	if !me.ReceivedAt.IsZero() {
+		return me.ReceivedAt
+	}
+
+	if object_id, err := primitive.ObjectIDFromHex(me.Id); err == nil {
+		return object_id.Timestamp()
+	}
+
+	return time.Time{}
+}
+
+// IsUsable checks whether the entry can be consumed at the given time,
+// an entry is not usable if it's quarantined or has passed its expiration date.
+// A zero expiration date means that the entry doesn't expire.
+func (me MaterialEntry) IsUsable(at time.Time) bool {
+	if me.IsQuarantined {
+		return false
+	}
+
+	return me.ExpirationDate.IsZero() || me.ExpirationDate.After(at)
 }
 
 // MaterialSettings represents settings associated with a material, such as stock alert threshold.
 type MaterialSettings struct {
 	StockAlertTreshold float64 `json:"stock_alert_treshold" bson:"stock_alert_treshold"`
+	// LeadTimeDays is the number of days the supplier takes to deliver the material after ordering.
+	LeadTimeDays float64 `json:"lead_time_days" bson:"lead_time_days"`
+	// SafetyStockDays is the number of days of average usage kept as a buffer on top of the lead time demand.
+	SafetyStockDays float64 `json:"safety_stock_days" bson:"safety_stock_days"`
+	// ExpiryWarningDays is the number of days before an entry expires to start warning about it,
+	// the inventory settings default is used when it's zero.
+	ExpiryWarningDays float64 `json:"expiry_warning_days" bson:"expiry_warning_days"`
 }
 
 // Material represents a material with its details, including entries and settings.
 type Material struct {
 	Id       string           `json:"id,omitempty" bson:"id,omitempty"`
 	Name     string           `json:"name"`
+	Category string           `json:"category" bson:"category"`
 	Entries  []MaterialEntry  `json:"entries" bson:"entries"`
 	Quantity float64          `json:"quantity"`
 	Settings MaterialSettings `json:"settings" bson:"settings"`
 	Unit     string           `json:"unit" bson:"unit"`
+	// Description and Name are in the default language, Translations has them in the other languages.
+	Description  string       `json:"description,omitempty" bson:"description,omitempty"`
+	Translations Translations `json:"translations,omitempty" bson:"translations,omitempty"`
+	// YieldPercent is the usable percentage of the material when it's part of a recipe, after peeling and trimming,
+	// the quantity of the recipe is the net quantity. It's treated as 100 when it's zero.
+	YieldPercent float64 `json:"yield_percent" bson:"yield_percent,omitempty"`
+	// GrossQuantity is the quantity taken from the inventory for the net quantity, it's only filled in the recipe tree.
+	GrossQuantity float64 `json:"gross_quantity,omitempty" bson:"-"`
+	// Nutrition is the nutrition facts of a single unit of the material, it's nil when they aren't known.
+	Nutrition *NutritionFacts `json:"nutrition,omitempty" bson:"nutrition,omitempty"`
+	// Allergens are the allergens the material contains.
+	Allergens []string `json:"allergens,omitempty" bson:"allergens,omitempty"`
+	// Diet is vegan or vegetarian when the material is suitable for the diet, it's empty otherwise.
+	Diet string `json:"diet,omitempty" bson:"diet,omitempty"`
+	// AverageCost is the moving average unit cost of the stock, it's updated when stock is received
+	// and it's what the weighted average costing method prices the consumptions at.
+	AverageCost float64 `json:"average_cost" bson:"average_cost"`
+}
+
+// Gross returns the quantity of the material to take from the inventory to end up
+// with the given net quantity after the trim loss.
+func (m Material) Gross(net float64) float64 {
+	return grossQuantity(net, m.YieldPercent)
 }
 
 // ProductEntry represents an entry of a product, detailing purchase and quantity information.
@@ -190,9 +301,46 @@ type Product struct {
 	Entries     []ProductEntry `bson:"entries" json:"entries"`
 	Price       float64        `bson:"price" json:"price"`
 	ImageURL    string         `bson:"image_url" json:"image_url"`
-	Unit        string         `bson:"unit" json:"unit"`
-	Quantity    float64        `bson:"quantity" json:"quantity"`
-	Ready       float64        `bson:"ready" json:"ready"`
+	// Description and Name are in the default language, Translations has them in the other languages.
+	Description  string       `bson:"description,omitempty" json:"description,omitempty"`
+	Translations Translations `bson:"translations,omitempty" json:"translations,omitempty"`
+	// Images are the uploaded images of the product, ImageURL is the url of the primary one.
+	Images   []ProductImage `bson:"images,omitempty" json:"images"`
+	Unit     string         `bson:"unit" json:"unit"`
+	Quantity float64        `bson:"quantity" json:"quantity"`
+	Ready    float64        `bson:"ready" json:"ready"`
+	// ShelfLifeHours is the default shelf life of the produced batches of the product, they don't expire when it's zero.
+	ShelfLifeHours float64 `bson:"shelf_life_hours" json:"shelf_life_hours"`
+	// Version is the number of the current version of the recipe, it's incremented every time the recipe is saved.
+	Version int `bson:"version" json:"version"`
+	// YieldPercent is the usable percentage of the product when it's a sub product of a recipe,
+	// the quantity of the sub product is the net quantity. It's treated as 100 when it's zero.
+	YieldPercent float64 `bson:"yield_percent,omitempty" json:"yield_percent"`
+	// GrossQuantity is the quantity of the sub product needed for the net quantity, it's only filled in the recipe tree.
+	GrossQuantity float64 `bson:"-" json:"gross_quantity,omitempty"`
+	// Schedule is the weekly windows the product is served in, it's served all the time when it's empty.
+	Schedule []AvailabilityWindow `bson:"schedule,omitempty" json:"schedule,omitempty"`
+	// IsEightySixed is set when the product ran out and can't be ordered, until EightySixedUntil when it's set.
+	IsEightySixed    bool      `bson:"is_eighty_sixed" json:"is_eighty_sixed"`
+	EightySixedUntil time.Time `bson:"eighty_sixed_until,omitempty" json:"eighty_sixed_until"`
+	// MenuAvailability is whether the product can be ordered at the moment, it's only filled when listing the products.
+	MenuAvailability *MenuAvailability `bson:"-" json:"menu_availability,omitempty"`
+	// Nutrition is rolled up from the materials of the recipe tree, it's only filled when the product is returned by the api.
+	Nutrition *ProductNutrition `bson:"-" json:"nutrition,omitempty"`
+}
+
+// Gross returns the quantity of the sub product needed to end up with the given net quantity after the loss.
+func (p Product) Gross(net float64) float64 {
+	return grossQuantity(net, p.YieldPercent)
+}
+
+// grossQuantity scales a net quantity up by a yield percentage, a zero yield means there is no loss.
+func grossQuantity(net float64, yield_percent float64) float64 {
+	if yield_percent <= 0 || yield_percent >= 100 {
+		return net
+	}
+
+	return net * 100 / yield_percent
 }
 
 // SalesLogs represents logs of sales, capturing sale price, items, and consumption details.
@@ -57,3 +57,13 @@ type WebsocketOrderSubmitServerMessage struct {
 	WebsocketTopicServerMessage `json:",inline"`
 	Order                       Order `json:"order"`
 }
+
+// WebsocketEightySixServerMessage is a message sent by the server when a product
+// is 86'd or back on the menu.
+type WebsocketEightySixServerMessage struct {
+	WebsocketTopicServerMessage `json:",inline"`
+	ProductId                   string    `json:"product_id"`
+	ProductName                 string    `json:"product_name"`
+	IsEightySixed               bool      `json:"is_eighty_sixed"`
+	EightySixedUntil            time.Time `json:"eighty_sixed_until"`
+}
@@ -0,0 +1,91 @@
+package models
+
+// Allergens of the materials, the 14 major allergens.
+const (
+	AllergenGluten      = "gluten"
+	AllergenCrustaceans = "crustaceans"
+	AllergenEggs        = "eggs"
+	AllergenFish        = "fish"
+	AllergenPeanuts     = "peanuts"
+	AllergenSoybeans    = "soybeans"
+	AllergenMilk        = "milk"
+	AllergenTreeNuts    = "tree_nuts"
+	AllergenCelery      = "celery"
+	AllergenMustard     = "mustard"
+	AllergenSesame      = "sesame"
+	AllergenSulphites   = "sulphites"
+	AllergenLupin       = "lupin"
+	AllergenMolluscs    = "molluscs"
+)
+
+// Allergens is the list of the known allergens.
+var Allergens = []string{
+	AllergenGluten, AllergenCrustaceans, AllergenEggs, AllergenFish, AllergenPeanuts, AllergenSoybeans, AllergenMilk,
+	AllergenTreeNuts, AllergenCelery, AllergenMustard, AllergenSesame, AllergenSulphites, AllergenLupin, AllergenMolluscs,
+}
+
+// Diets of the materials, a material without a diet isn't suitable for either.
+const (
+	DietVegan      = "vegan"
+	DietVegetarian = "vegetarian"
+)
+
+// Dietary tags of the products, derived from the diets and the allergens of their materials.
+const (
+	DietaryTagVegan      = "vegan"
+	DietaryTagVegetarian = "vegetarian"
+	DietaryTagGlutenFree = "gluten_free"
+	DietaryTagDairyFree  = "dairy_free"
+	DietaryTagNutFree    = "nut_free"
+)
+
+// NutritionFacts are the nutrition facts of a quantity of a material or a product.
+// The macros are in grams and the sodium in milligrams.
+type NutritionFacts struct {
+	EnergyKcal    float64 `json:"energy_kcal" bson:"energy_kcal"`
+	Protein       float64 `json:"protein" bson:"protein"`
+	Carbohydrates float64 `json:"carbohydrates" bson:"carbohydrates"`
+	Sugars        float64 `json:"sugars" bson:"sugars"`
+	Fat           float64 `json:"fat" bson:"fat"`
+	SaturatedFat  float64 `json:"saturated_fat" bson:"saturated_fat"`
+	Fiber         float64 `json:"fiber" bson:"fiber"`
+	Sodium        float64 `json:"sodium" bson:"sodium"`
+}
+
+// Add returns the sum of the nutrition facts.
+func (n NutritionFacts) Add(other NutritionFacts) NutritionFacts {
+	return NutritionFacts{
+		EnergyKcal:    n.EnergyKcal + other.EnergyKcal,
+		Protein:       n.Protein + other.Protein,
+		Carbohydrates: n.Carbohydrates + other.Carbohydrates,
+		Sugars:        n.Sugars + other.Sugars,
+		Fat:           n.Fat + other.Fat,
+		SaturatedFat:  n.SaturatedFat + other.SaturatedFat,
+		Fiber:         n.Fiber + other.Fiber,
+		Sodium:        n.Sodium + other.Sodium,
+	}
+}
+
+// Scale returns the nutrition facts of a quantity, the facts being of a single unit.
+func (n NutritionFacts) Scale(quantity float64) NutritionFacts {
+	return NutritionFacts{
+		EnergyKcal:    n.EnergyKcal * quantity,
+		Protein:       n.Protein * quantity,
+		Carbohydrates: n.Carbohydrates * quantity,
+		Sugars:        n.Sugars * quantity,
+		Fat:           n.Fat * quantity,
+		SaturatedFat:  n.SaturatedFat * quantity,
+		Fiber:         n.Fiber * quantity,
+		Sodium:        n.Sodium * quantity,
+	}
+}
+
+// ProductNutrition is the nutrition of a single unit of a product rolled up from its recipe tree.
+type ProductNutrition struct {
+	Facts       NutritionFacts `json:"facts"`
+	Allergens   []string       `json:"allergens"`
+	DietaryTags []string       `json:"dietary_tags"`
+	// IsComplete is set when all the materials of the recipe tree have their nutrition facts,
+	// otherwise the facts only cover the materials that have them. It isn't set for a recipe without components.
+	IsComplete bool `json:"is_complete"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// ProductPrice is a dated sale price of a product, the price in effect at a time is the latest one
+// effective from before it. A price effective in the future is a scheduled price change.
+type ProductPrice struct {
+	Id            string    `json:"id" bson:"id"`
+	ProductId     string    `json:"product_id" bson:"product_id"`
+	Price         float64   `json:"price" bson:"price"`
+	EffectiveFrom time.Time `json:"effective_from" bson:"effective_from"`
+	// IsApplied is set once the price was copied to the product, when it took effect.
+	IsApplied bool `json:"is_applied" bson:"is_applied"`
+	// Author is the id of the user who set the price, it's empty when the price was set by the system.
+	Author    string    `json:"author" bson:"author"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
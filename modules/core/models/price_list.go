@@ -0,0 +1,25 @@
+package models
+
+// Service styles of an order, a price list with a service style prices the orders of that style
+// that don't choose a price list explicitly.
+const (
+	ServiceStyleDineIn   = "dine_in"
+	ServiceStyleTakeAway = "takeaway"
+	ServiceStyleDelivery = "delivery"
+)
+
+// PriceListItem overrides the price of a product in a price list.
+type PriceListItem struct {
+	ProductId string  `json:"product_id" bson:"product_id"`
+	Price     float64 `json:"price" bson:"price"`
+}
+
+// PriceList is a named set of product prices for a sales channel, such as delivery, staff or an aggregator.
+// The products without an override are sold at their own price.
+type PriceList struct {
+	Id   string `json:"id" bson:"id"`
+	Name string `json:"name" bson:"name"`
+	// ServiceStyle is dine_in, takeaway or delivery, it's empty for the price lists only chosen explicitly on the order.
+	ServiceStyle string          `json:"service_style" bson:"service_style"`
+	Items        []PriceListItem `json:"items" bson:"items"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ProductionBatch represents a quantity of a product prepared ahead of the orders, it fills the product ready stock.
+// The batches are consumed from the oldest to the newest, and expire like material entries.
+type ProductionBatch struct {
+	Id          string `json:"id" bson:"id"`
+	ProductId   string `json:"product_id" bson:"product_id"`
+	ProductName string `json:"product_name" bson:"product_name"`
+	// Quantity is the produced quantity, and Remaining is what's left of it in the ready stock.
+	Quantity  float64 `json:"quantity" bson:"quantity"`
+	Remaining float64 `json:"remaining" bson:"remaining"`
+	// Cost is the total cost of the consumed materials and sub products, UnitCost is the cost of a single unit.
+	Cost           float64   `json:"cost" bson:"cost"`
+	UnitCost       float64   `json:"unit_cost" bson:"unit_cost"`
+	ProducedAt     time.Time `json:"produced_at" bson:"produced_at"`
+	ExpirationDate time.Time `json:"expiration_date" bson:"expiration_date"`
+	IsExpired      bool      `json:"is_expired" bson:"is_expired"`
+	ExpiredAt      time.Time `json:"expired_at" bson:"expired_at"`
+	// Item holds the materials entries and sub products consumed by the batch.
+	Item OrderItem `json:"item" bson:"item"`
+}
+
+// IsUsable checks if the batch can still be consumed at the given time.
+func (pb ProductionBatch) IsUsable(at time.Time) bool {
+	if pb.IsExpired || pb.Remaining <= 0 {
+		return false
+	}
+
+	return pb.ExpirationDate.IsZero() || pb.ExpirationDate.After(at)
+}
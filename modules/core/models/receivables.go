@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Receivable entry types, every change of the balance of a customer account is recorded as one of them.
+const (
+	// ReceivableTypeCharge charges a pay-later order to the account.
+	ReceivableTypeCharge = "charge"
+	// ReceivableTypePayment is a payment posted against the balance.
+	ReceivableTypePayment = "payment"
+	// ReceivableTypeReversal reverses the charge of a cancelled order.
+	ReceivableTypeReversal = "reversal"
+)
+
+// ReceivableEntry is an immutable record of a change of the balance of a customer account,
+// the balance of an account is the sum of its entries.
+type ReceivableEntry struct {
+	Id         string    `json:"id" bson:"id"`
+	CustomerId string    `json:"customer_id" bson:"customer_id"`
+	Type       string    `json:"type" bson:"type"`
+	Date       time.Time `json:"date" bson:"date"`
+	// Amount is signed, it's positive for the charges and negative for the payments and the reversals.
+	Amount float64 `json:"amount" bson:"amount"`
+	// Balance is the balance of the account after the entry.
+	Balance float64 `json:"balance" bson:"balance"`
+	// OrderId is the charged order, or the order a payment or a reversal settles.
+	OrderId        string `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	OrderDisplayId string `json:"order_display_id,omitempty" bson:"order_display_id,omitempty"`
+	// Method is how a payment was made, e.g. cash or card.
+	Method  string `json:"method,omitempty" bson:"method,omitempty"`
+	Comment string `json:"comment,omitempty" bson:"comment,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Recipe version actions, they describe how a version was created.
+const (
+	RecipeVersionActionCreate   = "create"
+	RecipeVersionActionUpdate   = "update"
+	RecipeVersionActionRollback = "rollback"
+	// RecipeVersionActionPrice is a scheduled price change that took effect.
+	RecipeVersionActionPrice = "price"
+)
+
+// RecipeVersion is an immutable snapshot of a recipe, a new version is created every time the recipe is saved.
+type RecipeVersion struct {
+	Id       string `json:"id" bson:"id"`
+	RecipeId string `json:"recipe_id" bson:"recipe_id"`
+	Version  int    `json:"version" bson:"version"`
+	Action   string `json:"action" bson:"action"`
+	// Author is the id of the user who saved the recipe, it's empty when the recipe was saved by the system.
+	Author    string    `json:"author" bson:"author"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// RolledBackFrom is the version restored by a rollback.
+	RolledBackFrom int     `json:"rolled_back_from,omitempty" bson:"rolled_back_from,omitempty"`
+	Recipe         Product `json:"recipe" bson:"recipe"`
+}
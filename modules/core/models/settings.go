@@ -1,5 +1,15 @@
 package models
 
+// Costing methods used to price the consumption and the valuation of the inventory.
+const (
+	// CostingMethodSpecific prices consumption at the purchase price of the consumed entry.
+	CostingMethodSpecific = "specific"
+	// CostingMethodFIFO prices consumption at the purchase price of the oldest entries in stock.
+	CostingMethodFIFO = "fifo"
+	// CostingMethodWeightedAverage prices consumption at the moving weighted average purchase price of the entries in stock.
+	CostingMethodWeightedAverage = "weighted_average"
+)
+
 // OrderQueueSettings represents the configuration settings for an order queue
 type OrderQueueSettings struct {
 	Prefix string `json:"prefix" bson:"prefix"`
@@ -21,10 +31,23 @@ type Settings struct {
 	Id        string `bson:"id,omitempty" json:"id"`
 	Inventory struct {
 		DefaultInventoryQuantityWarn float64 `json:"default_inventory_quantity_warn" bson:"default_inventory_quantity_warn"`
+		// ReorderLookbackDays is the number of past days of consumption used to compute the usage velocity.
+		ReorderLookbackDays int `json:"reorder_lookback_days" bson:"reorder_lookback_days"`
+		// ReorderReviewDays is the number of days of usage a reorder should cover after it is delivered.
+		ReorderReviewDays float64 `json:"reorder_review_days" bson:"reorder_review_days"`
+		// DefaultLeadTimeDays is used for materials that don't have their own supplier lead time.
+		DefaultLeadTimeDays float64 `json:"default_lead_time_days" bson:"default_lead_time_days"`
+		// DefaultExpiryWarningDays is used for materials that don't have their own expiry warning window.
+		DefaultExpiryWarningDays float64 `json:"default_expiry_warning_days" bson:"default_expiry_warning_days"`
+		// CostingMethod is one of specific, fifo or weighted_average, specific is used when it's not set.
+		CostingMethod string `json:"costing_method" bson:"costing_method"`
 	} `bson:"inventory" json:"inventory"`
 	Orders         OrderSettings    `bson:"orders" json:"orders"`
 	Language       LanguageSettings `bson:"language" json:"language"`
 	ReceiptPrinter struct {
 		Host string `bson:"host" json:"host"`
+		// IsPrintNutrition prints the energy and the allergens of the items on the receipts.
+		IsPrintNutrition bool `bson:"is_print_nutrition" json:"is_print_nutrition"`
 	} `bson:"receipt_printer" json:"receipt_printer"`
+	Loyalty LoyaltySettings `bson:"loyalty" json:"loyalty"`
 }
@@ -0,0 +1,57 @@
+package models
+
+// Translation is the name and the description of a product, a category or a material in a language.
+type Translation struct {
+	Name        string `json:"name" bson:"name"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// Translations are the translations of a catalog item by language code. The untranslated name and description
+// of the item are in the default language of the settings.
+type Translations map[string]Translation
+
+// Localize returns the name and the description in the language, each of them falls back to the untranslated one
+// when it isn't translated.
+func (t Translations) Localize(lang_code string, name string, description string) (string, string) {
+
+	translation, ok := t[lang_code]
+	if !ok {
+		return name, description
+	}
+
+	if translation.Name != "" {
+		name = translation.Name
+	}
+	if translation.Description != "" {
+		description = translation.Description
+	}
+
+	return name, description
+}
+
+// Normalize moves the translation of the default language to the untranslated name and description, its empty
+// description clears the untranslated one, and drops the empty translations. The translations are nil when none is left.
+func (t Translations) Normalize(default_lang_code string, name *string, description *string) Translations {
+
+	if translation, ok := t[default_lang_code]; ok && default_lang_code != "" {
+		if translation.Name != "" {
+			*name = translation.Name
+		}
+		*description = translation.Description
+	}
+
+	var normalized Translations
+
+	for lang_code, translation := range t {
+		if lang_code == default_lang_code || lang_code == "" || (translation.Name == "" && translation.Description == "") {
+			continue
+		}
+
+		if normalized == nil {
+			normalized = make(Translations)
+		}
+		normalized[lang_code] = translation
+	}
+
+	return normalized
+}
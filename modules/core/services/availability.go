@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// availabilityCacheTTL is how long a computed availability is served from the cache, the entries and the batches
+// expire with the time without a stock change to clear the cache.
+const availabilityCacheTTL = 5 * time.Minute
+
+// availabilityCache holds the computed availability of the recipes, it's shared by all the requests
+// and cleared by every change of the stock or the recipes.
+var availabilityCache = struct {
+	sync.Mutex
+	recipes map[string]dto.RecipeAvailability
+	// generation is incremented by every invalidation, so an availability computed
+	// before the invalidation isn't stored after it.
+	generation uint64
+}{
+	recipes: make(map[string]dto.RecipeAvailability),
+}
+
+// InvalidateAvailabilityCache clears the cached availability of all the recipes,
+// it's called whenever the stock of a material or a product, or a recipe changes.
+func InvalidateAvailabilityCache() {
+	availabilityCache.Lock()
+	defer availabilityCache.Unlock()
+
+	availabilityCache.recipes = make(map[string]dto.RecipeAvailability)
+	availabilityCache.generation++
+}
+
+// cachedAvailabilities returns the cached availabilities of the recipes that are still fresh,
+// the ids of the recipes that need to be computed, and the current cache generation.
+func cachedAvailabilities(recipe_ids []string, now time.Time) (cached map[string]dto.RecipeAvailability, missing []string, generation uint64) {
+	availabilityCache.Lock()
+	defer availabilityCache.Unlock()
+
+	cached = make(map[string]dto.RecipeAvailability)
+	for _, recipe_id := range recipe_ids {
+		availability, ok := availabilityCache.recipes[recipe_id]
+		if ok && now.Sub(availability.ComputedAt) < availabilityCacheTTL {
+			cached[recipe_id] = availability
+			continue
+		}
+		missing = append(missing, recipe_id)
+	}
+
+	return cached, missing, availabilityCache.generation
+}
+
+// cacheAvailabilities stores the computed availabilities unless the cache was invalidated while they were computed.
+func cacheAvailabilities(availabilities map[string]dto.RecipeAvailability, generation uint64) {
+	availabilityCache.Lock()
+	defer availabilityCache.Unlock()
+
+	if availabilityCache.generation != generation {
+		return
+	}
+
+	for recipe_id, availability := range availabilities {
+		availabilityCache.recipes[recipe_id] = availability
+	}
+}
+
+// availabilityEngine computes the availability of recipes from the recipes and the materials loaded in bulk.
+type availabilityEngine struct {
+	recipes   map[string]models.Product
+	materials map[string]models.Material
+	// inventory is the usable quantity of each material.
+	inventory map[string]float64
+	results   map[string]dto.RecipeAvailability
+	now       time.Time
+}
+
+// availabilityRequirement accumulates what's needed to make a number of units of a recipe.
+type availabilityRequirement struct {
+	materials map[string]float64
+	// ready is the quantity taken from the ready stock of each sub product.
+	ready map[string]float64
+}
+
+// loadAvailabilityEngine loads the recipes and their sub products level by level, then the materials they use,
+// with a query per level instead of a query per recipe.
+func loadAvailabilityEngine(ctx context.Context, client *mongo.Client, recipe_ids []string, now time.Time) (engine *availabilityEngine, err error) {
+
+	engine = &availabilityEngine{
+		recipes:   make(map[string]models.Product),
+		materials: make(map[string]models.Material),
+		inventory: make(map[string]float64),
+		results:   make(map[string]dto.RecipeAvailability),
+		now:       now,
+	}
+
+	load_recipes := func(filter bson.M) ([]models.Product, error) {
+		var recipes []models.Product
+		cursor, err := client.Database("waha").Collection("recipes").Find(ctx, filter)
+		if err != nil {
+			return recipes, err
+		}
+		err = cursor.All(ctx, &recipes)
+		return recipes, err
+	}
+
+	// the levels are bounded by the recipe depth, the recipes already loaded stop the cycles
+	pending := recipe_ids
+	for level := 0; len(pending) > 0 && level <= maxRecipeDepth+1; level++ {
+
+		recipes, err := load_recipes(bson.M{"id": bson.M{"$in": pending}})
+		if err != nil {
+			return engine, err
+		}
+
+		pending = nil
+		for _, recipe := range recipes {
+			engine.recipes[recipe.Id] = recipe
+		}
+		for _, recipe := range recipes {
+			for _, sub_product := range recipe.SubProducts {
+				if _, ok := engine.recipes[sub_product.Id]; !ok {
+					pending = append(pending, sub_product.Id)
+				}
+			}
+		}
+	}
+
+	material_ids := make([]string, 0)
+	for _, recipe := range engine.recipes {
+		for _, material := range recipe.Materials {
+			material_ids = append(material_ids, material.Id)
+		}
+	}
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{"id": bson.M{"$in": material_ids}})
+	if err != nil {
+		return engine, err
+	}
+
+	var materials []models.Material
+	if err := cursor.All(ctx, &materials); err != nil {
+		return engine, err
+	}
+
+	for _, material := range materials {
+		engine.materials[material.Id] = material
+		for _, entry := range material.Entries {
+			if entry.Quantity > 0 && entry.IsUsable(now) {
+				engine.inventory[material.Id] += float64(entry.Quantity)
+			}
+		}
+	}
+
+	return engine, nil
+}
+
+// require adds the materials needed to make the units of the recipe to the requirement. The ready stock
+// of the sub products is used first when use_ready is set, the rest is made from their own components.
+// It returns the id of the first sub product that runs short when it has no components to make the rest from.
+func (e *availabilityEngine) require(recipe models.Product, units float64, requirement availabilityRequirement, use_ready bool, depth int) (short string, err error) {
+
+	if depth > maxRecipeDepth {
+		return "", fmt.Errorf("%w: %s is nested deeper than %d levels", customerrors.ErrInvalidRecipe, recipe.Id, maxRecipeDepth)
+	}
+
+	for _, material := range recipe.Materials {
+		requirement.materials[material.Id] += material.Gross(material.Quantity) * units
+	}
+
+	for _, sub_product := range recipe.SubProducts {
+
+		needed := sub_product.Gross(sub_product.Quantity) * units
+		sub_recipe := e.recipes[sub_product.Id]
+
+		if use_ready {
+			taken := math.Max(0, math.Min(needed, sub_recipe.Ready-requirement.ready[sub_product.Id]))
+			requirement.ready[sub_product.Id] += taken
+			needed -= taken
+		}
+
+		if needed <= ledgerTolerance {
+			continue
+		}
+
+		if len(sub_recipe.Materials) == 0 && len(sub_recipe.SubProducts) == 0 {
+			if short == "" {
+				short = sub_product.Id
+			}
+			continue
+		}
+
+		sub_short, err := e.require(sub_recipe, needed, requirement, use_ready, depth+1)
+		if err != nil {
+			return short, err
+		}
+		if short == "" {
+			short = sub_short
+		}
+	}
+
+	return short, nil
+}
+
+// bottleneck checks whether the units of the recipe can be made, and returns the component
+// limiting them when they can't.
+func (e *availabilityEngine) bottleneck(recipe models.Product, units float64, per_unit map[string]float64) (bottleneck *dto.AvailabilityBottleneck, err error) {
+
+	requirement := availabilityRequirement{
+		materials: make(map[string]float64),
+		ready:     make(map[string]float64),
+	}
+
+	short, err := e.require(recipe, units, requirement, true, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if short != "" {
+		return &dto.AvailabilityBottleneck{
+			ComponentId:   short,
+			ComponentName: e.recipes[short].Name,
+			Type:          dto.BottleneckTypeProduct,
+			Available:     e.recipes[short].Ready,
+		}, nil
+	}
+
+	// the most overused material is the one limiting the recipe
+	worst_ratio := 1.0
+	for material_id, required := range requirement.materials {
+		if required <= ledgerTolerance {
+			continue
+		}
+
+		ratio := required / math.Max(e.inventory[material_id], ledgerTolerance)
+		if ratio > worst_ratio+ledgerTolerance {
+			worst_ratio = ratio
+			bottleneck = &dto.AvailabilityBottleneck{
+				ComponentId:     material_id,
+				ComponentName:   e.materials[material_id].Name,
+				Type:            dto.BottleneckTypeMaterial,
+				Available:       e.inventory[material_id],
+				RequiredPerUnit: per_unit[material_id],
+			}
+		}
+	}
+
+	return bottleneck, nil
+}
+
+// availability computes the availability of a recipe: its ready stock plus the units that can be made
+// from the inventory, after using the ready stock of its sub products.
+func (e *availabilityEngine) availability(recipe_id string) (availability dto.RecipeAvailability, err error) {
+
+	if availability, ok := e.results[recipe_id]; ok {
+		return availability, nil
+	}
+
+	recipe, ok := e.recipes[recipe_id]
+	if !ok {
+		return availability, mongo.ErrNoDocuments
+	}
+
+	availability = dto.RecipeAvailability{
+		RecipeId:              recipe_id,
+		Ready:                 recipe.Ready,
+		Available:             recipe.Ready,
+		ComponentRequirements: make(map[string]float64),
+		ComputedAt:            e.now,
+	}
+
+	per_unit := availabilityRequirement{
+		materials: availability.ComponentRequirements,
+		ready:     make(map[string]float64),
+	}
+
+	_, err = e.require(recipe, 1, per_unit, false, 0)
+	if err != nil {
+		return availability, err
+	}
+
+	if len(recipe.Materials) == 0 && len(recipe.SubProducts) == 0 {
+		e.results[recipe_id] = availability
+		return availability, nil
+	}
+
+	// the units that can be made grow by doubling until they run short, then they are narrowed down
+	low, high := 0.0, 1.0
+	var bottleneck *dto.AvailabilityBottleneck
+	for {
+		bottleneck, err = e.bottleneck(recipe, high, availability.ComponentRequirements)
+		if err != nil {
+			return availability, err
+		}
+		if bottleneck != nil || high >= 1e9 {
+			break
+		}
+		low, high = high, high*2
+	}
+
+	// nothing runs short when the components have no quantities, they don't make the recipe available
+	if bottleneck == nil {
+		low = 0
+	}
+
+	if bottleneck != nil {
+		for iteration := 0; iteration < 60 && high-low > 1e-7; iteration++ {
+			middle := (low + high) / 2
+
+			middle_bottleneck, err := e.bottleneck(recipe, middle, availability.ComponentRequirements)
+			if err != nil {
+				return availability, err
+			}
+
+			if middle_bottleneck == nil {
+				low = middle
+			} else {
+				high, bottleneck = middle, middle_bottleneck
+			}
+		}
+	}
+
+	availability.Available += math.Floor(low*1e6) / 1e6
+	availability.Bottleneck = bottleneck
+
+	e.results[recipe_id] = availability
+
+	return availability, nil
+}
+
+// CheckRecipesAvailability checks the availability of a list of recipes, or of all of them when the list is empty.
+// It returns a slice of RecipeAvailability with the available and ready number for each recipe, and the component
+// limiting it. The component requirements are the gross quantities after applying the recipe yields.
+//
+// The recipes and materials are loaded in bulk and the results are cached until the stock or the recipes change.
+func (rs *RecipeService) CheckRecipesAvailability(recipe_ids []string) (availabilities []dto.RecipeAvailability, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return availabilities, err
+	}
+
+	if len(recipe_ids) == 0 {
+		cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1}).SetSort(bson.M{"name": 1}))
+		if err != nil {
+			return availabilities, err
+		}
+
+		var recipes []models.Product
+		if err := cursor.All(ctx, &recipes); err != nil {
+			return availabilities, err
+		}
+
+		for _, recipe := range recipes {
+			recipe_ids = append(recipe_ids, recipe.Id)
+		}
+	}
+
+	now := time.Now()
+
+	cached, missing, generation := cachedAvailabilities(recipe_ids, now)
+
+	if len(missing) > 0 {
+
+		engine, err := loadAvailabilityEngine(ctx, client, missing, now)
+		if err != nil {
+			return availabilities, err
+		}
+
+		for _, recipe_id := range missing {
+			cached[recipe_id], err = engine.availability(recipe_id)
+			if err != nil {
+				return availabilities, err
+			}
+		}
+
+		cacheAvailabilities(engine.results, generation)
+	}
+
+	availabilities = make([]dto.RecipeAvailability, 0, len(recipe_ids))
+	for _, recipe_id := range recipe_ids {
+		availabilities = append(availabilities, cached[recipe_id])
+	}
+
+	return availabilities, nil
+}
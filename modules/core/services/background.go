@@ -9,20 +9,30 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
 	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// CheckExpirationDates is a background job that checks all materials if they are expired
+// defaultExpiryWarningDays is the expiry warning window used when neither the material
+// nor the inventory settings define one.
+const defaultExpiryWarningDays = 14
+
+// CheckExpirationDates is a background job that checks all material entries for their expiration date
 // and informs the admin about it. The function is designed to be called periodically
 // by the job scheduler.
-func CheckExpirationDates(log logger.ILogger, conf config.Config, notification_svc INotificationService) {
+//
+// Entries that are about to expire within the material warning window are notified on the expire_soon topic,
+// and expired entries are quarantined and notified on the expired topic. Each threshold is notified at most
+// once per entry, entries without an expiration date or without remaining quantity are ignored.
+func CheckExpirationDates(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
 
 	log.Info("core:background: Checking expiration dates")
 
@@ -48,7 +58,6 @@ func CheckExpirationDates(log logger.ILogger, conf config.Config, notification_s
 
 	// Connected successfully
 
-	// Get the "test" collection from the database
 	collection := client.Database("waha").Collection("materials")
 	cursor, err := collection.Find(ctx, bson.M{})
 	if err != nil {
@@ -57,7 +66,8 @@ func CheckExpirationDates(log logger.ILogger, conf config.Config, notification_s
 	}
 	defer cursor.Close(ctx)
 
-	// Iterate over the documents and print them as JSON
+	now := time.Now()
+
 	for cursor.Next(ctx) {
 		var component models.Material
 		err := cursor.Decode(&component)
@@ -66,30 +76,358 @@ func CheckExpirationDates(log logger.ILogger, conf config.Config, notification_s
 			return
 		}
 
+		warning_days := component.Settings.ExpiryWarningDays
+		if warning_days <= 0 {
+			warning_days = settings.Inventory.DefaultExpiryWarningDays
+		}
+		if warning_days <= 0 {
+			warning_days = defaultExpiryWarningDays
+		}
+
 		for _, entry := range component.Entries {
-			t := time.Until(entry.ExpirationDate)
-			if t <= 14*24*time.Hour {
 
-				msg := fmt.Sprintf("Material %s, entry %s will expire within 2 weeks", component.Name, entry.Id)
+			if entry.ExpirationDate.IsZero() || entry.Quantity <= 0 || entry.IsQuarantined {
+				continue
+			}
+
+			threshold := ""
+			severity := "warn"
+			msg := ""
+
+			t := entry.ExpirationDate.Sub(now)
+			if t <= 0 {
+				threshold = "expired"
+				severity = "error"
+				msg = fmt.Sprintf("Material %s, entry %s has expired and was quarantined", component.Name, entry.Id)
+			} else if t <= time.Duration(warning_days*24*float64(time.Hour)) {
+				threshold = "expire_soon"
+				msg = fmt.Sprintf("Material %s, entry %s will expire within %g days", component.Name, entry.Id, warning_days)
+			} else {
+				continue
+			}
 
-				log.Warning(msg)
+			is_alerted := slices.Contains(entry.ExpiryAlerts, threshold)
+			if is_alerted && threshold == "expire_soon" {
+				continue
+			}
 
-				topic_msg := &models.WebsocketTopicServerMessage{
-					Type:      "topic_message",
-					TopicName: "expire_soon",
-					Message:   msg,
-					Severity:  "warn",
-					Date:      time.Now(),
+			update := bson.M{"$addToSet": bson.M{"entries.$.expiry_alerts": threshold}}
+			if threshold == "expired" {
+				update["$set"] = bson.M{
+					"entries.$.is_quarantined": true,
+					"entries.$.quarantined_at": now,
 				}
+			}
+
+			_, err = collection.UpdateOne(ctx, bson.M{"id": component.Id, "entries.id": entry.Id}, update)
+			if err != nil {
+				log.Error(err.Error())
+				continue
+			}
+
+			if threshold == "expired" {
+				InvalidateAvailabilityCache()
 
-				jsonstr, err := json.Marshal(topic_msg)
+				logs_data := bson.M{
+					"type":         "component_quarantine",
+					"date":         now,
+					"component_id": component.Id,
+					"entry_id":     entry.Id,
+					"quantity":     entry.Quantity,
+				}
+				_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
 				if err != nil {
 					log.Error(err.Error())
-					return
 				}
+			}
+
+			if is_alerted {
+				continue
+			}
+
+			log.Warning(msg)
 
-				notification_svc.SendToTopic("expire_soon", string(jsonstr))
+			topic_msg := &models.WebsocketTopicServerMessage{
+				Type:      "topic_message",
+				TopicName: threshold,
+				Message:   msg,
+				Severity:  severity,
+				Date:      now,
+				Key:       fmt.Sprintf("%s@%s", threshold, entry.Id),
 			}
+
+			jsonstr, err := json.Marshal(topic_msg)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			notification_svc.SendToTopic(threshold, string(jsonstr))
 		}
 	}
 }
+
+// NotifyReorderSuggestions is a background job that computes the reorder suggestions
+// and posts the materials that need to be reordered to the reorder_suggestions topic.
+// The function is designed to be called daily by the job scheduler.
+func NotifyReorderSuggestions(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Computing reorder suggestions")
+
+	reorder_svc := ReorderService{
+		Logger:   log,
+		Config:   conf,
+		Settings: settings,
+	}
+
+	suggestions, err := reorder_svc.GetReorderSuggestions(GetReorderSuggestionsParams{OnlyNeeded: true})
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if len(suggestions) == 0 {
+		log.Info("core:background: No materials need to be reordered")
+		return
+	}
+
+	msg := fmt.Sprintf("%d materials need to be reordered", len(suggestions))
+
+	topic_msg := dto.ReorderSuggestionsServerMessage{
+		WebsocketTopicServerMessage: models.WebsocketTopicServerMessage{
+			Type:      "topic_message",
+			TopicName: "reorder_suggestions",
+			Message:   msg,
+			Severity:  "info",
+			Date:      time.Now(),
+			Key:       fmt.Sprintf("reorder_suggestions@%s", time.Now().Format("2006-01-02")),
+		},
+		Suggestions: suggestions,
+	}
+
+	jsonstr, err := json.Marshal(topic_msg)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	notification_svc.SendToTopic("reorder_suggestions", string(jsonstr))
+}
+
+// CheckLedgerConsistency is a background job that compares the stored entry quantities with the inventory ledger
+// and posts the drifting entries to the inventory_drift topic.
+// The function is designed to be called daily by the job scheduler.
+func CheckLedgerConsistency(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Checking the inventory ledger consistency")
+
+	ledger_svc := LedgerService{
+		Logger:   log,
+		Config:   conf,
+		Settings: settings,
+	}
+
+	drifts, err := ledger_svc.CheckConsistency()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if len(drifts) == 0 {
+		log.Info("core:background: The inventory ledger is consistent")
+		return
+	}
+
+	msg := fmt.Sprintf("%d entries drifted from the inventory ledger", len(drifts))
+
+	topic_msg := dto.LedgerDriftServerMessage{
+		WebsocketTopicServerMessage: models.WebsocketTopicServerMessage{
+			Type:      "topic_message",
+			TopicName: "inventory_drift",
+			Message:   msg,
+			Severity:  "warn",
+			Date:      time.Now(),
+			Key:       fmt.Sprintf("inventory_drift@%s", time.Now().Format("2006-01-02")),
+		},
+		Drifts: drifts,
+	}
+
+	jsonstr, err := json.Marshal(topic_msg)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	notification_svc.SendToTopic("inventory_drift", string(jsonstr))
+}
+
+// CheckBatchesExpiry is a background job that expires the production batches that passed their expiration date,
+// their remaining quantity is removed from the ready stock and each expired batch is notified on the batch_expired topic.
+// The function is designed to be called periodically by the job scheduler.
+func CheckBatchesExpiry(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Checking production batches expiry")
+
+	production_svc := ProductionService{
+		Logger:   log,
+		Config:   conf,
+		Settings: settings,
+	}
+
+	expired, err := production_svc.ExpireBatches(time.Now())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	for _, batch := range expired {
+
+		msg := fmt.Sprintf("Batch %s of %s expired, %f units were removed from the ready stock", batch.Id, batch.ProductName, batch.Remaining)
+
+		topic_msg := models.WebsocketTopicServerMessage{
+			Type:      "topic_message",
+			TopicName: "batch_expired",
+			Message:   msg,
+			Severity:  "warn",
+			Date:      time.Now(),
+			Key:       fmt.Sprintf("batch_expired@%s", batch.Id),
+		}
+
+		jsonstr, err := json.Marshal(topic_msg)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		notification_svc.SendToTopic("batch_expired", string(jsonstr))
+	}
+}
+
+// ApplyScheduledPrices is a background job that applies the scheduled prices that became due to their products,
+// each applied price is notified on the price_changed topic.
+// The function is designed to be called periodically by the job scheduler.
+func ApplyScheduledPrices(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Applying scheduled prices")
+
+	recipe_svc := RecipeService{
+		Logger: log,
+		Config: conf,
+	}
+
+	applied, err := recipe_svc.ApplyDuePrices(time.Now())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	for _, price := range applied {
+
+		msg := fmt.Sprintf("The price of product %s changed to %f", price.ProductId, price.Price)
+
+		topic_msg := models.WebsocketTopicServerMessage{
+			Type:      "topic_message",
+			TopicName: "price_changed",
+			Message:   msg,
+			Severity:  "info",
+			Date:      time.Now(),
+			Key:       fmt.Sprintf("price_changed@%s", price.Id),
+		}
+
+		jsonstr, err := json.Marshal(topic_msg)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		notification_svc.SendToTopic("price_changed", string(jsonstr))
+	}
+}
+
+// ResetEightySixedProducts is a background job that puts back on the menu the 86'd products whose reset time passed,
+// each product is notified on the product_86 topic.
+// The function is designed to be called periodically by the job scheduler.
+func ResetEightySixedProducts(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Resetting 86'd products")
+
+	recipe_svc := RecipeService{
+		Logger: log,
+		Config: conf,
+	}
+
+	products, err := recipe_svc.ResetEightySixed(time.Now())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	for _, product := range products {
+
+		msg := models.WebsocketEightySixServerMessage{
+			ProductId:     product.Id,
+			ProductName:   product.Name,
+			IsEightySixed: false,
+			WebsocketTopicServerMessage: models.WebsocketTopicServerMessage{
+				Type:      "topic_message",
+				TopicName: "product_86",
+				Message:   fmt.Sprintf("%s is back on the menu", product.Name),
+				Severity:  "info",
+				Date:      time.Now(),
+				Key:       fmt.Sprintf("product_86@%s@%d", product.Id, time.Now().UnixNano()),
+			},
+		}
+
+		jsonstr, err := json.Marshal(msg)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+
+		notification_svc.SendToTopic("product_86", string(jsonstr))
+	}
+}
+
+// ExpireLoyaltyPoints takes the loyalty points that expired from the customers.
+func ExpireLoyaltyPoints(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Expiring loyalty points")
+
+	loyalty_svc := LoyaltyService{
+		Logger:   log,
+		Config:   conf,
+		Settings: settings,
+	}
+
+	expired, err := loyalty_svc.ExpirePoints(time.Now())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if expired > 0 {
+		log.Info(fmt.Sprintf("core:background: Expired the points of %d loyalty lots", expired))
+	}
+}
+
+// ExpireGiftCards takes the balances left on the gift cards that expired.
+func ExpireGiftCards(log logger.ILogger, conf config.Config, settings models.Settings, notification_svc INotificationService) {
+
+	log.Info("core:background: Expiring gift cards")
+
+	gift_card_svc := GiftCardService{
+		Logger:   log,
+		Config:   conf,
+		Settings: settings,
+	}
+
+	expired, err := gift_card_svc.ExpireGiftCards(time.Now())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if expired > 0 {
+		log.Info(fmt.Sprintf("core:background: Expired %d gift cards", expired))
+	}
+}
@@ -0,0 +1,1045 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The catalogs that can be imported and exported.
+const (
+	CatalogMaterials  = "materials"
+	CatalogProducts   = "products"
+	CatalogCategories = "categories"
+)
+
+// The actions of the imported rows.
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+	ImportActionError  = "error"
+)
+
+// catalogImportDeadline is the time an import can take, it's longer than the deadline of a single request
+// since every product is validated against all the recipes.
+const catalogImportDeadline = 10 * time.Minute
+
+// CatalogService provides methods to import and export the materials, the products and the categories in bulk.
+type CatalogService struct {
+	Logger logger.ILogger
+	Config config.Config
+}
+
+// catalogKey returns the key the names are matched by, they're matched ignoring the case and the surrounding spaces.
+func catalogKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// catalogIndex finds the ids of the materials or the products by id, by the SKU of their entries and by name.
+type catalogIndex struct {
+	names  map[string]string
+	bySKU  map[string]string
+	byName map[string]string
+}
+
+func newCatalogIndex() *catalogIndex {
+	return &catalogIndex{
+		names:  make(map[string]string),
+		bySKU:  make(map[string]string),
+		byName: make(map[string]string),
+	}
+}
+
+// add indexes a record, the first record keeps a name or a SKU shared by many.
+func (ci *catalogIndex) add(id string, name string, skus ...string) {
+
+	ci.names[id] = name
+
+	if _, ok := ci.byName[catalogKey(name)]; !ok && catalogKey(name) != "" {
+		ci.byName[catalogKey(name)] = id
+	}
+
+	for _, sku := range skus {
+		if _, ok := ci.bySKU[sku]; !ok && sku != "" {
+			ci.bySKU[sku] = id
+		}
+	}
+}
+
+// resolve returns the id of the referenced record, ok is false when it isn't found.
+func (ci *catalogIndex) resolve(ref dto.CatalogReference) (id string, ok bool) {
+
+	if _, ok := ci.names[ref.Id]; ok && ref.Id != "" {
+		return ref.Id, true
+	}
+
+	if id, ok := ci.bySKU[ref.SKU]; ok && ref.SKU != "" {
+		return id, true
+	}
+
+	id, ok = ci.byName[catalogKey(ref.Name)]
+
+	return id, ok && catalogKey(ref.Name) != ""
+}
+
+// describeReference returns the name, the SKU or the id of the reference for the error messages.
+func describeReference(ref dto.CatalogReference) string {
+	switch {
+	case ref.Name != "":
+		return ref.Name
+	case ref.SKU != "":
+		return "sku " + ref.SKU
+	}
+
+	return "id " + ref.Id
+}
+
+// addImportResult adds the result of a row to the report.
+func addImportResult(report *dto.ImportReport, result dto.ImportRowResult) {
+
+	report.Total++
+
+	switch result.Action {
+	case ImportActionCreate:
+		report.Created++
+	case ImportActionUpdate:
+		report.Updated++
+	case ImportActionError:
+		report.Failed++
+		result.Id = ""
+	}
+
+	report.Rows = append(report.Rows, result)
+}
+
+// importedId returns the id of a record to create, the id of the imported row is kept when it isn't used
+// so a file exported from another branch keeps its ids.
+func importedId(id string, is_used func(string) bool) string {
+	if id == "" || is_used(id) {
+		return primitive.NewObjectID().Hex()
+	}
+
+	return id
+}
+
+// materialEntry converts an imported entry to an inventory entry received now,
+// the purchase quantity is the quantity when it's not set.
+func materialEntry(entry dto.CatalogMaterialEntry) models.MaterialEntry {
+
+	material_entry := models.MaterialEntry{
+		Id:               entry.Id,
+		SKU:              entry.SKU,
+		Company:          entry.Company,
+		Quantity:         float32(entry.Quantity),
+		PurchaseQuantity: float32(entry.PurchaseQuantity),
+		PurchasePrice:    entry.PurchasePrice,
+		ExpirationDate:   entry.ExpirationDate,
+		ReceivedAt:       time.Now(),
+	}
+
+	if material_entry.Id == "" {
+		material_entry.Id = primitive.NewObjectID().Hex()
+	}
+
+	if material_entry.PurchaseQuantity == 0 {
+		material_entry.PurchaseQuantity = material_entry.Quantity
+	}
+
+	return material_entry
+}
+
+// validateCatalogMaterial checks the name, the yield, the nutrition and the entries of an imported material.
+func validateCatalogMaterial(material dto.CatalogMaterial) error {
+
+	if strings.TrimSpace(material.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if material.YieldPercent < 0 || material.YieldPercent > 100 {
+		return fmt.Errorf("%w: %f", customerrors.ErrInvalidYield, material.YieldPercent)
+	}
+
+	err := validateMaterialNutrition(models.Material{
+		Nutrition: material.Nutrition,
+		Allergens: material.Allergens,
+		Diet:      material.Diet,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range material.Entries {
+		if entry.Quantity < 0 || entry.PurchaseQuantity < 0 || entry.PurchasePrice < 0 {
+			return fmt.Errorf("entry %s has a negative quantity or price", entry.SKU)
+		}
+	}
+
+	return nil
+}
+
+// ImportMaterials creates the materials of the rows that don't match an existing material by id or name,
+// and updates the details of the matched ones. The entries are received in the inventory through the ledger,
+// the entries already in the inventory, matched by id or SKU, are left untouched so importing a file again
+// doesn't receive their stock twice. Nothing is saved on a dry run.
+func (cs *CatalogService) ImportMaterials(materials []dto.CatalogMaterial, dry_run bool) (report dto.ImportReport, err error) {
+
+	report = dto.ImportReport{Kind: CatalogMaterials, IsDryRun: dry_run, Rows: make([]dto.ImportRowResult, 0, len(materials))}
+
+	client, _, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	ctx, cancel_import := context.WithTimeout(context.Background(), catalogImportDeadline)
+	defer cancel_import()
+
+	collection := client.Database("waha").Collection("materials")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+
+	var existing []models.Material
+	if err := cursor.All(ctx, &existing); err != nil {
+		return report, err
+	}
+
+	index := newCatalogIndex()
+	by_id := make(map[string]models.Material)
+	for _, material := range existing {
+		index.add(material.Id, material.Name)
+		by_id[material.Id] = material
+	}
+
+	seen := make(map[string]int)
+
+	for _, row := range materials {
+
+		result := dto.ImportRowResult{Row: row.Row, Name: row.Name}
+
+		material_id, action, err := cs.importMaterial(ctx, client, index, by_id, seen, row, dry_run)
+		if err != nil {
+			result.Action = ImportActionError
+			result.Error = err.Error()
+		} else {
+			result.Id = material_id
+			result.Action = action
+		}
+
+		addImportResult(&report, result)
+	}
+
+	return report, nil
+}
+
+// importMaterial validates and saves a single imported material, it returns the id of the material and
+// whether it was created or updated.
+func (cs *CatalogService) importMaterial(ctx context.Context, client *mongo.Client, index *catalogIndex, by_id map[string]models.Material, seen map[string]int, row dto.CatalogMaterial, dry_run bool) (material_id string, action string, err error) {
+
+	err = validateCatalogMaterial(row)
+	if err != nil {
+		return "", "", err
+	}
+
+	if first_row, ok := seen[catalogKey(row.Name)]; ok {
+		return "", "", fmt.Errorf("%s is repeated, it's first imported at row %d", row.Name, first_row)
+	}
+	seen[catalogKey(row.Name)] = row.Row
+
+	collection := client.Database("waha").Collection("materials")
+
+	material_id, ok := index.resolve(dto.CatalogReference{Id: row.Id, Name: row.Name})
+
+	if !ok {
+		material := models.Material{
+			Id: importedId(row.Id, func(id string) bool {
+				_, ok := by_id[id]
+				return ok
+			}),
+			Name:         row.Name,
+			Description:  row.Description,
+			Translations: row.Translations,
+			Category:     row.Category,
+			Unit:         row.Unit,
+			YieldPercent: row.YieldPercent,
+			Settings:     row.Settings,
+			Nutrition:    row.Nutrition,
+			Allergens:    row.Allergens,
+			Diet:         row.Diet,
+			Entries:      make([]models.MaterialEntry, 0, len(row.Entries)),
+		}
+
+		received := make([]models.MaterialEntry, 0, len(row.Entries))
+
+		// the entries are inserted empty, their quantities are received through the ledger
+		for _, entry := range row.Entries {
+			material_entry := materialEntry(entry)
+			received = append(received, material_entry)
+			material_entry.Quantity = 0
+			material.Entries = append(material.Entries, material_entry)
+		}
+
+		index.add(material.Id, material.Name)
+		by_id[material.Id] = material
+
+		if dry_run {
+			return material.Id, ImportActionCreate, nil
+		}
+
+		_, err = collection.InsertOne(ctx, material)
+		if err != nil {
+			return "", "", err
+		}
+
+		err = receiveEntries(ctx, client, material.Id, received)
+
+		return material.Id, ImportActionCreate, err
+	}
+
+	existing := by_id[material_id]
+
+	existing_entries := make(map[string]bool)
+	for _, entry := range existing.Entries {
+		existing_entries[entry.Id] = true
+		if entry.SKU != "" {
+			existing_entries["sku:"+entry.SKU] = true
+		}
+	}
+
+	received := make([]models.MaterialEntry, 0)
+	pushed := make([]models.MaterialEntry, 0)
+
+	for _, entry := range row.Entries {
+		if existing_entries[entry.Id] || (entry.SKU != "" && existing_entries["sku:"+entry.SKU]) {
+			continue
+		}
+
+		material_entry := materialEntry(entry)
+		if existing_entries[material_entry.Id] {
+			material_entry.Id = primitive.NewObjectID().Hex()
+		}
+
+		received = append(received, material_entry)
+		material_entry.Quantity = 0
+		pushed = append(pushed, material_entry)
+	}
+
+	if dry_run {
+		return material_id, ImportActionUpdate, nil
+	}
+
+	set := bson.M{
+		"name":          row.Name,
+		"category":      row.Category,
+		"unit":          row.Unit,
+		"yield_percent": row.YieldPercent,
+		"settings":      row.Settings,
+	}
+
+	// the cleared nutrition fields are unset like when the material is edited
+	unset := bson.M{}
+	if row.Nutrition != nil {
+		set["nutrition"] = row.Nutrition
+	} else {
+		unset["nutrition"] = ""
+	}
+	if len(row.Allergens) > 0 {
+		set["allergens"] = row.Allergens
+	} else {
+		unset["allergens"] = ""
+	}
+	if row.Diet != "" {
+		set["diet"] = row.Diet
+	} else {
+		unset["diet"] = ""
+	}
+	if row.Description != "" {
+		set["description"] = row.Description
+	} else {
+		unset["description"] = ""
+	}
+	if len(row.Translations) > 0 {
+		set["translations"] = row.Translations
+	} else {
+		unset["translations"] = ""
+	}
+
+	update := bson.M{"$set": set, "$unset": unset}
+	if len(pushed) > 0 {
+		update["$push"] = bson.M{"entries": bson.M{"$each": pushed}}
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"id": material_id}, update)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = receiveEntries(ctx, client, material_id, received)
+
+	return material_id, ImportActionUpdate, err
+}
+
+// receiveEntries records the receipt of the quantities of the entries in the ledger.
+func receiveEntries(ctx context.Context, client *mongo.Client, material_id string, entries []models.MaterialEntry) error {
+
+	for _, entry := range entries {
+		if entry.Quantity <= 0 {
+			continue
+		}
+
+		_, err := recordMovement(ctx, client, receiptMovement(material_id, entry), true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExportMaterials returns all the materials with their entries sorted by name, in the format they're imported in.
+func (cs *CatalogService) ExportMaterials() (materials []dto.CatalogMaterial, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return materials, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return materials, err
+	}
+
+	var existing []models.Material
+	if err := cursor.All(ctx, &existing); err != nil {
+		return materials, err
+	}
+
+	materials = make([]dto.CatalogMaterial, 0, len(existing))
+
+	for _, material := range existing {
+
+		entries := make([]dto.CatalogMaterialEntry, 0, len(material.Entries))
+		for _, entry := range material.Entries {
+			entries = append(entries, dto.CatalogMaterialEntry{
+				Id:               entry.Id,
+				SKU:              entry.SKU,
+				Company:          entry.Company,
+				Quantity:         float64(entry.Quantity),
+				PurchaseQuantity: float64(entry.PurchaseQuantity),
+				PurchasePrice:    entry.PurchasePrice,
+				ExpirationDate:   entry.ExpirationDate,
+			})
+		}
+
+		materials = append(materials, dto.CatalogMaterial{
+			Id:           material.Id,
+			Name:         material.Name,
+			Description:  material.Description,
+			Translations: material.Translations,
+			Category:     material.Category,
+			Unit:         material.Unit,
+			YieldPercent: material.YieldPercent,
+			Settings:     material.Settings,
+			Nutrition:    material.Nutrition,
+			Allergens:    material.Allergens,
+			Diet:         material.Diet,
+			Entries:      entries,
+		})
+	}
+
+	return materials, nil
+}
+
+// loadMaterialIndex indexes the materials by id, by the SKUs of their entries and by name.
+func loadMaterialIndex(ctx context.Context, client *mongo.Client) (index *catalogIndex, units map[string]string, err error) {
+
+	index = newCatalogIndex()
+	units = make(map[string]string)
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1, "name": 1, "unit": 1, "entries.sku": 1}))
+	if err != nil {
+		return index, units, err
+	}
+
+	var materials []models.Material
+	if err := cursor.All(ctx, &materials); err != nil {
+		return index, units, err
+	}
+
+	for _, material := range materials {
+		skus := make([]string, 0, len(material.Entries))
+		for _, entry := range material.Entries {
+			skus = append(skus, entry.SKU)
+		}
+
+		index.add(material.Id, material.Name, skus...)
+		units[material.Id] = material.Unit
+	}
+
+	return index, units, nil
+}
+
+// productSKUs returns the SKUs of the entries of the product.
+func productSKUs(product models.Product) []string {
+	skus := make([]string, 0, len(product.Entries))
+	for _, entry := range product.Entries {
+		skus = append(skus, entry.SKU)
+	}
+
+	return skus
+}
+
+// plannedProduct is an imported product with its resolved recipe.
+type plannedProduct struct {
+	result   dto.ImportRowResult
+	recipe   models.Product
+	existing *models.Product
+}
+
+// ImportProducts creates the products of the rows that don't match an existing product by id or name,
+// and saves the recipes of the matched ones as new versions. The materials and the sub products of the
+// recipes are referenced by id, SKU or name, a sub product can be one of the imported products.
+// The products are saved after their sub products, a product fails when one of its sub products does.
+// Nothing is saved on a dry run.
+func (cs *CatalogService) ImportProducts(products []dto.CatalogProduct, dry_run bool, author string) (report dto.ImportReport, err error) {
+
+	report = dto.ImportReport{Kind: CatalogProducts, IsDryRun: dry_run, Rows: make([]dto.ImportRowResult, 0, len(products))}
+
+	client, _, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	ctx, cancel_import := context.WithTimeout(context.Background(), catalogImportDeadline)
+	defer cancel_import()
+
+	graph, err := loadRecipeGraph(ctx, client)
+	if err != nil {
+		return report, err
+	}
+
+	material_index, material_units, err := loadMaterialIndex(ctx, client)
+	if err != nil {
+		return report, err
+	}
+
+	product_index := newCatalogIndex()
+	for _, recipe := range graph.recipes {
+		product_index.add(recipe.Id, recipe.Name, productSKUs(recipe)...)
+	}
+
+	planned := make([]*plannedProduct, len(products))
+	seen := make(map[string]int)
+
+	fail := func(plan *plannedProduct, err error) {
+		plan.result.Action = ImportActionError
+		plan.result.Error = err.Error()
+	}
+
+	// the ids are resolved first so the products can reference the products after them
+	for index, row := range products {
+
+		plan := &plannedProduct{result: dto.ImportRowResult{Row: row.Row, Name: row.Name}}
+		planned[index] = plan
+
+		switch {
+		case strings.TrimSpace(row.Name) == "":
+			fail(plan, fmt.Errorf("name is required"))
+			continue
+		case row.Price < 0:
+			fail(plan, fmt.Errorf("%w: %f", customerrors.ErrInvalidPrice, row.Price))
+			continue
+		case row.ShelfLifeHours < 0:
+			fail(plan, fmt.Errorf("shelf life can't be negative"))
+			continue
+		}
+
+		if first_row, ok := seen[catalogKey(row.Name)]; ok {
+			fail(plan, fmt.Errorf("%s is repeated, it's first imported at row %d", row.Name, first_row))
+			continue
+		}
+		seen[catalogKey(row.Name)] = row.Row
+
+		product_id, ok := product_index.resolve(dto.CatalogReference{Id: row.Id, Name: row.Name})
+		if ok {
+			existing := graph.recipes[product_id]
+			plan.existing = &existing
+			plan.recipe = existing
+			plan.result.Action = ImportActionUpdate
+		} else {
+			product_id = importedId(row.Id, func(id string) bool {
+				_, ok := graph.recipes[id]
+				return ok
+			})
+			plan.recipe = models.Product{Unit: row.Unit, Entries: make([]models.ProductEntry, 0)}
+			plan.result.Action = ImportActionCreate
+			product_index.add(product_id, row.Name)
+		}
+
+		plan.result.Id = product_id
+		plan.recipe.Id = product_id
+		plan.recipe.Name = row.Name
+		plan.recipe.Description = row.Description
+		plan.recipe.Translations = row.Translations
+		plan.recipe.Price = row.Price
+		plan.recipe.ShelfLifeHours = row.ShelfLifeHours
+	}
+
+	// the components are resolved and the recipes are checked as they will be saved together
+	for index, row := range products {
+
+		plan := planned[index]
+		if plan.result.Action == ImportActionError {
+			continue
+		}
+
+		recipe, err := resolveCatalogRecipe(row, plan.recipe, material_index, material_units, product_index)
+		if err != nil {
+			fail(plan, err)
+			continue
+		}
+
+		err = validateRecipeYields(recipe)
+		if err != nil {
+			fail(plan, err)
+			continue
+		}
+
+		plan.recipe = recipe
+		graph.recipes[recipe.Id] = recipe
+	}
+
+	by_id := make(map[string]*plannedProduct)
+	for _, plan := range planned {
+		if plan.result.Action != ImportActionError {
+			by_id[plan.recipe.Id] = plan
+		}
+	}
+
+	for _, plan := range planned {
+		if plan.result.Action == ImportActionError {
+			continue
+		}
+
+		cycle, depth := graph.walk(plan.recipe.Id, nil, make(map[string]int))
+		if cycle != nil {
+			fail(plan, fmt.Errorf("%w: sub products form a cycle %s", customerrors.ErrInvalidRecipe, strings.Join(cycle, " -> ")))
+		} else if depth > maxRecipeDepth {
+			fail(plan, fmt.Errorf("%w: sub products are nested %d levels deep, the maximum is %d", customerrors.ErrInvalidRecipe, depth, maxRecipeDepth))
+		}
+	}
+
+	// a product fails when one of its new sub products failed
+	for changed := true; changed; {
+		changed = false
+
+		for _, plan := range planned {
+			if plan.result.Action != ImportActionCreate && plan.result.Action != ImportActionUpdate {
+				continue
+			}
+
+			for _, sub_product := range plan.recipe.SubProducts {
+				dependency, ok := by_id[sub_product.Id]
+				if ok && dependency.result.Action == ImportActionError && dependency.existing == nil {
+					fail(plan, fmt.Errorf("sub product %s of row %d failed to import", dependency.result.Name, dependency.result.Row))
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	if !dry_run {
+		for _, plan := range orderPlannedProducts(planned, by_id) {
+
+			if plan.result.Action == ImportActionCreate {
+				_, err = insertRecipe(ctx, client, plan.recipe, author)
+			} else {
+				err = updateRecipe(ctx, client, plan.recipe.Id, plan.recipe, author)
+			}
+
+			if err != nil {
+				fail(plan, err)
+			}
+		}
+	}
+
+	for _, plan := range planned {
+		addImportResult(&report, plan.result)
+	}
+
+	return report, nil
+}
+
+// resolveCatalogRecipe sets the materials and the sub products of the imported product on its recipe.
+func resolveCatalogRecipe(row dto.CatalogProduct, recipe models.Product, material_index *catalogIndex, material_units map[string]string, product_index *catalogIndex) (models.Product, error) {
+
+	recipe.Materials = make([]models.Material, 0, len(row.Materials))
+	recipe.SubProducts = make([]models.Product, 0, len(row.SubProducts))
+
+	for _, component := range row.Materials {
+
+		material_id, ok := material_index.resolve(component.CatalogReference)
+		if !ok {
+			return recipe, fmt.Errorf("%w: material %s doesn't exist", customerrors.ErrInvalidRecipe, describeReference(component.CatalogReference))
+		}
+
+		if component.Quantity <= 0 {
+			return recipe, fmt.Errorf("quantity of material %s must be positive", material_index.names[material_id])
+		}
+
+		recipe.Materials = append(recipe.Materials, models.Material{
+			Id:           material_id,
+			Name:         material_index.names[material_id],
+			Unit:         material_units[material_id],
+			Quantity:     component.Quantity,
+			YieldPercent: component.YieldPercent,
+		})
+	}
+
+	for _, component := range row.SubProducts {
+
+		product_id, ok := product_index.resolve(component.CatalogReference)
+		if !ok {
+			return recipe, fmt.Errorf("%w: sub product %s doesn't exist", customerrors.ErrInvalidRecipe, describeReference(component.CatalogReference))
+		}
+
+		if component.Quantity <= 0 {
+			return recipe, fmt.Errorf("quantity of sub product %s must be positive", product_index.names[product_id])
+		}
+
+		recipe.SubProducts = append(recipe.SubProducts, models.Product{
+			Id:           product_id,
+			Name:         product_index.names[product_id],
+			Quantity:     component.Quantity,
+			YieldPercent: component.YieldPercent,
+		})
+	}
+
+	return recipe, nil
+}
+
+// orderPlannedProducts returns the products to save, each one after the imported sub products it uses.
+func orderPlannedProducts(planned []*plannedProduct, by_id map[string]*plannedProduct) []*plannedProduct {
+
+	ordered := make([]*plannedProduct, 0, len(planned))
+	visited := make(map[string]bool)
+
+	var visit func(plan *plannedProduct)
+	visit = func(plan *plannedProduct) {
+		if visited[plan.recipe.Id] {
+			return
+		}
+		visited[plan.recipe.Id] = true
+
+		for _, sub_product := range plan.recipe.SubProducts {
+			if dependency, ok := by_id[sub_product.Id]; ok {
+				visit(dependency)
+			}
+		}
+
+		if plan.result.Action != ImportActionError {
+			ordered = append(ordered, plan)
+		}
+	}
+
+	for _, plan := range planned {
+		if plan.result.Action != ImportActionError {
+			visit(plan)
+		}
+	}
+
+	return ordered
+}
+
+// ExportProducts returns all the products sorted by name with their materials and sub products
+// referenced by id and name, in the format they're imported in.
+func (cs *CatalogService) ExportProducts() (products []dto.CatalogProduct, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return products, err
+	}
+	defer cancel()
+	// connected to db
+
+	material_index, _, err := loadMaterialIndex(ctx, client)
+	if err != nil {
+		return products, err
+	}
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return products, err
+	}
+
+	var recipes []models.Product
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return products, err
+	}
+
+	product_names := make(map[string]string)
+	for _, recipe := range recipes {
+		product_names[recipe.Id] = recipe.Name
+	}
+
+	// the current names are exported, the names saved in the recipes may be outdated
+	name := func(names map[string]string, id string, saved string) string {
+		if name, ok := names[id]; ok {
+			return name
+		}
+		return saved
+	}
+
+	products = make([]dto.CatalogProduct, 0, len(recipes))
+
+	for _, recipe := range recipes {
+
+		product := dto.CatalogProduct{
+			Id:             recipe.Id,
+			Name:           recipe.Name,
+			Description:    recipe.Description,
+			Translations:   recipe.Translations,
+			Price:          recipe.Price,
+			Unit:           recipe.Unit,
+			ShelfLifeHours: recipe.ShelfLifeHours,
+			Materials:      make([]dto.CatalogComponent, 0, len(recipe.Materials)),
+			SubProducts:    make([]dto.CatalogComponent, 0, len(recipe.SubProducts)),
+		}
+
+		for _, material := range recipe.Materials {
+			product.Materials = append(product.Materials, dto.CatalogComponent{
+				CatalogReference: dto.CatalogReference{Id: material.Id, Name: name(material_index.names, material.Id, material.Name)},
+				Quantity:         material.Quantity,
+				YieldPercent:     material.YieldPercent,
+			})
+		}
+
+		for _, sub_product := range recipe.SubProducts {
+			product.SubProducts = append(product.SubProducts, dto.CatalogComponent{
+				CatalogReference: dto.CatalogReference{Id: sub_product.Id, Name: name(product_names, sub_product.Id, sub_product.Name)},
+				Quantity:         sub_product.Quantity,
+				YieldPercent:     sub_product.YieldPercent,
+			})
+		}
+
+		products = append(products, product)
+	}
+
+	return products, nil
+}
+
+// ImportCategories creates the categories of the rows that don't match an existing category by id or name,
+// and replaces the name and the products of the matched ones. The products are referenced by id, SKU or name.
+// Nothing is saved on a dry run.
+func (cs *CatalogService) ImportCategories(categories []dto.CatalogCategory, dry_run bool) (report dto.ImportReport, err error) {
+
+	report = dto.ImportReport{Kind: CatalogCategories, IsDryRun: dry_run, Rows: make([]dto.ImportRowResult, 0, len(categories))}
+
+	client, _, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	ctx, cancel_import := context.WithTimeout(context.Background(), catalogImportDeadline)
+	defer cancel_import()
+
+	collection := client.Database("waha").Collection("categories")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+
+	var existing []models.Category
+	if err := cursor.All(ctx, &existing); err != nil {
+		return report, err
+	}
+
+	category_index := newCatalogIndex()
+	for _, category := range existing {
+		category_index.add(category.Id, category.Name)
+	}
+
+	graph, err := loadRecipeGraph(ctx, client)
+	if err != nil {
+		return report, err
+	}
+
+	product_index := newCatalogIndex()
+	for _, recipe := range graph.recipes {
+		product_index.add(recipe.Id, recipe.Name, productSKUs(recipe)...)
+	}
+
+	seen := make(map[string]int)
+
+	for _, row := range categories {
+
+		result := dto.ImportRowResult{Row: row.Row, Name: row.Name}
+
+		category_id, action, err := importCategory(ctx, collection, category_index, product_index, seen, row, dry_run)
+		if err != nil {
+			result.Action = ImportActionError
+			result.Error = err.Error()
+		} else {
+			result.Id = category_id
+			result.Action = action
+		}
+
+		addImportResult(&report, result)
+	}
+
+	return report, nil
+}
+
+// importCategory validates and saves a single imported category, it returns the id of the category and
+// whether it was created or updated.
+func importCategory(ctx context.Context, collection *mongo.Collection, category_index *catalogIndex, product_index *catalogIndex, seen map[string]int, row dto.CatalogCategory, dry_run bool) (category_id string, action string, err error) {
+
+	if strings.TrimSpace(row.Name) == "" {
+		return "", "", fmt.Errorf("name is required")
+	}
+
+	if first_row, ok := seen[catalogKey(row.Name)]; ok {
+		return "", "", fmt.Errorf("%s is repeated, it's first imported at row %d", row.Name, first_row)
+	}
+	seen[catalogKey(row.Name)] = row.Row
+
+	products := make([]models.CategoryProduct, 0, len(row.Products))
+	included := make(map[string]bool)
+
+	for _, ref := range row.Products {
+		product_id, ok := product_index.resolve(ref)
+		if !ok {
+			return "", "", fmt.Errorf("product %s doesn't exist", describeReference(ref))
+		}
+
+		if included[product_id] {
+			continue
+		}
+		included[product_id] = true
+
+		products = append(products, models.CategoryProduct{ProductId: product_id, SortOrder: len(products)})
+	}
+
+	category_id, ok := category_index.resolve(dto.CatalogReference{Id: row.Id, Name: row.Name})
+
+	if !ok {
+		category := models.Category{
+			Id: importedId(row.Id, func(id string) bool {
+				_, ok := category_index.names[id]
+				return ok
+			}),
+			Name:         row.Name,
+			Description:  row.Description,
+			Translations: row.Translations,
+			ProductRefs:  products,
+		}
+
+		category_index.add(category.Id, category.Name)
+
+		if !dry_run {
+			_, err = collection.InsertOne(ctx, category)
+		}
+
+		return category.Id, ImportActionCreate, err
+	}
+
+	if !dry_run {
+		_, err = collection.UpdateOne(ctx, bson.M{"id": category_id}, bson.M{"$set": bson.M{
+			"name":         row.Name,
+			"description":  row.Description,
+			"translations": row.Translations,
+			"product_refs": products,
+		}})
+	}
+
+	return category_id, ImportActionUpdate, err
+}
+
+// ExportCategories returns all the categories sorted by name with their products referenced by id and name,
+// in the format they're imported in.
+func (cs *CatalogService) ExportCategories() (categories []dto.CatalogCategory, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return categories, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1, "name": 1}))
+	if err != nil {
+		return categories, err
+	}
+
+	var recipes []models.Product
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return categories, err
+	}
+
+	product_names := make(map[string]string)
+	for _, recipe := range recipes {
+		product_names[recipe.Id] = recipe.Name
+	}
+
+	cursor, err = client.Database("waha").Collection("categories").Find(ctx, bson.M{})
+	if err != nil {
+		return categories, err
+	}
+
+	var existing []models.Category
+	if err := cursor.All(ctx, &existing); err != nil {
+		return categories, err
+	}
+
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].Name < existing[j].Name
+	})
+
+	categories = make([]dto.CatalogCategory, 0, len(existing))
+
+	for _, category := range existing {
+
+		refs := append([]models.CategoryProduct{}, category.ProductRefs...)
+		sort.SliceStable(refs, func(i, j int) bool {
+			return refs[i].SortOrder < refs[j].SortOrder
+		})
+
+		products := make([]dto.CatalogReference, 0, len(refs))
+		for _, ref := range refs {
+			products = append(products, dto.CatalogReference{Id: ref.ProductId, Name: product_names[ref.ProductId]})
+		}
+
+		categories = append(categories, dto.CatalogCategory{
+			Id:           category.Id,
+			Name:         category.Name,
+			Description:  category.Description,
+			Translations: category.Translations,
+			Products:     products,
+		})
+	}
+
+	return categories, nil
+}
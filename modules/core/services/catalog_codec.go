@@ -0,0 +1,777 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// The formats of the catalog files.
+const (
+	CatalogFormatCSV  = "csv"
+	CatalogFormatJSON = "json"
+)
+
+// The columns of the catalog csv files. A material is written on a row per entry, a product on a row per
+// material or sub product, and a category on a row per product. The rows with the same name are merged,
+// the details of the record are read from its first row. The translations are in a name_<code> and a description_<code>
+// column per language, e.g. name_ar, after these columns.
+var (
+	materialColumns = []string{
+		"id", "name", "description", "category", "unit", "yield_percent",
+		"stock_alert_treshold", "lead_time_days", "safety_stock_days", "expiry_warning_days",
+		"energy_kcal", "protein", "carbohydrates", "sugars", "fat", "saturated_fat", "fiber", "sodium",
+		"allergens", "diet",
+		"entry_id", "entry_sku", "entry_company", "entry_quantity", "entry_purchase_quantity", "entry_purchase_price", "entry_expiration_date",
+	}
+	productColumns = []string{
+		"id", "name", "description", "price", "unit", "shelf_life_hours",
+		"component_type", "component_id", "component_sku", "component_name", "component_quantity", "component_yield_percent",
+	}
+	categoryColumns = []string{
+		"id", "name", "description", "product_id", "product_sku", "product_name",
+	}
+)
+
+// The prefixes of the translation columns, they're followed by the language code.
+const (
+	translatedNamePrefix        = "name_"
+	translatedDescriptionPrefix = "description_"
+)
+
+// The component types of the product csv rows.
+const (
+	componentTypeMaterial = "material"
+	componentTypeProduct  = "product"
+)
+
+// csvRecord is a row of a csv file, its cells are read by the column names of the header.
+type csvRecord struct {
+	line    int
+	columns map[string]int
+	cells   []string
+}
+
+// get returns the trimmed cell of the column, it's empty when the file doesn't have the column.
+func (r csvRecord) get(column string) string {
+	index, ok := r.columns[column]
+	if !ok || index >= len(r.cells) {
+		return ""
+	}
+
+	return strings.TrimSpace(r.cells[index])
+}
+
+// has tells whether any of the columns is filled.
+func (r csvRecord) has(columns ...string) bool {
+	for _, column := range columns {
+		if r.get(column) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// float returns the number in the cell of the column, an empty cell is zero.
+func (r csvRecord) float(column string) (float64, error) {
+	value := r.get(column)
+	if value == "" {
+		return 0, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: line %d: %s %q isn't a number", customerrors.ErrInvalidImport, r.line, column, value)
+	}
+
+	return number, nil
+}
+
+// floats reads the numbers of the columns into the targets, in the same order.
+func (r csvRecord) floats(columns []string, targets ...*float64) error {
+	for index, column := range columns {
+		number, err := r.float(column)
+		if err != nil {
+			return err
+		}
+
+		*targets[index] = number
+	}
+
+	return nil
+}
+
+// time returns the time in the cell of the column as RFC 3339 or as a 2006-01-02 date, an empty cell is the zero time.
+func (r csvRecord) time(column string) (time.Time, error) {
+	value := r.get(column)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+
+	at, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return at, fmt.Errorf("%w: line %d: %s %q isn't a date", customerrors.ErrInvalidImport, r.line, column, value)
+	}
+
+	return at, nil
+}
+
+// list returns the semicolon separated values of the cell of the column.
+func (r csvRecord) list(column string) []string {
+	values := make([]string, 0)
+	for _, value := range strings.Split(r.get(column), ";") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// translations returns the translations of the name_<code> and description_<code> columns, they're nil when none is filled.
+func (r csvRecord) translations() models.Translations {
+
+	var translations models.Translations
+
+	for column := range r.columns {
+
+		value := r.get(column)
+		if value == "" {
+			continue
+		}
+
+		var lang_code string
+		switch {
+		case strings.HasPrefix(column, translatedNamePrefix):
+			lang_code = strings.TrimPrefix(column, translatedNamePrefix)
+		case strings.HasPrefix(column, translatedDescriptionPrefix):
+			lang_code = strings.TrimPrefix(column, translatedDescriptionPrefix)
+		default:
+			continue
+		}
+
+		if translations == nil {
+			translations = make(models.Translations)
+		}
+
+		translation := translations[lang_code]
+		if strings.HasPrefix(column, translatedNamePrefix) {
+			translation.Name = value
+		} else {
+			translation.Description = value
+		}
+		translations[lang_code] = translation
+	}
+
+	return translations
+}
+
+// translationLanguages returns the sorted codes of the languages the records are translated to.
+func translationLanguages(translations ...models.Translations) []string {
+
+	seen := make(map[string]bool)
+	lang_codes := make([]string, 0)
+
+	for _, record_translations := range translations {
+		for lang_code := range record_translations {
+			if !seen[lang_code] {
+				seen[lang_code] = true
+				lang_codes = append(lang_codes, lang_code)
+			}
+		}
+	}
+
+	sort.Strings(lang_codes)
+
+	return lang_codes
+}
+
+// translationHeader returns the columns of the translations to the languages after the columns of the file.
+func translationHeader(columns []string, lang_codes []string) []string {
+
+	header := append([]string{}, columns...)
+	for _, lang_code := range lang_codes {
+		header = append(header, translatedNamePrefix+lang_code, translatedDescriptionPrefix+lang_code)
+	}
+
+	return header
+}
+
+// translationCells returns the cells of the translations to the languages, in the order of the header.
+func translationCells(translations models.Translations, lang_codes []string) []string {
+
+	cells := make([]string, 0, len(lang_codes)*2)
+	for _, lang_code := range lang_codes {
+		translation := translations[lang_code]
+		cells = append(cells, translation.Name, translation.Description)
+	}
+
+	return cells
+}
+
+// readCSV reads the rows of a csv file with a header, the header must have a name column.
+func readCSV(reader io.Reader) (records []csvRecord, err error) {
+
+	csv_reader := csv.NewReader(reader)
+	csv_reader.FieldsPerRecord = -1
+	csv_reader.TrimLeadingSpace = true
+
+	header, err := csv_reader.Read()
+	if err == io.EOF {
+		return records, fmt.Errorf("%w: the file is empty", customerrors.ErrInvalidImport)
+	}
+	if err != nil {
+		return records, fmt.Errorf("%w: %s", customerrors.ErrInvalidImport, err.Error())
+	}
+
+	columns := make(map[string]int)
+	for index, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))] = index
+	}
+
+	if _, ok := columns["name"]; !ok {
+		return records, fmt.Errorf("%w: the header has no name column", customerrors.ErrInvalidImport)
+	}
+
+	for {
+		cells, err := csv_reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, fmt.Errorf("%w: %s", customerrors.ErrInvalidImport, err.Error())
+		}
+
+		line, _ := csv_reader.FieldPos(0)
+		record := csvRecord{line: line, columns: columns, cells: cells}
+
+		// the rows of empty cells are skipped
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// groupCSV groups the records by name keeping the order of their first rows, the rows without a name aren't grouped.
+func groupCSV(records []csvRecord) [][]csvRecord {
+
+	groups := make([][]csvRecord, 0)
+	positions := make(map[string]int)
+
+	for _, record := range records {
+		key := catalogKey(record.get("name"))
+
+		if position, ok := positions[key]; ok && key != "" {
+			groups[position] = append(groups[position], record)
+			continue
+		}
+
+		positions[key] = len(groups)
+		groups = append(groups, []csvRecord{record})
+	}
+
+	return groups
+}
+
+// decodeJSON decodes a json array of the rows, or a {"data": [...]} document like the api responses.
+func decodeJSON[T any](reader io.Reader) (rows []T, err error) {
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return rows, err
+	}
+
+	data = bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(data, []byte("{")) {
+		document := struct {
+			Data []T `json:"data"`
+		}{}
+		err = json.Unmarshal(data, &document)
+		rows = document.Data
+	} else {
+		err = json.Unmarshal(data, &rows)
+	}
+
+	if err != nil {
+		return rows, fmt.Errorf("%w: %s", customerrors.ErrInvalidImport, err.Error())
+	}
+
+	return rows, nil
+}
+
+// formatFloat writes a number without trailing zeros.
+func formatFloat(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+// formatTime writes a time as RFC 3339, the zero time is written empty.
+func formatTime(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+
+	return at.Format(time.RFC3339)
+}
+
+// writeCSV writes the header and the rows.
+func writeCSV(writer io.Writer, header []string, rows [][]string) error {
+
+	csv_writer := csv.NewWriter(writer)
+
+	err := csv_writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	err = csv_writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+
+	return csv_writer.Error()
+}
+
+// writeJSON writes the rows as an indented json array.
+func writeJSON(writer io.Writer, rows any) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(rows)
+}
+
+// nutritionColumns are the csv columns of the nutrition facts of a material.
+var nutritionColumns = []string{"energy_kcal", "protein", "carbohydrates", "sugars", "fat", "saturated_fat", "fiber", "sodium"}
+
+// DecodeCatalogMaterials reads the materials of a csv or json file.
+func DecodeCatalogMaterials(format string, reader io.Reader) (materials []dto.CatalogMaterial, err error) {
+
+	if format == CatalogFormatJSON {
+		materials, err = decodeJSON[dto.CatalogMaterial](reader)
+		for index := range materials {
+			materials[index].Row = index + 1
+		}
+
+		return materials, err
+	}
+
+	records, err := readCSV(reader)
+	if err != nil {
+		return materials, err
+	}
+
+	for _, group := range groupCSV(records) {
+
+		first := group[0]
+		material := dto.CatalogMaterial{
+			Row:          first.line,
+			Id:           first.get("id"),
+			Name:         first.get("name"),
+			Description:  first.get("description"),
+			Translations: first.translations(),
+			Category:     first.get("category"),
+			Unit:         first.get("unit"),
+			Allergens:    first.list("allergens"),
+			Diet:         first.get("diet"),
+			Entries:      make([]dto.CatalogMaterialEntry, 0),
+		}
+
+		err = first.floats(
+			[]string{"yield_percent", "stock_alert_treshold", "lead_time_days", "safety_stock_days", "expiry_warning_days"},
+			&material.YieldPercent, &material.Settings.StockAlertTreshold, &material.Settings.LeadTimeDays, &material.Settings.SafetyStockDays, &material.Settings.ExpiryWarningDays,
+		)
+		if err != nil {
+			return materials, err
+		}
+
+		if first.has(nutritionColumns...) {
+			facts := models.NutritionFacts{}
+			err = first.floats(nutritionColumns, &facts.EnergyKcal, &facts.Protein, &facts.Carbohydrates, &facts.Sugars, &facts.Fat, &facts.SaturatedFat, &facts.Fiber, &facts.Sodium)
+			if err != nil {
+				return materials, err
+			}
+
+			material.Nutrition = &facts
+		}
+
+		for _, record := range group {
+			if !record.has("entry_id", "entry_sku", "entry_company", "entry_quantity", "entry_purchase_quantity", "entry_purchase_price", "entry_expiration_date") {
+				continue
+			}
+
+			entry := dto.CatalogMaterialEntry{
+				Id:      record.get("entry_id"),
+				SKU:     record.get("entry_sku"),
+				Company: record.get("entry_company"),
+			}
+
+			err = record.floats(
+				[]string{"entry_quantity", "entry_purchase_quantity", "entry_purchase_price"},
+				&entry.Quantity, &entry.PurchaseQuantity, &entry.PurchasePrice,
+			)
+			if err != nil {
+				return materials, err
+			}
+
+			entry.ExpirationDate, err = record.time("entry_expiration_date")
+			if err != nil {
+				return materials, err
+			}
+
+			material.Entries = append(material.Entries, entry)
+		}
+
+		materials = append(materials, material)
+	}
+
+	return materials, nil
+}
+
+// EncodeCatalogMaterials writes the materials as a csv or json file.
+func EncodeCatalogMaterials(format string, writer io.Writer, materials []dto.CatalogMaterial) error {
+
+	if format == CatalogFormatJSON {
+		return writeJSON(writer, materials)
+	}
+
+	rows := make([][]string, 0, len(materials))
+
+	material_translations := make([]models.Translations, 0, len(materials))
+	for _, material := range materials {
+		material_translations = append(material_translations, material.Translations)
+	}
+	lang_codes := translationLanguages(material_translations...)
+
+	for _, material := range materials {
+
+		nutrition := make([]string, len(nutritionColumns))
+		if facts := material.Nutrition; facts != nil {
+			for index, value := range []float64{facts.EnergyKcal, facts.Protein, facts.Carbohydrates, facts.Sugars, facts.Fat, facts.SaturatedFat, facts.Fiber, facts.Sodium} {
+				nutrition[index] = formatFloat(value)
+			}
+		}
+
+		details := []string{
+			material.Id, material.Name, material.Description, material.Category, material.Unit, formatFloat(material.YieldPercent),
+			formatFloat(material.Settings.StockAlertTreshold), formatFloat(material.Settings.LeadTimeDays),
+			formatFloat(material.Settings.SafetyStockDays), formatFloat(material.Settings.ExpiryWarningDays),
+		}
+		details = append(details, nutrition...)
+		details = append(details, strings.Join(material.Allergens, ";"), material.Diet)
+
+		translation_cells := translationCells(material.Translations, lang_codes)
+
+		if len(material.Entries) == 0 {
+			rows = append(rows, append(append(details, make([]string, 7)...), translation_cells...))
+			continue
+		}
+
+		for _, entry := range material.Entries {
+			rows = append(rows, append(append(append([]string{}, details...),
+				entry.Id, entry.SKU, entry.Company, formatFloat(entry.Quantity), formatFloat(entry.PurchaseQuantity),
+				formatFloat(entry.PurchasePrice), formatTime(entry.ExpirationDate),
+			), translation_cells...))
+		}
+	}
+
+	return writeCSV(writer, translationHeader(materialColumns, lang_codes), rows)
+}
+
+// DecodeCatalogProducts reads the products of a csv or json file.
+func DecodeCatalogProducts(format string, reader io.Reader) (products []dto.CatalogProduct, err error) {
+
+	if format == CatalogFormatJSON {
+		products, err = decodeJSON[dto.CatalogProduct](reader)
+		for index := range products {
+			products[index].Row = index + 1
+		}
+
+		return products, err
+	}
+
+	records, err := readCSV(reader)
+	if err != nil {
+		return products, err
+	}
+
+	for _, group := range groupCSV(records) {
+
+		first := group[0]
+		product := dto.CatalogProduct{
+			Row:          first.line,
+			Id:           first.get("id"),
+			Name:         first.get("name"),
+			Description:  first.get("description"),
+			Translations: first.translations(),
+			Unit:         first.get("unit"),
+			Materials:    make([]dto.CatalogComponent, 0),
+			SubProducts:  make([]dto.CatalogComponent, 0),
+		}
+
+		err = first.floats([]string{"price", "shelf_life_hours"}, &product.Price, &product.ShelfLifeHours)
+		if err != nil {
+			return products, err
+		}
+
+		for _, record := range group {
+			if !record.has("component_type", "component_id", "component_sku", "component_name") {
+				continue
+			}
+
+			component := dto.CatalogComponent{
+				CatalogReference: dto.CatalogReference{
+					Id:   record.get("component_id"),
+					SKU:  record.get("component_sku"),
+					Name: record.get("component_name"),
+				},
+			}
+
+			err = record.floats([]string{"component_quantity", "component_yield_percent"}, &component.Quantity, &component.YieldPercent)
+			if err != nil {
+				return products, err
+			}
+
+			switch strings.ToLower(record.get("component_type")) {
+			case componentTypeMaterial:
+				product.Materials = append(product.Materials, component)
+			case componentTypeProduct:
+				product.SubProducts = append(product.SubProducts, component)
+			default:
+				return products, fmt.Errorf("%w: line %d: component_type must be %s or %s", customerrors.ErrInvalidImport, record.line, componentTypeMaterial, componentTypeProduct)
+			}
+		}
+
+		products = append(products, product)
+	}
+
+	return products, nil
+}
+
+// EncodeCatalogProducts writes the products as a csv or json file.
+func EncodeCatalogProducts(format string, writer io.Writer, products []dto.CatalogProduct) error {
+
+	if format == CatalogFormatJSON {
+		return writeJSON(writer, products)
+	}
+
+	rows := make([][]string, 0, len(products))
+
+	product_translations := make([]models.Translations, 0, len(products))
+	for _, product := range products {
+		product_translations = append(product_translations, product.Translations)
+	}
+	lang_codes := translationLanguages(product_translations...)
+
+	for _, product := range products {
+
+		details := []string{product.Id, product.Name, product.Description, formatFloat(product.Price), product.Unit, formatFloat(product.ShelfLifeHours)}
+		translation_cells := translationCells(product.Translations, lang_codes)
+
+		component_row := func(component_type string, component dto.CatalogComponent) []string {
+			return append(append(append([]string{}, details...),
+				component_type, component.Id, component.SKU, component.Name,
+				formatFloat(component.Quantity), formatFloat(component.YieldPercent),
+			), translation_cells...)
+		}
+
+		if len(product.Materials) == 0 && len(product.SubProducts) == 0 {
+			rows = append(rows, append(append(details, make([]string, 6)...), translation_cells...))
+			continue
+		}
+
+		for _, component := range product.Materials {
+			rows = append(rows, component_row(componentTypeMaterial, component))
+		}
+
+		for _, component := range product.SubProducts {
+			rows = append(rows, component_row(componentTypeProduct, component))
+		}
+	}
+
+	return writeCSV(writer, translationHeader(productColumns, lang_codes), rows)
+}
+
+// DecodeCatalogCategories reads the categories of a csv or json file.
+func DecodeCatalogCategories(format string, reader io.Reader) (categories []dto.CatalogCategory, err error) {
+
+	if format == CatalogFormatJSON {
+		categories, err = decodeJSON[dto.CatalogCategory](reader)
+		for index := range categories {
+			categories[index].Row = index + 1
+		}
+
+		return categories, err
+	}
+
+	records, err := readCSV(reader)
+	if err != nil {
+		return categories, err
+	}
+
+	for _, group := range groupCSV(records) {
+
+		first := group[0]
+		category := dto.CatalogCategory{
+			Row:          first.line,
+			Id:           first.get("id"),
+			Name:         first.get("name"),
+			Description:  first.get("description"),
+			Translations: first.translations(),
+			Products:     make([]dto.CatalogReference, 0),
+		}
+
+		for _, record := range group {
+			if !record.has("product_id", "product_sku", "product_name") {
+				continue
+			}
+
+			category.Products = append(category.Products, dto.CatalogReference{
+				Id:   record.get("product_id"),
+				SKU:  record.get("product_sku"),
+				Name: record.get("product_name"),
+			})
+		}
+
+		categories = append(categories, category)
+	}
+
+	return categories, nil
+}
+
+// EncodeCatalogCategories writes the categories as a csv or json file.
+func EncodeCatalogCategories(format string, writer io.Writer, categories []dto.CatalogCategory) error {
+
+	if format == CatalogFormatJSON {
+		return writeJSON(writer, categories)
+	}
+
+	rows := make([][]string, 0, len(categories))
+
+	category_translations := make([]models.Translations, 0, len(categories))
+	for _, category := range categories {
+		category_translations = append(category_translations, category.Translations)
+	}
+	lang_codes := translationLanguages(category_translations...)
+
+	for _, category := range categories {
+
+		translation_cells := translationCells(category.Translations, lang_codes)
+
+		if len(category.Products) == 0 {
+			rows = append(rows, append([]string{category.Id, category.Name, category.Description, "", "", ""}, translation_cells...))
+			continue
+		}
+
+		for _, product := range category.Products {
+			rows = append(rows, append([]string{category.Id, category.Name, category.Description, product.Id, product.SKU, product.Name}, translation_cells...))
+		}
+	}
+
+	return writeCSV(writer, translationHeader(categoryColumns, lang_codes), rows)
+}
+
+// checkCatalog checks that the catalog and the format are known.
+func checkCatalog(kind string, format string) error {
+
+	switch kind {
+	case CatalogMaterials, CatalogProducts, CatalogCategories:
+	default:
+		return fmt.Errorf("%w: unknown catalog %s, it must be %s, %s or %s", customerrors.ErrInvalidImport, kind, CatalogMaterials, CatalogProducts, CatalogCategories)
+	}
+
+	switch format {
+	case CatalogFormatCSV, CatalogFormatJSON:
+	default:
+		return fmt.Errorf("%w: unknown format %s, it must be %s or %s", customerrors.ErrInvalidImport, format, CatalogFormatCSV, CatalogFormatJSON)
+	}
+
+	return nil
+}
+
+// Import reads the catalog file in the format and imports its rows, the rows are validated
+// and reported without being saved on a dry run.
+func (cs *CatalogService) Import(kind string, format string, reader io.Reader, dry_run bool, author string) (report dto.ImportReport, err error) {
+
+	err = checkCatalog(kind, format)
+	if err != nil {
+		return report, err
+	}
+
+	switch kind {
+	case CatalogMaterials:
+		materials, err := DecodeCatalogMaterials(format, reader)
+		if err != nil {
+			return report, err
+		}
+
+		return cs.ImportMaterials(materials, dry_run)
+
+	case CatalogProducts:
+		products, err := DecodeCatalogProducts(format, reader)
+		if err != nil {
+			return report, err
+		}
+
+		return cs.ImportProducts(products, dry_run, author)
+	}
+
+	categories, err := DecodeCatalogCategories(format, reader)
+	if err != nil {
+		return report, err
+	}
+
+	return cs.ImportCategories(categories, dry_run)
+}
+
+// Export writes the catalog in the format, the file can be imported back as is.
+func (cs *CatalogService) Export(kind string, format string, writer io.Writer) error {
+
+	err := checkCatalog(kind, format)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case CatalogMaterials:
+		materials, err := cs.ExportMaterials()
+		if err != nil {
+			return err
+		}
+
+		return EncodeCatalogMaterials(format, writer, materials)
+
+	case CatalogProducts:
+		products, err := cs.ExportProducts()
+		if err != nil {
+			return err
+		}
+
+		return EncodeCatalogProducts(format, writer, products)
+	}
+
+	categories, err := cs.ExportCategories()
+	if err != nil {
+		return err
+	}
+
+	return EncodeCatalogCategories(format, writer, categories)
+}
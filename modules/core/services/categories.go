@@ -8,9 +8,11 @@ package services
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -47,7 +49,18 @@ func (cs *CategoryService) InsertCategory(category models.Category) (err error)
 
 	// Connected successfully
 
+	err = validateSchedule(category.Schedule)
+	if err != nil {
+		return err
+	}
+
 	category.Id = primitive.NewObjectID().Hex()
+	category.ProductRefs = productReferences(category)
+
+	err = validateCategory(ctx, client, category)
+	if err != nil {
+		return err
+	}
 
 	collection := client.Database("waha").Collection("categories")
 	_, err = collection.InsertOne(ctx, category)
@@ -79,6 +92,27 @@ func (cs *CategoryService) DeleteCategory(category_id string) (err error) {
 	// Connected successfully
 
 	collection := client.Database("waha").Collection("categories")
+
+	var category models.Category
+	err = collection.FindOne(ctx, bson.M{"id": category_id}).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the subcategories are moved up to the parent of the deleted category
+	update := bson.M{"$unset": bson.M{"parent_id": ""}}
+	if category.ParentId != "" {
+		update = bson.M{"$set": bson.M{"parent_id": category.ParentId}}
+	}
+
+	_, err = collection.UpdateMany(ctx, bson.M{"parent_id": category_id}, update)
+	if err != nil {
+		return err
+	}
+
 	_, err = collection.DeleteOne(ctx, bson.M{"id": category_id})
 
 	return err
@@ -107,87 +141,366 @@ func (cs *CategoryService) UpdateCategory(category models.Category) (updatedCate
 
 	// Connected successfully
 
+	err = validateSchedule(category.Schedule)
+	if err != nil {
+		return updatedCategory, err
+	}
+
+	category.ProductRefs = productReferences(category)
+
+	err = validateCategory(ctx, client, category)
+	if err != nil {
+		return updatedCategory, err
+	}
+
+	set := bson.M{
+		"name":         category.Name,
+		"description":  category.Description,
+		"translations": category.Translations,
+		"product_refs": category.ProductRefs,
+		"schedule":     category.Schedule,
+		"sort_order":   category.SortOrder,
+	}
+	unset := bson.M{}
+
+	if category.ParentId != "" {
+		set["parent_id"] = category.ParentId
+	} else {
+		unset["parent_id"] = ""
+	}
+
+	if len(category.Channels) > 0 {
+		set["channels"] = category.Channels
+	} else {
+		unset["channels"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
 	collection := client.Database("waha").Collection("categories")
-	_, err = collection.UpdateOne(ctx, bson.M{"id": category.Id}, bson.M{"$set": bson.M{
-		"name":     category.Name,
-		"products": category.Products,
-	}})
+	_, err = collection.UpdateOne(ctx, bson.M{"id": category.Id}, update)
+	if err != nil {
+		return updatedCategory, err
+	}
+
+	err = resolveCategoryProducts(ctx, client, []models.Category{category})
 
 	return category, err
 
 }
 
-// GetCategories returns a list of categories from the database.
-func (cs *CategoryService) GetCategories(page_number int, page_size int) (categories []models.Category, err error) {
+// GetCategories returns a page of the categories sorted by their sort order then by name, with their products resolved
+// in their sort order. When the channel is set, only the categories shown on it are returned.
+func (cs *CategoryService) GetCategories(page_number int, page_size int, channel string) (categories []models.Category, err error) {
 
-	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
-
-	// Create a context with a timeout (optional)
-	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return categories, err
+	}
 	defer cancel()
+	// connected to db
 
-	// Connect to MongoDB
-	client, err := mongo.Connect(ctx, clientOptions)
+	findOptions := options.Find().SetSort(bson.D{{Key: "sort_order", Value: 1}, {Key: "name", Value: 1}})
+
+	skip := (page_number - 1) * page_size
+	findOptions.SetSkip(int64(skip))
+	findOptions.SetLimit(int64(page_size))
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, channelFilter(channel), findOptions)
 	if err != nil {
 		return categories, err
 	}
 
-	// Ping the database to check connectivity
-	err = client.Ping(ctx, nil)
+	categories = []models.Category{}
+	if err := cursor.All(ctx, &categories); err != nil {
+		return categories, err
+	}
+
+	err = resolveCategoryProducts(ctx, client, categories)
 	if err != nil {
 		return categories, err
 	}
 
-	// Connected successfully
-	cs.Logger.Info("Connected to MongoDB!")
+	err = resolveCategoryNutrition(ctx, client, categories)
 
-	// Fetch categories from the database
-	findOptions := options.Find()
+	return categories, err
+}
 
-	skip := (page_number - 1) * page_size
-	findOptions.SetSkip(int64(skip))
-	findOptions.SetLimit(int64(page_size))
-	cur, err := client.Database("waha").Collection("categories").Find(ctx, bson.D{}, findOptions)
+// GetCategoryTree returns the top level categories with their subcategories nested in them, each level sorted by
+// sort order then by name. When the channel is set, the categories hidden on it are left out with their subcategories.
+func (cs *CategoryService) GetCategoryTree(channel string) (tree []models.Category, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
 	if err != nil {
-		return categories, err
+		return tree, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, channelFilter(channel))
+	if err != nil {
+		return tree, err
+	}
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return tree, err
+	}
+
+	err = resolveCategoryProducts(ctx, client, categories)
+	if err != nil {
+		return tree, err
+	}
+
+	err = resolveCategoryNutrition(ctx, client, categories)
+	if err != nil {
+		return tree, err
+	}
+
+	return categoryTree(categories), nil
+}
+
+// IsCategoryChannel tells whether a category can be shown on the channel.
+func IsCategoryChannel(channel string) bool {
+	switch channel {
+	case models.ServiceStyleDineIn, models.ServiceStyleTakeAway, models.ServiceStyleDelivery:
+		return true
+	}
+
+	return false
+}
+
+// channelFilter matches the categories shown on the channel, it matches all of them when the channel is empty.
+func channelFilter(channel string) bson.M {
+	if channel == "" {
+		return bson.M{}
 	}
-	defer cur.Close(ctx)
 
-	// Iterate through the categories
-	for cur.Next(ctx) {
-		var category models.Category
-		err := cur.Decode(&category)
+	return bson.M{"$or": []bson.M{
+		{"channels": bson.M{"$exists": false}},
+		{"channels": channel},
+	}}
+}
+
+// productReferences returns the product references of the category, they're taken from its products in their order
+// when it has none. The references without a product and the repeated products are dropped.
+func productReferences(category models.Category) []models.CategoryProduct {
+
+	refs := category.ProductRefs
+	if len(refs) == 0 {
+		for index, product := range category.Products {
+			refs = append(refs, models.CategoryProduct{ProductId: product.Id, SortOrder: index})
+		}
+	}
+
+	unique := make([]models.CategoryProduct, 0, len(refs))
+	included := make(map[string]bool)
+
+	for _, ref := range refs {
+		if ref.ProductId == "" || included[ref.ProductId] {
+			continue
+		}
+		included[ref.ProductId] = true
+
+		unique = append(unique, ref)
+	}
+
+	return unique
+}
+
+// validateCategory checks that the products and the parent of the category exist, that the category isn't nested
+// in itself or in one of its subcategories, and that its channels are known.
+func validateCategory(ctx context.Context, client *mongo.Client, category models.Category) error {
+
+	for _, channel := range category.Channels {
+		if !IsCategoryChannel(channel) {
+			return fmt.Errorf("%w: unknown channel %s", customerrors.ErrInvalidCategory, channel)
+		}
+	}
+
+	if len(category.ProductRefs) > 0 {
+		product_ids := make([]string, 0, len(category.ProductRefs))
+		for _, ref := range category.ProductRefs {
+			product_ids = append(product_ids, ref.ProductId)
+		}
+
+		existing, err := client.Database("waha").Collection("recipes").Distinct(ctx, "id", bson.M{"id": bson.M{"$in": product_ids}})
 		if err != nil {
-			return categories, err
+			return err
+		}
+
+		found := make(map[string]bool, len(existing))
+		for _, id := range existing {
+			if product_id, ok := id.(string); ok {
+				found[product_id] = true
+			}
 		}
 
-		products := []models.Product{}
+		for _, product_id := range product_ids {
+			if !found[product_id] {
+				return fmt.Errorf("%w: product %s doesn't exist", customerrors.ErrInvalidCategory, product_id)
+			}
+		}
+	}
+
+	if category.ParentId == "" {
+		return nil
+	}
+
+	if category.ParentId == category.Id {
+		return fmt.Errorf("%w: category %s can't be nested in itself", customerrors.ErrInvalidCategory, category.Id)
+	}
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1, "parent_id": 1}))
+	if err != nil {
+		return err
+	}
 
-		// Fetch the recipes for each category using the Recipe IDs
-		for _, category_product := range category.Products {
-			var product models.Product
-			filter := bson.D{{Key: "id", Value: category_product.Id}}
-			err := client.Database("waha").Collection("recipes").FindOne(ctx, filter).Decode(&product)
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return err
+	}
+
+	parents := make(map[string]string, len(categories))
+	for _, existing := range categories {
+		parents[existing.Id] = existing.ParentId
+	}
+
+	if _, ok := parents[category.ParentId]; !ok {
+		return fmt.Errorf("%w: parent category %s doesn't exist", customerrors.ErrInvalidCategory, category.ParentId)
+	}
+
+	// walking up from the new parent must never reach the category
+	ancestor := category.ParentId
+	for steps := 0; ancestor != "" && steps <= len(parents); steps++ {
+		if ancestor == category.Id {
+			return fmt.Errorf("%w: category %s can't be nested in one of its subcategories", customerrors.ErrInvalidCategory, category.Id)
+		}
+		ancestor = parents[ancestor]
+	}
+
+	return nil
+}
+
+// sortCategories orders the categories by their sort order then by name.
+func sortCategories(categories []models.Category) {
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+}
+
+// resolveCategoryProducts fills the products of the categories from their references in their sort order,
+// the references to deleted products are skipped.
+func resolveCategoryProducts(ctx context.Context, client *mongo.Client, categories []models.Category) error {
+
+	product_ids := []string{}
+	for _, category := range categories {
+		for _, ref := range category.ProductRefs {
+			product_ids = append(product_ids, ref.ProductId)
+		}
+	}
+
+	products_by_id := make(map[string]models.Product)
+
+	if len(product_ids) > 0 {
+		cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{"id": bson.M{"$in": product_ids}})
+		if err != nil {
+			return err
+		}
+
+		var products []models.Product
+		if err := cursor.All(ctx, &products); err != nil {
+			return err
+		}
+
+		for _, product := range products {
+			products_by_id[product.Id] = product
+		}
+	}
+
+	for index := range categories {
+
+		refs := append([]models.CategoryProduct{}, categories[index].ProductRefs...)
+		sort.SliceStable(refs, func(i, j int) bool {
+			return refs[i].SortOrder < refs[j].SortOrder
+		})
+
+		categories[index].Products = make([]models.Product, 0, len(refs))
+		for _, ref := range refs {
+			if product, ok := products_by_id[ref.ProductId]; ok {
+				categories[index].Products = append(categories[index].Products, product)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveCategoryNutrition fills the nutrition of the resolved products of the categories.
+func resolveCategoryNutrition(ctx context.Context, client *mongo.Client, categories []models.Category) error {
+
+	calculator := newNutritionCalculator(ctx, client)
+
+	for i := range categories {
+		for j, product := range categories[i].Products {
+			nutrition, err := calculator.product(product, 0)
 			if err != nil {
-				cs.Logger.Error(`\nERROR: Recipe doesn't exist id: ${%v}`, category_product)
-				continue
+				return err
 			}
 
-			products = append(products, models.Product{
-				Id: product.Id,
-			})
+			categories[i].Products[j].Nutrition = &nutrition
 		}
+	}
+
+	return nil
+}
+
+// categoryTree nests the categories in their parents and returns the top level ones, each level is sorted.
+// The categories whose parent isn't among them are left out with their subcategories.
+func categoryTree(categories []models.Category) []models.Category {
+
+	children := make(map[string][]models.Category)
+	for _, category := range categories {
+		children[category.ParentId] = append(children[category.ParentId], category)
+	}
 
-		// Create a CategoriesContentRequest_Category with embedded recipes
-		contentCategory := models.Category{
-			Name:     category.Name,
-			Id:       category.Id,
-			Products: products,
+	var nest func(parent_id string, depth int) []models.Category
+	nest = func(parent_id string, depth int) []models.Category {
+
+		level := children[parent_id]
+		if depth > len(categories) {
+			return nil
 		}
 
-		// Append the contentCategory to the categories slice
-		categories = append(categories, contentCategory)
+		sortCategories(level)
+
+		for index := range level {
+			level[index].Children = nest(level[index].Id, depth+1)
+		}
+
+		return level
+	}
+
+	return nest("", 0)
+}
+
+// flattenCategoryTree lists the categories of the tree depth first, each category followed by its subcategories.
+func flattenCategoryTree(tree []models.Category) []models.Category {
+
+	flat := []models.Category{}
+	for _, category := range tree {
+		children := category.Children
+		category.Children = nil
+
+		flat = append(flat, category)
+		flat = append(flat, flattenCategoryTree(children)...)
 	}
 
-	return categories, nil
+	return flat
 }
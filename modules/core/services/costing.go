@@ -0,0 +1,200 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// CostingMethod returns the configured costing method of the inventory,
+// the specific identification method is used when it's not set.
+func (cs *MaterialService) CostingMethod() string {
+	switch cs.Settings.Inventory.CostingMethod {
+	case models.CostingMethodFIFO, models.CostingMethodWeightedAverage:
+		return cs.Settings.Inventory.CostingMethod
+	default:
+		return models.CostingMethodSpecific
+	}
+}
+
+// CalculateConsumptionCost calculates the cost of consuming a quantity of a material using the configured costing method.
+//
+// The material must be loaded with all of its entries, the entry_id is the entry chosen for the consumption,
+// it's the one priced by the specific identification method, and the fallback of the other methods
+// when the material has no entries in stock.
+func (cs *MaterialService) CalculateConsumptionCost(material models.Material, entry_id string, quantity float64) (cost float64, err error) {
+
+	var chosen_entry *models.MaterialEntry
+	for index := range material.Entries {
+		if material.Entries[index].Id == entry_id {
+			chosen_entry = &material.Entries[index]
+			break
+		}
+	}
+
+	in_stock := stockedEntries(material.Entries, time.Now())
+
+	switch cs.CostingMethod() {
+
+	case models.CostingMethodFIFO:
+		if len(in_stock) > 0 {
+			cost = fifoCost(in_stock, quantity)
+		} else if chosen_entry != nil {
+			cost = chosen_entry.UnitCost() * quantity
+		} else {
+			return 0, fmt.Errorf("entry %s not found in material %s", entry_id, material.Id)
+		}
+
+	case models.CostingMethodWeightedAverage:
+		if average := materialAverageCost(material); average > 0 || len(in_stock) > 0 {
+			cost = average * quantity
+		} else if chosen_entry != nil {
+			cost = chosen_entry.UnitCost() * quantity
+		} else {
+			return 0, fmt.Errorf("entry %s not found in material %s", entry_id, material.Id)
+		}
+
+	default:
+		if chosen_entry == nil {
+			return 0, fmt.Errorf("entry %s not found in material %s", entry_id, material.Id)
+		}
+		cost = chosen_entry.UnitCost() * quantity
+	}
+
+	// check if cost is positive or negative infinity (semantic bug in calculation that causes problems later on)
+	if math.IsInf(cost, 0) || math.IsNaN(cost) {
+		cost = 0
+	}
+
+	return cost, nil
+}
+
+// referenceEntry returns the entry a material is priced at when no entry is chosen for it, the newest entry
+// in stock or else the newest entry. The material must have entries.
+func referenceEntry(material models.Material) models.MaterialEntry {
+
+	if stocked := stockedEntries(material.Entries, time.Now()); len(stocked) > 0 {
+		return stocked[len(stocked)-1]
+	}
+
+	reference := material.Entries[0]
+	for _, entry := range material.Entries[1:] {
+		if entry.ReceivedTime().After(reference.ReceivedTime()) {
+			reference = entry
+		}
+	}
+
+	return reference
+}
+
+// stockedEntries returns the usable entries that still have quantity, ordered by their received time (oldest first).
+func stockedEntries(entries []models.MaterialEntry, at time.Time) (stocked []models.MaterialEntry) {
+
+	for _, entry := range entries {
+		if entry.Quantity > 0 && entry.IsUsable(at) {
+			stocked = append(stocked, entry)
+		}
+	}
+
+	sort.SliceStable(stocked, func(i, j int) bool {
+		return stocked[i].ReceivedTime().Before(stocked[j].ReceivedTime())
+	})
+
+	return stocked
+}
+
+// fifoCost walks the entries from the oldest to the newest consuming the quantity from each of them,
+// any quantity above the stocked quantity is priced at the newest entry unit cost.
+func fifoCost(stocked []models.MaterialEntry, quantity float64) (cost float64) {
+
+	remaining := quantity
+
+	for _, entry := range stocked {
+		if remaining <= 0 {
+			break
+		}
+
+		consumed := math.Min(remaining, float64(entry.Quantity))
+		cost += consumed * entry.UnitCost()
+		remaining -= consumed
+	}
+
+	if remaining > 0 && len(stocked) > 0 {
+		cost += remaining * stocked[len(stocked)-1].UnitCost()
+	}
+
+	return cost
+}
+
+// materialAverageCost returns the moving average unit cost of the material, the materials received before
+// the average was kept fall back to the average of their stocked entries.
+func materialAverageCost(material models.Material) float64 {
+
+	if material.AverageCost > 0 {
+		return material.AverageCost
+	}
+
+	return weightedAverageUnitCost(stockedEntries(material.Entries, time.Now()))
+}
+
+// isAverageInflow reports whether the movement adds stock at its own unit cost, the receipts and the positive
+// adjustments move the average unit cost while the rest of the movements leave it as it is.
+func isAverageInflow(movement models.InventoryMovement) bool {
+	return movement.Type == models.MovementTypeReceipt || (movement.Type == models.MovementTypeAdjustment && movement.Quantity > 0)
+}
+
+// movingAverageCost returns the average unit cost after adding the quantity at the unit cost to the stock
+// held at the average unit cost.
+func movingAverageCost(average float64, stock float64, quantity float64, unit_cost float64) float64 {
+
+	if stock <= 0 || stock+quantity <= 0 {
+		return unit_cost
+	}
+
+	return (stock*average + quantity*unit_cost) / (stock + quantity)
+}
+
+// fifoConsumption splits the quantity over the stocked entries from the oldest to the newest, it returns
+// the quantity taken from each entry by its id, and false when the entries don't hold the whole quantity.
+func fifoConsumption(stocked []models.MaterialEntry, quantity float64) (parts []consumptionPart, ok bool) {
+
+	remaining := quantity
+
+	for _, entry := range stocked {
+		if remaining <= ledgerTolerance {
+			break
+		}
+
+		consumed := math.Min(remaining, float64(entry.Quantity))
+		parts = append(parts, consumptionPart{EntryId: entry.Id, Quantity: consumed})
+		remaining -= consumed
+	}
+
+	return parts, remaining <= ledgerTolerance
+}
+
+// weightedAverageUnitCost returns the average unit cost of the stocked entries weighted by their remaining quantity.
+func weightedAverageUnitCost(stocked []models.MaterialEntry) float64 {
+
+	total_quantity := 0.0
+	total_value := 0.0
+
+	for _, entry := range stocked {
+		total_quantity += float64(entry.Quantity)
+		total_value += float64(entry.Quantity) * entry.UnitCost()
+	}
+
+	if total_quantity == 0 {
+		return 0
+	}
+
+	return total_value / total_quantity
+}
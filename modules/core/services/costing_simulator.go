@@ -0,0 +1,361 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// materialCost returns the cost of consuming a quantity of a material.
+type materialCost func(quantity float64) float64
+
+// costSimulator computes the cost of a single unit of the recipes from the costs of their materials,
+// the costs of the sub products are computed once and reused.
+type costSimulator struct {
+	recipes        map[string]models.Product
+	material_costs map[string]materialCost
+	costs          map[string]float64
+	visiting       map[string]bool
+}
+
+func newCostSimulator(recipes map[string]models.Product, material_costs map[string]materialCost) *costSimulator {
+	return &costSimulator{
+		recipes:        recipes,
+		material_costs: material_costs,
+		costs:          make(map[string]float64),
+		visiting:       make(map[string]bool),
+	}
+}
+
+// cost returns the cost of a unit of the recipe, the quantities of the components are net so
+// the gross quantities taken from the inventory are priced like when an order is finished.
+func (cs *costSimulator) cost(recipe_id string, depth int) (float64, error) {
+
+	if cost, ok := cs.costs[recipe_id]; ok {
+		return cost, nil
+	}
+
+	if cs.visiting[recipe_id] {
+		return 0, fmt.Errorf("%w: product %s contains itself", customerrors.ErrInvalidScenario, recipe_id)
+	}
+
+	if depth > maxRecipeDepth {
+		return 0, fmt.Errorf("%w: product %s is nested more than %d levels deep", customerrors.ErrInvalidScenario, recipe_id, maxRecipeDepth)
+	}
+
+	recipe, ok := cs.recipes[recipe_id]
+	if !ok {
+		return 0, nil
+	}
+
+	cs.visiting[recipe_id] = true
+	defer delete(cs.visiting, recipe_id)
+
+	cost := 0.0
+
+	for _, material := range recipe.Materials {
+		if material_cost, ok := cs.material_costs[material.Id]; ok {
+			cost += material_cost(material.Gross(material.Quantity))
+		}
+	}
+
+	for _, sub_product := range recipe.SubProducts {
+		sub_cost, err := cs.cost(sub_product.Id, depth+1)
+		if err != nil {
+			return 0, err
+		}
+
+		cost += sub_product.Gross(sub_product.Quantity) * sub_cost
+	}
+
+	cs.costs[recipe_id] = cost
+
+	return cost, nil
+}
+
+// materialCost returns the cost of consuming a quantity of the material with the configured costing method,
+// the way the consumptions of the orders are costed. The newest entry in stock, or else the newest entry,
+// is the one priced by the specific identification method.
+func (cs *MaterialService) materialCost(material models.Material) materialCost {
+
+	if len(material.Entries) == 0 {
+		return func(quantity float64) float64 { return 0 }
+	}
+
+	reference := referenceEntry(material)
+
+	return func(quantity float64) float64 {
+		// the reference is one of the entries of the material, so it can't be missing
+		cost, _ := cs.CalculateConsumptionCost(material, reference.Id, quantity)
+		return cost
+	}
+}
+
+// changedMaterialCost applies a change of the unit cost by a percentage, or to a new unit cost when it's set.
+func changedMaterialCost(cost materialCost, change_percent float64, new_unit_cost *float64) materialCost {
+	return func(quantity float64) float64 {
+		if new_unit_cost != nil {
+			return *new_unit_cost * quantity
+		}
+
+		return changedPrice(cost(quantity), change_percent, nil)
+	}
+}
+
+// changedPrice applies a change by a percentage, or to a new value when it's set.
+func changedPrice(value float64, change_percent float64, new_value *float64) float64 {
+	if new_value != nil {
+		return *new_value
+	}
+
+	return value * (1 + change_percent/100)
+}
+
+// changeComponents sets the net quantities of the components of a recipe, they're added when they're missing
+// and removed when the quantity is zero.
+func changeComponents[T any](components []T, changes []dto.ScenarioComponentChange, id func(T) string, set func(*T, float64), add func(string, float64) T) []T {
+
+	changed := append([]T{}, components...)
+
+	for _, change := range changes {
+
+		found := false
+		for index := 0; index < len(changed); index++ {
+			if id(changed[index]) != change.Id {
+				continue
+			}
+
+			found = true
+			if change.Quantity == 0 {
+				changed = append(changed[:index], changed[index+1:]...)
+				index--
+				continue
+			}
+
+			set(&changed[index], change.Quantity)
+		}
+
+		if !found && change.Quantity > 0 {
+			changed = append(changed, add(change.Id, change.Quantity))
+		}
+	}
+
+	return changed
+}
+
+// SimulateCosting recomputes the cost and the margin of the products through their recipe trees with the
+// hypothetical material prices, recipe tweaks and sale prices of the scenario, nothing is saved and the stock isn't touched.
+// It returns the affected products with their deltas, and the projected impact on the sales between the dates
+// had the scenario been in effect with the same sales mix. The products are sorted by their profit impact, worst first.
+func (cs *MaterialService) SimulateCosting(scenario dto.CostingScenario, from time.Time, to time.Time) (simulation dto.CostingSimulation, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return simulation, err
+	}
+	defer cancel()
+	// connected to db
+
+	simulation = dto.CostingSimulation{
+		From:     from,
+		To:       to,
+		Products: make([]dto.SimulatedProduct, 0),
+	}
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{})
+	if err != nil {
+		return simulation, err
+	}
+
+	var materials []models.Material
+	if err := cursor.All(ctx, &materials); err != nil {
+		return simulation, err
+	}
+
+	materials_by_id := make(map[string]models.Material, len(materials))
+	material_costs := make(map[string]materialCost, len(materials))
+
+	for _, material := range materials {
+		materials_by_id[material.Id] = material
+		material_costs[material.Id] = cs.materialCost(material)
+	}
+
+	graph, err := loadRecipeGraph(ctx, client)
+	if err != nil {
+		return simulation, err
+	}
+
+	// the scenario is applied to copies of the material costs, the recipes and the prices
+	new_material_costs := make(map[string]materialCost, len(material_costs))
+	for material_id, material_cost := range material_costs {
+		new_material_costs[material_id] = material_cost
+	}
+
+	for _, change := range scenario.Materials {
+		if _, ok := materials_by_id[change.MaterialId]; !ok {
+			return simulation, fmt.Errorf("%w: material %s doesn't exist", customerrors.ErrInvalidScenario, change.MaterialId)
+		}
+
+		// the costs aren't negative, so the change is checked on a unit cost of 1
+		if changedPrice(1, change.ChangePercent, change.UnitCost) < 0 {
+			return simulation, fmt.Errorf("%w: material %s ends up with a negative unit cost", customerrors.ErrInvalidScenario, change.MaterialId)
+		}
+
+		new_material_costs[change.MaterialId] = changedMaterialCost(material_costs[change.MaterialId], change.ChangePercent, change.UnitCost)
+	}
+
+	new_recipes := make(map[string]models.Product, len(graph.recipes))
+	for recipe_id, recipe := range graph.recipes {
+		new_recipes[recipe_id] = recipe
+	}
+
+	changed_recipes := make(map[string]bool)
+
+	for _, change := range scenario.Recipes {
+
+		recipe, ok := new_recipes[change.ProductId]
+		if !ok {
+			return simulation, fmt.Errorf("%w: product %s doesn't exist", customerrors.ErrInvalidScenario, change.ProductId)
+		}
+
+		for _, component := range change.Materials {
+			if _, ok := materials_by_id[component.Id]; !ok {
+				return simulation, fmt.Errorf("%w: material %s doesn't exist", customerrors.ErrInvalidScenario, component.Id)
+			}
+			if component.Quantity < 0 {
+				return simulation, fmt.Errorf("%w: material %s has a negative quantity", customerrors.ErrInvalidScenario, component.Id)
+			}
+		}
+
+		for _, component := range change.SubProducts {
+			if _, ok := graph.recipes[component.Id]; !ok {
+				return simulation, fmt.Errorf("%w: sub product %s doesn't exist", customerrors.ErrInvalidScenario, component.Id)
+			}
+			if component.Quantity < 0 {
+				return simulation, fmt.Errorf("%w: sub product %s has a negative quantity", customerrors.ErrInvalidScenario, component.Id)
+			}
+		}
+
+		recipe.Materials = changeComponents(recipe.Materials, change.Materials,
+			func(material models.Material) string { return material.Id },
+			func(material *models.Material, quantity float64) { material.Quantity = quantity },
+			func(material_id string, quantity float64) models.Material {
+				material := materials_by_id[material_id]
+				return models.Material{Id: material.Id, Name: material.Name, Unit: material.Unit, YieldPercent: material.YieldPercent, Quantity: quantity}
+			},
+		)
+
+		recipe.SubProducts = changeComponents(recipe.SubProducts, change.SubProducts,
+			func(sub_product models.Product) string { return sub_product.Id },
+			func(sub_product *models.Product, quantity float64) { sub_product.Quantity = quantity },
+			func(product_id string, quantity float64) models.Product {
+				return models.Product{Id: product_id, Name: graph.recipes[product_id].Name, Unit: graph.recipes[product_id].Unit, Quantity: quantity}
+			},
+		)
+
+		new_recipes[change.ProductId] = recipe
+		changed_recipes[change.ProductId] = true
+	}
+
+	new_prices := make(map[string]float64)
+
+	for _, change := range scenario.Prices {
+		recipe, ok := graph.recipes[change.ProductId]
+		if !ok {
+			return simulation, fmt.Errorf("%w: product %s doesn't exist", customerrors.ErrInvalidScenario, change.ProductId)
+		}
+
+		new_prices[change.ProductId] = changedPrice(recipe.Price, change.ChangePercent, change.Price)
+		if new_prices[change.ProductId] < 0 {
+			return simulation, fmt.Errorf("%w: product %s ends up with a negative price", customerrors.ErrInvalidScenario, change.ProductId)
+		}
+	}
+
+	sales, err := salesByProduct(ctx, client, from, to)
+	if err != nil {
+		return simulation, err
+	}
+
+	for _, product_sales := range sales {
+		simulation.Revenue += product_sales.revenue
+		simulation.Cost += product_sales.cost
+	}
+
+	simulation.NewRevenue = simulation.Revenue
+	simulation.NewCost = simulation.Cost
+
+	current := newCostSimulator(graph.recipes, material_costs)
+	simulated := newCostSimulator(new_recipes, new_material_costs)
+
+	for recipe_id, recipe := range graph.recipes {
+
+		cost, err := current.cost(recipe_id, 0)
+		if err != nil {
+			return simulation, err
+		}
+
+		new_cost, err := simulated.cost(recipe_id, 0)
+		if err != nil {
+			return simulation, err
+		}
+
+		new_price, is_repriced := new_prices[recipe_id]
+		if !is_repriced {
+			new_price = recipe.Price
+		}
+
+		if !is_repriced && !changed_recipes[recipe_id] && math.Abs(new_cost-cost) < 1e-9 {
+			continue
+		}
+
+		product := dto.SimulatedProduct{
+			ProductId:   recipe_id,
+			ProductName: recipe.Name,
+			Cost:        cost,
+			NewCost:     new_cost,
+			CostDelta:   new_cost - cost,
+			Price:       recipe.Price,
+			NewPrice:    new_price,
+			PriceDelta:  new_price - recipe.Price,
+			Margin:      recipe.Price - cost,
+			NewMargin:   new_price - new_cost,
+		}
+
+		product.MarginDelta = product.NewMargin - product.Margin
+		if product.Price != 0 {
+			product.MarginPercent = product.Margin / product.Price * 100
+		}
+		if product.NewPrice != 0 {
+			product.NewMarginPercent = product.NewMargin / product.NewPrice * 100
+		}
+
+		if product_sales, ok := sales[recipe_id]; ok {
+			product.UnitsSold = product_sales.units
+			product.ProfitImpact = product.UnitsSold * product.MarginDelta
+
+			simulation.NewRevenue += product.UnitsSold * product.PriceDelta
+			simulation.NewCost += product.UnitsSold * product.CostDelta
+		}
+
+		simulation.Products = append(simulation.Products, product)
+	}
+
+	simulation.Profit = simulation.Revenue - simulation.Cost
+	simulation.NewProfit = simulation.NewRevenue - simulation.NewCost
+	simulation.ProfitDelta = simulation.NewProfit - simulation.Profit
+
+	sort.SliceStable(simulation.Products, func(i, j int) bool {
+		if simulation.Products[i].ProfitImpact != simulation.Products[j].ProfitImpact {
+			return simulation.Products[i].ProfitImpact < simulation.Products[j].ProfitImpact
+		}
+		return simulation.Products[i].ProductName < simulation.Products[j].ProductName
+	})
+
+	return simulation, nil
+}
@@ -3,9 +3,14 @@ package services
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,6 +30,52 @@ type GetCustomersParams struct {
 	PageNumber int
 	// Rows is to set the desired row count limit.
 	PageSize int
+	// Search matches the customers whose name contains it, or whose phone contains its digits.
+	Search string
+	// Phone matches the customers whose phone contains its digits in order, whatever the spaces, dashes or brackets between them.
+	Phone string
+}
+
+// maxFavouriteProducts is the number of favourite products kept in the stats of a customer.
+const maxFavouriteProducts = 5
+
+// phonePattern returns a regex matching the phones containing the digits of the phone in order, the other
+// characters are ignored on both sides. It returns an empty pattern when the phone has no digits.
+func phonePattern(phone string) string {
+
+	digits := []string{}
+	for _, char := range phone {
+		if unicode.IsDigit(char) {
+			digits = append(digits, string(char))
+		}
+	}
+
+	return strings.Join(digits, `\D*`)
+}
+
+// customersFilter returns the filter of the customers matching the search and the phone of the params.
+func customersFilter(params GetCustomersParams) bson.M {
+
+	conditions := []bson.M{}
+
+	if pattern := phonePattern(params.Phone); pattern != "" {
+		conditions = append(conditions, bson.M{"phone": bson.M{"$regex": pattern}})
+	}
+
+	if search := strings.TrimSpace(params.Search); search != "" {
+		matches := []bson.M{{"name": bson.M{"$regex": regexp.QuoteMeta(search), "$options": "i"}}}
+		if pattern := phonePattern(search); pattern != "" {
+			matches = append(matches, bson.M{"phone": bson.M{"$regex": pattern}})
+		}
+
+		conditions = append(conditions, bson.M{"$or": matches})
+	}
+
+	if len(conditions) == 0 {
+		return bson.M{}
+	}
+
+	return bson.M{"$and": conditions}
 }
 
 func (cs CustomersService) GetCustomers(params GetCustomersParams) (customers []models.Customer, customers_count int, err error) {
@@ -49,11 +100,16 @@ func (cs CustomersService) GetCustomers(params GetCustomersParams) (customers []
 
 	collection := client.Database("waha").Collection("customers")
 
+	filter := customersFilter(params)
+
 	findOptions := options.Find()
 	findOptions.SetSkip(int64((params.PageNumber - 1) * params.PageSize))
 	findOptions.SetLimit(int64(params.PageSize))
+	if len(filter) > 0 {
+		findOptions.SetSort(bson.M{"name": 1})
+	}
 
-	cursor, err := collection.Find(ctx, bson.M{}, findOptions)
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		return customers, customers_count, err
 	}
@@ -68,7 +124,7 @@ func (cs CustomersService) GetCustomers(params GetCustomersParams) (customers []
 		customers = append(customers, customer)
 	}
 
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	count, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		return customers, customers_count, err
 	}
@@ -126,6 +182,16 @@ func (cs CustomersService) InsertNew(customer models.Customer) (afterInsert mode
 	collection := client.Database("waha").Collection("customers")
 
 	customer.Id = primitive.NewObjectID().Hex()
+	// the stats are only computed from the orders, the balance is only changed by the receivable entries,
+	// and the points are only changed by the loyalty entries
+	customer.Stats = nil
+	customer.Balance = 0
+	customer.Points = 0
+	customer.LifetimePoints = 0
+	customer.Tier = ""
+	if customer.CreditLimit != nil && *customer.CreditLimit < 0 {
+		customer.CreditLimit = nil
+	}
 
 	result, err := collection.InsertOne(ctx, customer)
 	if err != nil {
@@ -173,7 +239,16 @@ func (cs CustomersService) UpdateCustomer(customer models.Customer, customer_id
 
 	update["id"] = customer_id
 
-	_, err = collection.UpdateOne(ctx, bson.M{"id": customer_id}, bson.M{"$set": update})
+	changes := bson.M{"$set": update}
+
+	// a negative credit limit removes the limit
+	if customer.CreditLimit != nil && *customer.CreditLimit >= 0 {
+		update["credit_limit"] = *customer.CreditLimit
+	} else if customer.CreditLimit != nil {
+		changes["$unset"] = bson.M{"credit_limit": ""}
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"id": customer_id}, changes)
 	if err != nil {
 		return afterUpdate, err
 	}
@@ -213,3 +288,181 @@ func (cs CustomersService) DeleteCustomer(customer_id string) (err error) {
 
 	return
 }
+
+// GetCustomerOrders returns a page of the orders of the customer, the newest first.
+func (cs CustomersService) GetCustomerOrders(customer_id string, page_number int, page_size int) (orders []models.Order, orders_count int, err error) {
+
+	client, ctx, cancel, err := connectDB(cs.Config)
+	if err != nil {
+		return orders, orders_count, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": customer_id}).Err()
+	if err != nil {
+		return orders, orders_count, err
+	}
+
+	collection := client.Database("waha").Collection("orders")
+	filter := bson.M{"customer.id": customer_id}
+
+	findOptions := options.Find().SetSort(bson.M{"submitted_at": -1})
+	findOptions.SetSkip(int64((page_number - 1) * page_size))
+	findOptions.SetLimit(int64(page_size))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return orders, orders_count, err
+	}
+
+	orders = make([]models.Order, 0)
+	if err := cursor.All(ctx, &orders); err != nil {
+		return orders, orders_count, err
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return orders, orders_count, err
+	}
+
+	return orders, int(count), nil
+}
+
+// attachCustomer replaces the customer copied in the order with the saved customer, it's found by id, or else
+// by the digits of the phone. It returns the stats of the customer for the cashier, they aren't copied in the order
+// and neither is the account.
+// An order without a customer, or with the phone of an unknown customer, is left as it is.
+func attachCustomer(ctx context.Context, client *mongo.Client, order *models.Order) (stats *models.CustomerStats, err error) {
+
+	collection := client.Database("waha").Collection("customers")
+
+	var customer models.Customer
+
+	switch {
+	case order.Customer.Id != "":
+		err = collection.FindOne(ctx, bson.M{"id": order.Customer.Id}).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("%w: %s", customerrors.ErrCustomerNotFound, order.Customer.Id)
+		}
+	case phonePattern(order.Customer.Phone) != "":
+		err = collection.FindOne(ctx, bson.M{"phone": bson.M{"$regex": `^\D*` + phonePattern(order.Customer.Phone) + `\D*$`}}).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			order.Customer.Stats = nil
+			return nil, nil
+		}
+	default:
+		order.Customer.Stats = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats = customer.Stats
+	customer.Stats = nil
+	customer.Balance = 0
+	customer.CreditLimit = nil
+	customer.Points = 0
+	customer.LifetimePoints = 0
+	customer.Tier = ""
+	order.Customer = customer
+
+	return stats, nil
+}
+
+// customerStats computes the stats of a customer from their finished orders.
+func customerStats(orders []models.Order) models.CustomerStats {
+
+	stats := models.CustomerStats{
+		FavouriteProducts: []models.FavouriteProduct{},
+		UpdatedAt:         time.Now(),
+	}
+
+	favourites := make(map[string]*models.FavouriteProduct)
+
+	for _, order := range orders {
+
+		stats.VisitCount++
+		stats.TotalSpend += order.SalePrice
+
+		if stats.FirstVisit.IsZero() || order.SubmittedAt.Before(stats.FirstVisit) {
+			stats.FirstVisit = order.SubmittedAt
+		}
+		if order.SubmittedAt.After(stats.LastVisit) {
+			stats.LastVisit = order.SubmittedAt
+		}
+
+		in_order := make(map[string]bool)
+
+		for _, item := range order.Items {
+
+			favourite, ok := favourites[item.Product.Id]
+			if !ok {
+				favourite = &models.FavouriteProduct{ProductId: item.Product.Id, ProductName: item.Product.Name}
+				favourites[item.Product.Id] = favourite
+			}
+
+			quantity := item.Quantity
+			if quantity == 0 {
+				quantity = 1
+			}
+			favourite.Quantity += quantity
+
+			if !in_order[item.Product.Id] {
+				in_order[item.Product.Id] = true
+				favourite.OrderCount++
+			}
+		}
+	}
+
+	if stats.VisitCount > 0 {
+		stats.AverageTicket = stats.TotalSpend / float64(stats.VisitCount)
+	}
+
+	for _, favourite := range favourites {
+		stats.FavouriteProducts = append(stats.FavouriteProducts, *favourite)
+	}
+
+	sort.Slice(stats.FavouriteProducts, func(i, j int) bool {
+		if stats.FavouriteProducts[i].Quantity != stats.FavouriteProducts[j].Quantity {
+			return stats.FavouriteProducts[i].Quantity > stats.FavouriteProducts[j].Quantity
+		}
+		return stats.FavouriteProducts[i].ProductName < stats.FavouriteProducts[j].ProductName
+	})
+
+	if len(stats.FavouriteProducts) > maxFavouriteProducts {
+		stats.FavouriteProducts = stats.FavouriteProducts[:maxFavouriteProducts]
+	}
+
+	return stats
+}
+
+// refreshCustomerStats computes the stats of the customer from their finished orders and saves them.
+func refreshCustomerStats(ctx context.Context, client *mongo.Client, customer_id string) (stats models.CustomerStats, err error) {
+
+	cursor, err := client.Database("waha").Collection("orders").Find(ctx,
+		bson.M{"customer.id": customer_id, "state": "finished"},
+		options.Find().SetProjection(bson.M{
+			"submitted_at":       1,
+			"sale_price":         1,
+			"items.product.id":   1,
+			"items.product.name": 1,
+			"items.quantity":     1,
+		}),
+	)
+	if err != nil {
+		return stats, err
+	}
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return stats, err
+	}
+
+	stats = customerStats(orders)
+
+	_, err = client.Database("waha").Collection("customers").UpdateOne(ctx, bson.M{"id": customer_id}, bson.M{"$set": bson.M{"stats": stats}})
+
+	return stats, err
+}
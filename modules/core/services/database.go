@@ -0,0 +1,38 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connectDB connects to the configured database with the environment deadline,
+// the returned cancel function must be called when the work is done.
+func connectDB(conf config.Config) (client *mongo.Client, ctx context.Context, cancel context.CancelFunc, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", conf.Databases[0].Host, conf.Databases[0].Port))
+
+	deadline := 5 * time.Second
+	if conf.Env == "dev" {
+		deadline = 1000 * time.Second
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), deadline)
+
+	client, err = mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+
+	return client, ctx, cancel, nil
+}
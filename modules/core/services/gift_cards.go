@@ -0,0 +1,666 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// giftCardTolerance is the smallest balance that's considered left on a gift card, the balances are floats.
+const giftCardTolerance = 1e-6
+
+// giftCardCodeAlphabet leaves out the characters that are read as one another.
+const giftCardCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// giftCardCodeLength is the length of the generated codes.
+const giftCardCodeLength = 16
+
+// giftCardCodePattern matches the normalized codes.
+var giftCardCodePattern = regexp.MustCompile(`^[A-Z0-9]{4,32}$`)
+
+// GiftCardService sells the gift cards and keeps their balances, the cards are redeemed as payments on orders.
+type GiftCardService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// GetGiftCardsParams is the struct to hold the parameters for the GetGiftCards method.
+type GetGiftCardsParams struct {
+	// Search matches the beginning of the codes.
+	Search string
+	// State is active or closed, all the cards are returned when it's not set.
+	State      string
+	PageNumber int
+	PageSize   int
+}
+
+// normalizeGiftCardCode upper cases the code and drops the spaces and the dashes it's printed with.
+func normalizeGiftCardCode(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// newGiftCardCode returns a random code, the codes are bearer values so they aren't guessable.
+func newGiftCardCode() (string, error) {
+
+	code := make([]byte, giftCardCodeLength)
+	for index := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[index] = giftCardCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// findGiftCard returns the gift card with the code.
+func findGiftCard(ctx context.Context, client *mongo.Client, code string) (card models.GiftCard, err error) {
+
+	err = client.Database("waha").Collection("gift_cards").FindOne(ctx, bson.M{"code": normalizeGiftCardCode(code)}).Decode(&card)
+	if err == mongo.ErrNoDocuments {
+		return card, fmt.Errorf("%w: %s", customerrors.ErrGiftCardNotFound, code)
+	}
+
+	return card, err
+}
+
+// redeemableFilter matches the active gift cards that didn't expire by the date with at least the amount left.
+func redeemableFilter(amount float64, at time.Time) bson.M {
+	return bson.M{
+		"is_active": true,
+		"balance":   bson.M{"$gte": amount - giftCardTolerance},
+		"$or": []bson.M{
+			{"expires_at": nil},
+			{"expires_at": bson.M{"$gt": at}},
+		},
+	}
+}
+
+// postGiftCardTransaction changes the balance of the card by the amount of the transaction and records it with the
+// balance after it. The card is only changed when it matches the filter, and the closing transactions deactivate it.
+// It returns mongo.ErrNoDocuments when the card doesn't match the filter.
+func postGiftCardTransaction(ctx context.Context, client *mongo.Client, transaction models.GiftCardTransaction, filter bson.M) (models.GiftCardTransaction, error) {
+
+	cards := client.Database("waha").Collection("gift_cards")
+
+	if transaction.Date.IsZero() {
+		transaction.Date = time.Now()
+	}
+
+	card_filter := bson.M{"id": transaction.CardId}
+	for key, value := range filter {
+		card_filter[key] = value
+	}
+
+	update := bson.M{"$inc": bson.M{"balance": transaction.Amount}}
+
+	is_closing := transaction.Type == models.GiftCardTypeExpire || transaction.Type == models.GiftCardTypeDeactivate
+	if is_closing {
+		update["$set"] = bson.M{"is_active": false, "closed_at": transaction.Date}
+	}
+
+	var card models.GiftCard
+	err := cards.FindOneAndUpdate(ctx, card_filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&card)
+	if err != nil {
+		return transaction, err
+	}
+
+	transaction.Id = primitive.NewObjectID().Hex()
+	transaction.Code = card.Code
+	transaction.Balance = card.Balance
+
+	_, err = client.Database("waha").Collection("gift_card_transactions").InsertOne(ctx, transaction)
+	if err != nil {
+		// the balance is only changed along with its transaction
+		revert := bson.M{"$inc": bson.M{"balance": -transaction.Amount}}
+		if is_closing {
+			revert["$set"] = bson.M{"is_active": true}
+			revert["$unset"] = bson.M{"closed_at": ""}
+		}
+
+		_, revert_err := cards.UpdateOne(ctx, bson.M{"id": transaction.CardId}, revert)
+		if revert_err != nil {
+			return transaction, revert_err
+		}
+		return transaction, err
+	}
+
+	return transaction, nil
+}
+
+// closeGiftCard takes the balance left on the active card and deactivates it, the transaction type is
+// either expire or deactivate.
+func closeGiftCard(ctx context.Context, client *mongo.Client, card models.GiftCard, transaction_type string, comment string) (transaction models.GiftCardTransaction, err error) {
+
+	// the card is closed with the balance it's read with, a redemption in between fails the close and it's retried
+	for attempt := 0; attempt < 3; attempt++ {
+		transaction, err = postGiftCardTransaction(ctx, client, models.GiftCardTransaction{
+			CardId:  card.Id,
+			Type:    transaction_type,
+			Amount:  -card.Balance,
+			Comment: comment,
+		}, bson.M{"is_active": true, "balance": card.Balance})
+		if err != mongo.ErrNoDocuments {
+			return transaction, err
+		}
+
+		err = client.Database("waha").Collection("gift_cards").FindOne(ctx, bson.M{"id": card.Id}).Decode(&card)
+		if err != nil {
+			return transaction, err
+		}
+
+		if !card.IsActive {
+			return transaction, fmt.Errorf("%w: %s is already deactivated", customerrors.ErrGiftCardUnavailable, card.Code)
+		}
+	}
+
+	return transaction, fmt.Errorf("the balance of the gift card %s kept changing while it was closed", card.Code)
+}
+
+// giftCardsTotal returns what's paid with the gift cards on the order.
+func giftCardsTotal(order models.Order) (total float64) {
+
+	for _, gift_card := range order.GiftCards {
+		total += gift_card.Amount
+	}
+
+	return total
+}
+
+// checkOrderGiftCards normalizes the codes of the gift cards of the order, and checks they pay positive
+// amounts that don't add up to more than the order total.
+func checkOrderGiftCards(order *models.Order) error {
+
+	for index, gift_card := range order.GiftCards {
+		order.GiftCards[index].Code = normalizeGiftCardCode(gift_card.Code)
+
+		if gift_card.Amount <= 0 {
+			return fmt.Errorf("%w: the amount paid with %s must be positive", customerrors.ErrInvalidGiftCard, gift_card.Code)
+		}
+	}
+
+	if total := giftCardsTotal(*order); total > order.SalePrice+giftCardTolerance {
+		return fmt.Errorf("%w: the gift cards pay %.2f of a %.2f order", customerrors.ErrInvalidGiftCard, total, order.SalePrice)
+	}
+
+	return nil
+}
+
+// redeemOrderGiftCards takes what's paid with the gift cards of the order from their balances, the stashed orders
+// don't take it. Nothing is taken when any of the cards can't pay its amount.
+func redeemOrderGiftCards(ctx context.Context, client *mongo.Client, order models.Order) (redeemed bool, err error) {
+
+	if len(order.GiftCards) == 0 || order.State == "stashed" {
+		return false, nil
+	}
+
+	for _, gift_card := range order.GiftCards {
+
+		card, err := findGiftCard(ctx, client, gift_card.Code)
+		if err == nil {
+			_, err = postGiftCardTransaction(ctx, client, models.GiftCardTransaction{
+				CardId:         card.Id,
+				Type:           models.GiftCardTypeRedeem,
+				Date:           order.SubmittedAt,
+				Amount:         -gift_card.Amount,
+				OrderId:        order.Id,
+				OrderDisplayId: order.DisplayId,
+			}, redeemableFilter(gift_card.Amount, order.SubmittedAt))
+		}
+		if err == mongo.ErrNoDocuments {
+			err = fmt.Errorf("%w: %s is deactivated, expired, or has %.2f left of the %.2f paid with it",
+				customerrors.ErrGiftCardUnavailable, card.Code, card.Balance, gift_card.Amount)
+		}
+		if err != nil {
+			if refund_err := refundOrderGiftCards(ctx, client, order); refund_err != nil {
+				return false, refund_err
+			}
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// refundOrderGiftCards gives back what was paid with the gift cards on the order and wasn't refunded yet.
+// What's given back to a closed card is taken again as it was when the card was closed.
+func refundOrderGiftCards(ctx context.Context, client *mongo.Client, order models.Order) error {
+
+	cursor, err := client.Database("waha").Collection("gift_card_transactions").Find(ctx, bson.M{
+		"order_id": order.Id,
+		"type":     bson.M{"$in": []string{models.GiftCardTypeRedeem, models.GiftCardTypeRefund}},
+	})
+	if err != nil {
+		return err
+	}
+
+	transactions := []models.GiftCardTransaction{}
+	err = cursor.All(ctx, &transactions)
+	if err != nil {
+		return err
+	}
+
+	card_ids := []string{}
+	paid := make(map[string]float64)
+	for _, transaction := range transactions {
+		if _, ok := paid[transaction.CardId]; !ok {
+			card_ids = append(card_ids, transaction.CardId)
+		}
+		paid[transaction.CardId] -= transaction.Amount
+	}
+
+	for _, card_id := range card_ids {
+		if paid[card_id] <= giftCardTolerance {
+			continue
+		}
+
+		refund, err := postGiftCardTransaction(ctx, client, models.GiftCardTransaction{
+			CardId:         card_id,
+			Type:           models.GiftCardTypeRefund,
+			Amount:         paid[card_id],
+			OrderId:        order.Id,
+			OrderDisplayId: order.DisplayId,
+		}, nil)
+		if err != nil {
+			return err
+		}
+
+		var card models.GiftCard
+		err = client.Database("waha").Collection("gift_cards").FindOne(ctx, bson.M{"id": card_id}).Decode(&card)
+		if err != nil {
+			return err
+		}
+
+		if card.IsActive {
+			continue
+		}
+
+		closing_type := models.GiftCardTypeDeactivate
+		if card.ExpiresAt != nil && !card.ExpiresAt.After(refund.Date) {
+			closing_type = models.GiftCardTypeExpire
+		}
+
+		_, err = postGiftCardTransaction(ctx, client, models.GiftCardTransaction{
+			CardId:         card_id,
+			Type:           closing_type,
+			Amount:         -refund.Amount,
+			OrderId:        order.Id,
+			OrderDisplayId: order.DisplayId,
+			Comment:        "refunded to a closed gift card",
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IssueGiftCard sells a gift card with its initial balance, a unique code is generated when it's not set.
+func (gs *GiftCardService) IssueGiftCard(issue dto.GiftCardIssue) (card models.GiftCard, err error) {
+
+	if issue.InitialBalance <= 0 {
+		return card, fmt.Errorf("%w: the initial balance must be positive", customerrors.ErrInvalidGiftCard)
+	}
+
+	if issue.ExpiresAt != nil && !issue.ExpiresAt.After(time.Now()) {
+		return card, fmt.Errorf("%w: the gift card can't expire in the past", customerrors.ErrInvalidGiftCard)
+	}
+
+	code := normalizeGiftCardCode(issue.Code)
+	if code != "" && !giftCardCodePattern.MatchString(code) {
+		return card, fmt.Errorf("%w: the code must be 4 to 32 letters and digits", customerrors.ErrInvalidGiftCard)
+	}
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return card, err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("gift_cards")
+
+	for attempt := 0; code == "" && attempt < 5; attempt++ {
+		generated, err := newGiftCardCode()
+		if err != nil {
+			return card, err
+		}
+
+		count, err := collection.CountDocuments(ctx, bson.M{"code": generated})
+		if err != nil {
+			return card, err
+		}
+
+		if count == 0 {
+			code = generated
+		}
+	}
+
+	if code == "" {
+		return card, fmt.Errorf("couldn't generate a unique gift card code")
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{"code": code})
+	if err != nil {
+		return card, err
+	}
+	if count > 0 {
+		return card, fmt.Errorf("%w: the code %s is taken", customerrors.ErrInvalidGiftCard, code)
+	}
+
+	card = models.GiftCard{
+		Id:             primitive.NewObjectID().Hex(),
+		Code:           code,
+		InitialBalance: issue.InitialBalance,
+		Balance:        issue.InitialBalance,
+		IssuedAt:       time.Now(),
+		ExpiresAt:      issue.ExpiresAt,
+		IsActive:       true,
+		CustomerId:     issue.CustomerId,
+		Comment:        issue.Comment,
+	}
+
+	_, err = collection.InsertOne(ctx, card)
+	if err != nil {
+		return card, err
+	}
+
+	_, err = client.Database("waha").Collection("gift_card_transactions").InsertOne(ctx, models.GiftCardTransaction{
+		Id:      primitive.NewObjectID().Hex(),
+		CardId:  card.Id,
+		Code:    card.Code,
+		Type:    models.GiftCardTypeIssue,
+		Date:    card.IssuedAt,
+		Amount:  card.InitialBalance,
+		Balance: card.Balance,
+		Comment: issue.Comment,
+	})
+	if err != nil {
+		// the card is only sold along with its issue transaction
+		_, delete_err := collection.DeleteOne(ctx, bson.M{"id": card.Id})
+		if delete_err != nil {
+			return card, delete_err
+		}
+		return card, err
+	}
+
+	return card, nil
+}
+
+// GetGiftCard returns the gift card with the code, the card is expired when it's looked up after its expiry.
+func (gs *GiftCardService) GetGiftCard(code string) (card models.GiftCard, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return card, err
+	}
+	defer cancel()
+	// connected to db
+
+	card, err = findGiftCard(ctx, client, code)
+	if err != nil {
+		return card, err
+	}
+
+	if card.IsActive && card.ExpiresAt != nil && !card.ExpiresAt.After(time.Now()) {
+		_, err = closeGiftCard(ctx, client, card, models.GiftCardTypeExpire, "")
+		if err != nil {
+			return card, err
+		}
+
+		return findGiftCard(ctx, client, code)
+	}
+
+	return card, nil
+}
+
+// GetGiftCards returns a page of the gift cards, the newest first.
+func (gs *GiftCardService) GetGiftCards(params GetGiftCardsParams) (cards []models.GiftCard, cards_count int, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return cards, cards_count, err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("gift_cards")
+
+	filter := bson.M{}
+	if search := normalizeGiftCardCode(params.Search); search != "" {
+		filter["code"] = bson.M{"$regex": "^" + regexp.QuoteMeta(search)}
+	}
+	switch params.State {
+	case "active":
+		filter["is_active"] = true
+	case "closed":
+		filter["is_active"] = false
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "issued_at", Value: -1}, {Key: "id", Value: -1}})
+	findOptions.SetSkip(int64((params.PageNumber - 1) * params.PageSize))
+	findOptions.SetLimit(int64(params.PageSize))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return cards, cards_count, err
+	}
+
+	cards = []models.GiftCard{}
+	if err := cursor.All(ctx, &cards); err != nil {
+		return cards, cards_count, err
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return cards, cards_count, err
+	}
+
+	return cards, int(count), nil
+}
+
+// GetTransactions returns a page of the transactions of the gift card with the code, the newest first.
+func (gs *GiftCardService) GetTransactions(code string, page_number int, page_size int) (transactions []models.GiftCardTransaction, transactions_count int, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return transactions, transactions_count, err
+	}
+	defer cancel()
+	// connected to db
+
+	card, err := findGiftCard(ctx, client, code)
+	if err != nil {
+		return transactions, transactions_count, err
+	}
+
+	collection := client.Database("waha").Collection("gift_card_transactions")
+	filter := bson.M{"card_id": card.Id}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "id", Value: -1}})
+	findOptions.SetSkip(int64((page_number - 1) * page_size))
+	findOptions.SetLimit(int64(page_size))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return transactions, transactions_count, err
+	}
+
+	transactions = []models.GiftCardTransaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return transactions, transactions_count, err
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return transactions, transactions_count, err
+	}
+
+	return transactions, int(count), nil
+}
+
+// DeactivateGiftCard takes the balance left on the gift card with the code and deactivates it,
+// it can't be redeemed after it.
+func (gs *GiftCardService) DeactivateGiftCard(code string, deactivation dto.GiftCardDeactivation) (card models.GiftCard, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return card, err
+	}
+	defer cancel()
+	// connected to db
+
+	card, err = findGiftCard(ctx, client, code)
+	if err != nil {
+		return card, err
+	}
+
+	if !card.IsActive {
+		return card, fmt.Errorf("%w: %s is already deactivated", customerrors.ErrGiftCardUnavailable, card.Code)
+	}
+
+	_, err = closeGiftCard(ctx, client, card, models.GiftCardTypeDeactivate, deactivation.Comment)
+	if err != nil {
+		return card, err
+	}
+
+	return findGiftCard(ctx, client, code)
+}
+
+// ExpireGiftCards takes the balances left on the active gift cards that expired by the date,
+// it returns the number of the expired cards.
+func (gs *GiftCardService) ExpireGiftCards(at time.Time) (expired int, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return expired, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("gift_cards").Find(ctx, bson.M{
+		"is_active":  true,
+		"expires_at": bson.M{"$lte": at},
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	cards := []models.GiftCard{}
+	err = cursor.All(ctx, &cards)
+	if err != nil {
+		return expired, err
+	}
+
+	for _, card := range cards {
+		_, err = closeGiftCard(ctx, client, card, models.GiftCardTypeExpire, "")
+		if err != nil {
+			return expired, err
+		}
+
+		expired++
+	}
+
+	return expired, nil
+}
+
+// GetLiabilityReport returns what's owed on the gift card balances not redeemed by the date.
+func (gs *GiftCardService) GetLiabilityReport(as_of time.Time) (report dto.GiftCardLiability, err error) {
+
+	client, ctx, cancel, err := connectDB(gs.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("gift_card_transactions").Find(ctx, bson.M{"date": bson.M{"$lte": as_of}})
+	if err != nil {
+		return report, err
+	}
+
+	transactions := []models.GiftCardTransaction{}
+	err = cursor.All(ctx, &transactions)
+	if err != nil {
+		return report, err
+	}
+
+	balances := make(map[string]float64)
+
+	for _, transaction := range transactions {
+		balances[transaction.CardId] += transaction.Amount
+
+		switch transaction.Type {
+		case models.GiftCardTypeIssue:
+			report.Issued += transaction.Amount
+		case models.GiftCardTypeRedeem:
+			report.Redeemed -= transaction.Amount
+		case models.GiftCardTypeRefund:
+			report.Refunded += transaction.Amount
+		case models.GiftCardTypeExpire:
+			report.Expired -= transaction.Amount
+		case models.GiftCardTypeDeactivate:
+			report.Deactivated -= transaction.Amount
+		}
+	}
+
+	card_ids := []string{}
+	for card_id, balance := range balances {
+		if balance > giftCardTolerance {
+			card_ids = append(card_ids, card_id)
+		}
+	}
+
+	cards := []models.GiftCard{}
+	if len(card_ids) > 0 {
+		cursor, err = client.Database("waha").Collection("gift_cards").Find(ctx, bson.M{"id": bson.M{"$in": card_ids}})
+		if err != nil {
+			return report, err
+		}
+
+		err = cursor.All(ctx, &cards)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].IssuedAt.Before(cards[j].IssuedAt)
+	})
+
+	report.AsOf = as_of
+	report.Cards = []dto.GiftCardBalance{}
+
+	for _, card := range cards {
+		report.Cards = append(report.Cards, dto.GiftCardBalance{
+			Id:        card.Id,
+			Code:      card.Code,
+			Balance:   balances[card.Id],
+			IssuedAt:  card.IssuedAt,
+			ExpiresAt: card.ExpiresAt,
+		})
+
+		report.Outstanding += balances[card.Id]
+	}
+
+	return report, nil
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/helpers"
+	"github.com/elmawardy/nutrix/common/storage"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxImagePixels is the maximum number of pixels of an uploaded image, it keeps a small
+// but highly compressed image from taking all the memory when it's decoded.
+const maxImagePixels = 40_000_000
+
+// imageExtensions are the file extensions of the accepted image content types.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// MaxImageBytes returns the configured maximum size of an uploaded image, 5MB when it's not set.
+func MaxImageBytes(conf config.Config) int64 {
+	if conf.Storage.MaxImageBytes <= 0 {
+		return 5 << 20
+	}
+
+	return conf.Storage.MaxImageBytes
+}
+
+// thumbnailWidth returns the configured width of the thumbnails, 320 pixels when it's not set.
+func thumbnailWidth(conf config.Config) int {
+	if conf.Storage.ThumbnailWidth <= 0 {
+		return 320
+	}
+
+	return conf.Storage.ThumbnailWidth
+}
+
+// uploadedImage is a validated image with its encoded thumbnail.
+type uploadedImage struct {
+	data                 []byte
+	contentType          string
+	width                int
+	height               int
+	thumbnail            []byte
+	thumbnailContentType string
+}
+
+// processImage checks the size and the sniffed content type of the data, decodes it and generates its thumbnail.
+func processImage(conf config.Config, data []byte) (uploaded uploadedImage, err error) {
+
+	if int64(len(data)) > MaxImageBytes(conf) {
+		return uploaded, fmt.Errorf("%w: image is bigger than %d bytes", customerrors.ErrImageTooLarge, MaxImageBytes(conf))
+	}
+
+	if len(data) == 0 {
+		return uploaded, fmt.Errorf("%w: image is empty", customerrors.ErrInvalidImage)
+	}
+
+	content_type := http.DetectContentType(data)
+	if _, ok := imageExtensions[content_type]; !ok {
+		return uploaded, fmt.Errorf("%w: %s isn't a jpeg, png or gif image", customerrors.ErrInvalidImage, content_type)
+	}
+
+	image_config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return uploaded, fmt.Errorf("%w: %s", customerrors.ErrInvalidImage, err.Error())
+	}
+
+	if image_config.Width <= 0 || image_config.Height <= 0 {
+		return uploaded, fmt.Errorf("%w: image has no pixels", customerrors.ErrInvalidImage)
+	}
+
+	if image_config.Width*image_config.Height > maxImagePixels {
+		return uploaded, fmt.Errorf("%w: image is %dx%d pixels", customerrors.ErrImageTooLarge, image_config.Width, image_config.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return uploaded, fmt.Errorf("%w: %s", customerrors.ErrInvalidImage, err.Error())
+	}
+
+	thumbnail := resizeImage(decoded, thumbnailWidth(conf))
+
+	// the png and gif images may be transparent, their thumbnails are kept lossless
+	var encoded bytes.Buffer
+	thumbnail_content_type := "image/png"
+
+	if content_type == "image/jpeg" {
+		thumbnail_content_type = "image/jpeg"
+		err = jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&encoded, thumbnail)
+	}
+	if err != nil {
+		return uploaded, err
+	}
+
+	return uploadedImage{
+		data:                 data,
+		contentType:          content_type,
+		width:                image_config.Width,
+		height:               image_config.Height,
+		thumbnail:            encoded.Bytes(),
+		thumbnailContentType: thumbnail_content_type,
+	}, nil
+}
+
+// resizeImage scales the image down to the width keeping its aspect ratio, each pixel is the average of the
+// pixels of the source it covers. An image narrower than the width is copied as is.
+func resizeImage(src image.Image, width int) *image.RGBA64 {
+
+	bounds := src.Bounds()
+	if width > bounds.Dx() {
+		width = bounds.Dx()
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, count uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					count++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(a / count),
+			})
+		}
+	}
+
+	return dst
+}
+
+// storeProductImage uploads the image and its thumbnail under the folder of the product,
+// the uploaded files are removed when one of them fails.
+func storeProductImage(ctx context.Context, store storage.IStorage, product_id string, uploaded uploadedImage) (product_image models.ProductImage, err error) {
+
+	name := "products/" + product_id + "/" + helpers.RandStringBytesMaskImprSrc(20)
+
+	product_image = models.ProductImage{
+		Id:           primitive.NewObjectID().Hex(),
+		Key:          name + imageExtensions[uploaded.contentType],
+		ThumbnailKey: name + "_thumb" + imageExtensions[uploaded.thumbnailContentType],
+		ContentType:  uploaded.contentType,
+		Width:        uploaded.width,
+		Height:       uploaded.height,
+		Size:         int64(len(uploaded.data)),
+	}
+
+	err = store.Put(ctx, product_image.Key, uploaded.contentType, uploaded.data)
+	if err != nil {
+		return product_image, err
+	}
+
+	err = store.Put(ctx, product_image.ThumbnailKey, uploaded.thumbnailContentType, uploaded.thumbnail)
+	if err != nil {
+		store.Delete(ctx, product_image.Key)
+		return product_image, err
+	}
+
+	product_image.URL = store.URL(product_image.Key)
+	product_image.ThumbnailURL = store.URL(product_image.ThumbnailKey)
+
+	return product_image, nil
+}
+
+// removeImageFiles deletes the image and its thumbnail from the storage.
+func (rs *RecipeService) removeImageFiles(ctx context.Context, store storage.IStorage, product_image models.ProductImage) {
+	for _, key := range []string{product_image.Key, product_image.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+
+		err := store.Delete(ctx, key)
+		if err != nil {
+			rs.Logger.Error(fmt.Sprintf("Error deleting image file %s: %s", key, err.Error()))
+		}
+	}
+}
+
+// removeLegacyImage deletes the image of the product uploaded before the products had multiple images,
+// it's the file of the image url when the url isn't one of the images of the product.
+func (rs *RecipeService) removeLegacyImage(ctx context.Context, store storage.IStorage, product models.Product) {
+
+	if product.ImageURL == "" || strings.Contains(product.ImageURL, "://") {
+		return
+	}
+
+	for _, product_image := range product.Images {
+		if product_image.URL == product.ImageURL {
+			return
+		}
+	}
+
+	rs.removeImageFiles(ctx, store, models.ProductImage{Key: product.ImageURL})
+}
+
+// saveProductImages replaces the images of the product, the primary image is the first one flagged
+// or the first image when none is, and its url is set as the image url of the product.
+func saveProductImages(ctx context.Context, client *mongo.Client, product_id string, images []models.ProductImage) (product models.Product, err error) {
+
+	primary := -1
+	for index := range images {
+		if images[index].IsPrimary && primary == -1 {
+			primary = index
+		}
+		images[index].IsPrimary = false
+	}
+
+	if primary == -1 && len(images) > 0 {
+		primary = 0
+	}
+
+	image_url := ""
+	if primary != -1 {
+		images[primary].IsPrimary = true
+		image_url = images[primary].URL
+	}
+
+	collection := client.Database("waha").Collection("recipes")
+
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"id": product_id},
+		bson.M{"$set": bson.M{"images": images, "image_url": image_url}},
+	)
+	if err != nil {
+		return product, err
+	}
+
+	if result.MatchedCount == 0 {
+		return product, mongo.ErrNoDocuments
+	}
+
+	err = collection.FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+
+	return product, err
+}
+
+// AddProductImage validates the image, stores it with its thumbnail and adds it to the images of the product.
+// It's made the primary image when is_primary is set or when the product has no images.
+func (rs *RecipeService) AddProductImage(product_id string, data []byte, is_primary bool) (product models.Product, err error) {
+
+	uploaded, err := processImage(rs.Config, data)
+	if err != nil {
+		return product, err
+	}
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return product, err
+	}
+
+	store := storage.StorageFactory(rs.Config)
+
+	product_image, err := storeProductImage(ctx, store, product_id, uploaded)
+	if err != nil {
+		return product, err
+	}
+
+	product_image.IsPrimary = is_primary
+	images := []models.ProductImage{product_image}
+	if !is_primary {
+		images = append(product.Images, product_image)
+	} else {
+		images = append(images, product.Images...)
+	}
+
+	updated, err := saveProductImages(ctx, client, product_id, images)
+	if err != nil {
+		rs.removeImageFiles(ctx, store, product_image)
+		return product, err
+	}
+
+	return updated, nil
+}
+
+// ReplaceProductImage stores the image as the primary image of the product,
+// the previous primary image and its thumbnail are deleted.
+func (rs *RecipeService) ReplaceProductImage(product_id string, data []byte) (product models.Product, err error) {
+
+	uploaded, err := processImage(rs.Config, data)
+	if err != nil {
+		return product, err
+	}
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return product, err
+	}
+
+	store := storage.StorageFactory(rs.Config)
+
+	product_image, err := storeProductImage(ctx, store, product_id, uploaded)
+	if err != nil {
+		return product, err
+	}
+
+	product_image.IsPrimary = true
+	images := []models.ProductImage{product_image}
+	replaced := make([]models.ProductImage, 0)
+
+	for _, old_image := range product.Images {
+		if old_image.IsPrimary {
+			replaced = append(replaced, old_image)
+			continue
+		}
+		images = append(images, old_image)
+	}
+
+	updated, err := saveProductImages(ctx, client, product_id, images)
+	if err != nil {
+		rs.removeImageFiles(ctx, store, product_image)
+		return product, err
+	}
+
+	rs.removeLegacyImage(ctx, store, product)
+	for _, old_image := range replaced {
+		rs.removeImageFiles(ctx, store, old_image)
+	}
+
+	return updated, nil
+}
+
+// DeleteProductImage removes the image from the product and deletes its files,
+// the next image becomes the primary one when the primary image is deleted.
+func (rs *RecipeService) DeleteProductImage(product_id string, image_id string) (product models.Product, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return product, err
+	}
+
+	images := make([]models.ProductImage, 0, len(product.Images))
+	var deleted *models.ProductImage
+
+	for index, product_image := range product.Images {
+		if product_image.Id == image_id {
+			deleted = &product.Images[index]
+			continue
+		}
+		images = append(images, product_image)
+	}
+
+	if deleted == nil {
+		return product, mongo.ErrNoDocuments
+	}
+
+	updated, err := saveProductImages(ctx, client, product_id, images)
+	if err != nil {
+		return product, err
+	}
+
+	rs.removeImageFiles(ctx, storage.StorageFactory(rs.Config), *deleted)
+
+	return updated, nil
+}
+
+// SetPrimaryProductImage makes the image the primary image of the product.
+func (rs *RecipeService) SetPrimaryProductImage(product_id string, image_id string) (product models.Product, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return product, err
+	}
+
+	images := make([]models.ProductImage, 0, len(product.Images))
+	found := false
+
+	for _, product_image := range product.Images {
+		product_image.IsPrimary = product_image.Id == image_id
+		found = found || product_image.IsPrimary
+		images = append(images, product_image)
+	}
+
+	if !found {
+		return product, mongo.ErrNoDocuments
+	}
+
+	return saveProductImages(ctx, client, product_id, images)
+}
+
+// removeProductImages deletes the files of all the images of the product.
+func (rs *RecipeService) removeProductImages(ctx context.Context, product models.Product) {
+
+	store := storage.StorageFactory(rs.Config)
+
+	rs.removeLegacyImage(ctx, store, product)
+	for _, product_image := range product.Images {
+		rs.removeImageFiles(ctx, store, product_image)
+	}
+}
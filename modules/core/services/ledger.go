@@ -0,0 +1,719 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ledgerTolerance is the largest difference between the stored and the ledger quantity of an entry
+// that is not reported as a drift, entry quantities are stored as float32.
+const ledgerTolerance = 1e-3
+
+// Reconciliation sources, they define which side is trusted when the stored quantities drift from the ledger.
+const (
+	// ReconcileFromLedger overwrites the stored entry quantities with the ledger quantities.
+	ReconcileFromLedger = "ledger"
+	// ReconcileFromStored records adjustment movements so the ledger matches the stored quantities.
+	ReconcileFromStored = "stored"
+)
+
+// LedgerService records the inventory movements, every change of a material entry quantity
+// goes through it, and checks the stored quantities against the ledger.
+type LedgerService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// GetMovementsParams is the struct to hold the parameters for the GetMovements method.
+type GetMovementsParams struct {
+	MaterialId string
+	EntryId    string
+	Type       string
+	// From and To limit the movements date, they are ignored when zero.
+	From       time.Time
+	To         time.Time
+	PageNumber int
+	PageSize   int
+}
+
+// validateMovement checks the type of the movement and the sign of its quantity.
+func validateMovement(movement models.InventoryMovement) error {
+
+	if movement.MaterialId == "" || movement.EntryId == "" {
+		return fmt.Errorf("%w: material_id and entry_id are required", customerrors.ErrInvalidMovement)
+	}
+
+	switch movement.Type {
+	case models.MovementTypeReceipt, models.MovementTypeReturn:
+		if movement.Quantity <= 0 {
+			return fmt.Errorf("%w: %s quantity must be positive", customerrors.ErrInvalidMovement, movement.Type)
+		}
+	case models.MovementTypeConsumption, models.MovementTypeWaste:
+		if movement.Quantity >= 0 {
+			return fmt.Errorf("%w: %s quantity must be negative", customerrors.ErrInvalidMovement, movement.Type)
+		}
+	case models.MovementTypeAdjustment, models.MovementTypeTransfer:
+		if movement.Quantity == 0 {
+			return fmt.Errorf("%w: %s quantity can't be zero", customerrors.ErrInvalidMovement, movement.Type)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %s", customerrors.ErrInvalidMovement, movement.Type)
+	}
+
+	return nil
+}
+
+// recordMovement stores the movement in the inventory_movements collection, and applies its quantity
+// to the entry when apply is set. The movement is removed if it can't be applied, so the ledger only
+// holds the applied movements. The unit cost is taken from the entry when it's not set, and the
+// movements adding stock at their own unit cost update the moving average unit cost of the material.
+func recordMovement(ctx context.Context, client *mongo.Client, movement models.InventoryMovement, apply bool) (models.InventoryMovement, error) {
+
+	if err := validateMovement(movement); err != nil {
+		return movement, err
+	}
+
+	if movement.Id == "" {
+		movement.Id = primitive.NewObjectID().Hex()
+	}
+	if movement.Date.IsZero() {
+		movement.Date = time.Now()
+	}
+
+	if apply {
+		var material models.Material
+		err := client.Database("waha").Collection("materials").FindOne(ctx, bson.M{
+			"id":         movement.MaterialId,
+			"entries.id": movement.EntryId,
+		}, options.FindOne().SetProjection(bson.M{"entries.$": 1})).Decode(&material)
+		if err == mongo.ErrNoDocuments || (err == nil && len(material.Entries) == 0) {
+			return movement, fmt.Errorf("%w: entry %s of material %s", customerrors.ErrEntryNotFound, movement.EntryId, movement.MaterialId)
+		}
+		if err != nil {
+			return movement, err
+		}
+
+		entry := material.Entries[0]
+
+		if movement.UnitCost == 0 {
+			movement.UnitCost = entry.UnitCost()
+		}
+
+		// consumptions are checked against the entry availability before they are recorded
+		if (movement.Type == models.MovementTypeWaste || movement.Type == models.MovementTypeTransfer) && float64(entry.Quantity)+movement.Quantity < -ledgerTolerance {
+			return movement, fmt.Errorf("%w: entry %s only has %f", customerrors.ErrInvalidMovement, movement.EntryId, entry.Quantity)
+		}
+	}
+
+	_, err := client.Database("waha").Collection("inventory_movements").InsertOne(ctx, movement)
+	if err != nil {
+		return movement, err
+	}
+
+	if !apply {
+		return movement, nil
+	}
+
+	update := bson.M{"$inc": bson.M{"entries.$.quantity": movement.Quantity}}
+
+	if isAverageInflow(movement) {
+		var material models.Material
+		err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{"id": movement.MaterialId}).Decode(&material)
+		if err != nil {
+			client.Database("waha").Collection("inventory_movements").DeleteOne(ctx, bson.M{"id": movement.Id})
+			return movement, err
+		}
+
+		stock := 0.0
+		for _, entry := range material.Entries {
+			if entry.Quantity > 0 {
+				stock += float64(entry.Quantity)
+			}
+		}
+
+		update["$set"] = bson.M{"average_cost": movingAverageCost(materialAverageCost(material), stock, movement.Quantity, movement.UnitCost)}
+	}
+
+	_, err = client.Database("waha").Collection("materials").UpdateOne(
+		ctx,
+		bson.M{"id": movement.MaterialId, "entries.id": movement.EntryId},
+		update,
+	)
+	if err != nil {
+		client.Database("waha").Collection("inventory_movements").DeleteOne(ctx, bson.M{"id": movement.Id})
+		return movement, err
+	}
+
+	InvalidateAvailabilityCache()
+
+	return movement, nil
+}
+
+// receiptMovement returns the receipt movement of a newly received entry.
+func receiptMovement(material_id string, entry models.MaterialEntry) models.InventoryMovement {
+	return models.InventoryMovement{
+		Type:       models.MovementTypeReceipt,
+		Date:       entry.ReceivedTime(),
+		MaterialId: material_id,
+		EntryId:    entry.Id,
+		Quantity:   float64(entry.Quantity),
+		UnitCost:   entry.UnitCost(),
+	}
+}
+
+// RecordMovement records a movement in the ledger and applies its quantity to the entry.
+func (ls *LedgerService) RecordMovement(movement models.InventoryMovement) (models.InventoryMovement, error) {
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return movement, err
+	}
+	defer cancel()
+	// connected to db
+
+	return recordMovement(ctx, client, movement, true)
+}
+
+// RecordTransfer moves a quantity from an entry to another one, the transfer is recorded as two
+// transfer movements linked by their transfer_id, the destination keeps the source unit cost.
+func (ls *LedgerService) RecordTransfer(from_material_id string, from_entry_id string, to_material_id string, to_entry_id string, quantity float64, reason string) (movements []models.InventoryMovement, err error) {
+
+	movements = make([]models.InventoryMovement, 0)
+
+	if quantity <= 0 {
+		return movements, fmt.Errorf("%w: transfer quantity must be positive", customerrors.ErrInvalidMovement)
+	}
+
+	if from_material_id == to_material_id && from_entry_id == to_entry_id {
+		return movements, fmt.Errorf("%w: can't transfer to the same entry", customerrors.ErrInvalidMovement)
+	}
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return movements, err
+	}
+	defer cancel()
+	// connected to db
+
+	var destination models.Material
+	err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{"id": to_material_id, "entries.id": to_entry_id}).Decode(&destination)
+	if err == mongo.ErrNoDocuments {
+		return movements, fmt.Errorf("%w: entry %s of material %s", customerrors.ErrEntryNotFound, to_entry_id, to_material_id)
+	}
+	if err != nil {
+		return movements, err
+	}
+
+	transfer_id := primitive.NewObjectID().Hex()
+	now := time.Now()
+
+	out, err := recordMovement(ctx, client, models.InventoryMovement{
+		Type:       models.MovementTypeTransfer,
+		Date:       now,
+		MaterialId: from_material_id,
+		EntryId:    from_entry_id,
+		Quantity:   -quantity,
+		Reason:     reason,
+		TransferId: transfer_id,
+	}, true)
+	if err != nil {
+		return movements, err
+	}
+	movements = append(movements, out)
+
+	in, err := recordMovement(ctx, client, models.InventoryMovement{
+		Type:       models.MovementTypeTransfer,
+		Date:       now,
+		MaterialId: to_material_id,
+		EntryId:    to_entry_id,
+		Quantity:   quantity,
+		UnitCost:   out.UnitCost,
+		Reason:     reason,
+		TransferId: transfer_id,
+	}, true)
+	if err != nil {
+		return movements, err
+	}
+	movements = append(movements, in)
+
+	return movements, nil
+}
+
+// GetMovements returns the ledger movements matching the params, the newest first.
+func (ls *LedgerService) GetMovements(params GetMovementsParams) (movements []models.InventoryMovement, totalRecords int64, err error) {
+
+	movements = make([]models.InventoryMovement, 0)
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return movements, 0, err
+	}
+	defer cancel()
+	// connected to db
+
+	filter := bson.M{}
+	if params.MaterialId != "" {
+		filter["material_id"] = params.MaterialId
+	}
+	if params.EntryId != "" {
+		filter["entry_id"] = params.EntryId
+	}
+	if params.Type != "" {
+		filter["type"] = params.Type
+	}
+
+	date_filter := bson.M{}
+	if !params.From.IsZero() {
+		date_filter["$gte"] = params.From
+	}
+	if !params.To.IsZero() {
+		date_filter["$lte"] = params.To
+	}
+	if len(date_filter) > 0 {
+		filter["date"] = date_filter
+	}
+
+	collection := client.Database("waha").Collection("inventory_movements")
+
+	totalRecords, err = collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return movements, 0, err
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"date": -1})
+	if params.PageSize > 0 {
+		skip := int64(0)
+		if params.PageNumber > 1 {
+			skip = int64((params.PageNumber - 1) * params.PageSize)
+		}
+		findOptions.SetSkip(skip).SetLimit(int64(params.PageSize))
+	}
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return movements, totalRecords, err
+	}
+
+	err = cursor.All(ctx, &movements)
+	return movements, totalRecords, err
+}
+
+// entryBalance is the sum of the ledger movements of an entry.
+type entryBalance struct {
+	MaterialId string
+	EntryId    string
+	Quantity   float64
+	Movements  int
+}
+
+// ledgerBalances sums the movements of each entry up to the given time (all of them when it's zero),
+// the balances are keyed by the entry id.
+func ledgerBalances(ctx context.Context, client *mongo.Client, as_of time.Time) (balances map[string]entryBalance, err error) {
+
+	balances = make(map[string]entryBalance)
+
+	match := bson.M{}
+	if !as_of.IsZero() {
+		match["date"] = bson.M{"$lte": as_of}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"material_id": "$material_id",
+				"entry_id":    "$entry_id",
+			},
+			"quantity":  bson.M{"$sum": "$quantity"},
+			"movements": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := client.Database("waha").Collection("inventory_movements").Aggregate(ctx, pipeline)
+	if err != nil {
+		return balances, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			Id struct {
+				MaterialId string `bson:"material_id"`
+				EntryId    string `bson:"entry_id"`
+			} `bson:"_id"`
+			Quantity  float64 `bson:"quantity"`
+			Movements int     `bson:"movements"`
+		}
+
+		if err := cursor.Decode(&row); err != nil {
+			return balances, err
+		}
+
+		balances[row.Id.EntryId] = entryBalance{
+			MaterialId: row.Id.MaterialId,
+			EntryId:    row.Id.EntryId,
+			Quantity:   row.Quantity,
+			Movements:  row.Movements,
+		}
+	}
+
+	return balances, cursor.Err()
+}
+
+// findAllMaterials returns all the materials with their entries.
+func findAllMaterials(ctx context.Context, client *mongo.Client) (materials []models.Material, err error) {
+
+	materials = make([]models.Material, 0)
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{})
+	if err != nil {
+		return materials, err
+	}
+
+	err = cursor.All(ctx, &materials)
+	return materials, err
+}
+
+// GetStockAsOf returns the quantity of each material and entry as of the given time, derived from the ledger movements.
+func (ls *LedgerService) GetStockAsOf(as_of time.Time) (stock dto.StockAsOf, err error) {
+
+	stock = dto.StockAsOf{
+		AsOf:      as_of,
+		Materials: make([]dto.MaterialStockLevel, 0),
+	}
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return stock, err
+	}
+	defer cancel()
+	// connected to db
+
+	balances, err := ledgerBalances(ctx, client, as_of)
+	if err != nil {
+		return stock, err
+	}
+
+	materials, err := findAllMaterials(ctx, client)
+	if err != nil {
+		return stock, err
+	}
+
+	levels := make(map[string]*dto.MaterialStockLevel)
+	material_ids := make([]string, 0)
+
+	for _, material := range materials {
+		levels[material.Id] = &dto.MaterialStockLevel{
+			MaterialId:   material.Id,
+			MaterialName: material.Name,
+			Unit:         material.Unit,
+			Entries:      make([]dto.EntryStockLevel, 0),
+		}
+		material_ids = append(material_ids, material.Id)
+
+		for _, entry := range material.Entries {
+			balance, ok := balances[entry.Id]
+			if !ok {
+				continue
+			}
+			delete(balances, entry.Id)
+
+			if math.Abs(balance.Quantity) <= ledgerTolerance {
+				continue
+			}
+
+			levels[material.Id].Quantity += balance.Quantity
+			levels[material.Id].Entries = append(levels[material.Id].Entries, dto.EntryStockLevel{
+				EntryId:  entry.Id,
+				SKU:      entry.SKU,
+				Company:  entry.Company,
+				Quantity: balance.Quantity,
+			})
+		}
+	}
+
+	// balances of the entries that were removed from their material since then
+	for _, balance := range balances {
+		if math.Abs(balance.Quantity) <= ledgerTolerance {
+			continue
+		}
+
+		if _, ok := levels[balance.MaterialId]; !ok {
+			levels[balance.MaterialId] = &dto.MaterialStockLevel{
+				MaterialId: balance.MaterialId,
+				Entries:    make([]dto.EntryStockLevel, 0),
+			}
+			material_ids = append(material_ids, balance.MaterialId)
+		}
+
+		levels[balance.MaterialId].Quantity += balance.Quantity
+		levels[balance.MaterialId].Entries = append(levels[balance.MaterialId].Entries, dto.EntryStockLevel{
+			EntryId:  balance.EntryId,
+			Quantity: balance.Quantity,
+		})
+	}
+
+	for _, material_id := range material_ids {
+		if len(levels[material_id].Entries) == 0 {
+			continue
+		}
+		stock.Materials = append(stock.Materials, *levels[material_id])
+	}
+
+	sort.SliceStable(stock.Materials, func(i, j int) bool {
+		return stock.Materials[i].MaterialName < stock.Materials[j].MaterialName
+	})
+
+	return stock, nil
+}
+
+// checkConsistency compares the stored quantity of each entry with the sum of its ledger movements.
+func checkConsistency(ctx context.Context, client *mongo.Client) (drifts []dto.LedgerDrift, err error) {
+
+	drifts = make([]dto.LedgerDrift, 0)
+
+	balances, err := ledgerBalances(ctx, client, time.Time{})
+	if err != nil {
+		return drifts, err
+	}
+
+	materials, err := findAllMaterials(ctx, client)
+	if err != nil {
+		return drifts, err
+	}
+
+	names := make(map[string]string)
+
+	for _, material := range materials {
+		names[material.Id] = material.Name
+
+		for _, entry := range material.Entries {
+			balance, has_movements := balances[entry.Id]
+			delete(balances, entry.Id)
+
+			drift := dto.LedgerDrift{
+				MaterialId:     material.Id,
+				MaterialName:   material.Name,
+				EntryId:        entry.Id,
+				StoredQuantity: float64(entry.Quantity),
+				LedgerQuantity: balance.Quantity,
+				HasMovements:   has_movements,
+			}
+			drift.Drift = drift.StoredQuantity - drift.LedgerQuantity
+
+			if math.Abs(drift.Drift) > ledgerTolerance {
+				drifts = append(drifts, drift)
+			}
+		}
+	}
+
+	for _, balance := range balances {
+		if math.Abs(balance.Quantity) <= ledgerTolerance {
+			continue
+		}
+
+		drifts = append(drifts, dto.LedgerDrift{
+			MaterialId:     balance.MaterialId,
+			MaterialName:   names[balance.MaterialId],
+			EntryId:        balance.EntryId,
+			LedgerQuantity: balance.Quantity,
+			Drift:          -balance.Quantity,
+			HasMovements:   true,
+			IsEntryMissing: true,
+		})
+	}
+
+	return drifts, nil
+}
+
+// CheckConsistency returns the entries whose stored quantity drifted from their ledger quantity.
+func (ls *LedgerService) CheckConsistency() (drifts []dto.LedgerDrift, err error) {
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return drifts, err
+	}
+	defer cancel()
+	// connected to db
+
+	return checkConsistency(ctx, client)
+}
+
+// backfillEntry records the history of an entry created before the ledger: its receipt,
+// and the consumption and waste logs of the entry. It returns the resulting ledger quantity.
+func backfillEntry(ctx context.Context, client *mongo.Client, material_id string, entry models.MaterialEntry) (quantity float64, err error) {
+
+	unit_cost := entry.UnitCost()
+
+	if entry.PurchaseQuantity > 0 {
+		receipt := receiptMovement(material_id, entry)
+		receipt.Quantity = float64(entry.PurchaseQuantity)
+		receipt.Reason = "backfill"
+
+		if _, err := recordMovement(ctx, client, receipt, false); err != nil {
+			return quantity, err
+		}
+		quantity += receipt.Quantity
+	}
+
+	movement_types := map[string]string{
+		"component_consume": models.MovementTypeConsumption,
+		"component_waste":   models.MovementTypeWaste,
+	}
+
+	for log_type, movement_type := range movement_types {
+		logs, err := findConsumptionLogs(ctx, client, log_type, bson.M{"entry_id": entry.Id})
+		if err != nil {
+			return quantity, err
+		}
+
+		for _, log := range logs {
+			if log.Quantity <= 0 {
+				continue
+			}
+
+			reason := log.Reason
+			if reason == "" {
+				reason = "backfill"
+			}
+
+			_, err := recordMovement(ctx, client, models.InventoryMovement{
+				Type:       movement_type,
+				Date:       log.Date,
+				MaterialId: material_id,
+				EntryId:    entry.Id,
+				Quantity:   -log.Quantity,
+				UnitCost:   unit_cost,
+				Reason:     reason,
+				OrderId:    log.OrderId,
+				RecipeId:   log.RecipeId,
+			}, false)
+			if err != nil {
+				return quantity, err
+			}
+			quantity -= log.Quantity
+		}
+	}
+
+	return quantity, nil
+}
+
+// Reconcile fixes the drifts between the stored quantities and the ledger, and returns the fixed drifts.
+//
+// With the ledger source, the stored quantity of each drifting entry is overwritten with its ledger quantity,
+// the entries without movements are skipped as they would be emptied. With the stored source, adjustment
+// movements are recorded so the ledger matches the stored quantities, the entries without movements are
+// first backfilled from their purchase quantity and their consumption and waste logs.
+func (ls *LedgerService) Reconcile(source string) (drifts []dto.LedgerDrift, err error) {
+
+	drifts = make([]dto.LedgerDrift, 0)
+
+	if source != ReconcileFromLedger && source != ReconcileFromStored {
+		return drifts, fmt.Errorf("unknown reconciliation source %s", source)
+	}
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return drifts, err
+	}
+	defer cancel()
+	// connected to db
+
+	found, err := checkConsistency(ctx, client)
+	if err != nil {
+		return drifts, err
+	}
+
+	var materials map[string]models.Material
+	if source == ReconcileFromStored {
+		all_materials, err := findAllMaterials(ctx, client)
+		if err != nil {
+			return drifts, err
+		}
+
+		materials = make(map[string]models.Material)
+		for _, material := range all_materials {
+			materials[material.Id] = material
+		}
+	}
+
+	for _, drift := range found {
+
+		if source == ReconcileFromLedger {
+			if drift.IsEntryMissing || !drift.HasMovements {
+				continue
+			}
+
+			_, err := client.Database("waha").Collection("materials").UpdateOne(
+				ctx,
+				bson.M{"id": drift.MaterialId, "entries.id": drift.EntryId},
+				bson.M{"$set": bson.M{"entries.$.quantity": drift.LedgerQuantity}},
+			)
+			if err != nil {
+				return drifts, err
+			}
+
+			InvalidateAvailabilityCache()
+
+			drifts = append(drifts, drift)
+			continue
+		}
+
+		ledger_quantity := drift.LedgerQuantity
+		reason := "reconcile"
+
+		if drift.IsEntryMissing {
+			reason = "reconcile: entry removed"
+		}
+
+		if !drift.HasMovements {
+			for _, entry := range materials[drift.MaterialId].Entries {
+				if entry.Id != drift.EntryId {
+					continue
+				}
+
+				ledger_quantity, err = backfillEntry(ctx, client, drift.MaterialId, entry)
+				if err != nil {
+					return drifts, err
+				}
+			}
+		}
+
+		remainder := drift.StoredQuantity - ledger_quantity
+		if math.Abs(remainder) > ledgerTolerance {
+			_, err := recordMovement(ctx, client, models.InventoryMovement{
+				Type:       models.MovementTypeAdjustment,
+				MaterialId: drift.MaterialId,
+				EntryId:    drift.EntryId,
+				Quantity:   remainder,
+				Reason:     reason,
+			}, false)
+			if err != nil {
+				return drifts, err
+			}
+		}
+
+		drifts = append(drifts, drift)
+	}
+
+	return drifts, nil
+}
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// loyaltyTolerance is the fewest points that are considered left of a lot, the points are floats.
+const loyaltyTolerance = 1e-6
+
+// LoyaltyService keeps the loyalty points of the customers, the points are earned on the finished orders
+// and redeemed for discounts and free products at checkout.
+type LoyaltyService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// GetLoyaltyEntriesParams is the struct to hold the parameters for the GetEntries method.
+type GetLoyaltyEntriesParams struct {
+	CustomerId string
+	PageNumber int
+	PageSize   int
+}
+
+// pointsLot is an entry adding points with the points of it that are still left.
+type pointsLot struct {
+	entry models.LoyaltyEntry
+	left  float64
+}
+
+// postLoyalty changes the points of the customer by the points of the entry and records it with the balance after it.
+// The entries taking points are refused when the customer doesn't have enough of them, except for the reversals of
+// earned points that are taken back even when they were redeemed since. The earned and the reversed points change
+// the lifetime points of the customer as well.
+func postLoyalty(ctx context.Context, client *mongo.Client, entry models.LoyaltyEntry) (models.LoyaltyEntry, error) {
+
+	customers := client.Database("waha").Collection("customers")
+
+	filter := bson.M{"id": entry.CustomerId}
+	if entry.Points < 0 && entry.Type != models.LoyaltyTypeReversal {
+		filter["points"] = bson.M{"$gte": -entry.Points - loyaltyTolerance}
+	}
+
+	inc := bson.M{"points": entry.Points}
+	if entry.Type == models.LoyaltyTypeEarn || entry.Type == models.LoyaltyTypeReversal {
+		inc["lifetime_points"] = entry.Points
+	}
+
+	var customer models.Customer
+	err := customers.FindOneAndUpdate(ctx, filter, bson.M{"$inc": inc},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&customer)
+
+	if err == mongo.ErrNoDocuments {
+		err = customers.FindOne(ctx, bson.M{"id": entry.CustomerId}).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			return entry, fmt.Errorf("%w: %s", customerrors.ErrCustomerNotFound, entry.CustomerId)
+		}
+		if err != nil {
+			return entry, err
+		}
+
+		return entry, fmt.Errorf("%w: %s has %.0f points, %.0f are needed",
+			customerrors.ErrInsufficientPoints, customer.Name, customer.Points, -entry.Points)
+	}
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Id = primitive.NewObjectID().Hex()
+	entry.Balance = customer.Points
+	if entry.Date.IsZero() {
+		entry.Date = time.Now()
+	}
+
+	_, err = client.Database("waha").Collection("loyalty").InsertOne(ctx, entry)
+	if err != nil {
+		// the points are only changed along with their entry
+		revert := bson.M{}
+		for key, value := range inc {
+			revert[key] = -value.(float64)
+		}
+		_, revert_err := customers.UpdateOne(ctx, bson.M{"id": entry.CustomerId}, bson.M{"$inc": revert})
+		if revert_err != nil {
+			return entry, revert_err
+		}
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// pointsExpiry returns when the points added now expire, it's nil when they never expire.
+func pointsExpiry(settings models.LoyaltySettings, from time.Time) *time.Time {
+
+	if settings.ExpiryDays <= 0 {
+		return nil
+	}
+
+	expires_at := from.AddDate(0, 0, settings.ExpiryDays)
+	return &expires_at
+}
+
+// customerTier returns the highest tier reached by the lifetime points, the tier is empty when none is reached.
+func customerTier(settings models.LoyaltySettings, lifetime_points float64) (tier models.LoyaltyTier) {
+
+	found := false
+	for _, t := range settings.Tiers {
+		if lifetime_points+loyaltyTolerance >= t.MinPoints && (!found || t.MinPoints > tier.MinPoints) {
+			tier = t
+			found = true
+		}
+	}
+
+	return tier
+}
+
+// loadLoyaltyEntries returns the entries matching the filter in date order.
+func loadLoyaltyEntries(ctx context.Context, client *mongo.Client, filter bson.M) (entries []models.LoyaltyEntry, err error) {
+
+	cursor, err := client.Database("waha").Collection("loyalty").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "id", Value: 1}}))
+	if err != nil {
+		return entries, err
+	}
+
+	entries = []models.LoyaltyEntry{}
+	err = cursor.All(ctx, &entries)
+
+	return entries, err
+}
+
+// allocatePoints takes the points taken from a customer from the lots that added them. The expired points are taken
+// from their lot, the rest of them are taken from the lots expiring first. It returns the lots with the points left.
+func allocatePoints(entries []models.LoyaltyEntry) []pointsLot {
+
+	lots := []pointsLot{}
+	by_id := make(map[string]int)
+	pool := 0.0
+
+	for _, entry := range entries {
+		if entry.Points > 0 {
+			by_id[entry.Id] = len(lots)
+			lots = append(lots, pointsLot{entry: entry, left: entry.Points})
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Points >= 0 {
+			continue
+		}
+
+		taken := -entry.Points
+
+		if index, ok := by_id[entry.LotId]; ok && entry.LotId != "" {
+			from_lot := math.Min(taken, lots[index].left)
+			lots[index].left -= from_lot
+			taken -= from_lot
+		}
+
+		pool += taken
+	}
+
+	by_expiry := make([]int, len(lots))
+	for index := range lots {
+		by_expiry[index] = index
+	}
+
+	// the lots that never expire are taken last
+	sort.SliceStable(by_expiry, func(i, j int) bool {
+		a, b := lots[by_expiry[i]].entry.ExpiresAt, lots[by_expiry[j]].entry.ExpiresAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+
+	for _, index := range by_expiry {
+		if pool <= 0 {
+			break
+		}
+
+		from_lot := math.Min(pool, lots[index].left)
+		lots[index].left -= from_lot
+		pool -= from_lot
+	}
+
+	return lots
+}
+
+// applyLoyalty prices the reward items of the order with the points of the rewards, and discounts the order by
+// the tier of the customer and the points they redeem. It sets the loyalty of the order with the points to take
+// from the customer, the total is the sale price of the order before its discount.
+func applyLoyalty(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, order *models.Order, total float64) error {
+
+	redeem_points := 0.0
+	if order.Loyalty != nil {
+		redeem_points = order.Loyalty.RedeemPoints
+	}
+
+	has_rewards := false
+	for _, item := range order.Items {
+		has_rewards = has_rewards || item.IsReward
+	}
+
+	if !settings.IsEnabled || order.Customer.Id == "" {
+		if redeem_points != 0 || has_rewards {
+			return fmt.Errorf("%w: the points are only redeemed by a customer when the loyalty program is enabled", customerrors.ErrInvalidRedemption)
+		}
+		order.Loyalty = nil
+		return nil
+	}
+
+	if redeem_points < 0 {
+		return fmt.Errorf("%w: the redeemed points can't be negative", customerrors.ErrInvalidRedemption)
+	}
+
+	var customer models.Customer
+	err := client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": order.Customer.Id}).Decode(&customer)
+	if err != nil {
+		return err
+	}
+
+	rewards := make(map[string]float64)
+	for _, reward := range settings.Rewards {
+		rewards[reward.ProductId] = reward.Points
+	}
+
+	loyalty := models.OrderLoyalty{RedeemPoints: redeem_points}
+
+	for _, item := range order.Items {
+		if !item.IsReward {
+			continue
+		}
+
+		points, ok := rewards[item.Product.Id]
+		if !ok {
+			return fmt.Errorf("%w: %s isn't a reward", customerrors.ErrInvalidRedemption, item.Product.Name)
+		}
+
+		// an item is priced as a single unit whatever its quantity, and so is a reward item
+		loyalty.RedeemedPoints += points
+	}
+
+	tier := customerTier(settings, customer.LifetimePoints)
+	loyalty.Tier = tier.Name
+	loyalty.TierDiscount = total * tier.DiscountPercent / 100
+
+	if redeem_points > 0 {
+		if redeem_points < settings.MinRedeemPoints || settings.PointValue <= 0 {
+			return fmt.Errorf("%w: at least %.0f points are redeemed for a discount", customerrors.ErrInvalidRedemption, settings.MinRedeemPoints)
+		}
+
+		loyalty.PointsDiscount = redeem_points * settings.PointValue
+		if loyalty.PointsDiscount > total-order.Discount-loyalty.TierDiscount+loyaltyTolerance {
+			return fmt.Errorf("%w: the discount of %.0f points is more than the order total", customerrors.ErrInvalidRedemption, redeem_points)
+		}
+
+		loyalty.RedeemedPoints += redeem_points
+	}
+
+	if loyalty.RedeemedPoints > customer.Points+loyaltyTolerance {
+		return fmt.Errorf("%w: %s has %.0f points, %.0f are needed",
+			customerrors.ErrInsufficientPoints, customer.Name, customer.Points, loyalty.RedeemedPoints)
+	}
+
+	order.Discount += loyalty.PointsDiscount + loyalty.TierDiscount
+
+	if loyalty.RedeemedPoints == 0 && loyalty.Tier == "" {
+		order.Loyalty = nil
+		return nil
+	}
+
+	order.Loyalty = &loyalty
+
+	return nil
+}
+
+// redeemOrderPoints takes the points redeemed on the order from its customer, the stashed orders don't take them.
+func redeemOrderPoints(ctx context.Context, client *mongo.Client, order models.Order) (redeemed bool, err error) {
+
+	if order.Loyalty == nil || order.Loyalty.RedeemedPoints <= 0 || order.State == "stashed" {
+		return false, nil
+	}
+
+	_, err = postLoyalty(ctx, client, models.LoyaltyEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           models.LoyaltyTypeRedeem,
+		Date:           order.SubmittedAt,
+		Points:         -order.Loyalty.RedeemedPoints,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+	})
+
+	return err == nil, err
+}
+
+// refundOrderPoints gives back the points redeemed on the order that weren't refunded yet, they're valid
+// as long as newly earned points.
+func refundOrderPoints(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, order models.Order) error {
+
+	if order.Customer.Id == "" {
+		return nil
+	}
+
+	entries, err := loadLoyaltyEntries(ctx, client, bson.M{
+		"order_id": order.Id,
+		"type":     bson.M{"$in": []string{models.LoyaltyTypeRedeem, models.LoyaltyTypeRefund}},
+	})
+	if err != nil {
+		return err
+	}
+
+	redeemed := 0.0
+	for _, entry := range entries {
+		redeemed -= entry.Points
+	}
+
+	if redeemed <= loyaltyTolerance {
+		return nil
+	}
+
+	_, err = postLoyalty(ctx, client, models.LoyaltyEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           models.LoyaltyTypeRefund,
+		Points:         redeemed,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+		ExpiresAt:      pointsExpiry(settings, time.Now()),
+	})
+
+	return err
+}
+
+// earnOrderPoints adds the points earned on the finished order to its customer and moves them to the tier they reach.
+// The points are earned on what's paid for the items, multiplied by the categories of the products and the tier of
+// the customer. The sale price is the price of the order after its discount, the reward items don't earn points.
+func earnOrderPoints(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, order models.Order, sale_price float64) error {
+
+	if !settings.IsEnabled || settings.PointsPerUnit <= 0 || order.Customer.Id == "" {
+		return nil
+	}
+
+	collection := client.Database("waha").Collection("loyalty")
+
+	// the points of an order are only earned once
+	err := collection.FindOne(ctx, bson.M{"order_id": order.Id, "type": models.LoyaltyTypeEarn}).Err()
+	if err == nil {
+		return nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	product_ids := []string{}
+	for _, item := range order.Items {
+		product_ids = append(product_ids, item.Product.Id)
+	}
+
+	multipliers, err := productMultipliers(ctx, client, settings, product_ids)
+	if err != nil {
+		return err
+	}
+
+	gross := 0.0
+	weighted := 0.0
+	for _, item := range order.Items {
+		if item.IsReward {
+			continue
+		}
+
+		multiplier, ok := multipliers[item.Product.Id]
+		if !ok {
+			multiplier = 1
+		}
+
+		gross += item.SalePrice
+		weighted += item.SalePrice * multiplier
+	}
+
+	if gross <= 0 || sale_price <= 0 {
+		return nil
+	}
+
+	var customer models.Customer
+	err = client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": order.Customer.Id}).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tier_multiplier := customerTier(settings, customer.LifetimePoints).EarnMultiplier
+	if tier_multiplier <= 0 {
+		tier_multiplier = 1
+	}
+
+	// the discount of the order is spread over its items
+	points := math.Floor(weighted*math.Min(sale_price/gross, 1)*settings.PointsPerUnit*tier_multiplier + loyaltyTolerance)
+	if points <= 0 {
+		return nil
+	}
+
+	_, err = postLoyalty(ctx, client, models.LoyaltyEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           models.LoyaltyTypeEarn,
+		Points:         points,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+		ExpiresAt:      pointsExpiry(settings, time.Now()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateCustomerTier(ctx, client, settings, order.Customer.Id)
+}
+
+// reverseOrderPoints takes back the points earned on the order that weren't taken back yet, from the lot they were
+// earned in. The customer keeps the tier of their lifetime points without them. The balance of a customer who
+// already redeemed the points goes negative, and it's made up by the points they earn next.
+func reverseOrderPoints(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, order models.Order) error {
+
+	if order.Customer.Id == "" {
+		return nil
+	}
+
+	entries, err := loadLoyaltyEntries(ctx, client, bson.M{
+		"order_id": order.Id,
+		"type":     bson.M{"$in": []string{models.LoyaltyTypeEarn, models.LoyaltyTypeReversal}},
+	})
+	if err != nil {
+		return err
+	}
+
+	earned := 0.0
+	lot_id := ""
+	for _, entry := range entries {
+		earned += entry.Points
+		if entry.Type == models.LoyaltyTypeEarn {
+			lot_id = entry.Id
+		}
+	}
+
+	if earned <= loyaltyTolerance {
+		return nil
+	}
+
+	_, err = postLoyalty(ctx, client, models.LoyaltyEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           models.LoyaltyTypeReversal,
+		Points:         -earned,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+		LotId:          lot_id,
+	})
+	if err != nil {
+		return err
+	}
+
+	return updateCustomerTier(ctx, client, settings, order.Customer.Id)
+}
+
+// updateCustomerTier moves the customer to the tier their lifetime points reach.
+func updateCustomerTier(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, customer_id string) error {
+
+	var customer models.Customer
+	err := client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": customer_id}).Decode(&customer)
+	if err != nil {
+		return err
+	}
+
+	tier := customerTier(settings, customer.LifetimePoints)
+	if tier.Name == customer.Tier {
+		return nil
+	}
+
+	_, err = client.Database("waha").Collection("customers").UpdateOne(ctx, bson.M{"id": customer_id}, bson.M{"$set": bson.M{"tier": tier.Name}})
+
+	return err
+}
+
+// productMultipliers returns the highest points multiplier of the categories of each of the products,
+// the products without a multiplier are left out.
+func productMultipliers(ctx context.Context, client *mongo.Client, settings models.LoyaltySettings, product_ids []string) (multipliers map[string]float64, err error) {
+
+	multipliers = make(map[string]float64)
+
+	if len(settings.CategoryMultipliers) == 0 || len(product_ids) == 0 {
+		return multipliers, nil
+	}
+
+	category_ids := []string{}
+	for category_id := range settings.CategoryMultipliers {
+		category_ids = append(category_ids, category_id)
+	}
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, bson.M{
+		"id":                      bson.M{"$in": category_ids},
+		"product_refs.product_id": bson.M{"$in": product_ids},
+	})
+	if err != nil {
+		return multipliers, err
+	}
+
+	categories := []models.Category{}
+	err = cursor.All(ctx, &categories)
+	if err != nil {
+		return multipliers, err
+	}
+
+	for _, category := range categories {
+		multiplier := settings.CategoryMultipliers[category.Id]
+		for _, ref := range category.ProductRefs {
+			if current, ok := multipliers[ref.ProductId]; !ok || multiplier > current {
+				multipliers[ref.ProductId] = multiplier
+			}
+		}
+	}
+
+	return multipliers, nil
+}
+
+// GetEntries returns a page of the loyalty entries of the customer, the newest first.
+func (ls *LoyaltyService) GetEntries(params GetLoyaltyEntriesParams) (entries []models.LoyaltyEntry, entries_count int, err error) {
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return entries, entries_count, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": params.CustomerId}).Err()
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	collection := client.Database("waha").Collection("loyalty")
+	filter := bson.M{"customer_id": params.CustomerId}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "id", Value: -1}})
+	findOptions.SetSkip(int64((params.PageNumber - 1) * params.PageSize))
+	findOptions.SetLimit(int64(params.PageSize))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	entries = []models.LoyaltyEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return entries, entries_count, err
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	return entries, int(count), nil
+}
+
+// AdjustPoints adds or takes points of the customer by hand, the added points expire as the earned ones do.
+func (ls *LoyaltyService) AdjustPoints(customer_id string, adjustment dto.LoyaltyAdjustment) (entry models.LoyaltyEntry, err error) {
+
+	if adjustment.Points == 0 {
+		return entry, fmt.Errorf("%w: the adjusted points can't be zero", customerrors.ErrInvalidRedemption)
+	}
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return entry, err
+	}
+	defer cancel()
+	// connected to db
+
+	entry = models.LoyaltyEntry{
+		CustomerId: customer_id,
+		Type:       models.LoyaltyTypeAdjustment,
+		Points:     adjustment.Points,
+		Comment:    adjustment.Comment,
+	}
+
+	if adjustment.Points > 0 {
+		entry.ExpiresAt = pointsExpiry(ls.Settings.Loyalty, time.Now())
+	}
+
+	return postLoyalty(ctx, client, entry)
+}
+
+// ExpirePoints takes the points left of the lots that expired by the date, it returns the number of the expired lots.
+func (ls *LoyaltyService) ExpirePoints(at time.Time) (expired int, err error) {
+
+	client, ctx, cancel, err := connectDB(ls.Config)
+	if err != nil {
+		return expired, err
+	}
+	defer cancel()
+	// connected to db
+
+	customer_ids, err := client.Database("waha").Collection("loyalty").Distinct(ctx, "customer_id", bson.M{
+		"points":     bson.M{"$gt": 0},
+		"expires_at": bson.M{"$lte": at},
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	for _, customer_id := range customer_ids {
+
+		entries, err := loadLoyaltyEntries(ctx, client, bson.M{"customer_id": customer_id})
+		if err != nil {
+			return expired, err
+		}
+
+		for _, lot := range allocatePoints(entries) {
+			if lot.left <= loyaltyTolerance || lot.entry.ExpiresAt == nil || lot.entry.ExpiresAt.After(at) {
+				continue
+			}
+
+			_, err = postLoyalty(ctx, client, models.LoyaltyEntry{
+				CustomerId: lot.entry.CustomerId,
+				Type:       models.LoyaltyTypeExpire,
+				Date:       at,
+				Points:     -lot.left,
+				LotId:      lot.entry.Id,
+			})
+			if err != nil {
+				return expired, err
+			}
+
+			expired++
+		}
+	}
+
+	return expired, nil
+}
@@ -12,7 +12,9 @@ import (
 	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -31,8 +33,8 @@ type MaterialService struct {
 }
 
 // CalculateMaterialCost calculates the cost of a material entry based on its ID, material ID, and quantity.
-// It connects to the MongoDB database, retrieves the specific material entry, and calculates the cost
-// using the purchase price and purchase quantity.
+// It connects to the MongoDB database, retrieves the material with its entries, and calculates the cost
+// using the configured costing method.
 func (cs *MaterialService) CalculateMaterialCost(entry_id, material_id string, quantity float64) (cost float64, err error) {
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
 
@@ -59,19 +61,12 @@ func (cs *MaterialService) CalculateMaterialCost(entry_id, material_id string, q
 	err = client.Database("waha").Collection("materials").FindOne(context.Background(), bson.M{
 		"id":         material_id,
 		"entries.id": entry_id,
-	},
-		options.FindOne().SetProjection(bson.M{"entries.$": 1})).Decode(&material)
+	}).Decode(&material)
 	if err != nil {
 		return 0, err
 	}
 
-	if len(material.Entries) == 0 {
-		return 0.0, fmt.Errorf("entry %s not found in material %s", entry_id, material_id)
-	}
-
-	cost = (material.Entries[0].PurchasePrice / float64(material.Entries[0].PurchaseQuantity)) * quantity
-
-	return cost, nil
+	return cs.CalculateConsumptionCost(material, entry_id, quantity)
 }
 
 // GetMaterialEntryAvailability retrieves the quantity of a specific material entry
@@ -113,15 +108,93 @@ func (cs *MaterialService) GetMaterialEntryAvailability(material_id string, entr
 		return 0.0, fmt.Errorf("entry %s not found in material %s", entry_id, material_id)
 	}
 
+	// quarantined and expired entries are not available for consumption
+	if !material.Entries[0].IsUsable(time.Now()) {
+		return 0.0, nil
+	}
+
 	amount = material.Entries[0].Quantity
 
 	return amount, err
 
 }
 
-// ConsumeItemComponentsForOrder consumes components for an order item, and returns the notifications to be sent via websocket.
-// It returns an error if something goes wrong.
-func (cs *MaterialService) ConsumeItemComponentsForOrder(item models.OrderItem, order models.Order, item_order_index int) (notifications []models.WebsocketTopicServerMessage, err error) {
+// GetMaterialEntry retrieves a specific entry of a material from the database.
+func (cs *MaterialService) GetMaterialEntry(material_id string, entry_id string) (entry models.MaterialEntry, err error) {
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	// Create a context with a timeout (optional)
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	// Connect to MongoDB
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return entry, err
+	}
+
+	var material models.Material
+	err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{
+		"id":         material_id,
+		"entries.id": entry_id,
+	},
+		options.FindOne().SetProjection(bson.M{"entries.$": 1})).Decode(&material)
+	if err != nil {
+		return entry, err
+	}
+
+	if len(material.Entries) == 0 {
+		return entry, fmt.Errorf("entry %s not found in material %s", entry_id, material_id)
+	}
+
+	return material.Entries[0], nil
+}
+
+// consumptionRef identifies what the components are consumed for, an order or a production batch.
+type consumptionRef struct {
+	OrderId        string
+	OrderDisplayId string
+	// ProductionBatchId is the id of the batch produced from the consumed components.
+	ProductionBatchId string
+}
+
+// String describes the consumer in the notification messages.
+func (ref consumptionRef) String() string {
+	if ref.ProductionBatchId != "" {
+		return fmt.Sprintf("production batch: %s", ref.ProductionBatchId)
+	}
+	return fmt.Sprintf("order_id: %s (display_id: %s)", ref.OrderId, ref.OrderDisplayId)
+}
+
+// ConsumeItemComponentsForOrder consumes components for an order item, and returns the consumed item and the notifications
+// to be sent via websocket. It returns an error if something goes wrong.
+//
+// The quantities of the item are the net quantities of its recipe, they are scaled up by the recipe yields before consuming,
+// so the quantities of the consumed item are gross.
+func (cs *MaterialService) ConsumeItemComponentsForOrder(item models.OrderItem, order models.Order, item_order_index int) (consumed models.OrderItem, notifications []models.WebsocketTopicServerMessage, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return item, notifications, err
+	}
+
+	item, err = grossItemTree(ctx, client, item)
+	if err != nil {
+		return item, notifications, err
+	}
+
+	return cs.consumeItemComponents(item, consumptionRef{OrderId: order.Id, OrderDisplayId: order.DisplayId}, item_order_index)
+}
+
+// consumeItemComponents consumes the components of an item, or its ready stock, for an order or a production batch,
+// and returns the notifications to be sent via websocket. The consumed item has the unit cost of the ready stock
+// its ready items are served from.
+func (cs *MaterialService) consumeItemComponents(item models.OrderItem, ref consumptionRef, item_order_index int) (consumed models.OrderItem, notifications []models.WebsocketTopicServerMessage, err error) {
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
 
@@ -132,13 +205,13 @@ func (cs *MaterialService) ConsumeItemComponentsForOrder(item models.OrderItem,
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return notifications, err
+		return item, notifications, err
 	}
 
 	// Ping the database to check connectivity
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return notifications, err
+		return item, notifications, err
 	}
 
 	// Connected successfully
@@ -150,87 +223,266 @@ func (cs *MaterialService) ConsumeItemComponentsForOrder(item models.OrderItem,
 	}
 
 	if item.IsConsumeFromReady {
-		err = productService.ConsumeFromReady(item.Product.Id, item.Quantity)
-		return notifications, err
+		cost, err := productService.ConsumeFromReady(item.Product.Id, item.Quantity, ref.OrderId, ref.ProductionBatchId, func() (float64, error) {
+			return cs.recipeUnitCost(ctx, client, item)
+		})
+		if err != nil {
+			return item, notifications, err
+		}
+
+		if item.Quantity > 0 {
+			item.ReadyCost = cost / item.Quantity
+		}
+		return item, notifications, nil
 	}
 
 	for _, component := range item.Materials {
+
+		var material models.Material
+		err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{"id": component.Material.Id}).Decode(&material)
 		if err != nil {
-			return notifications, err
+			return item, notifications, err
 		}
 
-		material_available_amount, err := cs.GetMaterialEntryAvailability(component.Material.Id, component.Entry.Id)
+		parts, component_notifications, err := cs.componentConsumption(material, component, component.Quantity*item.Quantity, ref)
+		notifications = append(notifications, component_notifications...)
 		if err != nil {
-			return notifications, err
+			return item, notifications, err
 		}
 
-		if float64(material_available_amount) < (component.Quantity*item.Quantity) || material_available_amount < 0 {
+		for _, part := range parts {
+
+			movement := models.InventoryMovement{
+				Type:              models.MovementTypeConsumption,
+				MaterialId:        component.Material.Id,
+				EntryId:           part.EntryId,
+				Quantity:          -part.Quantity,
+				OrderId:           ref.OrderId,
+				ProductionBatchId: ref.ProductionBatchId,
+				RecipeId:          item.Product.Id,
+			}
+
+			// the weighted average method takes the quantity out at the average unit cost
+			if cs.CostingMethod() == models.CostingMethodWeightedAverage {
+				movement.UnitCost = materialAverageCost(material)
+			}
+
+			_, err = recordMovement(ctx, client, movement, true)
+			if err != nil {
+				return item, notifications, err
+			}
+
+			logs_data := bson.M{
+				"type":             "component_consume",
+				"date":             time.Now(),
+				"component_id":     component.Material.Id,
+				"quantity":         part.Quantity,
+				"entry_id":         part.EntryId,
+				"order_id":         ref.OrderId,
+				"recipe_id":        item.Product.Id,
+				"item_order_index": item_order_index,
+			}
+			if ref.ProductionBatchId != "" {
+				logs_data["production_batch_id"] = ref.ProductionBatchId
+			}
+			_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+			if err != nil {
+				return item, notifications, err
+			}
+		}
+
+		quantity, err := cs.GetComponentAvailability(component.Material.Id)
+		if err != nil {
+			return item, notifications, err
+		}
+
+		if float64(quantity) <= cs.Settings.Inventory.DefaultInventoryQuantityWarn {
 			notifications = append(notifications, models.WebsocketTopicServerMessage{
-				TopicName: "inventory_insufficient",
+				TopicName: "inventory_low",
 				Type:      "topic_message",
-				Severity:  "error",
-				Message:   fmt.Sprintf("Inventory for %s is insufficient, quantity requested by order_id: %s (display_id: %s) is %f, but entry %s only has %f", component.Material.Name, order.Id, order.DisplayId, (component.Quantity * item.Quantity), component.Entry.Id, float64(material_available_amount)),
-				Key:       fmt.Sprintf("inventory_insufficient@%s", component.Material.Id),
+				Severity:  "warn",
+				Message:   fmt.Sprintf("Inventory for %s is low: %f", component.Material.Name, float64(quantity)),
+				Key:       fmt.Sprintf("low_inventiry@%s", component.Material.Id),
 			})
-			return notifications, fmt.Errorf("entry %s is insufficient", component.Material.Id)
 		}
 
-		filter := bson.M{"id": component.Material.Id, "entries.id": component.Entry.Id}
-		// Define the update operation
-		update := bson.M{
-			"$inc": bson.M{
-				"entries.$.quantity": -component.Quantity * item.Quantity,
-			},
-		}
+	}
+
+	// copy the sub items so the caller's item isn't changed
+	sub_items := make([]models.OrderItem, len(item.SubItems))
+	copy(sub_items, item.SubItems)
+	item.SubItems = sub_items
 
-		_, err = client.Database("waha").Collection("materials").UpdateOne(context.Background(), filter, update)
+	for index, subrecipe := range item.SubItems {
+
+		consumed_subrecipe, sub_notifications, err := cs.consumeItemComponents(subrecipe, ref, item_order_index)
+		notifications = append(notifications, sub_notifications...)
 		if err != nil {
-			return notifications, err
+			return item, notifications, err
 		}
 
-		logs_data := bson.M{
-			"type":             "component_consume",
-			"date":             time.Now(),
-			"component_id":     component.Material.Id,
-			"quantity":         component.Quantity * item.Quantity,
-			"entry_id":         component.Entry.Id,
-			"order_id":         order.Id,
-			"recipe_id":        item.Product.Id,
-			"item_order_index": item_order_index,
+		item.SubItems[index] = consumed_subrecipe
+	}
+
+	return item, notifications, nil
+}
+
+// recipeUnitCost returns the cost of a unit of a ready item made from its components, it's the cost of the
+// ready stock that isn't backed by a production batch. The item is costed from the components it carries,
+// or from the recipe of its product when it has none.
+func (cs *MaterialService) recipeUnitCost(ctx context.Context, client *mongo.Client, item models.OrderItem) (float64, error) {
+
+	item.IsConsumeFromReady = false
+	item.ReadyCost = 0
+
+	if len(item.Materials) == 0 && len(item.SubItems) == 0 {
+		recipe_item, err := recipeItem(ctx, client, item.Product.Id, 0)
+		if err != nil {
+			return 0, err
 		}
-		_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+
+		item.Materials = recipe_item.Materials
+		item.SubItems = recipe_item.SubItems
+	}
+
+	order_service := OrderService{
+		Logger:   cs.Logger,
+		Config:   cs.Config,
+		Settings: cs.Settings,
+	}
+
+	items_cost, err := order_service.CalculateCost([]models.OrderItem{item})
+	if err != nil || len(items_cost) == 0 {
+		return 0, err
+	}
+
+	return items_cost[0].Cost, nil
+}
+
+// recipeItem builds a unit of an item from the recipe of a product with the net quantities of its components,
+// the materials are taken from the entries they are priced at when no entry is chosen. The materials
+// without entries are left out.
+func recipeItem(ctx context.Context, client *mongo.Client, product_id string, depth int) (item models.OrderItem, err error) {
+
+	if depth > maxRecipeDepth {
+		return item, fmt.Errorf("%w: %s is nested deeper than %d levels", customerrors.ErrInvalidRecipe, product_id, maxRecipeDepth)
+	}
+
+	var recipe models.Product
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&recipe)
+	if err != nil {
+		return item, err
+	}
+
+	item = models.OrderItem{
+		Product:   models.Product{Id: recipe.Id, Name: recipe.Name},
+		Quantity:  1,
+		Materials: make([]models.OrderItemMaterial, 0),
+		SubItems:  make([]models.OrderItem, 0),
+	}
+
+	for _, recipe_material := range recipe.Materials {
+
+		var material models.Material
+		err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{"id": recipe_material.Id}).Decode(&material)
 		if err != nil {
-			return notifications, err
+			return item, err
 		}
 
-		quantity, err := cs.GetComponentAvailability(component.Material.Id)
+		if len(material.Entries) == 0 {
+			continue
+		}
+
+		item.Materials = append(item.Materials, models.OrderItemMaterial{
+			Material: models.Material{Id: material.Id, Name: material.Name, Unit: material.Unit},
+			Entry:    referenceEntry(material),
+			Quantity: recipe_material.Quantity,
+		})
+	}
+
+	for _, recipe_sub_product := range recipe.SubProducts {
+
+		sub_item, err := recipeItem(ctx, client, recipe_sub_product.Id, depth+1)
 		if err != nil {
-			return notifications, err
+			return item, err
 		}
 
-		if float64(quantity) <= cs.Settings.Inventory.DefaultInventoryQuantityWarn {
+		sub_item.Quantity = recipe_sub_product.Quantity
+		item.SubItems = append(item.SubItems, sub_item)
+	}
+
+	return item, nil
+}
+
+// consumptionPart is the quantity of a consumption taken from one of the entries of a material.
+type consumptionPart struct {
+	EntryId  string
+	Quantity float64
+}
+
+// componentConsumption splits the quantity of the component over the entries of the material it's taken from.
+// The fifo method takes it from the oldest usable entries so the stock left matches the cost recorded for it,
+// the rest of the methods take it from the entry chosen for the component.
+func (cs *MaterialService) componentConsumption(material models.Material, component models.OrderItemMaterial, quantity float64, ref consumptionRef) (parts []consumptionPart, notifications []models.WebsocketTopicServerMessage, err error) {
+
+	if cs.CostingMethod() == models.CostingMethodFIFO {
+		stocked := stockedEntries(material.Entries, time.Now())
+
+		parts, ok := fifoConsumption(stocked, quantity)
+		if !ok {
+			available := 0.0
+			for _, entry := range stocked {
+				available += float64(entry.Quantity)
+			}
+
 			notifications = append(notifications, models.WebsocketTopicServerMessage{
-				TopicName: "inventory_low",
+				TopicName: "inventory_insufficient",
 				Type:      "topic_message",
-				Severity:  "warn",
-				Message:   fmt.Sprintf("Inventory for %s is low: %f", component.Material.Name, float64(quantity)),
-				Key:       fmt.Sprintf("low_inventiry@%s", component.Material.Id),
+				Severity:  "error",
+				Message:   fmt.Sprintf("Inventory for %s is insufficient, quantity requested by %s is %f, but the usable entries only have %f", component.Material.Name, ref, quantity, available),
+				Key:       fmt.Sprintf("inventory_insufficient@%s", component.Material.Id),
 			})
+			return nil, notifications, fmt.Errorf("material %s is insufficient", component.Material.Id)
 		}
 
+		return parts, notifications, nil
 	}
 
-	for _, subrecipe := range item.SubItems {
-
-		sub_notifications, err := cs.ConsumeItemComponentsForOrder(subrecipe, order, item_order_index)
-		if err != nil {
-			return notifications, err
+	var entry *models.MaterialEntry
+	for index := range material.Entries {
+		if material.Entries[index].Id == component.Entry.Id {
+			entry = &material.Entries[index]
+			break
 		}
+	}
 
-		notifications = append(notifications, sub_notifications...)
+	if entry == nil {
+		return nil, notifications, fmt.Errorf("entry %s not found in material %s", component.Entry.Id, component.Material.Id)
+	}
+
+	if !entry.IsUsable(time.Now()) {
+		notifications = append(notifications, models.WebsocketTopicServerMessage{
+			TopicName: "entry_not_usable",
+			Type:      "topic_message",
+			Severity:  "error",
+			Message:   fmt.Sprintf("Entry %s of %s is quarantined or expired and can't be consumed by %s", component.Entry.Id, component.Material.Name, ref),
+			Key:       fmt.Sprintf("entry_not_usable@%s", component.Entry.Id),
+		})
+		return nil, notifications, customerrors.ErrEntryNotUsable
+	}
+
+	if float64(entry.Quantity) < quantity || entry.Quantity < 0 {
+		notifications = append(notifications, models.WebsocketTopicServerMessage{
+			TopicName: "inventory_insufficient",
+			Type:      "topic_message",
+			Severity:  "error",
+			Message:   fmt.Sprintf("Inventory for %s is insufficient, quantity requested by %s is %f, but entry %s only has %f", component.Material.Name, ref, quantity, component.Entry.Id, float64(entry.Quantity)),
+			Key:       fmt.Sprintf("inventory_insufficient@%s", component.Material.Id),
+		})
+		return nil, notifications, fmt.Errorf("entry %s is insufficient", component.Material.Id)
 	}
 
-	return notifications, nil
+	return []consumptionPart{{EntryId: entry.Id, Quantity: quantity}}, notifications, nil
 }
 
 // GetComponentAvailability retrieves the total quantity of a specific component.
@@ -277,8 +529,9 @@ func (cs *MaterialService) GetComponentAvailability(componentid string) (amount
 		return 0.0, err
 	}
 
+	now := time.Now()
 	for _, entry := range component.Entries {
-		if entry.Quantity > 0 {
+		if entry.Quantity > 0 && entry.IsUsable(now) {
 			amount += entry.Quantity
 		}
 	}
@@ -382,10 +635,58 @@ func (cs *MaterialService) EditMaterial(material_id string, material_to_edit mod
 		return err
 	}
 
-	existingMaterial.Settings.StockAlertTreshold = material_to_edit.Settings.StockAlertTreshold
+	err = validateMaterialNutrition(material_to_edit)
+	if err != nil {
+		return err
+	}
+
+	if material_to_edit.Settings.ExpiryWarningDays < 0 {
+		return fmt.Errorf("expiry warning days can't be negative")
+	}
+
+	// only the settings and the metadata are set, the entries are changed through the inventory movements
+	set := bson.M{
+		"settings.stock_alert_treshold": material_to_edit.Settings.StockAlertTreshold,
+		"settings.lead_time_days":       material_to_edit.Settings.LeadTimeDays,
+		"settings.safety_stock_days":    material_to_edit.Settings.SafetyStockDays,
+		"settings.expiry_warning_days":  material_to_edit.Settings.ExpiryWarningDays,
+	}
+
+	// the cleared nutrition fields are unset
+	unset := bson.M{}
+	if material_to_edit.Nutrition == nil {
+		unset["nutrition"] = ""
+	} else {
+		set["nutrition"] = material_to_edit.Nutrition
+	}
+	if len(material_to_edit.Allergens) == 0 {
+		unset["allergens"] = ""
+	} else {
+		set["allergens"] = material_to_edit.Allergens
+	}
+	if material_to_edit.Diet == "" {
+		unset["diet"] = ""
+	} else {
+		set["diet"] = material_to_edit.Diet
+	}
+	if material_to_edit.Description == "" {
+		unset["description"] = ""
+	} else {
+		set["description"] = material_to_edit.Description
+	}
+	if len(material_to_edit.Translations) == 0 {
+		unset["translations"] = ""
+	} else {
+		set["translations"] = material_to_edit.Translations
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
 
 	// Update the material
-	_, err = client.Database("waha").Collection("materials").UpdateOne(context.Background(), bson.M{"id": material_id}, bson.M{"$set": existingMaterial})
+	_, err = client.Database("waha").Collection("materials").UpdateOne(context.Background(), bson.M{"id": material_id}, update)
 	if err != nil {
 		cs.Logger.Error(err.Error())
 		return err
@@ -421,6 +722,23 @@ func (cs *MaterialService) AddComponent(material models.Material) error {
 	// Connected successfully
 	fmt.Println("Connected to MongoDB!")
 
+	err = validateMaterialNutrition(material)
+	if err != nil {
+		return err
+	}
+
+	received := make([]models.MaterialEntry, len(material.Entries))
+
+	// the entries are inserted empty, their quantities are received through the ledger
+	for index := range material.Entries {
+		if material.Entries[index].Id == "" {
+			material.Entries[index].Id = primitive.NewObjectID().Hex()
+		}
+		material.Entries[index].ReceivedAt = time.Now()
+		received[index] = material.Entries[index]
+		material.Entries[index].Quantity = 0
+	}
+
 	// Insert the DBComponent struct into the "materials" collection
 	collection := client.Database("waha").Collection("materials")
 	_, err = collection.InsertOne(ctx, material)
@@ -429,7 +747,15 @@ func (cs *MaterialService) AddComponent(material models.Material) error {
 		return err
 	}
 
-	for _, entry := range material.Entries {
+	for _, entry := range received {
+
+		if entry.Quantity > 0 {
+			_, err = recordMovement(ctx, client, receiptMovement(material.Id, entry), true)
+			if err != nil {
+				cs.Logger.Error(err.Error())
+				return err
+			}
+		}
 
 		logs_data := bson.M{
 			"type":     "component_add",
@@ -480,22 +806,35 @@ func (cs *MaterialService) PushMaterialEntry(componentId string, entries []model
 
 	for _, entry := range entries {
 
+		entry.Id = primitive.NewObjectID().Hex()
 		entry.PurchaseQuantity = entry.Quantity
+		entry.ReceivedAt = time.Now()
 
+		// the entry is pushed empty, its quantity is received through the ledger
 		entry_data := bson.M{
-			"id":                primitive.NewObjectID().Hex(),
+			"id":                entry.Id,
 			"purchase_quantity": entry.PurchaseQuantity,
 			"price":             entry.PurchasePrice,
-			"quantity":          entry.Quantity,
+			"quantity":          0,
 			"company":           entry.Company,
 			"sku":               entry.SKU,
 			"expiration_date":   entry.ExpirationDate,
+			"received_at":       entry.ReceivedAt,
 		}
 
 		update := bson.M{"$push": bson.M{"entries": entry_data}}
 		opts := options.Update().SetUpsert(false)
 
-		_, err = client.Database("waha").Collection("materials").UpdateOne(ctx, filter, update, opts)
+		result, err := client.Database("waha").Collection("materials").UpdateOne(ctx, filter, update, opts)
+		if err != nil {
+			return err
+		}
+
+		if result.MatchedCount == 0 || entry.Quantity <= 0 {
+			continue
+		}
+
+		_, err = recordMovement(ctx, client, receiptMovement(componentId, entry), true)
 		if err != nil {
 			return err
 		}
@@ -535,6 +874,21 @@ func (cs *MaterialService) DeleteEntry(entryid string, componentid string) error
 	// Connect to the database
 	collection := client.Database("waha").Collection("materials")
 
+	// the remaining quantity of the entry is written off in the ledger before it's removed
+	entry, err := cs.GetMaterialEntry(componentid, entryid)
+	if err == nil && entry.Quantity != 0 {
+		_, err = recordMovement(ctx, client, models.InventoryMovement{
+			Type:       models.MovementTypeAdjustment,
+			MaterialId: componentid,
+			EntryId:    entryid,
+			Quantity:   -float64(entry.Quantity),
+			Reason:     "entry deleted",
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Find the component document and update the entries array
 	filter := bson.M{"id": componentid}
 	update := bson.M{"$pull": bson.M{"entries": bson.M{"id": entryid}}}
@@ -545,3 +899,180 @@ func (cs *MaterialService) DeleteEntry(entryid string, componentid string) error
 
 	return nil
 }
+
+// GetQuarantinedEntries retrieves all the quarantined material entries that are awaiting review.
+func (cs *MaterialService) GetQuarantinedEntries() (entries []dto.QuarantinedEntry, err error) {
+
+	entries = make([]dto.QuarantinedEntry, 0)
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	// Create a context with a timeout (optional)
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	// Connect to MongoDB
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return entries, err
+	}
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{"entries.is_quarantined": true})
+	if err != nil {
+		return entries, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var material models.Material
+		if err := cursor.Decode(&material); err != nil {
+			return entries, err
+		}
+
+		for _, entry := range material.Entries {
+			if !entry.IsQuarantined {
+				continue
+			}
+
+			entries = append(entries, dto.QuarantinedEntry{
+				MaterialId:   material.Id,
+				MaterialName: material.Name,
+				Unit:         material.Unit,
+				Entry:        entry,
+			})
+		}
+	}
+
+	return entries, cursor.Err()
+}
+
+// WasteEntry marks a quarantined entry as wasted, its remaining quantity is written off
+// and logged as a component_waste log, and the entry is released from the quarantine.
+func (cs *MaterialService) WasteEntry(material_id string, entry_id string, reason string) error {
+
+	entry, err := cs.GetMaterialEntry(material_id, entry_id)
+	if err != nil {
+		return err
+	}
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	// Create a context with a timeout (optional)
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	// Connect to MongoDB
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+
+	// the entry is released first, so it's only wasted once
+	result, err := client.Database("waha").Collection("materials").UpdateOne(
+		ctx,
+		bson.M{"id": material_id, "entries": bson.M{"$elemMatch": bson.M{"id": entry_id, "is_quarantined": true}}},
+		bson.M{"$set": bson.M{
+			"entries.$.is_quarantined": false,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: entry %s of material %s", customerrors.ErrEntryNotQuarantined, entry_id, material_id)
+	}
+
+	if entry.Quantity > 0 {
+		_, err = recordMovement(ctx, client, models.InventoryMovement{
+			Type:       models.MovementTypeWaste,
+			MaterialId: material_id,
+			EntryId:    entry_id,
+			Quantity:   -float64(entry.Quantity),
+			Reason:     reason,
+		}, true)
+		if err != nil {
+			client.Database("waha").Collection("materials").UpdateOne(
+				ctx,
+				bson.M{"id": material_id, "entries.id": entry_id},
+				bson.M{"$set": bson.M{"entries.$.is_quarantined": true}},
+			)
+			return err
+		}
+	}
+
+	cost := 0.0
+	if entry.PurchaseQuantity > 0 {
+		cost = entry.PurchasePrice / float64(entry.PurchaseQuantity) * float64(entry.Quantity)
+	}
+
+	logs_data := bson.M{
+		"type":         "component_waste",
+		"date":         time.Now(),
+		"component_id": material_id,
+		"entry_id":     entry_id,
+		"quantity":     entry.Quantity,
+		"cost":         cost,
+		"reason":       reason,
+	}
+	_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExtendEntryExpiry sets a new expiration date for an entry and releases it from the quarantine,
+// the sent expiry alerts are reset so that the entry is notified again when it reaches the new date.
+func (cs *MaterialService) ExtendEntryExpiry(material_id string, entry_id string, expiration_date time.Time) error {
+
+	if !expiration_date.After(time.Now()) {
+		return fmt.Errorf("expiration date must be in the future")
+	}
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	// Create a context with a timeout (optional)
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	// Connect to MongoDB
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return err
+	}
+
+	result, err := client.Database("waha").Collection("materials").UpdateOne(
+		ctx,
+		bson.M{"id": material_id, "entries.id": entry_id},
+		bson.M{"$set": bson.M{
+			"entries.$.expiration_date": expiration_date,
+			"entries.$.is_quarantined":  false,
+			"entries.$.expiry_alerts":   []string{},
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("entry %s not found in material %s", entry_id, material_id)
+	}
+
+	InvalidateAvailabilityCache()
+
+	logs_data := bson.M{
+		"type":            "entry_expiry_extend",
+		"date":            time.Now(),
+		"component_id":    material_id,
+		"entry_id":        entry_id,
+		"expiration_date": expiration_date,
+	}
+	_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
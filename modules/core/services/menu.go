@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbroglie/mustache"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// Public menu formats.
+const (
+	MenuFormatJSON = "json"
+	MenuFormatHTML = "html"
+	MenuFormatPDF  = "pdf"
+)
+
+// menuLabels are the language pack keys of the labels shown on the public menu.
+var menuLabels = []string{"menu", "price", "sold_out", "not_on_menu", "allergens", "energy", "category", "egp"}
+
+// maxRenderedMenus is the number of rendered menus kept in memory, the cache is cleared when it's exceeded.
+const maxRenderedMenus = 16
+
+var (
+	rendered_menus    = make(map[string][]byte)
+	rendered_menus_mu sync.Mutex
+)
+
+// MenuService provides the public menu shown to the guests on the QR menus and the menu boards.
+type MenuService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// PublicMenuParams are the options of the public menu.
+type PublicMenuParams struct {
+	// PriceListId is the price list the menu is priced with, it takes precedence over the service style.
+	PriceListId  string
+	ServiceStyle string
+	LangCode     string
+	// AssetBaseURL is prefixed to the relative image urls, e.g. https://pos.example.com
+	AssetBaseURL string
+}
+
+// absoluteAssetURL makes the url of a stored image absolute, the bare keys are served under /public/.
+func absoluteAssetURL(base string, url string) string {
+
+	if url == "" || strings.Contains(url, "://") {
+		return url
+	}
+
+	base = strings.TrimRight(base, "/")
+
+	if strings.HasPrefix(url, "/") {
+		return base + url
+	}
+
+	return base + "/public/" + url
+}
+
+// GetPublicMenu returns the categories of the menu with their products, priced for the price list or the
+// service style and translated to the language. A product is available when it's on the menu at the moment
+// and at least a single unit of it is ready or can be made from the stock.
+func (ms *MenuService) GetPublicMenu(params PublicMenuParams) (menu dto.PublicMenu, err error) {
+
+	switch params.ServiceStyle {
+	case "", models.ServiceStyleDineIn, models.ServiceStyleTakeAway, models.ServiceStyleDelivery:
+	default:
+		return menu, fmt.Errorf("%w: unknown service style %s", customerrors.ErrInvalidPriceList, params.ServiceStyle)
+	}
+
+	lang_svc := LanguageService{
+		Config:   ms.Config,
+		Settings: ms.Settings,
+		Logger:   ms.Logger,
+	}
+
+	lang, err := lang_svc.GetLanguage(params.LangCode)
+	if err != nil {
+		return menu, err
+	}
+
+	menu.Language = lang.Code
+	menu.Direction = lang.Orientation
+	if menu.Direction == "" {
+		menu.Direction = "ltr"
+	}
+
+	menu.Labels = make(map[string]string)
+	for _, key := range menuLabels {
+		if label, ok := lang.Pack[key].(string); ok {
+			menu.Labels[key] = label
+		}
+	}
+
+	client, ctx, cancel, err := connectDB(ms.Config)
+	if err != nil {
+		return menu, err
+	}
+	defer cancel()
+	// connected to db
+
+	price_list_id, err := resolveOrderPriceList(ctx, client, models.Order{
+		PriceListId: params.PriceListId,
+		IsDelivery:  params.ServiceStyle == models.ServiceStyleDelivery,
+		IsTakeAway:  params.ServiceStyle == models.ServiceStyleTakeAway,
+		IsDineIn:    params.ServiceStyle == models.ServiceStyleDineIn,
+	})
+	if err != nil {
+		return menu, err
+	}
+
+	menu.PriceListId = price_list_id
+	menu.ServiceStyle = params.ServiceStyle
+
+	prices, err := priceListPrices(ctx, client, price_list_id)
+	if err != nil {
+		return menu, err
+	}
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, channelFilter(params.ServiceStyle))
+	if err != nil {
+		return menu, err
+	}
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return menu, err
+	}
+
+	err = resolveCategoryProducts(ctx, client, categories)
+	if err != nil {
+		return menu, err
+	}
+
+	// the subcategories follow their parents, the ones of the categories hidden on the service style are left out
+	categories = flattenCategoryTree(categoryTree(categories))
+
+	localizer := NewCatalogLocalizer(lang.Code, ms.Settings)
+	localizer.LocalizeCategories(categories)
+
+	product_ids := []string{}
+	products := []models.Product{}
+	products_by_id := make(map[string]models.Product)
+	for _, category := range categories {
+		for _, product := range category.Products {
+			if _, ok := products_by_id[product.Id]; ok {
+				continue
+			}
+
+			products_by_id[product.Id] = product
+			product_ids = append(product_ids, product.Id)
+			products = append(products, product)
+		}
+	}
+
+	rs := RecipeService{Logger: ms.Logger, Config: ms.Config}
+
+	stock := make(map[string]bool)
+	if len(products) > 0 {
+		availabilities, err := rs.CheckRecipesAvailability(product_ids)
+		if err != nil {
+			return menu, err
+		}
+
+		for _, availability := range availabilities {
+			// the available units include the ready stock, a product with no components can only be served from it
+			stock[availability.RecipeId] = availability.Available >= 1
+		}
+	}
+
+	product_categories, err := loadProductCategories(ctx, client)
+	if err != nil {
+		return menu, err
+	}
+
+	now := time.Now().In(menuLocation(ms.Config))
+	calculator := newNutritionCalculator(ctx, client)
+
+	menu.Categories = []dto.PublicMenuCategory{}
+
+	for _, category := range categories {
+
+		menu_category := dto.PublicMenuCategory{
+			Id:          category.Id,
+			ParentId:    category.ParentId,
+			Name:        category.Name,
+			Description: category.Description,
+			Products:    []dto.PublicMenuProduct{},
+		}
+
+		for _, product := range category.Products {
+
+			menu_product := dto.PublicMenuProduct{
+				Id:          product.Id,
+				Name:        product.Name,
+				Description: product.Description,
+				Price:       product.Price,
+				ImageURL:    absoluteAssetURL(params.AssetBaseURL, product.ImageURL),
+				Images:      []dto.PublicMenuImage{},
+				Allergens:   []string{},
+				DietaryTags: []string{},
+			}
+
+			if price, ok := prices[product.Id]; ok {
+				menu_product.Price = price
+			}
+
+			for _, image := range product.Images {
+				menu_image := dto.PublicMenuImage{
+					URL:          absoluteAssetURL(params.AssetBaseURL, image.URL),
+					ThumbnailURL: absoluteAssetURL(params.AssetBaseURL, image.ThumbnailURL),
+				}
+
+				if image.IsPrimary {
+					menu_product.ThumbnailURL = menu_image.ThumbnailURL
+				}
+
+				menu_product.Images = append(menu_product.Images, menu_image)
+			}
+
+			availability := menuAvailability(product, product_categories[product.Id], now)
+			menu_product.IsOnMenu = availability.IsOnMenu
+			menu_product.Reason = availability.Reason
+
+			if availability.IsOnMenu {
+				menu_product.IsAvailable = stock[product.Id]
+				if !menu_product.IsAvailable {
+					menu_product.Reason = models.MenuUnavailableSoldOut
+				}
+			}
+
+			nutrition, err := calculator.product(product, 0)
+			if err != nil {
+				return menu, err
+			}
+
+			if nutrition.Allergens != nil {
+				menu_product.Allergens = nutrition.Allergens
+			}
+			if nutrition.DietaryTags != nil {
+				menu_product.DietaryTags = nutrition.DietaryTags
+			}
+			if nutrition.IsComplete {
+				energy := nutrition.Facts.EnergyKcal
+				menu_product.EnergyKcal = &energy
+			}
+
+			menu_category.Products = append(menu_category.Products, menu_product)
+		}
+
+		menu.Categories = append(menu.Categories, menu_category)
+	}
+
+	return menu, nil
+}
+
+// MenuETag returns the entity tag of the menu in the format, it changes whenever anything shown on the menu does.
+func MenuETag(menu dto.PublicMenu, format string) (string, error) {
+
+	data, err := json.Marshal(menu)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+	hash.Write([]byte(format))
+
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil))[:32]), nil
+}
+
+// RenderMenu renders the menu as an html page or a pdf document. The rendered menus are cached by their entity tag,
+// so the menu is rendered again only when it changes.
+func (ms *MenuService) RenderMenu(menu dto.PublicMenu, format string, etag string) ([]byte, error) {
+
+	cache_key := etag + format
+
+	rendered_menus_mu.Lock()
+	cached, ok := rendered_menus[cache_key]
+	rendered_menus_mu.Unlock()
+
+	if ok {
+		return cached, nil
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []map[string]interface{}{}
+	for _, category := range menu.Categories {
+
+		products := []map[string]interface{}{}
+		for _, product := range category.Products {
+
+			item := map[string]interface{}{
+				"name":         product.Name,
+				"description":  product.Description,
+				"price":        fmt.Sprintf("%.2f", product.Price),
+				"image_url":    product.ThumbnailURL,
+				"is_available": product.IsAvailable,
+				"allergens":    strings.Join(product.Allergens, ", "),
+			}
+
+			if item["image_url"] == "" {
+				item["image_url"] = product.ImageURL
+			}
+
+			if product.EnergyKcal != nil {
+				item["energy_kcal"] = fmt.Sprintf("%.0f", *product.EnergyKcal)
+			}
+
+			if product.Reason == models.MenuUnavailableSoldOut {
+				item["unavailable_label"] = menu.Labels["sold_out"]
+			} else if !product.IsOnMenu {
+				item["unavailable_label"] = menu.Labels["not_on_menu"]
+			}
+
+			products = append(products, item)
+		}
+
+		categories = append(categories, map[string]interface{}{"name": category.Name, "products": products})
+	}
+
+	data := map[string]interface{}{
+		"direction":   menu.Direction,
+		"t_menu":      menu.Labels["menu"],
+		"t_allergens": menu.Labels["allergens"],
+		"t_energy":    menu.Labels["energy"],
+		"t_currency":  menu.Labels["egp"],
+		"categories":  categories,
+	}
+
+	output, err := mustache.RenderFile(pwd+"/modules/core/templates/menu_0.mustache", data)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := []byte(output)
+
+	if format == MenuFormatPDF {
+		rendered, err = printPDF(output)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rendered_menus_mu.Lock()
+	if len(rendered_menus) >= maxRenderedMenus {
+		rendered_menus = make(map[string][]byte)
+	}
+	rendered_menus[cache_key] = rendered
+	rendered_menus_mu.Unlock()
+
+	return rendered, nil
+}
+
+// printPDF prints the html page to a pdf document with chrome.
+func printPDF(html string) ([]byte, error) {
+
+	ctx, cancel := chromedp.NewContext(context.Background())
+	defer cancel()
+
+	ctx, timeout_cancel := context.WithTimeout(ctx, time.Minute)
+	defer timeout_cancel()
+
+	uri := "data:text/html;base64," + base64.StdEncoding.EncodeToString([]byte(html))
+
+	var buf []byte
+	err := chromedp.Run(ctx,
+		chromedp.Navigate(uri),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			buf, _, err = page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+			return err
+		}),
+	)
+
+	return buf, err
+}
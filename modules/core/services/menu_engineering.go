@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// menuPopularityFactor is the share of an even menu mix a product needs to be popular, 70% in the classic analysis.
+const menuPopularityFactor = 0.7
+
+// productSales holds the sales of a product over a period.
+type productSales struct {
+	name    string
+	units   float64
+	revenue float64
+	cost    float64
+}
+
+// salesByProduct sums the units sold, the revenue and the cost of each product from the sales days within the dates.
+// The sale price and the cost of an item are for a single unit, they are multiplied by its quantity.
+func salesByProduct(ctx context.Context, client *mongo.Client, from time.Time, to time.Time) (sales map[string]*productSales, err error) {
+
+	sales = make(map[string]*productSales)
+
+	cursor, err := client.Database("waha").Collection("sales").Find(ctx, bson.M{
+		"date": bson.M{"$gte": from.Format("2006-01-02"), "$lte": to.Format("2006-01-02")},
+	})
+	if err != nil {
+		return sales, err
+	}
+
+	var days []models.SalesPerDay
+	if err := cursor.All(ctx, &days); err != nil {
+		return sales, err
+	}
+
+	for _, day := range days {
+		for _, sales_order := range day.Orders {
+			for index, item_cost := range sales_order.Costs {
+
+				units := 1.0
+				if index < len(sales_order.Order.Items) && sales_order.Order.Items[index].Quantity > 0 {
+					units = sales_order.Order.Items[index].Quantity
+				}
+
+				product, ok := sales[item_cost.RecipeId]
+				if !ok {
+					product = &productSales{name: item_cost.ItemName}
+					sales[item_cost.RecipeId] = product
+				}
+
+				product.units += units
+				product.revenue += item_cost.SalePrice * units
+				product.cost += item_cost.Cost * units
+			}
+		}
+	}
+
+	return sales, nil
+}
+
+// classifyMenu computes the stats of the products of a category from their sales and classifies them,
+// it returns the stats of each product and the popularity and margin thresholds of the category.
+func classifyMenu(product_ids []string, sales map[string]*productSales) (stats map[string]dto.MenuPeriodStats, popularity_threshold float64, margin_threshold float64) {
+
+	stats = make(map[string]dto.MenuPeriodStats)
+
+	total_units, total_margin := 0.0, 0.0
+	for _, product_id := range product_ids {
+		if product, ok := sales[product_id]; ok {
+			total_units += product.units
+			total_margin += product.revenue - product.cost
+		}
+	}
+
+	if len(product_ids) > 0 {
+		popularity_threshold = 100 / float64(len(product_ids)) * menuPopularityFactor
+	}
+
+	if total_units > 0 {
+		margin_threshold = total_margin / total_units
+	}
+
+	for _, product_id := range product_ids {
+
+		product_stats := dto.MenuPeriodStats{}
+
+		if product, ok := sales[product_id]; ok {
+			product_stats.UnitsSold = product.units
+			product_stats.Revenue = product.revenue
+			product_stats.Cost = product.cost
+			product_stats.TotalMargin = product.revenue - product.cost
+			if product.units > 0 {
+				product_stats.UnitMargin = product_stats.TotalMargin / product.units
+			}
+			if total_units > 0 {
+				product_stats.MenuMix = product.units / total_units * 100
+			}
+		}
+
+		is_popular := product_stats.UnitsSold > 0 && product_stats.MenuMix >= popularity_threshold
+		is_profitable := product_stats.UnitsSold > 0 && product_stats.UnitMargin >= margin_threshold
+
+		switch {
+		case is_popular && is_profitable:
+			product_stats.Class = dto.MenuClassStar
+		case is_popular:
+			product_stats.Class = dto.MenuClassPlowhorse
+		case is_profitable:
+			product_stats.Class = dto.MenuClassPuzzle
+		default:
+			product_stats.Class = dto.MenuClassDog
+		}
+
+		stats[product_id] = product_stats
+	}
+
+	return stats, popularity_threshold, margin_threshold
+}
+
+// GetMenuEngineering classifies the products of each category as stars, plowhorses, puzzles or dogs by their
+// popularity (units sold) and contribution margin (sale price - cost) from the sales between the dates.
+// The products sold outside of any category are grouped as uncategorized. Each product is compared to
+// the previous period of the same number of days to show the trend.
+//
+// The sales are kept by day, so the periods start at the beginning of the day of from in the menu time zone.
+func (ss *SalesService) GetMenuEngineering(from time.Time, to time.Time) (report dto.MenuEngineeringReport, err error) {
+
+	client, ctx, cancel, err := connectDB(ss.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	loc := menuLocation(ss.Config)
+	from, to = from.In(loc), to.In(loc)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+
+	// the days are rounded so the daylight saving changes don't shorten the period
+	days := int(math.Round(time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, loc).Sub(from).Hours()/24)) + 1
+
+	report = dto.MenuEngineeringReport{
+		From:         from,
+		To:           to,
+		PreviousFrom: from.AddDate(0, 0, -days),
+		PreviousTo:   from.Add(-time.Nanosecond),
+		Categories:   make([]dto.MenuEngineeringCategory, 0),
+	}
+
+	current, err := salesByProduct(ctx, client, report.From, report.To)
+	if err != nil {
+		return report, err
+	}
+
+	previous, err := salesByProduct(ctx, client, report.PreviousFrom, report.PreviousTo)
+	if err != nil {
+		return report, err
+	}
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1, "name": 1}))
+	if err != nil {
+		return report, err
+	}
+
+	var recipes []models.Product
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return report, err
+	}
+
+	names := make(map[string]string)
+	for _, recipe := range recipes {
+		names[recipe.Id] = recipe.Name
+	}
+
+	cursor, err = client.Database("waha").Collection("categories").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return report, err
+	}
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return report, err
+	}
+
+	categorized := make(map[string]bool)
+	for _, category := range categories {
+		for _, ref := range category.ProductRefs {
+			categorized[ref.ProductId] = true
+		}
+	}
+
+	uncategorized_ids := make([]string, 0)
+	for product_id := range current {
+		if !categorized[product_id] {
+			uncategorized_ids = append(uncategorized_ids, product_id)
+		}
+	}
+	sort.Strings(uncategorized_ids)
+
+	uncategorized := models.Category{Name: "uncategorized"}
+	for _, product_id := range uncategorized_ids {
+		uncategorized.ProductRefs = append(uncategorized.ProductRefs, models.CategoryProduct{ProductId: product_id})
+	}
+	if len(uncategorized.ProductRefs) > 0 {
+		categories = append(categories, uncategorized)
+	}
+
+	for _, category := range categories {
+
+		product_ids := make([]string, 0, len(category.ProductRefs))
+		for _, ref := range category.ProductRefs {
+			product_ids = append(product_ids, ref.ProductId)
+		}
+
+		current_stats, popularity_threshold, margin_threshold := classifyMenu(product_ids, current)
+		previous_stats, _, _ := classifyMenu(product_ids, previous)
+
+		category_report := dto.MenuEngineeringCategory{
+			CategoryId:          category.Id,
+			CategoryName:        category.Name,
+			PopularityThreshold: popularity_threshold,
+			MarginThreshold:     margin_threshold,
+			Items:               make([]dto.MenuEngineeringItem, 0, len(product_ids)),
+		}
+
+		for _, product_id := range product_ids {
+
+			name, ok := names[product_id]
+			if !ok && current[product_id] != nil {
+				name = current[product_id].name
+			}
+
+			item := dto.MenuEngineeringItem{
+				ProductId:        product_id,
+				ProductName:      name,
+				Current:          current_stats[product_id],
+				Previous:         previous_stats[product_id],
+				UnitsSoldChange:  current_stats[product_id].UnitsSold - previous_stats[product_id].UnitsSold,
+				UnitMarginChange: current_stats[product_id].UnitMargin - previous_stats[product_id].UnitMargin,
+				IsClassChanged:   current_stats[product_id].Class != previous_stats[product_id].Class,
+			}
+
+			category_report.UnitsSold += item.Current.UnitsSold
+			category_report.TotalMargin += item.Current.TotalMargin
+
+			switch item.Current.Class {
+			case dto.MenuClassStar:
+				category_report.Stars++
+			case dto.MenuClassPlowhorse:
+				category_report.Plowhorses++
+			case dto.MenuClassPuzzle:
+				category_report.Puzzles++
+			default:
+				category_report.Dogs++
+			}
+
+			category_report.Items = append(category_report.Items, item)
+		}
+
+		sort.SliceStable(category_report.Items, func(i, j int) bool {
+			return category_report.Items[i].Current.TotalMargin > category_report.Items[j].Current.TotalMargin
+		})
+
+		report.Categories = append(report.Categories, category_report)
+	}
+
+	return report, nil
+}
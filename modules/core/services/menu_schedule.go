@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// menuLocation returns the configured time zone the menu schedules are in, or UTC if it's not set or invalid.
+func menuLocation(conf config.Config) *time.Location {
+	loc, err := time.LoadLocation(conf.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// parseClock returns the minutes since midnight of a 15:04 time.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateSchedule checks that the windows have valid weekdays and 15:04 times.
+func validateSchedule(windows []models.AvailabilityWindow) error {
+
+	for _, window := range windows {
+
+		for _, weekday := range window.Weekdays {
+			if weekday < time.Sunday || weekday > time.Saturday {
+				return fmt.Errorf("%w: weekday %d is out of the 0 to 6 range", customerrors.ErrInvalidSchedule, weekday)
+			}
+		}
+
+		from, err := parseClock(window.From)
+		if err != nil {
+			return fmt.Errorf("%w: from %q isn't a 15:04 time", customerrors.ErrInvalidSchedule, window.From)
+		}
+
+		to, err := parseClock(window.To)
+		if err != nil {
+			return fmt.Errorf("%w: to %q isn't a 15:04 time", customerrors.ErrInvalidSchedule, window.To)
+		}
+
+		if from == to {
+			return fmt.Errorf("%w: window from %s to %s is empty", customerrors.ErrInvalidSchedule, window.From, window.To)
+		}
+	}
+
+	return nil
+}
+
+// opensOn tells whether the window opens on the weekday, it opens every day when it has no weekdays.
+func opensOn(window models.AvailabilityWindow, weekday time.Weekday) bool {
+
+	if len(window.Weekdays) == 0 {
+		return true
+	}
+
+	for _, day := range window.Weekdays {
+		if day == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInSchedule tells whether the time falls in one of the windows, the time must be in the menu location.
+// An empty schedule is always open, the invalid windows are skipped.
+func isInSchedule(windows []models.AvailabilityWindow, at time.Time) bool {
+
+	if len(windows) == 0 {
+		return true
+	}
+
+	minutes := at.Hour()*60 + at.Minute()
+
+	for _, window := range windows {
+
+		from, err := parseClock(window.From)
+		if err != nil {
+			continue
+		}
+
+		to, err := parseClock(window.To)
+		if err != nil {
+			continue
+		}
+
+		if from < to {
+			if opensOn(window, at.Weekday()) && minutes >= from && minutes < to {
+				return true
+			}
+			continue
+		}
+
+		// the window closes on the next day
+		if opensOn(window, at.Weekday()) && minutes >= from {
+			return true
+		}
+		if opensOn(window, (at.Weekday()+6)%7) && minutes < to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadProductCategories returns the categories of each product by product id.
+func loadProductCategories(ctx context.Context, client *mongo.Client) (map[string][]models.Category, error) {
+
+	product_categories := make(map[string][]models.Category)
+
+	cursor, err := client.Database("waha").Collection("categories").Find(ctx, bson.M{})
+	if err != nil {
+		return product_categories, err
+	}
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return product_categories, err
+	}
+
+	for _, category := range categories {
+		for _, ref := range category.ProductRefs {
+			product_categories[ref.ProductId] = append(product_categories[ref.ProductId], category)
+		}
+	}
+
+	return product_categories, nil
+}
+
+// menuAvailability tells whether the product can be ordered at the time. A product is off the menu when it's 86'd,
+// out of its own schedule, or when all of its categories are out of their schedules.
+func menuAvailability(product models.Product, categories []models.Category, at time.Time) models.MenuAvailability {
+
+	if product.IsEightySixed && (product.EightySixedUntil.IsZero() || product.EightySixedUntil.After(at)) {
+		return models.MenuAvailability{Reason: models.MenuUnavailableEightySixed}
+	}
+
+	if !isInSchedule(product.Schedule, at) {
+		return models.MenuAvailability{Reason: models.MenuUnavailableOutOfSchedule}
+	}
+
+	if len(categories) == 0 {
+		return models.MenuAvailability{IsOnMenu: true}
+	}
+
+	for _, category := range categories {
+		if isInSchedule(category.Schedule, at) {
+			return models.MenuAvailability{IsOnMenu: true}
+		}
+	}
+
+	return models.MenuAvailability{Reason: models.MenuUnavailableOutOfSchedule}
+}
+
+// checkItemsOnMenu checks that the products of the items can be ordered at the time,
+// the sub items are components of their items and aren't checked.
+func checkItemsOnMenu(ctx context.Context, client *mongo.Client, conf config.Config, items []models.OrderItem, at time.Time) error {
+
+	product_categories, err := loadProductCategories(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	at = at.In(menuLocation(conf))
+
+	for _, item := range items {
+
+		var product models.Product
+		err := client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": item.Product.Id}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		availability := menuAvailability(product, product_categories[product.Id], at)
+		if !availability.IsOnMenu {
+			return fmt.Errorf("%w: %s is %s", customerrors.ErrNotOnMenu, product.Name, availability.Reason)
+		}
+	}
+
+	return nil
+}
+
+// fillMenuAvailability sets whether each product can be ordered at the moment.
+func fillMenuAvailability(ctx context.Context, client *mongo.Client, conf config.Config, products []models.Product) error {
+
+	product_categories, err := loadProductCategories(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(menuLocation(conf))
+
+	for index, product := range products {
+		availability := menuAvailability(product, product_categories[product.Id], now)
+		products[index].MenuAvailability = &availability
+	}
+
+	return nil
+}
+
+// SetEightySixed 86's a product so it can't be ordered, or puts it back on the menu.
+// The product is put back on the menu automatically at until when it's set.
+func (rs *RecipeService) SetEightySixed(product_id string, is_eighty_sixed bool, until time.Time) (product models.Product, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product, err
+	}
+	defer cancel()
+	// connected to db
+
+	set := bson.M{"is_eighty_sixed": is_eighty_sixed}
+	update := bson.M{"$set": set}
+
+	if is_eighty_sixed && !until.IsZero() {
+		set["eighty_sixed_until"] = until
+	} else {
+		update["$unset"] = bson.M{"eighty_sixed_until": ""}
+	}
+
+	err = client.Database("waha").Collection("recipes").FindOneAndUpdate(
+		ctx,
+		bson.M{"id": product_id},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+
+	return product, err
+}
+
+// ResetEightySixed puts back on the menu the 86'd products whose reset time passed, and returns them.
+func (rs *RecipeService) ResetEightySixed(at time.Time) (products []models.Product, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return products, err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("recipes")
+
+	filter := bson.M{"is_eighty_sixed": true, "eighty_sixed_until": bson.M{"$lte": at}}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return products, err
+	}
+
+	if err := cursor.All(ctx, &products); err != nil {
+		return products, err
+	}
+
+	expired := products
+	products = make([]models.Product, 0, len(expired))
+
+	for _, product := range expired {
+		// the product is skipped when it's 86'd again since it was found
+		result, err := collection.UpdateOne(
+			ctx,
+			bson.M{"id": product.Id, "is_eighty_sixed": true, "eighty_sixed_until": bson.M{"$lte": at}},
+			bson.M{"$set": bson.M{"is_eighty_sixed": false}, "$unset": bson.M{"eighty_sixed_until": ""}},
+		)
+		if err != nil {
+			return products, err
+		}
+
+		if result.MatchedCount == 0 {
+			continue
+		}
+
+		product.IsEightySixed = false
+		product.EightySixedUntil = time.Time{}
+		products = append(products, product)
+	}
+
+	return products, nil
+}
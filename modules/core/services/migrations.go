@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MigrationService upgrades the documents saved by the older versions of nutrix to the current models.
+type MigrationService struct {
+	Logger logger.ILogger
+	Config config.Config
+}
+
+// Migrate runs all the migrations, it's safe to run it more than once.
+func (ms *MigrationService) Migrate() error {
+
+	migrated, err := ms.MigrateCategoryProducts()
+	if err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		ms.Logger.Info(fmt.Sprintf("migrated the products of %d categories to references", migrated))
+	}
+
+	migrated, err = ms.MigrateCustomerStats()
+	if err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		ms.Logger.Info(fmt.Sprintf("computed the stats of %d customers", migrated))
+	}
+
+	migrated, err = ms.MigrateMaterialAverageCosts()
+	if err != nil {
+		return err
+	}
+
+	if migrated > 0 {
+		ms.Logger.Info(fmt.Sprintf("computed the average cost of %d materials", migrated))
+	}
+
+	return nil
+}
+
+// MigrateMaterialAverageCosts starts the moving average unit cost of the materials saved before it was kept
+// at the average of their stocked entries. It returns the number of migrated materials.
+func (ms *MigrationService) MigrateMaterialAverageCosts() (migrated int, err error) {
+
+	client, ctx, cancel, err := connectDB(ms.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("materials")
+
+	cursor, err := collection.Find(ctx, bson.M{"average_cost": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	materials := []models.Material{}
+	err = cursor.All(ctx, &materials)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, material := range materials {
+		_, err = collection.UpdateOne(ctx, bson.M{"id": material.Id, "average_cost": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"average_cost": materialAverageCost(material)}})
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateCustomerStats computes the stats of the customers saved before the stats were kept, from their finished orders.
+// It returns the number of migrated customers.
+func (ms *MigrationService) MigrateCustomerStats() (migrated int, err error) {
+
+	client, ctx, cancel, err := connectDB(ms.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	// connected to db
+
+	customer_ids, err := client.Database("waha").Collection("customers").Distinct(ctx, "id", bson.M{"stats": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range customer_ids {
+		customer_id, ok := id.(string)
+		if !ok {
+			continue
+		}
+
+		_, err = refreshCustomerStats(ctx, client, customer_id)
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateCategoryProducts converts the copies of the products embedded in the categories to references, in the order
+// they're embedded in. The categories that already have references are skipped. It returns the number of migrated categories.
+func (ms *MigrationService) MigrateCategoryProducts() (migrated int, err error) {
+
+	client, ctx, cancel, err := connectDB(ms.Config)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("categories")
+
+	cursor, err := collection.Find(ctx, bson.M{"product_refs": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	var categories []struct {
+		Id       string `bson:"id"`
+		Products []struct {
+			Id string `bson:"id"`
+		} `bson:"products"`
+	}
+	if err := cursor.All(ctx, &categories); err != nil {
+		return 0, err
+	}
+
+	for _, category := range categories {
+
+		refs := make([]models.CategoryProduct, 0, len(category.Products))
+		included := make(map[string]bool)
+
+		for _, product := range category.Products {
+			if product.Id == "" || included[product.Id] {
+				continue
+			}
+			included[product.Id] = true
+
+			refs = append(refs, models.CategoryProduct{ProductId: product.Id, SortOrder: len(refs)})
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.M{"id": category.Id, "product_refs": bson.M{"$exists": false}}, bson.M{
+			"$set":   bson.M{"product_refs": refs},
+			"$unset": bson.M{"products": ""},
+		})
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
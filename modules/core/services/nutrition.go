@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateMaterialNutrition checks that the nutrition facts of the material aren't negative,
+// and that its allergens and diet are known.
+func validateMaterialNutrition(material models.Material) error {
+
+	if facts := material.Nutrition; facts != nil {
+		values := []float64{facts.EnergyKcal, facts.Protein, facts.Carbohydrates, facts.Sugars, facts.Fat, facts.SaturatedFat, facts.Fiber, facts.Sodium}
+		for _, value := range values {
+			if value < 0 {
+				return fmt.Errorf("%w: nutrition facts can't be negative", customerrors.ErrInvalidNutrition)
+			}
+		}
+	}
+
+	for _, allergen := range material.Allergens {
+		if !slices.Contains(models.Allergens, allergen) {
+			return fmt.Errorf("%w: unknown allergen %s", customerrors.ErrInvalidNutrition, allergen)
+		}
+	}
+
+	switch material.Diet {
+	case "", models.DietVegan, models.DietVegetarian:
+	default:
+		return fmt.Errorf("%w: unknown diet %s", customerrors.ErrInvalidNutrition, material.Diet)
+	}
+
+	return nil
+}
+
+// nutritionCalculator rolls the nutrition of the products up from their materials,
+// the materials and the computed products are kept so a shared sub product is computed once.
+type nutritionCalculator struct {
+	ctx       context.Context
+	client    *mongo.Client
+	materials map[string]*models.Material
+	products  map[string]models.ProductNutrition
+}
+
+func newNutritionCalculator(ctx context.Context, client *mongo.Client) *nutritionCalculator {
+	return &nutritionCalculator{
+		ctx:       ctx,
+		client:    client,
+		materials: make(map[string]*models.Material),
+		products:  make(map[string]models.ProductNutrition),
+	}
+}
+
+// material returns the material from the database, it's nil when the material doesn't exist.
+func (nc *nutritionCalculator) material(material_id string) (*models.Material, error) {
+
+	if material, ok := nc.materials[material_id]; ok {
+		return material, nil
+	}
+
+	var material models.Material
+	err := nc.client.Database("waha").Collection("materials").FindOne(nc.ctx, bson.M{"id": material_id}).Decode(&material)
+	if err == mongo.ErrNoDocuments {
+		nc.materials[material_id] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nc.materials[material_id] = &material
+
+	return &material, nil
+}
+
+// productById returns the nutrition of a single unit of the product with the id, ok is false when it doesn't exist.
+func (nc *nutritionCalculator) productById(product_id string, depth int) (nutrition models.ProductNutrition, ok bool, err error) {
+
+	if nutrition, ok := nc.products[product_id]; ok {
+		return nutrition, true, nil
+	}
+
+	var recipe models.Product
+	err = nc.client.Database("waha").Collection("recipes").FindOne(nc.ctx, bson.M{"id": product_id}).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return nutrition, false, nil
+	}
+	if err != nil {
+		return nutrition, false, err
+	}
+
+	nutrition, err = nc.product(recipe, depth)
+
+	return nutrition, err == nil, err
+}
+
+// product returns the nutrition of a single unit of the product from the net quantities of its recipe.
+// A product is vegan or vegetarian when all of its materials are, and free of an allergen when none of its
+// materials contain it. The dietary tags are left out when a material or a sub product of the recipe is missing,
+// or when the recipe has no components.
+func (nc *nutritionCalculator) product(recipe models.Product, depth int) (nutrition models.ProductNutrition, err error) {
+
+	if depth > maxRecipeDepth {
+		return nutrition, fmt.Errorf("%w: %s is nested deeper than %d levels", customerrors.ErrInvalidRecipe, recipe.Id, maxRecipeDepth)
+	}
+
+	if nutrition, ok := nc.products[recipe.Id]; ok {
+		return nutrition, nil
+	}
+
+	allergens := make(map[string]bool)
+	is_vegan, is_vegetarian, is_known := true, true, true
+	nutrition.IsComplete = true
+
+	// nothing is known about a product without components
+	if len(recipe.Materials) == 0 && len(recipe.SubProducts) == 0 {
+		is_known = false
+		nutrition.IsComplete = false
+	}
+
+	for _, recipe_material := range recipe.Materials {
+
+		material, err := nc.material(recipe_material.Id)
+		if err != nil {
+			return nutrition, err
+		}
+
+		if material == nil {
+			is_known = false
+			nutrition.IsComplete = false
+			continue
+		}
+
+		if material.Nutrition == nil {
+			nutrition.IsComplete = false
+		} else {
+			nutrition.Facts = nutrition.Facts.Add(material.Nutrition.Scale(recipe_material.Quantity))
+		}
+
+		for _, allergen := range material.Allergens {
+			allergens[allergen] = true
+		}
+
+		is_vegan = is_vegan && material.Diet == models.DietVegan
+		is_vegetarian = is_vegetarian && (material.Diet == models.DietVegan || material.Diet == models.DietVegetarian)
+	}
+
+	for _, sub_product := range recipe.SubProducts {
+
+		sub_nutrition, ok, err := nc.productById(sub_product.Id, depth+1)
+		if err != nil {
+			return nutrition, err
+		}
+
+		if !ok {
+			is_known = false
+			nutrition.IsComplete = false
+			continue
+		}
+
+		nutrition.Facts = nutrition.Facts.Add(sub_nutrition.Facts.Scale(sub_product.Quantity))
+		nutrition.IsComplete = nutrition.IsComplete && sub_nutrition.IsComplete
+
+		for _, allergen := range sub_nutrition.Allergens {
+			allergens[allergen] = true
+		}
+
+		is_vegan = is_vegan && slices.Contains(sub_nutrition.DietaryTags, models.DietaryTagVegan)
+		is_vegetarian = is_vegetarian && slices.Contains(sub_nutrition.DietaryTags, models.DietaryTagVegetarian)
+		is_known = is_known && sub_nutrition.DietaryTags != nil
+	}
+
+	nutrition.Allergens = make([]string, 0, len(allergens))
+	for allergen := range allergens {
+		nutrition.Allergens = append(nutrition.Allergens, allergen)
+	}
+	sort.Strings(nutrition.Allergens)
+
+	if is_known {
+		nutrition.DietaryTags = make([]string, 0)
+
+		if is_vegan {
+			nutrition.DietaryTags = append(nutrition.DietaryTags, models.DietaryTagVegan)
+		}
+		if is_vegetarian {
+			nutrition.DietaryTags = append(nutrition.DietaryTags, models.DietaryTagVegetarian)
+		}
+		if !allergens[models.AllergenGluten] {
+			nutrition.DietaryTags = append(nutrition.DietaryTags, models.DietaryTagGlutenFree)
+		}
+		if !allergens[models.AllergenMilk] {
+			nutrition.DietaryTags = append(nutrition.DietaryTags, models.DietaryTagDairyFree)
+		}
+		if !allergens[models.AllergenPeanuts] && !allergens[models.AllergenTreeNuts] {
+			nutrition.DietaryTags = append(nutrition.DietaryTags, models.DietaryTagNutFree)
+		}
+	}
+
+	nc.products[recipe.Id] = nutrition
+
+	return nutrition, nil
+}
+
+// fillNutrition sets the nutrition of each product.
+func fillNutrition(ctx context.Context, client *mongo.Client, products []models.Product) error {
+
+	calculator := newNutritionCalculator(ctx, client)
+
+	for index, product := range products {
+		nutrition, err := calculator.product(product, 0)
+		if err != nil {
+			return err
+		}
+
+		products[index].Nutrition = &nutrition
+	}
+
+	return nil
+}
+
+// fillTreeNutrition sets the nutrition of the product of the recipe tree and of its sub products,
+// and the nutrition facts, the allergens and the diet of its materials.
+func fillTreeNutrition(calculator *nutritionCalculator, tree *models.Product) error {
+
+	nutrition, ok, err := calculator.productById(tree.Id, 0)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		tree.Nutrition = &nutrition
+	}
+
+	for index := range tree.Materials {
+		material, err := calculator.material(tree.Materials[index].Id)
+		if err != nil {
+			return err
+		}
+
+		if material != nil {
+			tree.Materials[index].Nutrition = material.Nutrition
+			tree.Materials[index].Allergens = material.Allergens
+			tree.Materials[index].Diet = material.Diet
+		}
+	}
+
+	for index := range tree.SubProducts {
+		err := fillTreeNutrition(calculator, &tree.SubProducts[index])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
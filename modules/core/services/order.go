@@ -10,7 +10,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
 	"time"
 
@@ -67,11 +66,49 @@ func (os *OrderService) DeleteOrder(order_id string) (err error) {
 	// Connected successfully
 
 	collection := client.Database("waha").Collection("orders")
+
+	var order models.Order
+	err = collection.FindOne(ctx, bson.M{"id": order_id}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the account, the points and the gift cards don't keep what the deleted order took from them
+	err = releaseOrder(ctx, client, os.Settings.Loyalty, order)
+	if err != nil {
+		return err
+	}
+
 	_, err = collection.DeleteOne(ctx, bson.M{"id": order_id})
 
 	return
 }
 
+// releaseOrder reverses the charge of the order to the account of its customer and the loyalty points earned on it,
+// and gives back the loyalty points and the gift card amounts redeemed on it.
+func releaseOrder(ctx context.Context, client *mongo.Client, loyalty_settings models.LoyaltySettings, order models.Order) error {
+
+	err := settleOrder(ctx, client, order, models.ReceivableTypeReversal)
+	if err != nil {
+		return err
+	}
+
+	err = reverseOrderPoints(ctx, client, loyalty_settings, order)
+	if err != nil {
+		return err
+	}
+
+	err = refundOrderPoints(ctx, client, loyalty_settings, order)
+	if err != nil {
+		return err
+	}
+
+	return refundOrderGiftCards(ctx, client, order)
+}
+
 // PayUnpaidOrder sets the is_paid field of the order with the given order_id to true.
 func (os *OrderService) PayUnpaidOrder(order_id string) (err error) {
 
@@ -97,6 +134,19 @@ func (os *OrderService) PayUnpaidOrder(order_id string) (err error) {
 	collection := client.Database("waha").Collection("orders")
 
 	filter := bson.M{"id": order_id}
+
+	var order models.Order
+	err = collection.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		return err
+	}
+
+	// what's still owed of the order on the account of the customer is paid
+	err = settleOrder(ctx, client, order, models.ReceivableTypePayment)
+	if err != nil {
+		return err
+	}
+
 	update := bson.M{"$set": bson.M{"is_paid": true}}
 
 	_, err = collection.UpdateOne(ctx, filter, update)
@@ -178,17 +228,82 @@ func (os *OrderService) CancelOrder(order_id string) (err error) {
 	filter := bson.M{"id": order_id}
 	update := bson.M{"$set": bson.M{"state": "cancelled"}}
 
+	var order models.Order
+	err = collection.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		return err
+	}
+
 	// Update the order in the database
 	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
 
-	return err
+	// the order is released once it's cancelled, so it isn't marked as paid by its own reversal. The whole charge
+	// of a pay-later order is reversed even when a payment settled it, the payment is allocated to the other
+	// charges of the account. Releasing it again gives back only what wasn't given back yet.
+	return releaseOrder(ctx, client, os.Settings.Loyalty, order)
 }
 
-// CalculateCost calculates the cost of each item in the provided list of order items.
+// CalculateCost calculates the cost of each item in the provided list of order items, priced at the current prices.
 func (os *OrderService) CalculateCost(items []models.OrderItem) (cost []models.ItemCost, err error) {
+	return os.CalculateCostAt(items, time.Now(), "")
+}
+
+// CalculateCostAt calculates the cost of each item in the provided list of order items. The sale price of each item
+// is its price in the price list, or else the price of its product in effect at the time. The price list is optional.
+func (os *OrderService) CalculateCostAt(items []models.OrderItem, at time.Time, price_list_id string) (cost []models.ItemCost, err error) {
+	return os.calculateCostAt(items, at, price_list_id, nil)
+}
+
+// consumedKey identifies the consumptions of a material by the items of a recipe.
+func consumedKey(recipe_id string, material_id string) string {
+	return recipe_id + "/" + material_id
+}
+
+// orderConsumedUnitCosts returns the unit cost the materials were consumed at for an order by the recipe and
+// the material, from the consumption movements of the order. The quantity split over several entries is priced
+// at the unit cost of each of them.
+func orderConsumedUnitCosts(ctx context.Context, client *mongo.Client, order_id string) (unit_costs map[string]float64, err error) {
+
+	unit_costs = make(map[string]float64)
+
+	cursor, err := client.Database("waha").Collection("inventory_movements").Find(ctx, bson.M{
+		"type":     models.MovementTypeConsumption,
+		"order_id": order_id,
+	})
+	if err != nil {
+		return unit_costs, err
+	}
+
+	var movements []models.InventoryMovement
+	err = cursor.All(ctx, &movements)
+	if err != nil {
+		return unit_costs, err
+	}
+
+	quantities := make(map[string]float64)
+	values := make(map[string]float64)
+
+	for _, movement := range movements {
+		key := consumedKey(movement.RecipeId, movement.MaterialId)
+		quantities[key] -= movement.Quantity
+		values[key] -= movement.Quantity * movement.UnitCost
+	}
+
+	for key, quantity := range quantities {
+		if quantity > 0 {
+			unit_costs[key] = values[key] / quantity
+		}
+	}
+
+	return unit_costs, nil
+}
+
+// calculateCostAt calculates the cost of each item like CalculateCostAt, the materials with a consumed unit cost
+// are priced at it instead of the stock left.
+func (os *OrderService) calculateCostAt(items []models.OrderItem, at time.Time, price_list_id string, consumed_unit_costs map[string]float64) (cost []models.ItemCost, err error) {
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", os.Config.Databases[0].Host, os.Config.Databases[0].Port))
 	// Create a context with a timeout (optional)
@@ -210,14 +325,39 @@ func (os *OrderService) CalculateCost(items []models.OrderItem) (cost []models.I
 	// Connected successfully
 	os.Logger.Info("Connected to MongoDB!")
 
+	materialService := MaterialService{
+		Logger:   os.Logger,
+		Config:   os.Config,
+		Settings: os.Settings,
+	}
+
+	list_prices, err := priceListPrices(ctx, client, price_list_id)
+	if err != nil {
+		return cost, err
+	}
+
 	for itemIndex, item := range items {
 
+		// the quantities of the item are net, the cost is of the gross quantities taken from the inventory
+		item, err = grossItem(ctx, client, item)
+		if err != nil {
+			return cost, err
+		}
+
 		itemCost := models.ItemCost{
 			ItemName: items[itemIndex].Product.Name,
 			Cost:     0.0,
 			RecipeId: items[itemIndex].Product.Id,
 		}
 
+		// the ready stock is costed at what the item was served from once it's consumed, the items
+		// that aren't consumed yet are costed at their components
+		if item.IsConsumeFromReady && item.ReadyCost > 0 {
+			itemCost.Cost = item.ReadyCost
+			item.Materials = nil
+			item.SubItems = nil
+		}
+
 		for _, component := range item.Materials {
 
 			itemComponent := struct {
@@ -233,26 +373,28 @@ func (os *OrderService) CalculateCost(items []models.OrderItem) (cost []models.I
 				Quantity:      component.Quantity,
 			}
 
-			var component_with_specific_entry models.Material
-
-			err = client.Database("waha").Collection("materials").FindOne(
-				context.Background(), bson.M{"id": component.Material.Id, "entries.id": component.Entry.Id}, options.FindOne().SetProjection(bson.M{"entries.$": 1})).Decode(&component_with_specific_entry)
+			quantity_cost := 0.0
 
-			if err == nil {
-				quantity_cost := (component_with_specific_entry.Entries[0].PurchasePrice / float64(component_with_specific_entry.Entries[0].PurchaseQuantity)) * float64(component.Quantity)
+			if unit_cost, ok := consumed_unit_costs[consumedKey(items[itemIndex].Product.Id, component.Material.Id)]; ok {
+				quantity_cost = unit_cost * component.Quantity
+			} else {
+				var material models.Material
 
-				// check if cost is positive or negative infinity (semantic bug in calculation that causes problems later on)
-				if math.IsInf(quantity_cost, 0) || math.IsInf(quantity_cost, -1) {
-					quantity_cost = 0
+				err = client.Database("waha").Collection("materials").FindOne(
+					context.Background(), bson.M{"id": component.Material.Id, "entries.id": component.Entry.Id}).Decode(&material)
+				if err != nil {
+					return cost, err
 				}
 
-				itemCost.Cost += quantity_cost
-				itemComponent.Cost = quantity_cost
-
-			} else {
-				return cost, err
+				quantity_cost, err = materialService.CalculateConsumptionCost(material, component.Entry.Id, component.Quantity)
+				if err != nil {
+					return cost, err
+				}
 			}
 
+			itemCost.Cost += quantity_cost
+			itemComponent.Cost = quantity_cost
+
 			itemCost.Components = append(itemCost.Components, itemComponent)
 
 		}
@@ -265,7 +407,7 @@ func (os *OrderService) CalculateCost(items []models.OrderItem) (cost []models.I
 		}
 
 		for _, subrecipe := range item.SubItems {
-			total_cost, err := os.CalculateCost([]models.OrderItem{subrecipe})
+			total_cost, err := os.calculateCostAt([]models.OrderItem{subrecipe}, at, price_list_id, consumed_unit_costs)
 			if err != nil {
 				return cost, err
 			}
@@ -277,7 +419,15 @@ func (os *OrderService) CalculateCost(items []models.OrderItem) (cost []models.I
 			}
 		}
 
-		itemCost.SalePrice = recipe.Price
+		if list_price, ok := list_prices[recipe.Id]; ok {
+			itemCost.SalePrice = list_price
+		} else {
+			itemCost.SalePrice, err = priceAt(ctx, client, recipe, at)
+			if err != nil {
+				return cost, err
+			}
+		}
+
 		cost = append(cost, itemCost)
 	}
 
@@ -325,29 +475,45 @@ func (os *OrderService) FinishOrder(order_id string) (err error) {
 
 	totalCost := 0.0
 	totalSalePrice := 0.0
+	discounted_sale_price := order.SalePrice
+
+	// the materials are costed at what the order consumed when it started, not at the stock left after it
+	consumed_unit_costs, err := orderConsumedUnitCosts(ctx, client, order.Id)
+	if err != nil {
+		return err
+	}
 
-	items_cost, err := os.CalculateCost(order.Items)
+	items_cost, err := os.calculateCostAt(order.Items, order.SubmittedAt, order.PriceListId, consumed_unit_costs)
 	if err != nil {
 		return err
 	}
 
+	// the items keep the sale prices they were sold at when the order was submitted, the price lists
+	// and the product prices changed since don't change the recorded sales
 	for index, recipe_cost := range items_cost {
 
+		items_cost[index].SalePrice = order.Items[index].SalePrice
 		order.Items[index].Cost = recipe_cost.Cost
-		order.Items[index].SalePrice = recipe_cost.SalePrice
 
 		totalCost += recipe_cost.Cost
-		totalSalePrice += recipe_cost.SalePrice
+		totalSalePrice += order.Items[index].SalePrice
+	}
+
+	materialService := MaterialService{
+		Logger:   os.Logger,
+		Config:   os.Config,
+		Settings: os.Settings,
 	}
 
 	logs_data := bson.M{
-		"type":          "order_finish",
-		"date":          time.Now(),
-		"cost":          totalCost,
-		"sale_price":    totalSalePrice,
-		"items":         items_cost,
-		"order_id":      order_id,
-		"time_consumed": time.Since(order.SubmittedAt),
+		"type":           "order_finish",
+		"date":           time.Now(),
+		"cost":           totalCost,
+		"sale_price":     totalSalePrice,
+		"items":          items_cost,
+		"order_id":       order_id,
+		"costing_method": materialService.CostingMethod(),
+		"time_consumed":  time.Since(order.SubmittedAt),
 	}
 	_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
 	if err != nil {
@@ -363,6 +529,15 @@ func (os *OrderService) FinishOrder(order_id string) (err error) {
 		return err
 	}
 
+	err = earnOrderPoints(ctx, client, os.Settings.Loyalty, order, discounted_sale_price)
+	if err != nil {
+		return err
+	}
+
+	if order.Customer.Id != "" {
+		_, err = refreshCustomerStats(ctx, client, order.Customer.Id)
+	}
+
 	return err
 }
 
@@ -444,6 +619,21 @@ func (os *OrderService) SubmitOrder(order models.Order) (models.Order, error) {
 	// Connected successfully
 	os.Logger.Info("Connected to MongoDB!")
 
+	err = checkItemsOnMenu(ctx, client, os.Config, order.Items, time.Now())
+	if err != nil {
+		return order, err
+	}
+
+	order.PriceListId, err = resolveOrderPriceList(ctx, client, order)
+	if err != nil {
+		return order, err
+	}
+
+	customer_stats, err := attachCustomer(ctx, client, &order)
+	if err != nil {
+		return order, err
+	}
+
 	order.DisplayId, err = os.GetOrderDisplayId()
 	if err != nil {
 		return order, err
@@ -452,13 +642,18 @@ func (os *OrderService) SubmitOrder(order models.Order) (models.Order, error) {
 	totalCost := 0.0
 	totalSalePrice := 0.0
 
-	items_cost, err := os.CalculateCost(order.Items)
+	items_cost, err := os.CalculateCostAt(order.Items, time.Now(), order.PriceListId)
 	if err != nil {
 		return order, err
 	}
 
 	for index, recipe_cost := range items_cost {
 
+		// the reward items are paid for with points
+		if order.Items[index].IsReward {
+			recipe_cost.SalePrice = 0
+		}
+
 		order.Items[index].Cost = recipe_cost.Cost
 		order.Items[index].SalePrice = recipe_cost.SalePrice
 
@@ -466,6 +661,11 @@ func (os *OrderService) SubmitOrder(order models.Order) (models.Order, error) {
 		totalSalePrice += recipe_cost.SalePrice
 	}
 
+	err = applyLoyalty(ctx, client, os.Settings.Loyalty, &order, totalSalePrice)
+	if err != nil {
+		return order, err
+	}
+
 	order.SalePrice = totalSalePrice - order.Discount
 	order.Cost = totalCost
 	order.SubmittedAt = time.Now()
@@ -475,11 +675,57 @@ func (os *OrderService) SubmitOrder(order models.Order) (models.Order, error) {
 		order.State = "pending"
 	}
 
-	_, err = client.Database("waha").Collection("orders").InsertOne(ctx, order)
+	err = checkOrderGiftCards(&order)
+	if err != nil {
+		return order, err
+	}
+
+	// an order the gift cards pay in full isn't left unpaid
+	if len(order.GiftCards) > 0 && order.State != "stashed" && giftCardsTotal(order) >= order.SalePrice-giftCardTolerance {
+		order.IsPaid = true
+	}
+
+	err = stampRecipeVersions(ctx, client, order.Items)
+	if err != nil {
+		return order, err
+	}
+
+	is_redeemed, err := redeemOrderPoints(ctx, client, order)
 	if err != nil {
 		return order, err
 	}
 
+	is_charged := false
+
+	is_gift_cards_redeemed, err := redeemOrderGiftCards(ctx, client, order)
+	if err == nil {
+		is_charged, err = chargeOrder(ctx, client, order)
+	}
+	if err == nil {
+		_, err = client.Database("waha").Collection("orders").InsertOne(ctx, order)
+	}
+	if err != nil {
+		if is_gift_cards_redeemed {
+			if refund_err := refundOrderGiftCards(ctx, client, order); refund_err != nil {
+				return order, refund_err
+			}
+		}
+		if is_charged {
+			if reverse_err := settleOrder(ctx, client, order, models.ReceivableTypeReversal); reverse_err != nil {
+				return order, reverse_err
+			}
+		}
+		if is_redeemed {
+			if refund_err := refundOrderPoints(ctx, client, os.Settings.Loyalty, order); refund_err != nil {
+				return order, refund_err
+			}
+		}
+		return order, err
+	}
+
+	// the cashier is shown the stats of the customer
+	order.Customer.Stats = customer_stats
+
 	return order, err
 }
 
@@ -610,7 +856,9 @@ func (os *OrderService) GetOrders(params GetOrdersParameters) (orders []models.O
 
 }
 
-func (os *OrderService) ConsumeOrderComponents(order models.Order) error {
+// ConsumeOrderComponents consumes the components of the order items, and returns the consumed items
+// with the unit costs of the ready stock they are served from.
+func (os *OrderService) ConsumeOrderComponents(order models.Order) (consumed []models.OrderItem, err error) {
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", os.Config.Databases[0].Host, os.Config.Databases[0].Port))
 
 	// Create a context with a timeout (optional)
@@ -638,7 +886,7 @@ func (os *OrderService) ConsumeOrderComponents(order models.Order) error {
 	refined_notifications := map[string]models.WebsocketTopicServerMessage{}
 
 	for itemIndex, item := range order.Items {
-		notifications, err := materialService.ConsumeItemComponentsForOrder(item, order, itemIndex)
+		consumed_item, notifications, err := materialService.ConsumeItemComponentsForOrder(item, order, itemIndex)
 		for _, notification := range notifications {
 			if _, ok := refined_notifications[notification.Key]; !ok {
 				refined_notifications[notification.Key] = notification
@@ -648,13 +896,13 @@ func (os *OrderService) ConsumeOrderComponents(order models.Order) error {
 		if len(refined_notifications) > 0 {
 			notificationService, err := SpawnNotificationSingletonSvc("melody", os.Logger, os.Config)
 			if err != nil {
-				return err
+				return consumed, err
 			}
 			for _, notification := range refined_notifications {
 
 				json_notification, err := json.Marshal(notification)
 				if err != nil {
-					return err
+					return consumed, err
 				}
 
 				notificationService.SendToTopic(notification.TopicName, string(json_notification))
@@ -662,12 +910,13 @@ func (os *OrderService) ConsumeOrderComponents(order models.Order) error {
 		}
 
 		if err != nil {
-			return err
+			return consumed, err
 		}
 
+		consumed = append(consumed, consumed_item)
 	}
 
-	return nil
+	return consumed, nil
 }
 
 // StartOrder sets the state of the order with the given order_id to "in_progress",
@@ -703,7 +952,16 @@ func (os *OrderService) StartOrder(order_id string, order_items []models.OrderIt
 	}
 
 	// decrease the ingredient component quantity from the components inventory
-	err = os.ConsumeOrderComponents(order)
+	consumed_items, err := os.ConsumeOrderComponents(order)
+	if err != nil {
+		return err
+	}
+
+	keepSubmittedPrices(order.Items, order_items)
+	keepReadyCosts(consumed_items, order_items)
+
+	// the items are prepared with the current versions of their recipes
+	err = stampRecipeVersions(ctx, client, order_items)
 	if err != nil {
 		return err
 	}
@@ -734,6 +992,35 @@ func (os *OrderService) StartOrder(order_id string, order_items []models.OrderIt
 	return nil
 }
 
+// keepSubmittedPrices sets the sale prices the items were submitted at on the items the order is started with,
+// so the prices sent by the kitchen don't change what the order is sold at.
+func keepSubmittedPrices(submitted []models.OrderItem, items []models.OrderItem) {
+
+	for index := range items {
+		if index >= len(submitted) || submitted[index].Product.Id != items[index].Product.Id {
+			continue
+		}
+
+		items[index].SalePrice = submitted[index].SalePrice
+		items[index].IsReward = submitted[index].IsReward
+	}
+}
+
+// keepReadyCosts sets the unit costs of the ready stock the consumed items were served from on the items,
+// and on their sub items. The items that weren't consumed have no ready cost.
+func keepReadyCosts(consumed []models.OrderItem, items []models.OrderItem) {
+
+	for index := range items {
+		if index >= len(consumed) || consumed[index].Product.Id != items[index].Product.Id {
+			items[index].ReadyCost = 0
+			continue
+		}
+
+		items[index].ReadyCost = consumed[index].ReadyCost
+		keepReadyCosts(consumed[index].SubItems, items[index].SubItems)
+	}
+}
+
 // GetOrder retrieves an order from the database with the given order_id.
 func (os *OrderService) GetOrder(order_id string) (models.Order, error) {
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", os.Config.Databases[0].Host, os.Config.Databases[0].Port))
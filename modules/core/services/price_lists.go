@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PriceListService provides methods to manage the price lists of the sales channels.
+type PriceListService struct {
+	Logger logger.ILogger
+	Config config.Config
+}
+
+// orderServiceStyle returns the service style of the order, it's empty when none is set.
+func orderServiceStyle(order models.Order) string {
+	switch {
+	case order.IsDelivery:
+		return models.ServiceStyleDelivery
+	case order.IsTakeAway:
+		return models.ServiceStyleTakeAway
+	case order.IsDineIn:
+		return models.ServiceStyleDineIn
+	}
+
+	return ""
+}
+
+// resolveOrderPriceList returns the id of the price list the order is priced with, the one set on the order
+// or else the one of its service style. It's empty when the order is sold at the products prices.
+func resolveOrderPriceList(ctx context.Context, client *mongo.Client, order models.Order) (string, error) {
+
+	collection := client.Database("waha").Collection("price_lists")
+
+	var price_list models.PriceList
+
+	if order.PriceListId != "" {
+		err := collection.FindOne(ctx, bson.M{"id": order.PriceListId}).Decode(&price_list)
+		if err == mongo.ErrNoDocuments {
+			return "", fmt.Errorf("%w: price list %s doesn't exist", customerrors.ErrInvalidPriceList, order.PriceListId)
+		}
+
+		return price_list.Id, err
+	}
+
+	service_style := orderServiceStyle(order)
+	if service_style == "" {
+		return "", nil
+	}
+
+	err := collection.FindOne(ctx, bson.M{"service_style": service_style}).Decode(&price_list)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+
+	return price_list.Id, err
+}
+
+// priceListPrices returns the prices of the price list by product id, it's empty when the id is empty
+// or the price list was deleted since.
+func priceListPrices(ctx context.Context, client *mongo.Client, price_list_id string) (map[string]float64, error) {
+
+	prices := make(map[string]float64)
+
+	if price_list_id == "" {
+		return prices, nil
+	}
+
+	var price_list models.PriceList
+	err := client.Database("waha").Collection("price_lists").FindOne(ctx, bson.M{"id": price_list_id}).Decode(&price_list)
+	if err == mongo.ErrNoDocuments {
+		return prices, nil
+	}
+	if err != nil {
+		return prices, err
+	}
+
+	for _, item := range price_list.Items {
+		prices[item.ProductId] = item.Price
+	}
+
+	return prices, nil
+}
+
+// validatePriceList checks that the price list has a name, a known service style not used by another list,
+// and non negative prices of existing products, each product at most once.
+func validatePriceList(ctx context.Context, client *mongo.Client, price_list models.PriceList) error {
+
+	if price_list.Name == "" {
+		return fmt.Errorf("%w: name is required", customerrors.ErrInvalidPriceList)
+	}
+
+	switch price_list.ServiceStyle {
+	case "", models.ServiceStyleDineIn, models.ServiceStyleTakeAway, models.ServiceStyleDelivery:
+	default:
+		return fmt.Errorf("%w: unknown service style %s", customerrors.ErrInvalidPriceList, price_list.ServiceStyle)
+	}
+
+	if price_list.ServiceStyle != "" {
+		count, err := client.Database("waha").Collection("price_lists").CountDocuments(ctx, bson.M{
+			"service_style": price_list.ServiceStyle,
+			"id":            bson.M{"$ne": price_list.Id},
+		})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fmt.Errorf("%w: another price list is used for %s", customerrors.ErrInvalidPriceList, price_list.ServiceStyle)
+		}
+	}
+
+	product_ids := make([]string, 0, len(price_list.Items))
+	seen := make(map[string]bool)
+
+	for _, item := range price_list.Items {
+		if item.Price < 0 {
+			return fmt.Errorf("%w: price of product %s is negative", customerrors.ErrInvalidPriceList, item.ProductId)
+		}
+
+		if seen[item.ProductId] {
+			return fmt.Errorf("%w: product %s is priced more than once", customerrors.ErrInvalidPriceList, item.ProductId)
+		}
+
+		seen[item.ProductId] = true
+		product_ids = append(product_ids, item.ProductId)
+	}
+
+	if len(product_ids) == 0 {
+		return nil
+	}
+
+	count, err := client.Database("waha").Collection("recipes").CountDocuments(ctx, bson.M{"id": bson.M{"$in": product_ids}})
+	if err != nil {
+		return err
+	}
+
+	if int(count) != len(product_ids) {
+		return fmt.Errorf("%w: some of the products don't exist", customerrors.ErrInvalidPriceList)
+	}
+
+	return nil
+}
+
+// GetPriceLists returns all the price lists sorted by name.
+func (pls *PriceListService) GetPriceLists() (price_lists []models.PriceList, err error) {
+
+	client, ctx, cancel, err := connectDB(pls.Config)
+	if err != nil {
+		return price_lists, err
+	}
+	defer cancel()
+	// connected to db
+
+	price_lists = make([]models.PriceList, 0)
+
+	cursor, err := client.Database("waha").Collection("price_lists").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return price_lists, err
+	}
+
+	err = cursor.All(ctx, &price_lists)
+
+	return price_lists, err
+}
+
+// GetPriceList returns a single price list.
+func (pls *PriceListService) GetPriceList(price_list_id string) (price_list models.PriceList, err error) {
+
+	client, ctx, cancel, err := connectDB(pls.Config)
+	if err != nil {
+		return price_list, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("price_lists").FindOne(ctx, bson.M{"id": price_list_id}).Decode(&price_list)
+
+	return price_list, err
+}
+
+// InsertPriceList validates and inserts a new price list.
+func (pls *PriceListService) InsertPriceList(price_list models.PriceList) (models.PriceList, error) {
+
+	client, ctx, cancel, err := connectDB(pls.Config)
+	if err != nil {
+		return price_list, err
+	}
+	defer cancel()
+	// connected to db
+
+	price_list.Id = primitive.NewObjectID().Hex()
+	if price_list.Items == nil {
+		price_list.Items = make([]models.PriceListItem, 0)
+	}
+
+	err = validatePriceList(ctx, client, price_list)
+	if err != nil {
+		return price_list, err
+	}
+
+	_, err = client.Database("waha").Collection("price_lists").InsertOne(ctx, price_list)
+
+	return price_list, err
+}
+
+// UpdatePriceList validates and replaces the name, the service style and the prices of a price list.
+func (pls *PriceListService) UpdatePriceList(price_list_id string, price_list models.PriceList) (models.PriceList, error) {
+
+	client, ctx, cancel, err := connectDB(pls.Config)
+	if err != nil {
+		return price_list, err
+	}
+	defer cancel()
+	// connected to db
+
+	price_list.Id = price_list_id
+	if price_list.Items == nil {
+		price_list.Items = make([]models.PriceListItem, 0)
+	}
+
+	err = validatePriceList(ctx, client, price_list)
+	if err != nil {
+		return price_list, err
+	}
+
+	result, err := client.Database("waha").Collection("price_lists").UpdateOne(
+		ctx,
+		bson.M{"id": price_list_id},
+		bson.M{"$set": bson.M{
+			"name":          price_list.Name,
+			"service_style": price_list.ServiceStyle,
+			"items":         price_list.Items,
+		}},
+	)
+	if err != nil {
+		return price_list, err
+	}
+
+	if result.MatchedCount == 0 {
+		return price_list, mongo.ErrNoDocuments
+	}
+
+	return price_list, nil
+}
+
+// DeletePriceList deletes a price list, the orders priced with it keep the prices they were submitted at.
+func (pls *PriceListService) DeletePriceList(price_list_id string) (err error) {
+
+	client, ctx, cancel, err := connectDB(pls.Config)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	// connected to db
+
+	result, err := client.Database("waha").Collection("price_lists").DeleteOne(ctx, bson.M{"id": price_list_id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// priceAt returns the price of the product in effect at the time, the latest price effective from before it.
+// The price of the product is returned when it has no dated price by then, as for the products priced before the history was kept.
+func priceAt(ctx context.Context, client *mongo.Client, product models.Product, at time.Time) (float64, error) {
+
+	var price models.ProductPrice
+	err := client.Database("waha").Collection("product_prices").FindOne(
+		ctx,
+		bson.M{"product_id": product.Id, "effective_from": bson.M{"$lte": at}},
+		options.FindOne().SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "created_at", Value: -1}}),
+	).Decode(&price)
+	if err == mongo.ErrNoDocuments {
+		return product.Price, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return price.Price, nil
+}
+
+// currentPrice returns the price stored on the product.
+func currentPrice(ctx context.Context, client *mongo.Client, product_id string) (float64, error) {
+
+	var product models.Product
+	err := client.Database("waha").Collection("recipes").FindOne(
+		ctx,
+		bson.M{"id": product_id},
+		options.FindOne().SetProjection(bson.M{"price": 1}),
+	).Decode(&product)
+
+	return product.Price, err
+}
+
+// recordPrice stores a price of the product effective from now, it's used when the price is set on the product directly.
+func recordPrice(ctx context.Context, client *mongo.Client, product_id string, price float64, author string) error {
+
+	now := time.Now()
+
+	_, err := client.Database("waha").Collection("product_prices").InsertOne(ctx, models.ProductPrice{
+		Id:            primitive.NewObjectID().Hex(),
+		ProductId:     product_id,
+		Price:         price,
+		EffectiveFrom: now,
+		IsApplied:     true,
+		Author:        author,
+		CreatedAt:     now,
+	})
+
+	return err
+}
+
+// applyPrice copies the price to the product as a new recipe version and marks it as applied.
+func applyPrice(ctx context.Context, client *mongo.Client, price models.ProductPrice) error {
+
+	_, err := commitRecipe(
+		ctx,
+		client,
+		price.ProductId,
+		bson.M{"price": price.Price},
+		models.RecipeVersion{
+			Action: models.RecipeVersionActionPrice,
+			Author: price.Author,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Database("waha").Collection("product_prices").UpdateOne(
+		ctx,
+		bson.M{"id": price.Id},
+		bson.M{"$set": bson.M{"is_applied": true}},
+	)
+
+	return err
+}
+
+// SchedulePrice sets the price of a product effective from a time, the price is applied to the product
+// right away when the time is not in the future, otherwise it's applied by the background job when it's due.
+// The current time is used when effective_from is zero or in the past, the prices can't be backdated into
+// the history the orders were priced with.
+func (rs *RecipeService) SchedulePrice(product_id string, price float64, effective_from time.Time, author string) (product_price models.ProductPrice, err error) {
+
+	if price < 0 {
+		return product_price, customerrors.ErrInvalidPrice
+	}
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return product_price, err
+	}
+	defer cancel()
+	// connected to db
+
+	_, err = currentPrice(ctx, client, product_id)
+	if err != nil {
+		return product_price, err
+	}
+
+	now := time.Now()
+	if effective_from.IsZero() || effective_from.Before(now) {
+		effective_from = now
+	}
+
+	product_price = models.ProductPrice{
+		Id:            primitive.NewObjectID().Hex(),
+		ProductId:     product_id,
+		Price:         price,
+		EffectiveFrom: effective_from,
+		Author:        author,
+		CreatedAt:     now,
+	}
+
+	_, err = client.Database("waha").Collection("product_prices").InsertOne(ctx, product_price)
+	if err != nil {
+		return product_price, err
+	}
+
+	if effective_from.After(now) {
+		return product_price, nil
+	}
+
+	err = applyPrice(ctx, client, product_price)
+	product_price.IsApplied = err == nil
+
+	return product_price, err
+}
+
+// GetPriceHistory returns the dated prices of a product including the scheduled ones, the latest effective first.
+func (rs *RecipeService) GetPriceHistory(product_id string) (prices []models.ProductPrice, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return prices, err
+	}
+	defer cancel()
+	// connected to db
+
+	prices = make([]models.ProductPrice, 0)
+
+	cursor, err := client.Database("waha").Collection("product_prices").Find(
+		ctx,
+		bson.M{"product_id": product_id},
+		options.Find().SetSort(bson.D{{Key: "effective_from", Value: -1}, {Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return prices, err
+	}
+
+	err = cursor.All(ctx, &prices)
+
+	return prices, err
+}
+
+// CancelScheduledPrice deletes a scheduled price of a product, the prices that already took effect are kept.
+func (rs *RecipeService) CancelScheduledPrice(product_id string, price_id string) (err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	// connected to db
+
+	collection := client.Database("waha").Collection("product_prices")
+
+	var price models.ProductPrice
+	err = collection.FindOne(ctx, bson.M{"id": price_id, "product_id": product_id}).Decode(&price)
+	if err != nil {
+		return err
+	}
+
+	if price.IsApplied || !price.EffectiveFrom.After(time.Now()) {
+		return customerrors.ErrPriceInEffect
+	}
+
+	_, err = collection.DeleteOne(ctx, bson.M{"id": price_id, "is_applied": false})
+
+	return err
+}
+
+// ApplyDuePrices applies the scheduled prices effective from before the time to their products,
+// in the order they take effect. It returns the applied prices.
+func (rs *RecipeService) ApplyDuePrices(at time.Time) (applied []models.ProductPrice, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return applied, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("product_prices").Find(
+		ctx,
+		bson.M{"is_applied": false, "effective_from": bson.M{"$lte": at}},
+		options.Find().SetSort(bson.D{{Key: "effective_from", Value: 1}, {Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return applied, err
+	}
+
+	var due []models.ProductPrice
+	if err := cursor.All(ctx, &due); err != nil {
+		return applied, err
+	}
+
+	for _, price := range due {
+
+		err = applyPrice(ctx, client, price)
+		if err == mongo.ErrNoDocuments {
+			// the product was deleted since, its price is dropped
+			_, err = client.Database("waha").Collection("product_prices").DeleteOne(ctx, bson.M{"id": price.Id})
+			if err != nil {
+				return applied, err
+			}
+			continue
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		price.IsApplied = true
+		applied = append(applied, price)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,503 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductionService records the production batches of the products, the batches fill the ready stock
+// of the products and are consumed by the orders from the oldest to the newest.
+type ProductionService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// usableBatches returns the batches of a product that can be consumed at the given time, the oldest first.
+func usableBatches(ctx context.Context, client *mongo.Client, product_id string, at time.Time) (batches []models.ProductionBatch, err error) {
+
+	batches = make([]models.ProductionBatch, 0)
+
+	cursor, err := client.Database("waha").Collection("production_batches").Find(
+		ctx,
+		bson.M{"product_id": product_id, "remaining": bson.M{"$gt": 0}, "is_expired": false},
+		options.Find().SetSort(bson.M{"produced_at": 1}),
+	)
+	if err != nil {
+		return batches, err
+	}
+
+	var found []models.ProductionBatch
+	if err := cursor.All(ctx, &found); err != nil {
+		return batches, err
+	}
+
+	for _, batch := range found {
+		if batch.IsUsable(at) {
+			batches = append(batches, batch)
+		}
+	}
+
+	return batches, nil
+}
+
+// productionAllocation holds the quantities already allocated by a production,
+// so the entries and the ready stock shared by several sub products aren't counted twice.
+type productionAllocation struct {
+	entries map[string]float64
+	ready   map[string]float64
+}
+
+// allocateItem builds the item consuming the given quantity of a product. The materials are allocated from
+// the usable entries from the oldest to the newest, the sub products are taken from their ready stock when it
+// covers the needed quantity, or produced from their own materials otherwise. It returns the cost of the item
+// using the configured costing method.
+//
+// The allocated quantities are totals for the whole quantity, so the item quantity is 1.
+func (ps *ProductionService) allocateItem(ctx context.Context, client *mongo.Client, product models.Product, quantity float64, allocation productionAllocation) (item models.OrderItem, cost float64, err error) {
+
+	now := time.Now()
+
+	materialService := MaterialService{
+		Logger:   ps.Logger,
+		Config:   ps.Config,
+		Settings: ps.Settings,
+	}
+
+	item = models.OrderItem{
+		Product:   models.Product{Id: product.Id, Name: product.Name},
+		Quantity:  1,
+		Materials: make([]models.OrderItemMaterial, 0),
+		SubItems:  make([]models.OrderItem, 0),
+	}
+
+	for _, recipe_material := range product.Materials {
+
+		needed := recipe_material.Gross(recipe_material.Quantity) * quantity
+		if needed <= 0 {
+			continue
+		}
+
+		var material models.Material
+		err = client.Database("waha").Collection("materials").FindOne(ctx, bson.M{"id": recipe_material.Id}).Decode(&material)
+		if err != nil {
+			return item, cost, err
+		}
+
+		stocked := stockedEntries(material.Entries, now)
+		// the weighted average method prices the batch at the moving average the consumptions are recorded at
+		average_unit_cost := materialAverageCost(material)
+
+		for _, entry := range stocked {
+			if needed <= ledgerTolerance {
+				break
+			}
+
+			available := float64(entry.Quantity) - allocation.entries[entry.Id]
+			if available <= 0 {
+				continue
+			}
+
+			taken := math.Min(needed, available)
+			allocation.entries[entry.Id] += taken
+			needed -= taken
+
+			item.Materials = append(item.Materials, models.OrderItemMaterial{
+				Material: models.Material{Id: material.Id, Name: material.Name, Unit: material.Unit},
+				Entry:    entry,
+				Quantity: taken,
+			})
+
+			// allocating from the oldest entries prices the fifo method at the entries purchase price
+			if materialService.CostingMethod() == models.CostingMethodWeightedAverage {
+				cost += average_unit_cost * taken
+			} else {
+				cost += entry.UnitCost() * taken
+			}
+		}
+
+		if needed > ledgerTolerance {
+			return item, cost, fmt.Errorf("%w: %s is short by %f %s", customerrors.ErrInsufficientStock, material.Name, needed, material.Unit)
+		}
+	}
+
+	for _, recipe_sub_product := range product.SubProducts {
+
+		needed := recipe_sub_product.Gross(recipe_sub_product.Quantity) * quantity
+		if needed <= 0 {
+			continue
+		}
+
+		var sub_product models.Product
+		err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": recipe_sub_product.Id}).Decode(&sub_product)
+		if err != nil {
+			return item, cost, err
+		}
+
+		if sub_product.Ready-allocation.ready[sub_product.Id] >= needed {
+
+			batches, err := usableBatches(ctx, client, sub_product.Id, now)
+			if err != nil {
+				return item, cost, err
+			}
+
+			// skip what's already allocated from the oldest batches
+			skipped := allocation.ready[sub_product.Id]
+			remaining := needed
+			ready_cost := 0.0
+			for _, batch := range batches {
+				available := batch.Remaining - skipped
+				skipped = math.Max(0, skipped-batch.Remaining)
+				if available <= 0 {
+					continue
+				}
+
+				taken := math.Min(remaining, available)
+				ready_cost += taken * batch.UnitCost
+				remaining -= taken
+
+				if remaining <= 0 {
+					break
+				}
+			}
+
+			allocation.ready[sub_product.Id] += needed
+			cost += ready_cost
+
+			item.SubItems = append(item.SubItems, models.OrderItem{
+				Product:            models.Product{Id: sub_product.Id, Name: sub_product.Name},
+				Quantity:           needed,
+				IsConsumeFromReady: true,
+				ReadyCost:          ready_cost / needed,
+			})
+			continue
+		}
+
+		sub_item, sub_cost, err := ps.allocateItem(ctx, client, sub_product, needed, allocation)
+		if err != nil {
+			return item, cost, err
+		}
+
+		cost += sub_cost
+		item.SubItems = append(item.SubItems, sub_item)
+	}
+
+	return item, cost, nil
+}
+
+// ProduceBatch records that a quantity of a product was prepared. It consumes the product materials and sub products
+// through the same path as the orders, increases the product ready stock, and returns the new batch.
+//
+// The batch expires at the given expiration date, or after the product shelf life when it's zero.
+func (ps *ProductionService) ProduceBatch(product_id string, quantity float64, expiration_date time.Time) (batch models.ProductionBatch, err error) {
+
+	if quantity <= 0 {
+		return batch, fmt.Errorf("quantity must be positive")
+	}
+
+	client, ctx, cancel, err := connectDB(ps.Config)
+	if err != nil {
+		return batch, err
+	}
+	defer cancel()
+	// connected to db
+
+	var product models.Product
+	err = client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return batch, err
+	}
+
+	item, cost, err := ps.allocateItem(ctx, client, product, quantity, productionAllocation{
+		entries: make(map[string]float64),
+		ready:   make(map[string]float64),
+	})
+	if err != nil {
+		return batch, err
+	}
+
+	now := time.Now()
+
+	batch = models.ProductionBatch{
+		Id:             primitive.NewObjectID().Hex(),
+		ProductId:      product.Id,
+		ProductName:    product.Name,
+		Quantity:       quantity,
+		Remaining:      quantity,
+		Cost:           cost,
+		UnitCost:       cost / quantity,
+		ProducedAt:     now,
+		ExpirationDate: expiration_date,
+		Item:           item,
+	}
+
+	if batch.ExpirationDate.IsZero() && product.ShelfLifeHours > 0 {
+		batch.ExpirationDate = now.Add(time.Duration(product.ShelfLifeHours * float64(time.Hour)))
+	}
+
+	materialService := MaterialService{
+		Logger:   ps.Logger,
+		Config:   ps.Config,
+		Settings: ps.Settings,
+	}
+
+	consumed, notifications, err := materialService.consumeItemComponents(item, consumptionRef{ProductionBatchId: batch.Id}, 0)
+
+	if len(notifications) > 0 {
+		notificationService, notification_err := SpawnNotificationSingletonSvc("melody", ps.Logger, ps.Config)
+		if notification_err != nil {
+			return batch, notification_err
+		}
+
+		sent := map[string]bool{}
+		for _, notification := range notifications {
+			if sent[notification.Key] {
+				continue
+			}
+			sent[notification.Key] = true
+
+			json_notification, json_err := json.Marshal(notification)
+			if json_err != nil {
+				return batch, json_err
+			}
+
+			notificationService.SendToTopic(notification.TopicName, string(json_notification))
+		}
+	}
+
+	if err == nil {
+		// the ready stock is costed at the batches it was actually taken from
+		cost += readyItemsCost(consumed) - readyItemsCost(item)
+
+		batch.Item = consumed
+		batch.Cost = cost
+		batch.UnitCost = cost / quantity
+
+		_, err = client.Database("waha").Collection("production_batches").InsertOne(ctx, batch)
+	}
+	if err != nil {
+		undo_err := undoBatchConsumption(ctx, client, batch.Id)
+		if undo_err != nil {
+			ps.Logger.Error(fmt.Sprintf("can't undo the consumption of production batch %s: %s", batch.Id, undo_err.Error()))
+		}
+		return batch, err
+	}
+
+	_, err = client.Database("waha").Collection("recipes").UpdateOne(ctx, bson.M{"id": product.Id}, bson.M{"$inc": bson.M{"ready": quantity}})
+	if err != nil {
+		return batch, err
+	}
+
+	InvalidateAvailabilityCache()
+
+	logs_data := bson.M{
+		"type":            "production",
+		"date":            now,
+		"batch_id":        batch.Id,
+		"product_id":      product.Id,
+		"quantity":        quantity,
+		"cost":            cost,
+		"expiration_date": batch.ExpirationDate,
+	}
+	_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+	if err != nil {
+		return batch, err
+	}
+
+	return batch, nil
+}
+
+// readyItemsCost returns the cost of the ready stock the sub items of an item are served from.
+func readyItemsCost(item models.OrderItem) (cost float64) {
+
+	for _, sub_item := range item.SubItems {
+		if sub_item.IsConsumeFromReady {
+			cost += sub_item.ReadyCost * sub_item.Quantity
+			continue
+		}
+
+		cost += readyItemsCost(sub_item)
+	}
+
+	return cost
+}
+
+// undoBatchConsumption gives back what was consumed for a production batch that couldn't be produced,
+// the materials are returned to the entries they were taken from and the ready stock to its batches.
+func undoBatchConsumption(ctx context.Context, client *mongo.Client, batch_id string) error {
+
+	cursor, err := client.Database("waha").Collection("inventory_movements").Find(ctx, bson.M{
+		"type":                models.MovementTypeConsumption,
+		"production_batch_id": batch_id,
+	})
+	if err != nil {
+		return err
+	}
+
+	var consumptions []models.InventoryMovement
+	err = cursor.All(ctx, &consumptions)
+	if err != nil {
+		return err
+	}
+
+	for _, consumption := range consumptions {
+		_, err = recordMovement(ctx, client, models.InventoryMovement{
+			Type:              models.MovementTypeReturn,
+			MaterialId:        consumption.MaterialId,
+			EntryId:           consumption.EntryId,
+			Quantity:          -consumption.Quantity,
+			UnitCost:          consumption.UnitCost,
+			Reason:            "production batch failed",
+			RecipeId:          consumption.RecipeId,
+			ProductionBatchId: batch_id,
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	ready_logs, err := findConsumptionLogs(ctx, client, "ready_consume", bson.M{"production_batch_id": batch_id})
+	if err != nil {
+		return err
+	}
+
+	for _, ready_log := range ready_logs {
+		if ready_log.BatchId != "" {
+			_, err = client.Database("waha").Collection("production_batches").UpdateOne(ctx, bson.M{"id": ready_log.BatchId}, bson.M{"$inc": bson.M{"remaining": ready_log.Quantity}})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = client.Database("waha").Collection("recipes").UpdateOne(ctx, bson.M{"id": ready_log.ProductId}, bson.M{"$inc": bson.M{"ready": ready_log.Quantity}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the batch was never produced, so nothing is traced to it
+	_, err = client.Database("waha").Collection("logs").DeleteMany(ctx, bson.M{
+		"type":                bson.M{"$in": []string{"component_consume", "ready_consume"}},
+		"production_batch_id": batch_id,
+	})
+	if err != nil {
+		return err
+	}
+
+	InvalidateAvailabilityCache()
+
+	return nil
+}
+
+// GetBatches returns the production batches of a product, the newest first.
+// The consumed and expired batches are only included when include_closed is set.
+func (ps *ProductionService) GetBatches(product_id string, include_closed bool) (batches []models.ProductionBatch, err error) {
+
+	batches = make([]models.ProductionBatch, 0)
+
+	client, ctx, cancel, err := connectDB(ps.Config)
+	if err != nil {
+		return batches, err
+	}
+	defer cancel()
+	// connected to db
+
+	filter := bson.M{"product_id": product_id}
+	if !include_closed {
+		filter["remaining"] = bson.M{"$gt": 0}
+		filter["is_expired"] = false
+	}
+
+	cursor, err := client.Database("waha").Collection("production_batches").Find(ctx, filter, options.Find().SetSort(bson.M{"produced_at": -1}))
+	if err != nil {
+		return batches, err
+	}
+
+	err = cursor.All(ctx, &batches)
+	return batches, err
+}
+
+// ExpireBatches marks the batches that passed their expiration date as expired, their remaining quantity
+// is removed from the product ready stock and logged as a batch_expire log. It returns the expired batches.
+func (ps *ProductionService) ExpireBatches(at time.Time) (expired []models.ProductionBatch, err error) {
+
+	expired = make([]models.ProductionBatch, 0)
+
+	client, ctx, cancel, err := connectDB(ps.Config)
+	if err != nil {
+		return expired, err
+	}
+	defer cancel()
+	// connected to db
+
+	cursor, err := client.Database("waha").Collection("production_batches").Find(ctx, bson.M{
+		"remaining":       bson.M{"$gt": 0},
+		"is_expired":      false,
+		"expiration_date": bson.M{"$gt": time.Time{}, "$lte": at},
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	var batches []models.ProductionBatch
+	if err := cursor.All(ctx, &batches); err != nil {
+		return expired, err
+	}
+
+	for _, batch := range batches {
+
+		_, err = client.Database("waha").Collection("production_batches").UpdateOne(
+			ctx,
+			bson.M{"id": batch.Id},
+			bson.M{"$set": bson.M{"is_expired": true, "expired_at": at, "remaining": 0}},
+		)
+		if err != nil {
+			return expired, err
+		}
+
+		_, err = client.Database("waha").Collection("recipes").UpdateOne(
+			ctx,
+			bson.M{"id": batch.ProductId},
+			bson.M{"$inc": bson.M{"ready": -batch.Remaining}},
+		)
+		if err != nil {
+			return expired, err
+		}
+
+		InvalidateAvailabilityCache()
+
+		logs_data := bson.M{
+			"type":       "batch_expire",
+			"date":       at,
+			"batch_id":   batch.Id,
+			"product_id": batch.ProductId,
+			"quantity":   batch.Remaining,
+			"cost":       batch.Remaining * batch.UnitCost,
+		}
+		_, err = client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
+		if err != nil {
+			return expired, err
+		}
+
+		expired = append(expired, batch)
+	}
+
+	return expired, nil
+}
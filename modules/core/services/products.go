@@ -10,13 +10,12 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"sync"
+	"math"
 	"time"
 
 	"github.com/elmawardy/nutrix/common/config"
 	"github.com/elmawardy/nutrix/common/customerrors"
 	"github.com/elmawardy/nutrix/common/logger"
-	"github.com/elmawardy/nutrix/modules/core/dto"
 	"github.com/elmawardy/nutrix/modules/core/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -53,15 +52,33 @@ func (rs *RecipeService) GetProduct(product_id string) (product models.Product,
 		return product, err
 	}
 
+	nutrition, err := newNutritionCalculator(ctx, client).product(product, 0)
+	if err != nil {
+		return product, err
+	}
+
+	product.Nutrition = &nutrition
+
 	return product, nil
 }
 
 // UpdateProduct updates a product in the database.
 //
-// It takes a product and updates it in the database.
+// It takes a product and updates it in the database, and stores the updated recipe as a new version saved by the author.
 //
 // If the product is not found, it will return an error.
-func (rs *RecipeService) UpdateProduct(product_id string, product models.Product) (err error) {
+func (rs *RecipeService) UpdateProduct(product_id string, product models.Product, author string) (err error) {
+
+	err = validateRecipeYields(product)
+	if err != nil {
+		return err
+	}
+
+	err = validateSchedule(product.Schedule)
+	if err != nil {
+		return err
+	}
+
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
 
 	deadline := 5 * time.Second
@@ -78,30 +95,59 @@ func (rs *RecipeService) UpdateProduct(product_id string, product models.Product
 	}
 	// connected to db
 
-	collection := client.Database("waha").Collection("recipes")
+	return updateRecipe(ctx, client, product_id, product, author)
+}
+
+// updateRecipe checks the references of the recipe and saves it as a new version of the product,
+// a new price is recorded in the price history. The image url and the ready stock are kept, they're only set
+// from the product images and the production batches.
+func updateRecipe(ctx context.Context, client *mongo.Client, product_id string, product models.Product, author string) (err error) {
+
+	product.Id = product_id
+	err = validateRecipeGraph(ctx, client, product)
+	if err != nil {
+		return err
+	}
+
+	price, err := currentPrice(ctx, client, product_id)
+	if err != nil {
+		return err
+	}
 
-	_, err = collection.UpdateOne(
+	_, err = commitRecipe(
 		ctx,
-		bson.M{"id": product_id},
+		client,
+		product_id,
 		bson.M{
-			"$set": bson.M{
-				"name":         product.Name,
-				"materials":    product.Materials,
-				"sub_products": product.SubProducts,
-				"ready":        product.Ready,
-				"recipeId":     product.Id,
-				"price":        product.Price,
-				"image_url":    product.ImageURL,
-			},
+			"name":             product.Name,
+			"description":      product.Description,
+			"translations":     product.Translations,
+			"materials":        product.Materials,
+			"sub_products":     product.SubProducts,
+			"recipeId":         product.Id,
+			"price":            product.Price,
+			"shelf_life_hours": product.ShelfLifeHours,
+			"schedule":         product.Schedule,
+		},
+		models.RecipeVersion{
+			Action: models.RecipeVersionActionUpdate,
+			Author: author,
 		},
 	)
+	if err != nil {
+		return err
+	}
+
+	if product.Price != price {
+		err = recordPrice(ctx, client, product_id, product.Price, author)
+	}
 
 	return err
 }
 
 // DeleteProduct deletes a product from the database.
 //
-// It takes a product_id and deletes it from the database.
+// It takes a product_id and deletes it from the database, and deletes the files of its images from the storage.
 func (rs *RecipeService) DeleteProduct(product_id string) (err error) {
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
@@ -121,20 +167,41 @@ func (rs *RecipeService) DeleteProduct(product_id string) (err error) {
 	// connected to db
 
 	collection := client.Database("waha").Collection("recipes")
+
+	var product models.Product
+	err = collection.FindOne(ctx, bson.M{"id": product_id}).Decode(&product)
+	if err != nil {
+		return err
+	}
+
 	_, err = collection.DeleteOne(ctx, bson.M{"id": product_id})
 	if err != nil {
 		return err
 	}
 
+	InvalidateAvailabilityCache()
+
+	rs.removeProductImages(ctx, product)
+
 	return err
 
 }
 
 // InsertNew inserts a new product into the database.
 //
-// It takes a product and inserts it into the database.
+// It takes a product and inserts it into the database as the first version of its recipe saved by the author.
 // It returns an error if the product could not be inserted.
-func (rs *RecipeService) InsertNew(product models.Product) (afterInsert models.Product, err error) {
+func (rs *RecipeService) InsertNew(product models.Product, author string) (afterInsert models.Product, err error) {
+
+	err = validateRecipeYields(product)
+	if err != nil {
+		return afterInsert, err
+	}
+
+	err = validateSchedule(product.Schedule)
+	if err != nil {
+		return afterInsert, err
+	}
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
 
@@ -152,9 +219,27 @@ func (rs *RecipeService) InsertNew(product models.Product) (afterInsert models.P
 	}
 	// connected to db
 
+	product.Id = primitive.NewObjectID().Hex()
+
+	return insertRecipe(ctx, client, product, author)
+}
+
+// insertRecipe checks the references of the recipe and inserts it with the id it has
+// as the first version of the product, its price is recorded in the price history.
+func insertRecipe(ctx context.Context, client *mongo.Client, product models.Product, author string) (afterInsert models.Product, err error) {
+
 	collection := client.Database("waha").Collection("recipes")
 
-	product.Id = primitive.NewObjectID().Hex()
+	product.Version = 1
+	// the images are uploaded to the product once it exists
+	product.Images = nil
+	// the ready stock is filled by the production batches
+	product.Ready = 0
+
+	err = validateRecipeGraph(ctx, client, product)
+	if err != nil {
+		return afterInsert, err
+	}
 
 	result, err := collection.InsertOne(ctx, product)
 	if err != nil {
@@ -166,6 +251,19 @@ func (rs *RecipeService) InsertNew(product models.Product) (afterInsert models.P
 		return afterInsert, err
 	}
 
+	_, err = insertRecipeVersion(ctx, client, afterInsert, models.RecipeVersion{
+		Action: models.RecipeVersionActionCreate,
+		Author: author,
+	})
+
+	InvalidateAvailabilityCache()
+
+	if err != nil {
+		return afterInsert, err
+	}
+
+	err = recordPrice(ctx, client, afterInsert.Id, afterInsert.Price, author)
+
 	return afterInsert, err
 }
 
@@ -244,11 +342,24 @@ func (rs *RecipeService) GetProducts(params GetProductsParams) (products []model
 		products = append(products, product)
 	}
 
+	err = fillMenuAvailability(ctx, client, rs.Config, products)
+	if err != nil {
+		return products, totalRecords, err
+	}
+
+	err = fillNutrition(ctx, client, products)
+
 	return products, totalRecords, err
 }
 
-// ConsumeFromReady consumes a quantity from the ready stock of a product.
-func (rs *RecipeService) ConsumeFromReady(product_id string, quantity float64) error {
+// ConsumeFromReady consumes a quantity from the ready stock of a product for an order or a production batch,
+// and returns the cost of the consumed quantity.
+//
+// The quantity is taken from the usable production batches of the product from the oldest to the newest,
+// the ready stock that isn't backed by a batch is consumed last at the unit cost returned by unbacked_unit_cost,
+// it's only called when the batches don't cover the quantity. Each consumption is logged with its batch
+// so it can be traced back to the order.
+func (rs *RecipeService) ConsumeFromReady(product_id string, quantity float64, order_id string, production_batch_id string, unbacked_unit_cost func() (float64, error)) (cost float64, err error) {
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
 
@@ -259,43 +370,107 @@ func (rs *RecipeService) ConsumeFromReady(product_id string, quantity float64) e
 	// Connect to MongoDB
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Ping the database to check connectivity
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Connected successfully
 	var product models.Product
 	err = client.Database("waha").Collection("recipes").FindOne(context.Background(), bson.M{"id": product_id}).Decode(&product)
 	if err != nil {
+		return 0, err
+	}
+
+	if product.Ready < quantity {
+		return 0, customerrors.ErrInsufficientReady
+	}
+
+	batches, err := usableBatches(ctx, client, product_id, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	log_consumption := func(batch_id string, consumed float64, unit_cost float64) error {
+		logs_data := bson.M{
+			"type":       "ready_consume",
+			"date":       time.Now(),
+			"product_id": product_id,
+			"quantity":   consumed,
+			"unit_cost":  unit_cost,
+			"order_id":   order_id,
+		}
+		if batch_id != "" {
+			logs_data["batch_id"] = batch_id
+		}
+		if production_batch_id != "" {
+			logs_data["production_batch_id"] = production_batch_id
+		}
+
+		_, err := client.Database("waha").Collection("logs").InsertOne(ctx, logs_data)
 		return err
 	}
 
-	ready := product.Ready
+	remaining := quantity
+
+	for _, batch := range batches {
+		if remaining <= 0 {
+			break
+		}
+
+		consumed := math.Min(remaining, batch.Remaining)
 
-	if ready < quantity {
-		return customerrors.ErrInsufficientReady
+		_, err = client.Database("waha").Collection("production_batches").UpdateOne(
+			ctx,
+			bson.M{"id": batch.Id},
+			bson.M{"$inc": bson.M{"remaining": -consumed}},
+		)
+		if err != nil {
+			return cost, err
+		}
+
+		err = log_consumption(batch.Id, consumed, batch.UnitCost)
+		if err != nil {
+			return cost, err
+		}
+
+		cost += consumed * batch.UnitCost
+		remaining -= consumed
 	}
 
-	ready -= quantity
+	if remaining > 0 {
+		unit_cost, err := unbacked_unit_cost()
+		if err != nil {
+			return cost, err
+		}
+
+		err = log_consumption("", remaining, unit_cost)
+		if err != nil {
+			return cost, err
+		}
+
+		cost += remaining * unit_cost
+	}
 
 	_, err = client.Database("waha").Collection("recipes").UpdateOne(
 		context.Background(),
 		bson.M{"id": product_id},
 		bson.M{
-			"$set": bson.M{"ready": ready},
+			"$inc": bson.M{"ready": -quantity},
 		},
 	)
 
 	if err != nil {
-		return err
+		return cost, err
 	}
 
-	return nil
+	InvalidateAvailabilityCache()
+
+	return cost, nil
 }
 
 // FillRecipeDesign fills the recipe design for an order item with its product and sub products.
@@ -355,8 +530,37 @@ func (rs *RecipeService) GetRecipeMaterials(recipe_id string) (materials []model
 }
 
 // GetRecipeTree returns the recipe tree for a given recipe_id.
+//
+// The quantities of the materials and the sub products are the net quantities of the recipe,
+// their gross quantities after the trim loss are returned alongside them. The nutrition of
+// the product and of each sub product is rolled up from the materials.
 func (rs *RecipeService) GetRecipeTree(recipe_id string) (tree models.Product, err error) {
 
+	tree, err = rs.getRecipeTree(recipe_id, 0)
+	if err != nil {
+		return tree, err
+	}
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return tree, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = fillTreeNutrition(newNutritionCalculator(ctx, client), &tree)
+
+	return tree, err
+}
+
+// getRecipeTree returns the recipe tree of a sub product at the given depth, it stops with an error
+// when the sub products are nested deeper than allowed, which also stops the cycles saved before the validation.
+func (rs *RecipeService) getRecipeTree(recipe_id string, depth int) (tree models.Product, err error) {
+
+	if depth > maxRecipeDepth {
+		return tree, fmt.Errorf("%w: %s is nested deeper than %d levels", customerrors.ErrInvalidRecipe, recipe_id, maxRecipeDepth)
+	}
+
 	self_materials := []models.Material{}
 
 	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
@@ -398,28 +602,32 @@ func (rs *RecipeService) GetRecipeTree(recipe_id string) (tree models.Product, e
 
 		valid_entries := []models.MaterialEntry{}
 		for _, entry := range db_component.Entries {
-			if entry.Quantity > 0 {
+			if entry.Quantity > 0 && entry.IsUsable(time.Now()) {
 				valid_entries = append(valid_entries, entry)
 			}
 		}
 
 		self_materials = append(self_materials, models.Material{
-			Id:       material.Id,
-			Name:     db_component.Name,
-			Quantity: material.Quantity,
-			Entries:  valid_entries,
-			Unit:     db_component.Unit,
+			Id:            material.Id,
+			Name:          db_component.Name,
+			Quantity:      material.Quantity,
+			YieldPercent:  material.YieldPercent,
+			GrossQuantity: material.Gross(material.Quantity),
+			Entries:       valid_entries,
+			Unit:          db_component.Unit,
 		})
 	}
 
 	for _, sub_product := range recipe.SubProducts {
-		sub_recipe, err := rs.GetRecipeTree(sub_product.Id)
+		sub_recipe, err := rs.getRecipeTree(sub_product.Id, depth+1)
 		if err != nil {
 			return tree, err
 		}
 
 		//TODO - Fix Quantity vs Ready from Product
 		sub_recipe.Quantity = float64(sub_product.Quantity)
+		sub_recipe.YieldPercent = sub_product.YieldPercent
+		sub_recipe.GrossQuantity = sub_product.Gross(sub_product.Quantity)
 		tree.SubProducts = append(tree.SubProducts, sub_recipe)
 	}
 
@@ -467,196 +675,3 @@ func (rs *RecipeService) GetReadyNumber(recipe_id string) (ready float64, err er
 
 	return ready, nil
 }
-
-// CheckRecipesAvailability checks the availability of a list of recipes.
-// It returns a slice of RecipeAvailability with the available and ready number for each recipe.
-func (rs *RecipeService) CheckRecipesAvailability(recipe_ids []string) (availabilities []dto.RecipeAvailability, err error) {
-
-	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
-
-	// Create a context with a timeout (optional)
-	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
-	defer cancel()
-
-	// Connect to MongoDB
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Ping the database to check connectivity
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Connected successfully
-	rs.Logger.Info("Connected to MongoDB!")
-
-	coll := client.Database("waha").Collection("recipes")
-
-	availabilitiesChan := make(chan dto.RecipeAvailability)
-	errorChan := make(chan error)
-
-	var wg sync.WaitGroup
-
-	for _, recipe_id := range recipe_ids {
-
-		wg.Add(1)
-
-		go func(ch chan<- dto.RecipeAvailability, errorChan chan<- error, recipe_id string) {
-
-			defer wg.Done()
-
-			var recipe models.Product
-			err = coll.FindOne(ctx, bson.M{"id": recipe_id}).Decode(&recipe)
-			if err != nil {
-				errorChan <- err
-				return
-			}
-
-			var recipeAvailability dto.RecipeAvailability
-			recipeAvailability.ComponentRequirements = make(map[string]float64)
-
-			self_component_requirements := make(map[string]float64)
-			materials_inventory := make(map[string]float64)
-
-			var lowest_available float64
-
-			// subrecipes_components_requirements := make(map[string]float64)
-			// subrecipes_components_consumption := make(map[string]float64)
-
-			subrecipe_availability := []dto.RecipeAvailability{}
-
-			for _, material := range recipe.Materials {
-
-				self_component_requirements[material.Id] = float64(material.Quantity)
-				recipeAvailability.ComponentRequirements[material.Id] += self_component_requirements[material.Id]
-
-				materialService := MaterialService{
-					Logger: rs.Logger,
-					Config: rs.Config,
-				}
-
-				component_amount, err := materialService.GetComponentAvailability(material.Id)
-				if err != nil {
-					errorChan <- err
-					return
-				}
-
-				materials_inventory[material.Id] = float64(component_amount)
-			}
-
-			for _, product := range recipe.SubProducts {
-
-				self_component_requirements[product.Id] = float64(product.Quantity)
-				subrecipe_available, err := rs.CheckRecipesAvailability([]string{product.Id})
-
-				if err != nil {
-					errorChan <- err
-					return
-				}
-
-				subrecipe_availability = append(subrecipe_availability, dto.RecipeAvailability{
-					RecipeId:              product.Id,
-					Ready:                 subrecipe_available[0].Ready,
-					ComponentRequirements: subrecipe_available[0].ComponentRequirements,
-				})
-			}
-
-			satisfied := false
-			for _, sra := range subrecipe_availability {
-				for k, v := range sra.ComponentRequirements {
-					recipeAvailability.ComponentRequirements[k] += v * self_component_requirements[sra.RecipeId]
-				}
-			}
-
-			for !satisfied {
-				satisfied = true
-				temp_component_requirements := make(map[string]float64)
-				temp_component_inventory := make(map[string]float64)
-
-				for k, v := range recipeAvailability.ComponentRequirements {
-					temp_component_requirements[k] = v
-				}
-
-				for k, v := range materials_inventory {
-					temp_component_inventory[k] = v
-				}
-
-				for index, subrecipe := range subrecipe_availability {
-					if subrecipe.Ready > 0 {
-						satisfied = false
-
-						subrecipe_reminder := 0.0
-
-						if self_component_requirements[subrecipe.RecipeId] > subrecipe.Ready {
-							subrecipe_reminder = self_component_requirements[subrecipe.RecipeId] - subrecipe.Ready
-						}
-
-						for component_id, value := range subrecipe.ComponentRequirements {
-							if _, ok := temp_component_requirements[component_id]; ok {
-								temp_component_requirements[component_id] -= value * (self_component_requirements[subrecipe.RecipeId] - subrecipe_reminder)
-							}
-						}
-
-						subrecipe_availability[index].Ready -= self_component_requirements[subrecipe.RecipeId] - subrecipe_reminder
-					}
-				}
-
-				increase_availability := false
-				for k, v := range temp_component_requirements {
-					if temp_component_inventory[k] >= v {
-						temp_component_inventory[k] -= v
-						increase_availability = true
-					}
-				}
-
-				if increase_availability && !satisfied {
-					recipeAvailability.Available += 1
-					for k, v := range temp_component_inventory {
-						materials_inventory[k] = v
-					}
-				}
-
-			}
-
-			for index, ingredient := range recipe.Materials {
-
-				if index == 0 {
-					lowest_available = materials_inventory[ingredient.Id] / recipeAvailability.ComponentRequirements[ingredient.Id]
-				}
-
-				if materials_inventory[ingredient.Id]/recipeAvailability.ComponentRequirements[ingredient.Id] < lowest_available {
-					lowest_available = materials_inventory[ingredient.Id] / recipeAvailability.ComponentRequirements[ingredient.Id]
-				}
-			}
-
-			recipeAvailability.RecipeId = recipe_id
-			recipeAvailability.Available += lowest_available + recipe.Ready
-			recipeAvailability.Ready = recipe.Ready
-
-			availabilitiesChan <- recipeAvailability
-
-		}(availabilitiesChan, errorChan, recipe_id)
-
-	}
-
-	go func() {
-		wg.Wait()
-		close(availabilitiesChan)
-	}()
-
-	for {
-		select {
-		case availability, hasMore := <-availabilitiesChan:
-			if !hasMore {
-				return availabilities, err
-			}
-
-			availabilities = append(availabilities, availability)
-		case err := <-errorChan:
-			return availabilities, err
-		}
-	}
-}
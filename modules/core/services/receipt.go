@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"image/png"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +18,9 @@ import (
 	"github.com/elmawardy/nutrix/common/config"
 	"github.com/elmawardy/nutrix/common/logger"
 	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ReceiptService struct {
@@ -41,18 +45,70 @@ func (rs *ReceiptService) Print(order models.Order, discount float64, service_co
 		Logger:   rs.Logger,
 	}
 
+	localizer := NewCatalogLocalizer(lang_code, rs.Settings)
+
 	lang, err := lang_svc.GetLanguage(lang_code)
 	if err != nil {
 		return err
 	}
 
+	// the receipt falls back to the default language when there is no language pack for the code
+	if lang.Code == "" {
+		lang, err = lang_svc.GetLanguage(localizer.DefaultLangCode)
+		if err != nil {
+			return err
+		}
+		localizer.LangCode = localizer.DefaultLangCode
+	}
+
 	order_items := make([]map[string]interface{}, len(order.Items))
 	subtotal := 0
 
+	var calculator *nutritionCalculator
+	product_names := make(map[string]string)
+
+	if rs.Settings.ReceiptPrinter.IsPrintNutrition || !localizer.isDefault() {
+		client, db_ctx, db_cancel, err := connectDB(rs.Config)
+		if err != nil {
+			return err
+		}
+		defer db_cancel()
+
+		if rs.Settings.ReceiptPrinter.IsPrintNutrition {
+			calculator = newNutritionCalculator(db_ctx, client)
+		}
+
+		if !localizer.isDefault() {
+			product_names, err = localizedProductNames(db_ctx, client, localizer, order.Items)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	for _, item := range order.Items {
-		order_items = append(order_items,
-			map[string]interface{}{"name": item.Product.Name, "quantity": item.Quantity, "price": item.SalePrice * item.Quantity},
-		)
+		name, ok := product_names[item.Product.Id]
+		if !ok {
+			name = item.Product.Name
+		}
+
+		order_item := map[string]interface{}{"name": name, "quantity": item.Quantity, "price": item.SalePrice * item.Quantity}
+
+		if calculator != nil {
+			nutrition, ok, err := calculator.productById(item.Product.Id, 0)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				order_item["nutrition"] = map[string]interface{}{
+					"energy_kcal": fmt.Sprintf("%.0f", nutrition.Facts.EnergyKcal*item.Quantity),
+					"allergens":   strings.Join(nutrition.Allergens, ", "),
+				}
+			}
+		}
+
+		order_items = append(order_items, order_item)
 		subtotal += int(item.SalePrice) * int(item.Quantity)
 	}
 
@@ -75,6 +131,8 @@ func (rs *ReceiptService) Print(order models.Order, discount float64, service_co
 		"service_cost":   service_cost,
 		"total":          total,
 		"subtotal":       subtotal,
+		"t_energy":       lang.Pack["energy"],
+		"t_allergens":    lang.Pack["allergens"],
 	}
 
 	if order.IsDelivery {
@@ -90,6 +148,50 @@ func (rs *ReceiptService) Print(order models.Order, discount float64, service_co
 		data["is_delivery"] = false
 	}
 
+	// the gift card codes are bearer values, only their last digits are printed
+	if len(order.GiftCards) > 0 {
+		gift_cards := []map[string]interface{}{}
+		for _, gift_card := range order.GiftCards {
+			code := gift_card.Code
+			if len(code) > 4 {
+				code = "****" + code[len(code)-4:]
+			}
+			gift_cards = append(gift_cards, map[string]interface{}{"code": code, "amount": gift_card.Amount})
+		}
+
+		data["gift_cards"] = gift_cards
+		data["t_gift_card"] = lang.Pack["gift_card"]
+	}
+
+	// the points are read as they are after the order, the customer copy of the order doesn't hold them
+	if rs.Settings.Loyalty.IsEnabled && order.Customer.Id != "" {
+		client, db_ctx, db_cancel, err := connectDB(rs.Config)
+		if err != nil {
+			return err
+		}
+		defer db_cancel()
+
+		var customer models.Customer
+		err = client.Database("waha").Collection("customers").FindOne(db_ctx, bson.M{"id": order.Customer.Id}).Decode(&customer)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		if err == nil {
+			loyalty := map[string]interface{}{
+				"t_points_balance": lang.Pack["points_balance"],
+				"points_balance":   fmt.Sprintf("%.0f", customer.Points),
+			}
+
+			if order.Loyalty != nil && order.Loyalty.RedeemedPoints > 0 {
+				loyalty["t_points_redeemed"] = lang.Pack["points_redeemed"]
+				loyalty["points_redeemed"] = fmt.Sprintf("%.0f", order.Loyalty.RedeemedPoints)
+			}
+
+			data["loyalty"] = loyalty
+		}
+	}
+
 	output, err := mustache.RenderFile(template, data)
 	if err != nil {
 		return err
@@ -153,3 +255,35 @@ func (rs *ReceiptService) Print(order models.Order, discount float64, service_co
 
 	return nil
 }
+
+// localizedProductNames returns the names of the products of the order items in the language of the localizer by product id.
+func localizedProductNames(ctx context.Context, client *mongo.Client, localizer CatalogLocalizer, items []models.OrderItem) (map[string]string, error) {
+
+	names := make(map[string]string)
+
+	product_ids := make([]string, 0, len(items))
+	for _, item := range items {
+		product_ids = append(product_ids, item.Product.Id)
+	}
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(
+		ctx,
+		bson.M{"id": bson.M{"$in": product_ids}},
+		options.Find().SetProjection(bson.M{"id": 1, "name": 1, "translations": 1}),
+	)
+	if err != nil {
+		return names, err
+	}
+
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return names, err
+	}
+
+	for _, product := range products {
+		localizer.LocalizeProduct(&product)
+		names[product.Id] = product.Name
+	}
+
+	return names, nil
+}
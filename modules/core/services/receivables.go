@@ -0,0 +1,592 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cbroglie/mustache"
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// receivableTolerance is the largest amount of a charge that's considered settled, the amounts are floats.
+const receivableTolerance = 1e-6
+
+// Statement formats.
+const (
+	StatementFormatJSON = "json"
+	StatementFormatHTML = "html"
+	StatementFormatPDF  = "pdf"
+)
+
+// statementLabels are the language pack keys of the labels shown on the customer statements.
+var statementLabels = []string{"statement", "date", "order", "amount", "balance", "opening_balance", "closing_balance",
+	"charge", "payment", "reversal", "credit_limit", "aging_current", "aging_31_60", "aging_over_60", "total", "egp"}
+
+// ReceivablesService keeps the accounts of the customers, the pay-later orders are charged to them
+// and the payments are posted against their balances.
+type ReceivablesService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// GetReceivablesParams is the struct to hold the parameters for the GetReceivables method.
+type GetReceivablesParams struct {
+	CustomerId string
+	PageNumber int
+	PageSize   int
+}
+
+// owedCharge is a charge of an account with the amount of it that's still owed.
+type owedCharge struct {
+	entry models.ReceivableEntry
+	owed  float64
+}
+
+// postReceivable changes the balance of the account by the amount of the entry and records it with the balance after it.
+// A charge is refused when it would take the balance over the credit limit of the customer.
+func postReceivable(ctx context.Context, client *mongo.Client, entry models.ReceivableEntry) (models.ReceivableEntry, error) {
+
+	customers := client.Database("waha").Collection("customers")
+
+	filter := bson.M{"id": entry.CustomerId}
+	if entry.Amount > 0 {
+		filter["$or"] = []bson.M{
+			{"credit_limit": nil},
+			{"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$balance", 0}}, entry.Amount}},
+				bson.M{"$add": bson.A{"$credit_limit", receivableTolerance}},
+			}}},
+		}
+	}
+
+	var customer models.Customer
+	err := customers.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"balance": entry.Amount}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&customer)
+
+	if err == mongo.ErrNoDocuments {
+		err = customers.FindOne(ctx, bson.M{"id": entry.CustomerId}).Decode(&customer)
+		if err == mongo.ErrNoDocuments {
+			return entry, fmt.Errorf("%w: %s", customerrors.ErrCustomerNotFound, entry.CustomerId)
+		}
+		if err != nil {
+			return entry, err
+		}
+
+		return entry, fmt.Errorf("%w: %s owes %.2f of a %.2f limit, the charge is %.2f",
+			customerrors.ErrCreditLimitExceeded, customer.Name, customer.Balance, *customer.CreditLimit, entry.Amount)
+	}
+	if err != nil {
+		return entry, err
+	}
+
+	entry.Id = primitive.NewObjectID().Hex()
+	entry.Balance = customer.Balance
+	if entry.Date.IsZero() {
+		entry.Date = time.Now()
+	}
+
+	_, err = client.Database("waha").Collection("receivables").InsertOne(ctx, entry)
+	if err != nil {
+		// the balance is only changed along with its entry
+		_, revert_err := customers.UpdateOne(ctx, bson.M{"id": entry.CustomerId}, bson.M{"$inc": bson.M{"balance": -entry.Amount}})
+		if revert_err != nil {
+			return entry, revert_err
+		}
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// chargeOrder charges what the gift cards don't pay of a pay-later order to the account of its customer. The stashed
+// orders, the orders without a customer, and the ones that are paid or free aren't charged.
+func chargeOrder(ctx context.Context, client *mongo.Client, order models.Order) (charged bool, err error) {
+
+	amount := order.SalePrice - giftCardsTotal(order)
+
+	if !order.IsPayLater || order.IsPaid || order.Customer.Id == "" || order.State == "stashed" || amount <= receivableTolerance {
+		return false, nil
+	}
+
+	_, err = postReceivable(ctx, client, models.ReceivableEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           models.ReceivableTypeCharge,
+		Date:           order.SubmittedAt,
+		Amount:         amount,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+	})
+
+	return err == nil, err
+}
+
+// settleOrder posts what's still owed of the charge of the order as a payment when it's paid. When it's cancelled
+// the whole charge that wasn't reversed yet is reversed, the payments the charge was settled by are allocated again
+// to the other charges of the account. It does nothing when the order wasn't charged to an account.
+func settleOrder(ctx context.Context, client *mongo.Client, order models.Order, entry_type string) error {
+
+	if order.Customer.Id == "" {
+		return nil
+	}
+
+	entries, err := loadReceivables(ctx, client, bson.M{"customer_id": order.Customer.Id})
+	if err != nil {
+		return err
+	}
+
+	owed := 0.0
+	if entry_type == models.ReceivableTypeReversal {
+		for _, entry := range entries {
+			if entry.OrderId == order.Id && (entry.Type == models.ReceivableTypeCharge || entry.Type == models.ReceivableTypeReversal) {
+				owed += entry.Amount
+			}
+		}
+	} else {
+		for _, charge := range allocateCredits(entries) {
+			if charge.entry.OrderId == order.Id {
+				owed += charge.owed
+			}
+		}
+	}
+
+	if owed <= receivableTolerance {
+		return nil
+	}
+
+	_, err = postReceivable(ctx, client, models.ReceivableEntry{
+		CustomerId:     order.Customer.Id,
+		Type:           entry_type,
+		Amount:         -owed,
+		OrderId:        order.Id,
+		OrderDisplayId: order.DisplayId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return markSettledOrders(ctx, client, order.Customer.Id)
+}
+
+// loadReceivables returns the entries matching the filter in date order.
+func loadReceivables(ctx context.Context, client *mongo.Client, filter bson.M) (entries []models.ReceivableEntry, err error) {
+
+	cursor, err := client.Database("waha").Collection("receivables").Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "id", Value: 1}}))
+	if err != nil {
+		return entries, err
+	}
+
+	entries = []models.ReceivableEntry{}
+	err = cursor.All(ctx, &entries)
+
+	return entries, err
+}
+
+// allocateCredits settles the charges with the payments and the reversals of an account. The credits of an order
+// settle its charge first, the rest of them settle the oldest charges first. It returns the charges in date order
+// with the amounts still owed.
+func allocateCredits(entries []models.ReceivableEntry) []owedCharge {
+
+	charges := []owedCharge{}
+	by_order := make(map[string]int)
+	pool := 0.0
+
+	for _, entry := range entries {
+		if entry.Amount > 0 {
+			if entry.OrderId != "" {
+				by_order[entry.OrderId] = len(charges)
+			}
+			charges = append(charges, owedCharge{entry: entry, owed: entry.Amount})
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Amount >= 0 {
+			continue
+		}
+
+		credit := -entry.Amount
+
+		if index, ok := by_order[entry.OrderId]; ok && entry.OrderId != "" {
+			settled := math.Min(credit, charges[index].owed)
+			charges[index].owed -= settled
+			credit -= settled
+		}
+
+		pool += credit
+	}
+
+	for index := range charges {
+		if pool <= 0 {
+			break
+		}
+
+		settled := math.Min(pool, charges[index].owed)
+		charges[index].owed -= settled
+		pool -= settled
+	}
+
+	return charges
+}
+
+// agingBuckets sums the amounts still owed of the charges by their age at the date.
+func agingBuckets(charges []owedCharge, as_of time.Time) (buckets dto.AgingBuckets, oldest *time.Time) {
+
+	for _, charge := range charges {
+		if charge.owed <= receivableTolerance {
+			continue
+		}
+
+		age_days := as_of.Sub(charge.entry.Date).Hours() / 24
+
+		switch {
+		case age_days <= 30:
+			buckets.Current += charge.owed
+		case age_days <= 60:
+			buckets.Days31To60 += charge.owed
+		default:
+			buckets.Over60 += charge.owed
+		}
+
+		buckets.Total += charge.owed
+
+		if oldest == nil {
+			date := charge.entry.Date
+			oldest = &date
+		}
+	}
+
+	return buckets, oldest
+}
+
+// markSettledOrders marks the pay-later orders whose charges are settled as paid, the cancelled ones are left as they are.
+func markSettledOrders(ctx context.Context, client *mongo.Client, customer_id string) error {
+
+	entries, err := loadReceivables(ctx, client, bson.M{"customer_id": customer_id})
+	if err != nil {
+		return err
+	}
+
+	settled := []string{}
+	for _, charge := range allocateCredits(entries) {
+		if charge.entry.OrderId != "" && charge.owed <= receivableTolerance {
+			settled = append(settled, charge.entry.OrderId)
+		}
+	}
+
+	if len(settled) == 0 {
+		return nil
+	}
+
+	_, err = client.Database("waha").Collection("orders").UpdateMany(ctx,
+		bson.M{"id": bson.M{"$in": settled}, "is_paid": false, "state": bson.M{"$ne": "cancelled"}},
+		bson.M{"$set": bson.M{"is_paid": true}},
+	)
+
+	return err
+}
+
+// PostPayment posts a payment against the balance of the customer, and marks the orders it settles as paid.
+func (rs *ReceivablesService) PostPayment(customer_id string, payment dto.CustomerPayment) (entry models.ReceivableEntry, err error) {
+
+	if payment.Amount <= 0 {
+		return entry, fmt.Errorf("%w: the amount must be positive", customerrors.ErrInvalidPayment)
+	}
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return entry, err
+	}
+	defer cancel()
+	// connected to db
+
+	entry = models.ReceivableEntry{
+		CustomerId: customer_id,
+		Type:       models.ReceivableTypePayment,
+		Amount:     -payment.Amount,
+		Method:     payment.Method,
+		Comment:    payment.Comment,
+	}
+
+	if payment.OrderId != "" {
+		var order models.Order
+		err = client.Database("waha").Collection("orders").FindOne(ctx, bson.M{"id": payment.OrderId, "customer.id": customer_id}).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			return entry, fmt.Errorf("%w: order %s isn't an order of the customer", customerrors.ErrInvalidPayment, payment.OrderId)
+		}
+		if err != nil {
+			return entry, err
+		}
+
+		entry.OrderId = order.Id
+		entry.OrderDisplayId = order.DisplayId
+	}
+
+	entry, err = postReceivable(ctx, client, entry)
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, markSettledOrders(ctx, client, customer_id)
+}
+
+// GetReceivables returns a page of the entries of the account of the customer, the newest first.
+func (rs *ReceivablesService) GetReceivables(params GetReceivablesParams) (entries []models.ReceivableEntry, entries_count int, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return entries, entries_count, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": params.CustomerId}).Err()
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	collection := client.Database("waha").Collection("receivables")
+	filter := bson.M{"customer_id": params.CustomerId}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "id", Value: -1}})
+	findOptions.SetSkip(int64((params.PageNumber - 1) * params.PageSize))
+	findOptions.SetLimit(int64(params.PageSize))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	entries = []models.ReceivableEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return entries, entries_count, err
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return entries, entries_count, err
+	}
+
+	return entries, int(count), nil
+}
+
+// GetStatement returns the statement of the account of the customer between the dates.
+func (rs *ReceivablesService) GetStatement(customer_id string, from time.Time, to time.Time) (statement dto.CustomerStatement, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return statement, err
+	}
+	defer cancel()
+	// connected to db
+
+	err = client.Database("waha").Collection("customers").FindOne(ctx, bson.M{"id": customer_id}).Decode(&statement.Customer)
+	if err != nil {
+		return statement, err
+	}
+	statement.Customer.Stats = nil
+
+	entries, err := loadReceivables(ctx, client, bson.M{"customer_id": customer_id, "date": bson.M{"$lte": to}})
+	if err != nil {
+		return statement, err
+	}
+
+	statement.From = from
+	statement.To = to
+	statement.Entries = []models.ReceivableEntry{}
+
+	for _, entry := range entries {
+		if entry.Date.Before(from) {
+			statement.OpeningBalance += entry.Amount
+			continue
+		}
+
+		statement.Entries = append(statement.Entries, entry)
+	}
+
+	statement.ClosingBalance = statement.OpeningBalance
+	for index, entry := range statement.Entries {
+		statement.ClosingBalance += entry.Amount
+		// the balances are restated in the period, the entries of the same time may be recorded in any order
+		statement.Entries[index].Balance = statement.ClosingBalance
+	}
+
+	statement.Aging, _ = agingBuckets(allocateCredits(entries), to)
+
+	return statement, nil
+}
+
+// GetAgingReport returns the balances of the customer accounts as of the date by the age of their charges:
+// 0 to 30 days, 31 to 60 days and over 60 days. The accounts without a balance are left out.
+func (rs *ReceivablesService) GetAgingReport(as_of time.Time) (report dto.AgingReport, err error) {
+
+	client, ctx, cancel, err := connectDB(rs.Config)
+	if err != nil {
+		return report, err
+	}
+	defer cancel()
+	// connected to db
+
+	report = dto.AgingReport{AsOf: as_of, Customers: []dto.CustomerAging{}}
+
+	entries, err := loadReceivables(ctx, client, bson.M{"date": bson.M{"$lte": as_of}})
+	if err != nil {
+		return report, err
+	}
+
+	by_customer := make(map[string][]models.ReceivableEntry)
+	for _, entry := range entries {
+		by_customer[entry.CustomerId] = append(by_customer[entry.CustomerId], entry)
+	}
+
+	customer_ids := make([]string, 0, len(by_customer))
+	for customer_id := range by_customer {
+		customer_ids = append(customer_ids, customer_id)
+	}
+
+	cursor, err := client.Database("waha").Collection("customers").Find(ctx, bson.M{"id": bson.M{"$in": customer_ids}})
+	if err != nil {
+		return report, err
+	}
+
+	var customers []models.Customer
+	if err := cursor.All(ctx, &customers); err != nil {
+		return report, err
+	}
+
+	customers_by_id := make(map[string]models.Customer, len(customers))
+	for _, customer := range customers {
+		customers_by_id[customer.Id] = customer
+	}
+
+	for customer_id, customer_entries := range by_customer {
+
+		aging := dto.CustomerAging{CustomerId: customer_id}
+		if customer, ok := customers_by_id[customer_id]; ok {
+			aging.CustomerName = customer.Name
+			aging.Phone = customer.Phone
+			aging.CreditLimit = customer.CreditLimit
+		}
+
+		for _, entry := range customer_entries {
+			aging.Balance += entry.Amount
+		}
+
+		if math.Abs(aging.Balance) <= receivableTolerance {
+			continue
+		}
+
+		aging.AgingBuckets, aging.OldestCharge = agingBuckets(allocateCredits(customer_entries), as_of)
+
+		report.Totals.Current += aging.Current
+		report.Totals.Days31To60 += aging.Days31To60
+		report.Totals.Over60 += aging.Over60
+		report.Totals.Total += aging.Total
+
+		report.Customers = append(report.Customers, aging)
+	}
+
+	sort.Slice(report.Customers, func(i, j int) bool {
+		if report.Customers[i].Balance != report.Customers[j].Balance {
+			return report.Customers[i].Balance > report.Customers[j].Balance
+		}
+		return report.Customers[i].CustomerName < report.Customers[j].CustomerName
+	})
+
+	return report, nil
+}
+
+// RenderStatement renders the statement as an html page or a pdf document in the language.
+func (rs *ReceivablesService) RenderStatement(statement dto.CustomerStatement, format string, lang_code string) ([]byte, error) {
+
+	lang_svc := LanguageService{
+		Config:   rs.Config,
+		Settings: rs.Settings,
+		Logger:   rs.Logger,
+	}
+
+	lang, err := lang_svc.GetLanguage(lang_code)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := lang.Orientation
+	if direction == "" {
+		direction = "ltr"
+	}
+
+	labels := make(map[string]string)
+	for _, key := range statementLabels {
+		if label, ok := lang.Pack[key].(string); ok {
+			// the singular of the pluralized labels
+			labels[key] = strings.TrimSpace(strings.Split(label, "|")[0])
+		} else {
+			labels[key] = key
+		}
+	}
+
+	location := menuLocation(rs.Config)
+
+	entries := []map[string]interface{}{}
+	for _, entry := range statement.Entries {
+		entries = append(entries, map[string]interface{}{
+			"date":     entry.Date.In(location).Format("2006-01-02 15:04"),
+			"type":     labels[entry.Type],
+			"order":    entry.OrderDisplayId,
+			"comment":  entry.Comment,
+			"amount":   fmt.Sprintf("%.2f", entry.Amount),
+			"balance":  fmt.Sprintf("%.2f", entry.Balance),
+			"is_debit": entry.Amount > 0,
+		})
+	}
+
+	data := map[string]interface{}{
+		"direction":       direction,
+		"labels":          labels,
+		"customer_name":   statement.Customer.Name,
+		"customer_phone":  statement.Customer.Phone,
+		"from":            statement.From.In(location).Format("2006-01-02"),
+		"to":              statement.To.In(location).Format("2006-01-02"),
+		"opening_balance": fmt.Sprintf("%.2f", statement.OpeningBalance),
+		"closing_balance": fmt.Sprintf("%.2f", statement.ClosingBalance),
+		"entries":         entries,
+		"aging_current":   fmt.Sprintf("%.2f", statement.Aging.Current),
+		"aging_31_60":     fmt.Sprintf("%.2f", statement.Aging.Days31To60),
+		"aging_over_60":   fmt.Sprintf("%.2f", statement.Aging.Over60),
+		"aging_total":     fmt.Sprintf("%.2f", statement.Aging.Total),
+	}
+
+	if statement.Customer.CreditLimit != nil {
+		data["credit_limit"] = fmt.Sprintf("%.2f", *statement.Customer.CreditLimit)
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := mustache.RenderFile(pwd+"/modules/core/templates/statement_0.mustache", data)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == StatementFormatPDF {
+		return printPDF(output)
+	}
+
+	return []byte(output), nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxRecipeDepth is the maximum number of nested sub product levels in a recipe.
+const maxRecipeDepth = 8
+
+// recipeGraph holds all the recipes and the ids of all the materials, it's used to check the references between them.
+type recipeGraph struct {
+	recipes   map[string]models.Product
+	materials map[string]bool
+}
+
+// loadRecipeGraph loads the recipes and the material ids from the database.
+func loadRecipeGraph(ctx context.Context, client *mongo.Client) (graph recipeGraph, err error) {
+
+	graph = recipeGraph{
+		recipes:   make(map[string]models.Product),
+		materials: make(map[string]bool),
+	}
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{})
+	if err != nil {
+		return graph, err
+	}
+
+	var recipes []models.Product
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return graph, err
+	}
+
+	for _, recipe := range recipes {
+		graph.recipes[recipe.Id] = recipe
+	}
+
+	cursor, err = client.Database("waha").Collection("materials").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return graph, err
+	}
+
+	var materials []models.Material
+	if err := cursor.All(ctx, &materials); err != nil {
+		return graph, err
+	}
+
+	for _, material := range materials {
+		graph.materials[material.Id] = true
+	}
+
+	return graph, nil
+}
+
+// walk goes through the sub products of the recipe depth first. It returns the chain of recipe ids
+// of the first cycle it finds, or the number of nested sub product levels under the recipe.
+// The depths of the recipes already walked are kept in depths, the missing recipes are skipped.
+func (g recipeGraph) walk(recipe_id string, path []string, depths map[string]int) (cycle []string, depth int) {
+
+	for index, ancestor := range path {
+		if ancestor == recipe_id {
+			cycle = append(cycle, path[index:]...)
+			return append(cycle, recipe_id), 0
+		}
+	}
+
+	if known, ok := depths[recipe_id]; ok {
+		return nil, known
+	}
+
+	recipe, ok := g.recipes[recipe_id]
+	if !ok {
+		return nil, 0
+	}
+
+	path = append(path, recipe_id)
+
+	for _, sub_product := range recipe.SubProducts {
+		cycle, sub_depth := g.walk(sub_product.Id, path, depths)
+		if cycle != nil {
+			return cycle, 0
+		}
+
+		depth = max(depth, sub_depth+1)
+	}
+
+	depths[recipe_id] = depth
+
+	return nil, depth
+}
+
+// validateRecipeGraph checks that the recipe only references existing materials and products,
+// doesn't include itself directly or through its sub products, and isn't nested deeper than maxRecipeDepth.
+func validateRecipeGraph(ctx context.Context, client *mongo.Client, recipe models.Product) error {
+
+	graph, err := loadRecipeGraph(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	// the recipe is checked as it will be saved
+	graph.recipes[recipe.Id] = recipe
+
+	for _, material := range recipe.Materials {
+		if !graph.materials[material.Id] {
+			return fmt.Errorf("%w: material %s doesn't exist", customerrors.ErrInvalidRecipe, material.Id)
+		}
+	}
+
+	for _, sub_product := range recipe.SubProducts {
+		if _, ok := graph.recipes[sub_product.Id]; !ok {
+			return fmt.Errorf("%w: sub product %s doesn't exist", customerrors.ErrInvalidRecipe, sub_product.Id)
+		}
+	}
+
+	cycle, depth := graph.walk(recipe.Id, nil, make(map[string]int))
+	if cycle != nil {
+		return fmt.Errorf("%w: sub products form a cycle %s", customerrors.ErrInvalidRecipe, strings.Join(cycle, " -> "))
+	}
+
+	if depth > maxRecipeDepth {
+		return fmt.Errorf("%w: sub products are nested %d levels deep, the maximum is %d", customerrors.ErrInvalidRecipe, depth, maxRecipeDepth)
+	}
+
+	return nil
+}
+
+// CheckRecipesIntegrity scans all the recipes and returns their problems: cycles, references to missing
+// materials or products, nesting deeper than allowed and yield percentages out of range.
+func (rs *RecipeService) CheckRecipesIntegrity() (problems []dto.RecipeIntegrityProblem, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return problems, err
+	}
+
+	graph, err := loadRecipeGraph(ctx, client)
+	if err != nil {
+		return problems, err
+	}
+
+	problems = make([]dto.RecipeIntegrityProblem, 0)
+
+	recipes := make([]models.Product, 0, len(graph.recipes))
+	for _, recipe := range graph.recipes {
+		recipes = append(recipes, recipe)
+	}
+	sort.Slice(recipes, func(i, j int) bool {
+		return recipes[i].Name < recipes[j].Name
+	})
+
+	depths := make(map[string]int)
+
+	for _, recipe := range recipes {
+
+		problem := func(problem string, details string) dto.RecipeIntegrityProblem {
+			return dto.RecipeIntegrityProblem{
+				RecipeId:   recipe.Id,
+				RecipeName: recipe.Name,
+				Problem:    problem,
+				Details:    details,
+			}
+		}
+
+		for _, material := range recipe.Materials {
+			if !graph.materials[material.Id] {
+				problems = append(problems, problem(dto.RecipeProblemMissingMaterial, fmt.Sprintf("material %s doesn't exist", material.Id)))
+			}
+		}
+
+		for _, sub_product := range recipe.SubProducts {
+			if _, ok := graph.recipes[sub_product.Id]; !ok {
+				problems = append(problems, problem(dto.RecipeProblemMissingProduct, fmt.Sprintf("sub product %s doesn't exist", sub_product.Id)))
+			}
+		}
+
+		if err := validateRecipeYields(recipe); err != nil {
+			problems = append(problems, problem(dto.RecipeProblemInvalidYield, err.Error()))
+		}
+
+		cycle, depth := graph.walk(recipe.Id, nil, depths)
+
+		// the cycle is reported by the recipes forming it, not by the ones including them
+		if cycle != nil && cycle[0] == recipe.Id {
+			cycle_problem := problem(dto.RecipeProblemCycle, fmt.Sprintf("sub products form a cycle %s", strings.Join(cycle, " -> ")))
+			cycle_problem.Path = cycle
+			problems = append(problems, cycle_problem)
+		}
+
+		if cycle == nil && depth > maxRecipeDepth {
+			problems = append(problems, problem(dto.RecipeProblemTooDeep, fmt.Sprintf("sub products are nested %d levels deep, the maximum is %d", depth, maxRecipeDepth)))
+		}
+	}
+
+	return problems, nil
+}
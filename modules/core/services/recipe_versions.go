@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// insertRecipeVersion stores the snapshot of the recipe as its current version.
+func insertRecipeVersion(ctx context.Context, client *mongo.Client, recipe models.Product, version models.RecipeVersion) (models.RecipeVersion, error) {
+
+	version.Id = primitive.NewObjectID().Hex()
+	version.RecipeId = recipe.Id
+	version.Version = recipe.Version
+	version.CreatedAt = time.Now()
+	version.Recipe = recipe
+
+	_, err := client.Database("waha").Collection("recipe_versions").InsertOne(ctx, version)
+
+	return version, err
+}
+
+// commitRecipe applies the changes to the recipe, increments its version and stores the snapshot of the new version.
+func commitRecipe(ctx context.Context, client *mongo.Client, recipe_id string, set bson.M, version models.RecipeVersion) (recipe models.Product, err error) {
+
+	err = client.Database("waha").Collection("recipes").FindOneAndUpdate(
+		ctx,
+		bson.M{"id": recipe_id},
+		bson.M{
+			"$set": set,
+			"$inc": bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&recipe)
+	if err != nil {
+		return recipe, err
+	}
+
+	InvalidateAvailabilityCache()
+
+	_, err = insertRecipeVersion(ctx, client, recipe, version)
+
+	return recipe, err
+}
+
+// stampRecipeVersions sets the current version of their recipes on the items and their sub items.
+func stampRecipeVersions(ctx context.Context, client *mongo.Client, items []models.OrderItem) error {
+
+	for index, item := range items {
+
+		var recipe models.Product
+		err := client.Database("waha").Collection("recipes").FindOne(
+			ctx,
+			bson.M{"id": item.Product.Id},
+			options.FindOne().SetProjection(bson.M{"version": 1}),
+		).Decode(&recipe)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		items[index].RecipeVersion = recipe.Version
+
+		err = stampRecipeVersions(ctx, client, item.SubItems)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRecipeVersions returns the versions of a recipe, the newest first.
+func (rs *RecipeService) GetRecipeVersions(recipe_id string) (versions []models.RecipeVersion, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return versions, err
+	}
+
+	versions = make([]models.RecipeVersion, 0)
+
+	cursor, err := client.Database("waha").Collection("recipe_versions").Find(
+		ctx,
+		bson.M{"recipe_id": recipe_id},
+		options.Find().SetSort(bson.M{"version": -1}),
+	)
+	if err != nil {
+		return versions, err
+	}
+
+	err = cursor.All(ctx, &versions)
+
+	return versions, err
+}
+
+// GetRecipeVersion returns a single version of a recipe.
+func (rs *RecipeService) GetRecipeVersion(recipe_id string, version_number int) (version models.RecipeVersion, err error) {
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return version, err
+	}
+
+	err = client.Database("waha").Collection("recipe_versions").FindOne(ctx, bson.M{"recipe_id": recipe_id, "version": version_number}).Decode(&version)
+
+	return version, err
+}
+
+// DiffRecipeVersions returns the changes of a recipe from a version to another,
+// the stock of the recipe (ready and quantity) isn't part of the diff.
+func (rs *RecipeService) DiffRecipeVersions(recipe_id string, from int, to int) (diff dto.RecipeVersionDiff, err error) {
+
+	from_version, err := rs.GetRecipeVersion(recipe_id, from)
+	if err != nil {
+		return diff, err
+	}
+
+	to_version, err := rs.GetRecipeVersion(recipe_id, to)
+	if err != nil {
+		return diff, err
+	}
+
+	diff = dto.RecipeVersionDiff{
+		RecipeId:    recipe_id,
+		From:        from,
+		To:          to,
+		Fields:      make([]dto.RecipeFieldChange, 0),
+		Materials:   make([]dto.RecipeComponentChange, 0),
+		SubProducts: make([]dto.RecipeComponentChange, 0),
+	}
+
+	old_recipe, new_recipe := from_version.Recipe, to_version.Recipe
+
+	fields := []dto.RecipeFieldChange{
+		{Field: "name", From: old_recipe.Name, To: new_recipe.Name},
+		{Field: "price", From: old_recipe.Price, To: new_recipe.Price},
+		{Field: "unit", From: old_recipe.Unit, To: new_recipe.Unit},
+		{Field: "image_url", From: old_recipe.ImageURL, To: new_recipe.ImageURL},
+		{Field: "shelf_life_hours", From: old_recipe.ShelfLifeHours, To: new_recipe.ShelfLifeHours},
+	}
+	for _, field := range fields {
+		if field.From != field.To {
+			diff.Fields = append(diff.Fields, field)
+		}
+	}
+
+	old_materials := make(map[string]models.Material)
+	for _, material := range old_recipe.Materials {
+		old_materials[material.Id] = material
+	}
+
+	new_materials := make(map[string]models.Material)
+	for _, material := range new_recipe.Materials {
+		new_materials[material.Id] = material
+
+		old, ok := old_materials[material.Id]
+		if !ok {
+			diff.Materials = append(diff.Materials, dto.RecipeComponentChange{Id: material.Id, Change: "added", ToQuantity: material.Quantity, ToYieldPercent: material.YieldPercent})
+			continue
+		}
+
+		if old.Quantity != material.Quantity || old.YieldPercent != material.YieldPercent {
+			diff.Materials = append(diff.Materials, dto.RecipeComponentChange{
+				Id:               material.Id,
+				Change:           "changed",
+				FromQuantity:     old.Quantity,
+				ToQuantity:       material.Quantity,
+				FromYieldPercent: old.YieldPercent,
+				ToYieldPercent:   material.YieldPercent,
+			})
+		}
+	}
+
+	for _, material := range old_recipe.Materials {
+		if _, ok := new_materials[material.Id]; !ok {
+			diff.Materials = append(diff.Materials, dto.RecipeComponentChange{Id: material.Id, Change: "removed", FromQuantity: material.Quantity, FromYieldPercent: material.YieldPercent})
+		}
+	}
+
+	old_sub_products := make(map[string]models.Product)
+	for _, sub_product := range old_recipe.SubProducts {
+		old_sub_products[sub_product.Id] = sub_product
+	}
+
+	new_sub_products := make(map[string]models.Product)
+	for _, sub_product := range new_recipe.SubProducts {
+		new_sub_products[sub_product.Id] = sub_product
+
+		old, ok := old_sub_products[sub_product.Id]
+		if !ok {
+			diff.SubProducts = append(diff.SubProducts, dto.RecipeComponentChange{Id: sub_product.Id, Change: "added", ToQuantity: sub_product.Quantity, ToYieldPercent: sub_product.YieldPercent})
+			continue
+		}
+
+		if old.Quantity != sub_product.Quantity || old.YieldPercent != sub_product.YieldPercent {
+			diff.SubProducts = append(diff.SubProducts, dto.RecipeComponentChange{
+				Id:               sub_product.Id,
+				Change:           "changed",
+				FromQuantity:     old.Quantity,
+				ToQuantity:       sub_product.Quantity,
+				FromYieldPercent: old.YieldPercent,
+				ToYieldPercent:   sub_product.YieldPercent,
+			})
+		}
+	}
+
+	for _, sub_product := range old_recipe.SubProducts {
+		if _, ok := new_sub_products[sub_product.Id]; !ok {
+			diff.SubProducts = append(diff.SubProducts, dto.RecipeComponentChange{Id: sub_product.Id, Change: "removed", FromQuantity: sub_product.Quantity, FromYieldPercent: sub_product.YieldPercent})
+		}
+	}
+
+	return diff, nil
+}
+
+// RollbackRecipe restores the recipe of an earlier version as a new version, the ready stock
+// and the images of the product are kept.
+func (rs *RecipeService) RollbackRecipe(recipe_id string, version_number int, author string) (recipe models.Product, err error) {
+
+	version, err := rs.GetRecipeVersion(recipe_id, version_number)
+	if err != nil {
+		return recipe, err
+	}
+
+	err = validateRecipeYields(version.Recipe)
+	if err != nil {
+		return recipe, err
+	}
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1000*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return recipe, err
+	}
+
+	// the materials and sub products of the version may have been deleted or changed since
+	version.Recipe.Id = recipe_id
+	err = validateRecipeGraph(ctx, client, version.Recipe)
+	if err != nil {
+		return recipe, err
+	}
+
+	price, err := currentPrice(ctx, client, recipe_id)
+	if err != nil {
+		return recipe, err
+	}
+
+	recipe, err = commitRecipe(
+		ctx,
+		client,
+		recipe_id,
+		bson.M{
+			"name":             version.Recipe.Name,
+			"materials":        version.Recipe.Materials,
+			"sub_products":     version.Recipe.SubProducts,
+			"price":            version.Recipe.Price,
+			"unit":             version.Recipe.Unit,
+			"shelf_life_hours": version.Recipe.ShelfLifeHours,
+			"schedule":         version.Recipe.Schedule,
+		},
+		models.RecipeVersion{
+			Action:         models.RecipeVersionActionRollback,
+			Author:         author,
+			RolledBackFrom: version_number,
+		},
+	)
+	if err != nil {
+		return recipe, err
+	}
+
+	if recipe.Price != price {
+		err = recordPrice(ctx, client, recipe_id, recipe.Price, author)
+	}
+
+	return recipe, err
+}
@@ -0,0 +1,287 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultReorderLookbackDays = 28
+	defaultReorderReviewDays   = 7
+	defaultLeadTimeDays        = 2
+)
+
+// ReorderService computes the consumption velocity of materials and suggests
+// what to buy based on the supplier lead time of each material.
+type ReorderService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// GetReorderSuggestionsParams is the struct to hold the parameters for the GetReorderSuggestions method.
+type GetReorderSuggestionsParams struct {
+	// LookbackDays is the number of past days of consumption logs to compute the usage from,
+	// the inventory settings value is used when it's zero.
+	LookbackDays int
+	// ByWeekday forecasts the lead time demand using the average usage of each weekday
+	// instead of the flat daily average.
+	ByWeekday bool
+	// OnlyNeeded filters out the materials that don't need to be reordered.
+	OnlyNeeded bool
+}
+
+// materialDailyUsage holds the consumed quantity of a material per local day (2006-01-02).
+type materialDailyUsage map[string]float64
+
+// GetMaterialsDailyUsage aggregates the component_consume logs since the given time,
+// and returns the consumed quantity per material per day in the configured time zone.
+func (rs *ReorderService) GetMaterialsDailyUsage(since time.Time) (usage map[string]materialDailyUsage, err error) {
+
+	usage = make(map[string]materialDailyUsage)
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", rs.Config.Databases[0].Host, rs.Config.Databases[0].Port))
+
+	deadline := 5 * time.Second
+	if rs.Config.Env == "dev" {
+		deadline = 1000 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return usage, err
+	}
+	// connected to db
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"type": "component_consume",
+			"date": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"component_id": "$component_id",
+				"day": bson.M{"$dateToString": bson.M{
+					"format":   "%Y-%m-%d",
+					"date":     "$date",
+					"timezone": aggregationTimeZone(rs.timeZone()),
+				}},
+			},
+			"quantity": bson.M{"$sum": "$quantity"},
+		}}},
+	}
+
+	cursor, err := client.Database("waha").Collection("logs").Aggregate(ctx, pipeline)
+	if err != nil {
+		return usage, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			Id struct {
+				ComponentId string `bson:"component_id"`
+				Day         string `bson:"day"`
+			} `bson:"_id"`
+			Quantity float64 `bson:"quantity"`
+		}
+
+		if err := cursor.Decode(&row); err != nil {
+			return usage, err
+		}
+
+		if _, ok := usage[row.Id.ComponentId]; !ok {
+			usage[row.Id.ComponentId] = make(materialDailyUsage)
+		}
+
+		usage[row.Id.ComponentId][row.Id.Day] += row.Quantity
+	}
+
+	return usage, cursor.Err()
+}
+
+// GetReorderSuggestions returns a reorder suggestion for each material in the inventory.
+//
+// The average daily usage is computed from the component_consume logs over the lookback window,
+// the reorder point is the demand during the supplier lead time plus the safety stock, and the
+// suggested quantity brings the stock back to the reorder point plus the review period demand.
+func (rs *ReorderService) GetReorderSuggestions(params GetReorderSuggestionsParams) (suggestions []dto.ReorderSuggestion, err error) {
+
+	suggestions = make([]dto.ReorderSuggestion, 0)
+
+	lookback_days := params.LookbackDays
+	if lookback_days <= 0 {
+		lookback_days = rs.Settings.Inventory.ReorderLookbackDays
+	}
+	if lookback_days <= 0 {
+		lookback_days = defaultReorderLookbackDays
+	}
+
+	review_days := rs.Settings.Inventory.ReorderReviewDays
+	if review_days <= 0 {
+		review_days = defaultReorderReviewDays
+	}
+
+	loc := rs.timeZone()
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	since := today.AddDate(0, 0, -lookback_days)
+
+	usage, err := rs.GetMaterialsDailyUsage(since)
+	if err != nil {
+		return suggestions, err
+	}
+
+	// count how many times each weekday occurred in the lookback window
+	weekday_occurrences := make(map[time.Weekday]int)
+	for d := since; d.Before(today); d = d.AddDate(0, 0, 1) {
+		weekday_occurrences[d.Weekday()]++
+	}
+
+	materialService := MaterialService{
+		Logger:   rs.Logger,
+		Config:   rs.Config,
+		Settings: rs.Settings,
+	}
+
+	materials, err := materialService.GetMaterials(1, math.MaxInt32)
+	if err != nil {
+		return suggestions, err
+	}
+
+	for _, material := range materials {
+
+		available, err := materialService.GetComponentAvailability(material.Id)
+		if err != nil {
+			return suggestions, err
+		}
+
+		total_usage := 0.0
+		weekday_usage := make(map[time.Weekday]float64)
+		for day, quantity := range usage[material.Id] {
+			date, err := time.ParseInLocation("2006-01-02", day, loc)
+			if err != nil {
+				continue
+			}
+
+			// today's partial usage is not part of the window
+			if !date.Before(today) {
+				continue
+			}
+
+			total_usage += quantity
+			weekday_usage[date.Weekday()] += quantity
+		}
+
+		lead_time := material.Settings.LeadTimeDays
+		if lead_time <= 0 {
+			lead_time = rs.Settings.Inventory.DefaultLeadTimeDays
+		}
+		if lead_time <= 0 {
+			lead_time = defaultLeadTimeDays
+		}
+
+		suggestion := dto.ReorderSuggestion{
+			MaterialId:        material.Id,
+			MaterialName:      material.Name,
+			Unit:              material.Unit,
+			Available:         float64(available),
+			AverageDailyUsage: total_usage / float64(lookback_days),
+			LeadTimeDays:      lead_time,
+			DaysOfStockLeft:   -1,
+		}
+
+		lead_time_demand := suggestion.AverageDailyUsage * lead_time
+		review_demand := suggestion.AverageDailyUsage * review_days
+
+		if params.ByWeekday {
+			suggestion.WeekdayAverageUsage = make(map[string]float64)
+			weekday_average := make(map[time.Weekday]float64)
+			for weekday, occurrences := range weekday_occurrences {
+				weekday_average[weekday] = weekday_usage[weekday] / float64(occurrences)
+				suggestion.WeekdayAverageUsage[weekday.String()] = weekday_average[weekday]
+			}
+
+			lead_time_demand = forecastDemand(weekday_average, today.AddDate(0, 0, 1), lead_time)
+			review_demand = forecastDemand(weekday_average, today.AddDate(0, 0, 1+int(math.Ceil(lead_time))), review_days)
+		}
+
+		suggestion.SafetyStock = suggestion.AverageDailyUsage * material.Settings.SafetyStockDays
+		suggestion.ReorderPoint = lead_time_demand + suggestion.SafetyStock
+
+		if suggestion.AverageDailyUsage > 0 {
+			suggestion.DaysOfStockLeft = suggestion.Available / suggestion.AverageDailyUsage
+		}
+
+		if suggestion.AverageDailyUsage > 0 && suggestion.Available <= suggestion.ReorderPoint {
+			suggestion.IsReorderNeeded = true
+			suggestion.SuggestedQuantity = math.Max(0, suggestion.ReorderPoint+review_demand-suggestion.Available)
+		}
+
+		if params.OnlyNeeded && !suggestion.IsReorderNeeded {
+			continue
+		}
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].IsReorderNeeded != suggestions[j].IsReorderNeeded {
+			return suggestions[i].IsReorderNeeded
+		}
+		return suggestions[i].DaysOfStockLeft < suggestions[j].DaysOfStockLeft
+	})
+
+	return suggestions, nil
+}
+
+// timeZone returns the configured time zone, or UTC if it's not set or invalid.
+func (rs *ReorderService) timeZone() *time.Location {
+	loc, err := time.LoadLocation(rs.Config.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// aggregationTimeZone returns the name of the time zone for the date operators of the aggregations,
+// the local time zone has no IANA name so its current UTC offset is used instead.
+func aggregationTimeZone(loc *time.Location) string {
+	if loc.String() != "Local" {
+		return loc.String()
+	}
+
+	return time.Now().In(loc).Format("-07:00")
+}
+
+// forecastDemand sums the weekday averages for the given number of days starting from the given day,
+// a fractional day is counted partially.
+func forecastDemand(weekday_average map[time.Weekday]float64, from time.Time, days float64) (demand float64) {
+
+	for i := 0; float64(i) < days; i++ {
+		portion := math.Min(1, days-float64(i))
+		demand += weekday_average[from.AddDate(0, 0, i).Weekday()] * portion
+	}
+
+	return demand
+}
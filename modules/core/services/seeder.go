@@ -163,8 +163,8 @@ func (s *Seeder) SeedProducts() error {
 func (s *Seeder) SeedCategories() error {
 	categories := []models.Category{
 		{
-			Name:     "CategorySeeded",
-			Products: []models.Product{},
+			Name:        "CategorySeeded",
+			ProductRefs: []models.CategoryProduct{},
 		},
 	}
 
@@ -234,8 +234,8 @@ func (s *Seeder) SeedCategories() error {
 				}
 
 				for index := range categories {
-					categories[index].Products = append(categories[index].Products, models.Product{
-						Id: product.Id,
+					categories[index].ProductRefs = append(categories[index].ProductRefs, models.CategoryProduct{
+						ProductId: product.Id,
 					})
 				}
 			}
@@ -243,8 +243,8 @@ func (s *Seeder) SeedCategories() error {
 			return err
 		} else {
 			for index := range categories {
-				categories[index].Products = append(categories[index].Products, models.Product{
-					Id: product.Id,
+				categories[index].ProductRefs = append(categories[index].ProductRefs, models.CategoryProduct{
+					ProductId: product.Id,
 				})
 			}
 		}
@@ -286,8 +286,8 @@ func (s *Seeder) SeedCategories() error {
 				}
 
 				for index := range categories {
-					categories[index].Products = append(categories[index].Products, models.Product{
-						Id: product.Id,
+					categories[index].ProductRefs = append(categories[index].ProductRefs, models.CategoryProduct{
+						ProductId: product.Id,
 					})
 				}
 			}
@@ -295,8 +295,8 @@ func (s *Seeder) SeedCategories() error {
 			return err
 		} else {
 			for index := range categories {
-				categories[index].Products = append(categories[index].Products, models.Product{
-					Id: product.Id,
+				categories[index].ProductRefs = append(categories[index].ProductRefs, models.CategoryProduct{
+					ProductId: product.Id,
 				})
 			}
 		}
@@ -433,6 +433,11 @@ func (s *Seeder) SeedMaterials(seedEntries bool) error {
 		if err != nil {
 			return err
 		}
+
+		err = seedReceipts(ctx, client, materials)
+		if err != nil {
+			return err
+		}
 		s.Logger.Info("materials seeded successfully")
 		return nil
 	} else if err != nil {
@@ -462,8 +467,33 @@ func (s *Seeder) SeedMaterials(seedEntries bool) error {
 		if err != nil {
 			return err
 		}
+
+		err = seedReceipts(ctx, client, materials)
+		if err != nil {
+			return err
+		}
 		s.Logger.Info("materials inserted successfully")
 	}
 
 	return nil
 }
+
+// seedReceipts records the receipt movements of the seeded entries in the ledger,
+// the entries are inserted with their quantities so the movements aren't applied.
+func seedReceipts(ctx context.Context, client *mongo.Client, materials []models.Material) error {
+
+	for _, material := range materials {
+		for _, entry := range material.Entries {
+			if entry.Quantity <= 0 {
+				continue
+			}
+
+			_, err := recordMovement(ctx, client, receiptMovement(material.Id, entry), false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
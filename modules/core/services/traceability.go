@@ -0,0 +1,501 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/common/config"
+	"github.com/elmawardy/nutrix/common/logger"
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TraceabilityService walks the consumption logs to find which orders and customers
+// received a lot (material entry), and which lots an order used.
+type TraceabilityService struct {
+	Logger   logger.ILogger
+	Config   config.Config
+	Settings models.Settings
+}
+
+// consumptionLog is a component_consume, component_waste or ready_consume document of the logs collection.
+type consumptionLog struct {
+	Date        time.Time `bson:"date"`
+	ComponentId string    `bson:"component_id"`
+	EntryId     string    `bson:"entry_id"`
+	ProductId   string    `bson:"product_id"`
+	Quantity    float64   `bson:"quantity"`
+	OrderId     string    `bson:"order_id"`
+	RecipeId    string    `bson:"recipe_id"`
+	Reason      string    `bson:"reason"`
+	// BatchId is the ready production batch consumed by a ready_consume log.
+	BatchId string `bson:"batch_id"`
+	// ProductionBatchId is the batch produced from the consumed quantity, instead of an order.
+	ProductionBatchId string `bson:"production_batch_id"`
+}
+
+// tracedLotFromEntry builds the traced lot of a material entry.
+func tracedLotFromEntry(material models.Material, entry models.MaterialEntry) dto.TracedLot {
+	return dto.TracedLot{
+		MaterialId:     material.Id,
+		MaterialName:   material.Name,
+		Unit:           material.Unit,
+		EntryId:        entry.Id,
+		SKU:            entry.SKU,
+		Company:        entry.Company,
+		ReceivedAt:     entry.ReceivedTime(),
+		ExpirationDate: entry.ExpirationDate,
+	}
+}
+
+// findConsumptionLogs returns the logs of the given type matching the filter, ordered by date.
+func findConsumptionLogs(ctx context.Context, client *mongo.Client, log_type string, filter bson.M) (logs []consumptionLog, err error) {
+
+	logs = make([]consumptionLog, 0)
+
+	filter["type"] = log_type
+	find_options := options.Find().SetSort(bson.M{"date": 1})
+
+	cursor, err := client.Database("waha").Collection("logs").Find(ctx, filter, find_options)
+	if err != nil {
+		return logs, err
+	}
+
+	err = cursor.All(ctx, &logs)
+	return logs, err
+}
+
+// findRecipeNames returns the names of the given recipes by their id.
+func findRecipeNames(ctx context.Context, client *mongo.Client, recipe_ids []string) (names map[string]string, err error) {
+
+	names = make(map[string]string)
+
+	if len(recipe_ids) == 0 {
+		return names, nil
+	}
+
+	cursor, err := client.Database("waha").Collection("recipes").Find(ctx, bson.M{"id": bson.M{"$in": recipe_ids}})
+	if err != nil {
+		return names, err
+	}
+
+	var recipes []models.Product
+	if err := cursor.All(ctx, &recipes); err != nil {
+		return names, err
+	}
+
+	for _, recipe := range recipes {
+		names[recipe.Id] = recipe.Name
+	}
+
+	return names, nil
+}
+
+// TraceLotForward finds the lots matching the entry_id or the sku (at least one of them is required),
+// and walks the consumption logs forward to the recipes, orders and customers that received them.
+//
+// The lots consumed by production batches are followed to the orders, and the other batches, served from
+// those batches. Recipes that consumed the lots and were later served from ready stock not backed by a batch
+// are followed to the orders that consumed that ready stock after the lots were used, those orders are reported
+// as possibly affected.
+func (ts *TraceabilityService) TraceLotForward(entry_id string, sku string) (trace dto.LotTrace, err error) {
+
+	trace = dto.LotTrace{
+		Lots:      make([]dto.TracedLot, 0),
+		Recipes:   make([]dto.TracedRecipe, 0),
+		Batches:   make([]dto.TracedBatch, 0),
+		Orders:    make([]dto.TracedOrder, 0),
+		Customers: make([]models.Customer, 0),
+	}
+
+	if entry_id == "" && sku == "" {
+		return trace, fmt.Errorf("entry_id or sku is required")
+	}
+
+	client, ctx, cancel, err := connectDB(ts.Config)
+	if err != nil {
+		return trace, err
+	}
+	defer cancel()
+	// connected to db
+
+	lots_filter := bson.A{}
+	if entry_id != "" {
+		lots_filter = append(lots_filter, bson.M{"entries.id": entry_id})
+	}
+	if sku != "" {
+		lots_filter = append(lots_filter, bson.M{"entries.sku": sku})
+	}
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{"$or": lots_filter})
+	if err != nil {
+		return trace, err
+	}
+
+	var materials []models.Material
+	if err := cursor.All(ctx, &materials); err != nil {
+		return trace, err
+	}
+
+	entry_ids := make([]string, 0)
+	for _, material := range materials {
+		for _, entry := range material.Entries {
+			if (entry_id != "" && entry.Id == entry_id) || (sku != "" && entry.SKU == sku) {
+				trace.Lots = append(trace.Lots, tracedLotFromEntry(material, entry))
+				entry_ids = append(entry_ids, entry.Id)
+			}
+		}
+	}
+
+	// the entry may have been removed from its material, its consumption logs are still traced
+	if entry_id != "" && len(entry_ids) == 0 {
+		entry_ids = append(entry_ids, entry_id)
+	}
+
+	if len(entry_ids) == 0 {
+		return trace, nil
+	}
+
+	consume_logs, err := findConsumptionLogs(ctx, client, "component_consume", bson.M{"entry_id": bson.M{"$in": entry_ids}})
+	if err != nil {
+		return trace, err
+	}
+
+	orders := make(map[string]*dto.TracedOrder)
+	order_ids := make([]string, 0)
+	recipes := make(map[string]*dto.TracedRecipe)
+	recipe_ids := make([]string, 0)
+	// the first time each recipe consumed the traced lots
+	recipe_first_use := make(map[string]time.Time)
+	// the production batches containing the traced lots, in the order they were reached
+	batch_ids := make([]string, 0)
+
+	traced_order := func(order_id string) *dto.TracedOrder {
+		if _, ok := orders[order_id]; !ok {
+			orders[order_id] = &dto.TracedOrder{OrderId: order_id, RecipeIds: make([]string, 0)}
+			order_ids = append(order_ids, order_id)
+		}
+		return orders[order_id]
+	}
+
+	for _, log := range consume_logs {
+
+		trace.TotalConsumed += log.Quantity
+
+		if _, ok := recipes[log.RecipeId]; !ok {
+			recipes[log.RecipeId] = &dto.TracedRecipe{RecipeId: log.RecipeId}
+			recipe_ids = append(recipe_ids, log.RecipeId)
+			recipe_first_use[log.RecipeId] = log.Date
+		}
+		recipes[log.RecipeId].Quantity += log.Quantity
+
+		if log.ProductionBatchId != "" {
+			if !containsString(batch_ids, log.ProductionBatchId) {
+				batch_ids = append(batch_ids, log.ProductionBatchId)
+			}
+			continue
+		}
+
+		order := traced_order(log.OrderId)
+		order.Quantity += log.Quantity
+		if !containsString(order.RecipeIds, log.RecipeId) {
+			order.RecipeIds = append(order.RecipeIds, log.RecipeId)
+		}
+	}
+
+	// follow the batches to the orders and the batches they were served to
+	for index := 0; index < len(batch_ids); index++ {
+		ready_logs, err := findConsumptionLogs(ctx, client, "ready_consume", bson.M{"batch_id": batch_ids[index]})
+		if err != nil {
+			return trace, err
+		}
+
+		for _, log := range ready_logs {
+			if log.ProductionBatchId != "" {
+				if !containsString(batch_ids, log.ProductionBatchId) {
+					batch_ids = append(batch_ids, log.ProductionBatchId)
+				}
+				continue
+			}
+
+			order := traced_order(log.OrderId)
+			if !containsString(order.ViaBatchIds, batch_ids[index]) {
+				order.ViaBatchIds = append(order.ViaBatchIds, batch_ids[index])
+			}
+		}
+	}
+
+	for _, recipe_id := range recipe_ids {
+		ready_logs, err := findConsumptionLogs(ctx, client, "ready_consume", bson.M{
+			"product_id": recipe_id,
+			"batch_id":   bson.M{"$exists": false},
+			"date":       bson.M{"$gte": recipe_first_use[recipe_id]},
+		})
+		if err != nil {
+			return trace, err
+		}
+
+		for _, log := range ready_logs {
+			if log.OrderId == "" {
+				continue
+			}
+
+			recipes[recipe_id].IsStockedReady = true
+
+			order := traced_order(log.OrderId)
+			if !containsString(order.ViaReadyProductIds, recipe_id) {
+				order.ViaReadyProductIds = append(order.ViaReadyProductIds, recipe_id)
+			}
+		}
+	}
+
+	names, err := findRecipeNames(ctx, client, recipe_ids)
+	if err != nil {
+		return trace, err
+	}
+
+	for _, recipe_id := range recipe_ids {
+		recipes[recipe_id].RecipeName = names[recipe_id]
+		trace.Recipes = append(trace.Recipes, *recipes[recipe_id])
+	}
+
+	if len(batch_ids) > 0 {
+		cursor, err = client.Database("waha").Collection("production_batches").Find(ctx, bson.M{"id": bson.M{"$in": batch_ids}}, options.Find().SetSort(bson.M{"produced_at": 1}))
+		if err != nil {
+			return trace, err
+		}
+
+		var batches []models.ProductionBatch
+		if err := cursor.All(ctx, &batches); err != nil {
+			return trace, err
+		}
+
+		for _, batch := range batches {
+			trace.Batches = append(trace.Batches, dto.TracedBatch{
+				BatchId:        batch.Id,
+				ProductId:      batch.ProductId,
+				ProductName:    batch.ProductName,
+				Quantity:       batch.Quantity,
+				ProducedAt:     batch.ProducedAt,
+				ExpirationDate: batch.ExpirationDate,
+				IsExpired:      batch.IsExpired,
+			})
+		}
+	}
+
+	cursor, err = client.Database("waha").Collection("orders").Find(ctx, bson.M{"id": bson.M{"$in": order_ids}})
+	if err != nil {
+		return trace, err
+	}
+
+	var found_orders []models.Order
+	if err := cursor.All(ctx, &found_orders); err != nil {
+		return trace, err
+	}
+
+	seen_customers := make(map[string]bool)
+	for _, found := range found_orders {
+		order := orders[found.Id]
+		order.DisplayId = found.DisplayId
+		order.SubmittedAt = found.SubmittedAt
+		order.State = found.State
+		order.Customer = found.Customer
+
+		customer_key := found.Customer.Id
+		if customer_key == "" {
+			customer_key = found.Customer.Phone
+		}
+		if customer_key != "" && !seen_customers[customer_key] {
+			seen_customers[customer_key] = true
+			trace.Customers = append(trace.Customers, found.Customer)
+		}
+	}
+
+	for _, order_id := range order_ids {
+		trace.Orders = append(trace.Orders, *orders[order_id])
+	}
+
+	sort.SliceStable(trace.Orders, func(i, j int) bool {
+		return trace.Orders[i].SubmittedAt.Before(trace.Orders[j].SubmittedAt)
+	})
+
+	return trace, nil
+}
+
+// TraceOrderBackward walks the consumption logs of an order backward to the lots it consumed, and the products
+// it was served from their ready stock. The production batches served to the order are followed to their lots.
+func (ts *TraceabilityService) TraceOrderBackward(order_id string) (trace dto.OrderLotTrace, err error) {
+
+	trace = dto.OrderLotTrace{
+		OrderId:      order_id,
+		Consumptions: make([]dto.TracedConsumption, 0),
+		Ready:        make([]dto.TracedReadyConsumption, 0),
+	}
+
+	client, ctx, cancel, err := connectDB(ts.Config)
+	if err != nil {
+		return trace, err
+	}
+	defer cancel()
+	// connected to db
+
+	var order models.Order
+	err = client.Database("waha").Collection("orders").FindOne(ctx, bson.M{"id": order_id}).Decode(&order)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return trace, err
+	}
+
+	trace.DisplayId = order.DisplayId
+	trace.SubmittedAt = order.SubmittedAt
+	trace.Customer = order.Customer
+
+	consume_logs, err := findConsumptionLogs(ctx, client, "component_consume", bson.M{"order_id": order_id})
+	if err != nil {
+		return trace, err
+	}
+
+	ready_logs, err := findConsumptionLogs(ctx, client, "ready_consume", bson.M{"order_id": order_id})
+	if err != nil {
+		return trace, err
+	}
+
+	if order.Id == "" && len(consume_logs) == 0 && len(ready_logs) == 0 {
+		return trace, mongo.ErrNoDocuments
+	}
+
+	// the batch served to the order through which each consumption was reached
+	via_batch := make([]string, len(consume_logs))
+
+	batch_ids := make([]string, 0)
+	served_batch := make(map[string]string)
+	for _, log := range ready_logs {
+		if log.BatchId != "" && !containsString(batch_ids, log.BatchId) {
+			batch_ids = append(batch_ids, log.BatchId)
+			served_batch[log.BatchId] = log.BatchId
+		}
+	}
+
+	for index := 0; index < len(batch_ids); index++ {
+		batch_id := batch_ids[index]
+
+		batch_consume_logs, err := findConsumptionLogs(ctx, client, "component_consume", bson.M{"production_batch_id": batch_id})
+		if err != nil {
+			return trace, err
+		}
+
+		for _, log := range batch_consume_logs {
+			consume_logs = append(consume_logs, log)
+			via_batch = append(via_batch, served_batch[batch_id])
+		}
+
+		// the batches consumed by this batch
+		batch_ready_logs, err := findConsumptionLogs(ctx, client, "ready_consume", bson.M{"production_batch_id": batch_id, "batch_id": bson.M{"$exists": true}})
+		if err != nil {
+			return trace, err
+		}
+
+		for _, log := range batch_ready_logs {
+			if !containsString(batch_ids, log.BatchId) {
+				batch_ids = append(batch_ids, log.BatchId)
+				served_batch[log.BatchId] = served_batch[batch_id]
+			}
+		}
+	}
+
+	material_ids := make([]string, 0)
+	recipe_ids := make([]string, 0)
+	for _, log := range consume_logs {
+		if !containsString(material_ids, log.ComponentId) {
+			material_ids = append(material_ids, log.ComponentId)
+		}
+		if !containsString(recipe_ids, log.RecipeId) {
+			recipe_ids = append(recipe_ids, log.RecipeId)
+		}
+	}
+	for _, log := range ready_logs {
+		if !containsString(recipe_ids, log.ProductId) {
+			recipe_ids = append(recipe_ids, log.ProductId)
+		}
+	}
+
+	materials := make(map[string]models.Material)
+	if len(material_ids) > 0 {
+		cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{"id": bson.M{"$in": material_ids}})
+		if err != nil {
+			return trace, err
+		}
+
+		var found []models.Material
+		if err := cursor.All(ctx, &found); err != nil {
+			return trace, err
+		}
+
+		for _, material := range found {
+			materials[material.Id] = material
+		}
+	}
+
+	names, err := findRecipeNames(ctx, client, recipe_ids)
+	if err != nil {
+		return trace, err
+	}
+
+	for index, log := range consume_logs {
+
+		material := materials[log.ComponentId]
+		lot := dto.TracedLot{
+			MaterialId:   log.ComponentId,
+			MaterialName: material.Name,
+			Unit:         material.Unit,
+			EntryId:      log.EntryId,
+		}
+
+		for _, entry := range material.Entries {
+			if entry.Id == log.EntryId {
+				lot = tracedLotFromEntry(material, entry)
+				break
+			}
+		}
+
+		trace.Consumptions = append(trace.Consumptions, dto.TracedConsumption{
+			Lot:        lot,
+			RecipeId:   log.RecipeId,
+			RecipeName: names[log.RecipeId],
+			Quantity:   log.Quantity,
+			Date:       log.Date,
+			ViaBatchId: via_batch[index],
+		})
+	}
+
+	for _, log := range ready_logs {
+		trace.Ready = append(trace.Ready, dto.TracedReadyConsumption{
+			ProductId:   log.ProductId,
+			BatchId:     log.BatchId,
+			ProductName: names[log.ProductId],
+			Quantity:    log.Quantity,
+			Date:        log.Date,
+		})
+	}
+
+	return trace, nil
+}
+
+// containsString checks if the slice contains the value.
+func containsString(slice []string, value string) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
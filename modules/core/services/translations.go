@@ -0,0 +1,112 @@
+package services
+
+import (
+	"github.com/elmawardy/nutrix/modules/core/models"
+)
+
+// CatalogLocalizer resolves the names and the descriptions of the products, categories and materials to a language.
+// The untranslated names are in the default language, so nothing is changed when the language is the default one.
+//
+// The untranslated name and description of a localized item are returned as the translation of the default language,
+// so an item sent back to be saved is normalized to its untranslated name with the Normalize methods.
+type CatalogLocalizer struct {
+	LangCode        string
+	DefaultLangCode string
+}
+
+// NewCatalogLocalizer returns a localizer to the language, the default language is the one of the settings or else English.
+func NewCatalogLocalizer(lang_code string, settings models.Settings) CatalogLocalizer {
+
+	default_lang_code := settings.Language.Code
+	if default_lang_code == "" {
+		default_lang_code = "en"
+	}
+
+	return CatalogLocalizer{LangCode: lang_code, DefaultLangCode: default_lang_code}
+}
+
+// isDefault tells whether the names are returned untranslated.
+func (cl CatalogLocalizer) isDefault() bool {
+	return cl.LangCode == "" || cl.LangCode == cl.DefaultLangCode
+}
+
+// localize returns the translations with the untranslated name and description as the default language one,
+// and the name and the description in the language.
+func (cl CatalogLocalizer) localize(translations models.Translations, name *string, description *string) models.Translations {
+
+	if cl.isDefault() || len(translations) == 0 {
+		return translations
+	}
+
+	localized := make(models.Translations, len(translations)+1)
+	for lang_code, translation := range translations {
+		localized[lang_code] = translation
+	}
+
+	if cl.DefaultLangCode != "" {
+		localized[cl.DefaultLangCode] = models.Translation{Name: *name, Description: *description}
+	}
+
+	*name, *description = translations.Localize(cl.LangCode, *name, *description)
+
+	return localized
+}
+
+// LocalizeMaterial resolves the name and the description of the material.
+func (cl CatalogLocalizer) LocalizeMaterial(material *models.Material) {
+	material.Translations = cl.localize(material.Translations, &material.Name, &material.Description)
+}
+
+// LocalizeMaterials resolves the names and the descriptions of the materials.
+func (cl CatalogLocalizer) LocalizeMaterials(materials []models.Material) {
+	for index := range materials {
+		cl.LocalizeMaterial(&materials[index])
+	}
+}
+
+// LocalizeProduct resolves the name and the description of the product, and of its materials and sub products.
+func (cl CatalogLocalizer) LocalizeProduct(product *models.Product) {
+
+	product.Translations = cl.localize(product.Translations, &product.Name, &product.Description)
+
+	cl.LocalizeMaterials(product.Materials)
+	cl.LocalizeProducts(product.SubProducts)
+}
+
+// LocalizeProducts resolves the names and the descriptions of the products.
+func (cl CatalogLocalizer) LocalizeProducts(products []models.Product) {
+	for index := range products {
+		cl.LocalizeProduct(&products[index])
+	}
+}
+
+// LocalizeCategory resolves the name and the description of the category, of its products and of its subcategories.
+func (cl CatalogLocalizer) LocalizeCategory(category *models.Category) {
+
+	category.Translations = cl.localize(category.Translations, &category.Name, &category.Description)
+
+	cl.LocalizeProducts(category.Products)
+	cl.LocalizeCategories(category.Children)
+}
+
+// LocalizeCategories resolves the names and the descriptions of the categories.
+func (cl CatalogLocalizer) LocalizeCategories(categories []models.Category) {
+	for index := range categories {
+		cl.LocalizeCategory(&categories[index])
+	}
+}
+
+// NormalizeMaterial moves the translation of the default language of the material to its untranslated name.
+func (cl CatalogLocalizer) NormalizeMaterial(material *models.Material) {
+	material.Translations = material.Translations.Normalize(cl.DefaultLangCode, &material.Name, &material.Description)
+}
+
+// NormalizeProduct moves the translation of the default language of the product to its untranslated name.
+func (cl CatalogLocalizer) NormalizeProduct(product *models.Product) {
+	product.Translations = product.Translations.Normalize(cl.DefaultLangCode, &product.Name, &product.Description)
+}
+
+// NormalizeCategory moves the translation of the default language of the category to its untranslated name.
+func (cl CatalogLocalizer) NormalizeCategory(category *models.Category) {
+	category.Translations = category.Translations.Normalize(cl.DefaultLangCode, &category.Name, &category.Description)
+}
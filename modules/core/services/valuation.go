@@ -0,0 +1,276 @@
+// Package services contains the business logic of the core module of nutrix.
+//
+// The services in this package are used to interact with the database and
+// external services. They are used to implement the HTTP handlers in the
+// handlers package.
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/elmawardy/nutrix/modules/core/dto"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetInventoryValuation returns the total stock value by material and by category as of the given date,
+// using the configured costing method, and the value of the ready stock held in production batches.
+//
+// The stocked quantity of each entry as of the date is the sum of its ledger movements up to the date,
+// entries received after the date are not included.
+func (cs *MaterialService) GetInventoryValuation(as_of time.Time) (valuation dto.InventoryValuation, err error) {
+
+	valuation = dto.InventoryValuation{
+		AsOf:          as_of,
+		CostingMethod: cs.CostingMethod(),
+		Materials:     make([]dto.MaterialValuation, 0),
+		Categories:    make([]dto.CategoryValuation, 0),
+		Products:      make([]dto.ProductValuation, 0),
+	}
+
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%v", cs.Config.Databases[0].Host, cs.Config.Databases[0].Port))
+
+	deadline := 5 * time.Second
+	if cs.Config.Env == "dev" {
+		deadline = 1000 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return valuation, err
+	}
+	// connected to db
+
+	balances, err := ledgerBalances(ctx, client, as_of)
+	if err != nil {
+		return valuation, err
+	}
+
+	all_balances, err := ledgerBalances(ctx, client, time.Time{})
+	if err != nil {
+		return valuation, err
+	}
+
+	average_costs := make(map[string]float64)
+	if cs.CostingMethod() == models.CostingMethodWeightedAverage {
+		average_costs, err = averageCostsAsOf(ctx, client, as_of)
+		if err != nil {
+			return valuation, err
+		}
+	}
+
+	cursor, err := client.Database("waha").Collection("materials").Find(ctx, bson.M{})
+	if err != nil {
+		return valuation, err
+	}
+	defer cursor.Close(ctx)
+
+	categories := make(map[string]*dto.CategoryValuation)
+
+	for cursor.Next(ctx) {
+		var material models.Material
+		if err := cursor.Decode(&material); err != nil {
+			return valuation, err
+		}
+
+		stocked := []models.MaterialEntry{}
+		for _, entry := range material.Entries {
+			received_at := entry.ReceivedTime()
+			if !received_at.IsZero() && received_at.After(as_of) {
+				continue
+			}
+
+			// entries created before the ledger and not reconciled yet are valued at their stored quantity
+			if balance, ok := balances[entry.Id]; ok {
+				entry.Quantity = float32(balance.Quantity)
+			} else if _, ok := all_balances[entry.Id]; ok {
+				entry.Quantity = 0
+			}
+
+			if entry.Quantity <= 0 {
+				continue
+			}
+
+			stocked = append(stocked, entry)
+		}
+
+		material_valuation := dto.MaterialValuation{
+			MaterialId:   material.Id,
+			MaterialName: material.Name,
+			Category:     material.Category,
+			Unit:         material.Unit,
+		}
+
+		for _, entry := range stocked {
+			material_valuation.Quantity += float64(entry.Quantity)
+			material_valuation.Value += float64(entry.Quantity) * entry.UnitCost()
+		}
+
+		// the weighted average method values the whole stock at the moving average unit cost it had at the date,
+		// while the specific and fifo methods keep each entry at its own purchase price.
+		if cs.CostingMethod() == models.CostingMethodWeightedAverage {
+			average, ok := average_costs[material.Id]
+			if !ok {
+				average = materialAverageCost(material)
+			}
+			material_valuation.Value = average * material_valuation.Quantity
+		}
+
+		if material_valuation.Quantity > 0 {
+			material_valuation.UnitCost = material_valuation.Value / material_valuation.Quantity
+		}
+
+		valuation.Materials = append(valuation.Materials, material_valuation)
+		valuation.TotalValue += material_valuation.Value
+
+		if _, ok := categories[material.Category]; !ok {
+			categories[material.Category] = &dto.CategoryValuation{Category: material.Category}
+		}
+		categories[material.Category].MaterialsCount++
+		categories[material.Category].Value += material_valuation.Value
+	}
+
+	for _, category := range categories {
+		valuation.Categories = append(valuation.Categories, *category)
+	}
+
+	sort.Slice(valuation.Categories, func(i, j int) bool {
+		return valuation.Categories[i].Value > valuation.Categories[j].Value
+	})
+
+	if err := cursor.Err(); err != nil {
+		return valuation, err
+	}
+
+	valuation.Products, err = readyValuation(ctx, client, as_of)
+	if err != nil {
+		return valuation, err
+	}
+
+	for _, product := range valuation.Products {
+		valuation.ReadyValue += product.Value
+	}
+	valuation.TotalValue += valuation.ReadyValue
+
+	return valuation, nil
+}
+
+// averageCostsAsOf replays the ledger movements up to the date, and returns the moving average unit cost
+// each material had at the date by its id. The materials that didn't receive stock by the date are left out.
+func averageCostsAsOf(ctx context.Context, client *mongo.Client, as_of time.Time) (averages map[string]float64, err error) {
+
+	averages = make(map[string]float64)
+	stocks := make(map[string]float64)
+
+	cursor, err := client.Database("waha").Collection("inventory_movements").Find(ctx,
+		bson.M{"date": bson.M{"$lte": as_of}},
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "id", Value: 1}}))
+	if err != nil {
+		return averages, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var movement models.InventoryMovement
+		if err := cursor.Decode(&movement); err != nil {
+			return averages, err
+		}
+
+		if isAverageInflow(movement) {
+			averages[movement.MaterialId] = movingAverageCost(averages[movement.MaterialId], stocks[movement.MaterialId], movement.Quantity, movement.UnitCost)
+		}
+
+		stocks[movement.MaterialId] += movement.Quantity
+	}
+
+	return averages, cursor.Err()
+}
+
+// readyValuation values the ready stock held in production batches as of the given date at the batches unit cost.
+//
+// The remaining quantity of each batch as of the date is its current remaining quantity plus what was consumed
+// from it or expired after the date, batches produced after the date or expired before it are not included.
+func readyValuation(ctx context.Context, client *mongo.Client, as_of time.Time) (products []dto.ProductValuation, err error) {
+
+	products = make([]dto.ProductValuation, 0)
+
+	outflows := make(map[string]float64)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"type":     bson.M{"$in": []string{"ready_consume", "batch_expire"}},
+			"batch_id": bson.M{"$exists": true},
+			"date":     bson.M{"$gt": as_of},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$batch_id",
+			"quantity": bson.M{"$sum": "$quantity"},
+		}}},
+	}
+
+	cursor, err := client.Database("waha").Collection("logs").Aggregate(ctx, pipeline)
+	if err != nil {
+		return products, err
+	}
+
+	for cursor.Next(ctx) {
+		var row struct {
+			BatchId  string  `bson:"_id"`
+			Quantity float64 `bson:"quantity"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			cursor.Close(ctx)
+			return products, err
+		}
+		outflows[row.BatchId] = row.Quantity
+	}
+	cursor.Close(ctx)
+
+	cursor, err = client.Database("waha").Collection("production_batches").Find(ctx, bson.M{"produced_at": bson.M{"$lte": as_of}})
+	if err != nil {
+		return products, err
+	}
+
+	var batches []models.ProductionBatch
+	if err := cursor.All(ctx, &batches); err != nil {
+		return products, err
+	}
+
+	valuations := make(map[string]*dto.ProductValuation)
+	product_ids := make([]string, 0)
+
+	for _, batch := range batches {
+		if !batch.ExpirationDate.IsZero() && !batch.ExpirationDate.After(as_of) {
+			continue
+		}
+
+		remaining := batch.Remaining + outflows[batch.Id]
+		if remaining <= 0 {
+			continue
+		}
+
+		if _, ok := valuations[batch.ProductId]; !ok {
+			valuations[batch.ProductId] = &dto.ProductValuation{ProductId: batch.ProductId, ProductName: batch.ProductName}
+			product_ids = append(product_ids, batch.ProductId)
+		}
+
+		valuations[batch.ProductId].Quantity += remaining
+		valuations[batch.ProductId].Value += remaining * batch.UnitCost
+	}
+
+	for _, product_id := range product_ids {
+		product := valuations[product_id]
+		product.UnitCost = product.Value / product.Quantity
+		products = append(products, *product)
+	}
+
+	return products, nil
+}
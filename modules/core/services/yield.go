@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elmawardy/nutrix/common/customerrors"
+	"github.com/elmawardy/nutrix/modules/core/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateRecipeYields checks that the yield percentages of the materials and the sub products
+// of a recipe are within the 0 to 100 range, zero means that the yield isn't set.
+func validateRecipeYields(product models.Product) error {
+	for _, material := range product.Materials {
+		if material.YieldPercent < 0 || material.YieldPercent > 100 {
+			return fmt.Errorf("%w: material %s has %f", customerrors.ErrInvalidYield, material.Id, material.YieldPercent)
+		}
+	}
+
+	for _, sub_product := range product.SubProducts {
+		if sub_product.YieldPercent < 0 || sub_product.YieldPercent > 100 {
+			return fmt.Errorf("%w: sub product %s has %f", customerrors.ErrInvalidYield, sub_product.Id, sub_product.YieldPercent)
+		}
+	}
+
+	return nil
+}
+
+// grossItem returns a copy of the item with the quantities of its materials and sub items scaled up
+// by the yields of its recipe, the quantities of an order item are the net quantities of the recipe.
+//
+// Only the first level is scaled, the sub items keep the net quantities of their own materials.
+func grossItem(ctx context.Context, client *mongo.Client, item models.OrderItem) (models.OrderItem, error) {
+
+	if item.IsConsumeFromReady || (len(item.Materials) == 0 && len(item.SubItems) == 0) {
+		return item, nil
+	}
+
+	var recipe models.Product
+	err := client.Database("waha").Collection("recipes").FindOne(ctx, bson.M{"id": item.Product.Id}).Decode(&recipe)
+	if err == mongo.ErrNoDocuments {
+		return item, nil
+	}
+	if err != nil {
+		return item, err
+	}
+
+	material_yields := make(map[string]models.Material)
+	for _, material := range recipe.Materials {
+		material_yields[material.Id] = material
+	}
+
+	sub_product_yields := make(map[string]models.Product)
+	for _, sub_product := range recipe.SubProducts {
+		sub_product_yields[sub_product.Id] = sub_product
+	}
+
+	// copy the slices so the net quantities of the caller's item aren't changed
+	materials := make([]models.OrderItemMaterial, len(item.Materials))
+	for index, component := range item.Materials {
+		component.Quantity = material_yields[component.Material.Id].Gross(component.Quantity)
+		materials[index] = component
+	}
+
+	sub_items := make([]models.OrderItem, len(item.SubItems))
+	for index, sub_item := range item.SubItems {
+		sub_item.Quantity = sub_product_yields[sub_item.Product.Id].Gross(sub_item.Quantity)
+		sub_items[index] = sub_item
+	}
+
+	item.Materials = materials
+	item.SubItems = sub_items
+
+	return item, nil
+}
+
+// grossItemTree scales the quantities of the item and all of its sub items by the yields of their recipes.
+func grossItemTree(ctx context.Context, client *mongo.Client, item models.OrderItem) (models.OrderItem, error) {
+
+	item, err := grossItem(ctx, client, item)
+	if err != nil {
+		return item, err
+	}
+
+	for index, sub_item := range item.SubItems {
+		item.SubItems[index], err = grossItemTree(ctx, client, sub_item)
+		if err != nil {
+			return item, err
+		}
+	}
+
+	return item, nil
+}